@@ -0,0 +1,143 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"flag"
+	"fmt"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+/*
+certgen creates a local certificate authority plus server and client
+certificates signed by it, for development and functional tests only
+*/
+func main() {
+	var outDir string
+	var hosts string
+	flag.StringVar(&outDir, "out", "./certs", "directory to write certificates to")
+	flag.StringVar(&hosts, "hosts", "localhost,127.0.0.1", "comma separated server dns names and ips")
+	flag.Parse()
+
+	if err := os.MkdirAll(outDir, 0o700); err != nil {
+		panic(err)
+	}
+
+	caKey, caCert, err := newCA()
+	if err != nil {
+		panic(err)
+	}
+
+	if err := writeCert(filepath.Join(outDir, "ca.pem"), caCert.Raw); err != nil {
+		panic(err)
+	}
+
+	serverTemplate := leafTemplate("ssosage", x509.ExtKeyUsageServerAuth)
+
+	for _, host := range strings.Split(hosts, ",") {
+		if ip := net.ParseIP(host); ip != nil {
+			serverTemplate.IPAddresses = append(serverTemplate.IPAddresses, ip)
+		} else if host != "" {
+			serverTemplate.DNSNames = append(serverTemplate.DNSNames, host)
+		}
+	}
+
+	if err := writeLeaf(outDir, "server", serverTemplate, caCert, caKey); err != nil {
+		panic(err)
+	}
+
+	if err := writeLeaf(outDir, "client", leafTemplate("ssosage-client", x509.ExtKeyUsageClientAuth), caCert, caKey); err != nil {
+		panic(err)
+	}
+
+	fmt.Println("certificates written to", outDir)
+}
+
+func newCA() (*ecdsa.PrivateKey, *x509.Certificate, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	template := &x509.Certificate{
+		SerialNumber:          serialNumber(),
+		Subject:               pkix.Name{CommonName: "ssosage local ca"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(365 * 24 * time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return key, cert, nil
+}
+
+func leafTemplate(commonName string, usage x509.ExtKeyUsage) *x509.Certificate {
+	return &x509.Certificate{
+		SerialNumber: serialNumber(),
+		Subject:      pkix.Name{CommonName: commonName},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(365 * 24 * time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{usage},
+	}
+}
+
+func writeLeaf(outDir string, name string, template *x509.Certificate, caCert *x509.Certificate, caKey *ecdsa.PrivateKey) error {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return err
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, caCert, &key.PublicKey, caKey)
+	if err != nil {
+		return err
+	}
+
+	if err := writeCert(filepath.Join(outDir, name+".pem"), der); err != nil {
+		return err
+	}
+
+	keyDer, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return err
+	}
+
+	return os.WriteFile(
+		filepath.Join(outDir, name+"-key.pem"),
+		pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDer}),
+		0o600,
+	)
+}
+
+func writeCert(path string, der []byte) error {
+	return os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o644)
+}
+
+func serialNumber() *big.Int {
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		panic(err)
+	}
+
+	return serial
+}
@@ -0,0 +1,359 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"ssosage/internal/storage/schema"
+
+	"github.com/golang-migrate/migrate/v4"
+)
+
+type migration struct {
+	version uint
+	name    string
+}
+
+// schemaMigrator is the part of migrate.Migrate the commands use
+type schemaMigrator interface {
+	Version() (uint, bool, error)
+	Up() error
+	Steps(n int) error
+	Migrate(version uint) error
+	Force(version int) error
+}
+
+// versionReader stands in for migrate.Migrate on dry runs, it only reads the version and never creates the database
+type versionReader struct {
+	driver      string
+	storagePath string
+	table       string
+}
+
+var errDryRun = errors.New("dry run doesn't migrate")
+
+func (r versionReader) Version() (uint, bool, error) {
+	return schema.ReadVersion(r.driver, r.storagePath, r.table)
+}
+
+func (r versionReader) Up() error          { return errDryRun }
+func (r versionReader) Steps(int) error    { return errDryRun }
+func (r versionReader) Migrate(uint) error { return errDryRun }
+func (r versionReader) Force(int) error    { return errDryRun }
+
+type commander struct {
+	m          schemaMigrator
+	migrations []migration
+	dryRun     bool
+	out        io.Writer
+}
+
+func (c commander) run(command string, args []string) error {
+	switch command {
+	case "up":
+		n, err := optionalCount(args, 0)
+
+		if err != nil {
+			return err
+		}
+
+		return c.up(n)
+	case "down":
+		n, err := optionalCount(args, 1)
+
+		if err != nil {
+			return err
+		}
+
+		return c.down(n)
+	case "goto":
+		v, err := requiredVersion(command, args)
+
+		if err != nil {
+			return err
+		}
+
+		return c.goTo(v)
+	case "force":
+		v, err := requiredVersion(command, args)
+
+		if err != nil {
+			return err
+		}
+
+		return c.force(v)
+	case "version":
+		if len(args) > 0 {
+			return fmt.Errorf("%w: version takes no arguments", errUsage)
+		}
+
+		return c.version()
+	case "status":
+		if len(args) > 0 {
+			return fmt.Errorf("%w: status takes no arguments", errUsage)
+		}
+
+		return c.status()
+	}
+
+	return fmt.Errorf("%w: unknown command %q", errUsage, command)
+}
+
+// up with n == 0 applies everything pending
+func (c commander) up(n int) error {
+	current, err := c.cleanVersion()
+
+	if err != nil {
+		return err
+	}
+
+	var pending []migration
+
+	for _, mg := range c.migrations {
+		if current == nil || mg.version > *current {
+			pending = append(pending, mg)
+		}
+	}
+
+	if n > 0 {
+		if n > len(pending) {
+			return fmt.Errorf("only %d pending migrations, can't apply %d", len(pending), n)
+		}
+
+		pending = pending[:n]
+	}
+
+	return c.apply("up", pending, func() error {
+		if n == 0 {
+			return c.m.Up()
+		}
+
+		return c.m.Steps(n)
+	})
+}
+
+func (c commander) down(n int) error {
+	current, err := c.cleanVersion()
+
+	if err != nil {
+		return err
+	}
+
+	applied := c.applied(current)
+
+	if n > len(applied) {
+		return fmt.Errorf("only %d applied migrations, can't roll back %d", len(applied), n)
+	}
+
+	return c.apply("down", applied[:n], func() error {
+		return c.m.Steps(-n)
+	})
+}
+
+func (c commander) goTo(v uint) error {
+	if !slices.ContainsFunc(c.migrations, func(mg migration) bool { return mg.version == v }) {
+		return fmt.Errorf("no migration with version %d", v)
+	}
+
+	current, err := c.cleanVersion()
+
+	if err != nil {
+		return err
+	}
+
+	if current != nil && v < *current {
+		var rollback []migration
+
+		for _, mg := range c.applied(current) {
+			if mg.version > v {
+				rollback = append(rollback, mg)
+			}
+		}
+
+		return c.apply("down", rollback, func() error {
+			return c.m.Migrate(v)
+		})
+	}
+
+	var pending []migration
+
+	for _, mg := range c.migrations {
+		if (current == nil || mg.version > *current) && mg.version <= v {
+			pending = append(pending, mg)
+		}
+	}
+
+	return c.apply("up", pending, func() error {
+		return c.m.Migrate(v)
+	})
+}
+
+func (c commander) force(v uint) error {
+	if c.dryRun {
+		fmt.Fprintf(c.out, "would force version %d\n", v)
+
+		return nil
+	}
+
+	if err := c.m.Force(int(v)); err != nil {
+		return fmt.Errorf("failed to force version %d: %w", v, err)
+	}
+
+	fmt.Fprintf(c.out, "forced version %d\n", v)
+
+	return nil
+}
+
+func (c commander) version() error {
+	v, dirty, err := c.m.Version()
+
+	if errors.Is(err, migrate.ErrNilVersion) {
+		fmt.Fprintln(c.out, "no migrations applied")
+
+		return nil
+	}
+
+	if err != nil {
+		return fmt.Errorf("failed to read version: %w", err)
+	}
+
+	if dirty {
+		fmt.Fprintf(c.out, "%d (dirty)\n", v)
+
+		return nil
+	}
+
+	fmt.Fprintln(c.out, v)
+
+	return nil
+}
+
+func (c commander) status() error {
+	v, dirty, err := c.m.Version()
+
+	if err != nil && !errors.Is(err, migrate.ErrNilVersion) {
+		return fmt.Errorf("failed to read version: %w", err)
+	}
+
+	applied := err == nil
+
+	for _, mg := range c.migrations {
+		state := "pending"
+
+		switch {
+		case applied && mg.version == v && dirty:
+			state = "dirty"
+		case applied && mg.version <= v:
+			state = "applied"
+		}
+
+		fmt.Fprintf(c.out, "%-8s %d_%s\n", state, mg.version, mg.name)
+	}
+
+	return nil
+}
+
+func (c commander) apply(direction string, migrations []migration, run func() error) error {
+	if len(migrations) == 0 {
+		fmt.Fprintln(c.out, "no change")
+
+		return nil
+	}
+
+	if c.dryRun {
+		for _, mg := range migrations {
+			fmt.Fprintf(c.out, "would apply %d_%s %s\n", mg.version, mg.name, direction)
+		}
+
+		return nil
+	}
+
+	if err := run(); err != nil {
+		if errors.Is(err, migrate.ErrNoChange) {
+			fmt.Fprintln(c.out, "no change")
+
+			return nil
+		}
+
+		return fmt.Errorf("failed to migrate %s: %w", direction, err)
+	}
+
+	for _, mg := range migrations {
+		fmt.Fprintf(c.out, "applied %d_%s %s\n", mg.version, mg.name, direction)
+	}
+
+	return nil
+}
+
+// cleanVersion returns nil when nothing is applied yet and fails on a dirty database
+func (c commander) cleanVersion() (*uint, error) {
+	v, dirty, err := c.m.Version()
+
+	if errors.Is(err, migrate.ErrNilVersion) {
+		return nil, nil
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("failed to read version: %w", err)
+	}
+
+	if dirty {
+		return nil, fmt.Errorf("database is dirty at version %d, fix it by hand and run force", v)
+	}
+
+	return &v, nil
+}
+
+// applied lists migrations up to current, the latest first
+func (c commander) applied(current *uint) []migration {
+	var applied []migration
+
+	if current == nil {
+		return nil
+	}
+
+	for _, mg := range slices.Backward(c.migrations) {
+		if mg.version <= *current {
+			applied = append(applied, mg)
+		}
+	}
+
+	return applied
+}
+
+// listMigrations reads versions and names of the migrations in order
+func listMigrations(driver string, dir string) ([]migration, error) {
+	src, err := schema.Source(driver, dir)
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer src.Close()
+
+	var migrations []migration
+
+	v, err := src.First()
+
+	for err == nil {
+		r, name, readErr := src.ReadUp(v)
+
+		if readErr != nil {
+			return nil, readErr
+		}
+
+		r.Close()
+
+		migrations = append(migrations, migration{version: v, name: name})
+
+		v, err = src.Next(v)
+	}
+
+	if !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+
+	return migrations, nil
+}
@@ -4,45 +4,153 @@ import (
 	"errors"
 	"flag"
 	"fmt"
+	"io"
+	"os"
 	"ssosage/internal/config/migrator"
+	"ssosage/internal/storage/schema"
+	"strconv"
+)
 
-	"github.com/golang-migrate/migrate/v4"
-	_ "github.com/golang-migrate/migrate/v4/database/sqlite3"
-	_ "github.com/golang-migrate/migrate/v4/source/file"
+const (
+	exitOK    = 0
+	exitError = 1
+	exitUsage = 2
 )
 
+const usage = `usage: migrator --config=path [--dry-run] [command]
+
+migrations embedded in the binary are used unless migrations_path is set
+
+commands:
+  up [n]      apply all pending migrations or the next n (default)
+  down [n]    roll back the last n migrations, 1 by default
+  goto V      migrate up or down to version V
+  force V     set version V and clear the dirty flag without running anything
+  version     print the current version
+  status      list applied and pending migrations
+`
+
+var errUsage = errors.New("invalid usage")
+
 func main() {
+	os.Exit(run(os.Args[1:], os.Stdout, os.Stderr))
+}
+
+func run(args []string, stdout io.Writer, stderr io.Writer) int {
+	flags := flag.NewFlagSet("migrator", flag.ContinueOnError)
+	flags.Usage = func() { fmt.Fprint(stderr, usage) }
+	flags.SetOutput(stderr)
 
 	var configPath string
-	flag.StringVar(&configPath, "config", "", "path to config file")
-	flag.Parse()
+	var dryRun bool
+
+	flags.StringVar(&configPath, "config", "", "path to config file")
+	flags.BoolVar(&dryRun, "dry-run", false, "print what would be done without touching the database")
+
+	if err := flags.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return exitOK
+		}
+
+		return exitUsage
+	}
 
-	cfg := migrator.MustLoad(configPath)
+	cfg, err := migrator.Load(configPath)
+
+	if err != nil {
+		fmt.Fprintln(stderr, err)
+
+		return exitUsage
+	}
 
 	if cfg.StoragePath == "" {
-		panic("storage path is required")
+		fmt.Fprintln(stderr, "storage path is required")
+
+		return exitUsage
+	}
+
+	if _, err := schema.DatabaseURL(cfg.StorageDriver, cfg.StoragePath, cfg.MigrationsTable); err != nil {
+		fmt.Fprintln(stderr, err)
+
+		return exitUsage
+	}
+
+	command, commandArgs := "up", []string(nil)
+
+	if flags.NArg() > 0 {
+		command, commandArgs = flags.Arg(0), flags.Args()[1:]
 	}
 
-	if cfg.MigrationsPath == "" {
-		panic("migrations path is required")
+	var m schemaMigrator = versionReader{driver: cfg.StorageDriver, storagePath: cfg.StoragePath, table: cfg.MigrationsTable}
+
+	// opening golang-migrate creates the database and its migrations table, a dry run only reads the version
+	if !dryRun {
+		opened, err := schema.New(cfg.StorageDriver, cfg.StoragePath, cfg.MigrationsTable, cfg.MigrationsPath)
+
+		if err != nil {
+			fmt.Fprintln(stderr, "failed to open migrations:", err)
+
+			return exitError
+		}
+
+		defer opened.Close()
+
+		m = opened
 	}
 
-	m, err := migrate.New(
-		"file://"+cfg.MigrationsPath,
-		fmt.Sprintf("sqlite3://%s?x-migrations-table=%s", cfg.StoragePath, cfg.MigrationsTable),
-	)
+	migrations, err := listMigrations(cfg.StorageDriver, cfg.MigrationsPath)
+
 	if err != nil {
-		panic(err)
+		fmt.Fprintln(stderr, "failed to list migrations:", err)
+
+		return exitError
+	}
+
+	c := commander{m: m, migrations: migrations, dryRun: dryRun, out: stdout}
+
+	if err := c.run(command, commandArgs); err != nil {
+		if errors.Is(err, errUsage) {
+			fmt.Fprintf(stderr, "%v\n\n%s", err, usage)
+
+			return exitUsage
+		}
+
+		fmt.Fprintln(stderr, err)
+
+		return exitError
 	}
 
-	if err := m.Up(); err != nil {
-		if errors.Is(err, migrate.ErrNoChange) {
-			fmt.Println("no migrations to apply")
+	return exitOK
+}
+
+// optionalCount parses the n of up and down
+func optionalCount(args []string, fallback int) (int, error) {
+	switch len(args) {
+	case 0:
+		return fallback, nil
+	case 1:
+		n, err := strconv.Atoi(args[0])
 
-			return
+		if err != nil || n <= 0 {
+			return 0, fmt.Errorf("%w: n must be a positive number, got %q", errUsage, args[0])
 		}
 
-		panic(err)
+		return n, nil
+	}
+
+	return 0, fmt.Errorf("%w: too many arguments", errUsage)
+}
+
+func requiredVersion(command string, args []string) (uint, error) {
+	if len(args) != 1 {
+		return 0, fmt.Errorf("%w: %s needs exactly one version", errUsage, command)
+	}
+
+	v, err := strconv.ParseUint(args[0], 10, 64)
+
+	if err != nil {
+		return 0, fmt.Errorf("%w: bad version %q", errUsage, args[0])
 	}
 
+	return uint(v), nil
 }
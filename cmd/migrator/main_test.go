@@ -0,0 +1,203 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// migrationFiles are small sqlite migrations, the third one fails halfway through
+var migrationFiles = map[string]string{
+	"1_clients.up.sql":   "CREATE TABLE clients (id INTEGER PRIMARY KEY);",
+	"1_clients.down.sql": "DROP TABLE clients;",
+	"2_apps.up.sql":      "CREATE TABLE apps (id INTEGER PRIMARY KEY);",
+	"2_apps.down.sql":    "DROP TABLE apps;",
+	"3_broken.up.sql":    "CREATE TABLE broken (id INTEGER PRIMARY KEY); INSERT INTO nothing VALUES (1);",
+	"3_broken.down.sql":  "DROP TABLE broken;",
+}
+
+// newMigrator writes the migrations and a config for a temp sqlite database, it returns the config path
+func newMigrator(t *testing.T, files map[string]string) string {
+	t.Helper()
+
+	dir := t.TempDir()
+	migrationsDir := filepath.Join(dir, "migrations")
+
+	if err := os.Mkdir(migrationsDir, 0o700); err != nil {
+		t.Fatalf("failed to create migrations dir: %v", err)
+	}
+
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(migrationsDir, name), []byte(content), 0o600); err != nil {
+			t.Fatalf("failed to write %s: %v", name, err)
+		}
+	}
+
+	configPath := filepath.Join(dir, "migrator.json")
+	config := fmt.Sprintf(`{"storage_path": %q, "migrations_path": %q}`, filepath.Join(dir, "ssosage.db"), migrationsDir)
+
+	if err := os.WriteFile(configPath, []byte(config), 0o600); err != nil {
+		t.Fatalf("failed to write config: %v", err)
+	}
+
+	return configPath
+}
+
+// runMigrator runs the migrator and checks its exit code, it returns what it printed
+func runMigrator(t *testing.T, code int, args ...string) (string, string) {
+	t.Helper()
+
+	var stdout, stderr bytes.Buffer
+
+	if got := run(args, &stdout, &stderr); got != code {
+		t.Fatalf("%v: expected exit code %d, got %d, stdout: %q, stderr: %q", args, code, got, stdout.String(), stderr.String())
+	}
+
+	return stdout.String(), stderr.String()
+}
+
+func TestCommands(t *testing.T) {
+	files := map[string]string{}
+
+	for name, content := range migrationFiles {
+		if !strings.HasPrefix(name, "3_") {
+			files[name] = content
+		}
+	}
+
+	config := "--config=" + newMigrator(t, files)
+
+	tests := []struct {
+		name   string
+		args   []string
+		stdout string
+	}{
+		{"nothing applied", []string{"version"}, "no migrations applied\n"},
+		{"status before up", []string{"status"}, "pending  1_clients\npending  2_apps\n"},
+		{"dry run up", []string{"--dry-run", "up"}, "would apply 1_clients up\nwould apply 2_apps up\n"},
+		{"dry run changes nothing", []string{"version"}, "no migrations applied\n"},
+		{"up one", []string{"up", "1"}, "applied 1_clients up\n"},
+		{"status after one", []string{"status"}, "applied  1_clients\npending  2_apps\n"},
+		{"up the rest", []string{"up"}, "applied 2_apps up\n"},
+		{"up with nothing pending", []string{"up"}, "no change\n"},
+		{"version after up", []string{"version"}, "2\n"},
+		{"dry run down", []string{"--dry-run", "down", "2"}, "would apply 2_apps down\nwould apply 1_clients down\n"},
+		{"down one by default", []string{"down"}, "applied 2_apps down\n"},
+		{"goto up", []string{"goto", "2"}, "applied 2_apps up\n"},
+		{"goto current", []string{"goto", "2"}, "no change\n"},
+		{"goto down", []string{"goto", "1"}, "applied 2_apps down\n"},
+		{"dry run force", []string{"--dry-run", "force", "2"}, "would force version 2\n"},
+		{"force", []string{"force", "2"}, "forced version 2\n"},
+		{"version after force", []string{"version"}, "2\n"},
+		{"status after force", []string{"status"}, "applied  1_clients\napplied  2_apps\n"},
+		// the default command is up
+		{"no command", nil, "no change\n"},
+	}
+
+	for _, tt := range tests {
+		if stdout, _ := runMigrator(t, exitOK, append([]string{config}, tt.args...)...); stdout != tt.stdout {
+			t.Fatalf("%s: expected %q, got %q", tt.name, tt.stdout, stdout)
+		}
+	}
+}
+
+func TestDryRunCreatesNothing(t *testing.T) {
+	configPath := newMigrator(t, migrationFiles)
+	config := "--config=" + configPath
+
+	if stdout, _ := runMigrator(t, exitOK, config, "--dry-run", "up", "2"); stdout != "would apply 1_clients up\nwould apply 2_apps up\n" {
+		t.Fatalf("unexpected dry run output: %q", stdout)
+	}
+
+	if stdout, _ := runMigrator(t, exitOK, config, "--dry-run", "status"); stdout != "pending  1_clients\npending  2_apps\npending  3_broken\n" {
+		t.Fatalf("unexpected dry run status: %q", stdout)
+	}
+
+	if _, err := os.Stat(filepath.Join(filepath.Dir(configPath), "ssosage.db")); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("expected a dry run to leave the database uncreated, got: %v", err)
+	}
+}
+
+func TestFailedMigration(t *testing.T) {
+	config := "--config=" + newMigrator(t, migrationFiles)
+
+	stdout, stderr := runMigrator(t, exitError, config, "up")
+
+	if stdout != "" || !strings.Contains(stderr, "failed to migrate up") {
+		t.Fatalf("unexpected output, stdout: %q, stderr: %q", stdout, stderr)
+	}
+
+	if stdout, _ := runMigrator(t, exitOK, config, "status"); stdout != "applied  1_clients\napplied  2_apps\ndirty    3_broken\n" {
+		t.Fatalf("expected the broken migration to be dirty, got %q", stdout)
+	}
+
+	if stdout, _ := runMigrator(t, exitOK, config, "version"); stdout != "3 (dirty)\n" {
+		t.Fatalf("expected a dirty version, got %q", stdout)
+	}
+
+	// nothing runs on a dirty database until it is forced
+	for _, args := range [][]string{{"up"}, {"down"}, {"goto", "1"}} {
+		if _, stderr := runMigrator(t, exitError, append([]string{config}, args...)...); !strings.Contains(stderr, "database is dirty at version 3") {
+			t.Fatalf("%v: expected a dirty database error, got %q", args, stderr)
+		}
+	}
+
+	runMigrator(t, exitOK, config, "force", "2")
+
+	if stdout, _ := runMigrator(t, exitOK, config, "down", "2"); stdout != "applied 2_apps down\napplied 1_clients down\n" {
+		t.Fatalf("expected both migrations rolled back, got %q", stdout)
+	}
+}
+
+func TestCommandErrors(t *testing.T) {
+	config := "--config=" + newMigrator(t, migrationFiles)
+
+	tests := []struct {
+		name string
+		args []string
+		code int
+	}{
+		{"no config", []string{"status"}, exitUsage},
+		{"missing config", []string{"--config=" + filepath.Join(t.TempDir(), "missing.json"), "status"}, exitUsage},
+		{"unknown flag", []string{config, "--force", "status"}, exitUsage},
+		{"unknown command", []string{config, "sideways"}, exitUsage},
+		{"bad count", []string{config, "up", "zero"}, exitUsage},
+		{"negative count", []string{config, "down", "-1"}, exitUsage},
+		{"too many arguments", []string{config, "up", "1", "2"}, exitUsage},
+		{"goto without version", []string{config, "goto"}, exitUsage},
+		{"bad version", []string{config, "force", "v2"}, exitUsage},
+		{"status with arguments", []string{config, "status", "all"}, exitUsage},
+		{"version with arguments", []string{config, "version", "now"}, exitUsage},
+		{"help", []string{"--help"}, exitOK},
+		{"goto unknown version", []string{config, "goto", "7"}, exitError},
+		{"down past the start", []string{config, "down"}, exitError},
+		{"up past the end", []string{config, "up", "4"}, exitError},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			runMigrator(t, tt.code, tt.args...)
+		})
+	}
+
+	// bad usage comes with the usage text
+	if _, stderr := runMigrator(t, exitUsage, config, "sideways"); !strings.Contains(stderr, "usage: migrator") {
+		t.Fatalf("expected usage, got %q", stderr)
+	}
+}
+
+func TestBadStoragePath(t *testing.T) {
+	configPath := filepath.Join(t.TempDir(), "migrator.json")
+
+	if err := os.WriteFile(configPath, []byte(`{"storage_driver": "mysql", "storage_path": "db"}`), 0o600); err != nil {
+		t.Fatalf("failed to write config: %v", err)
+	}
+
+	if _, stderr := runMigrator(t, exitUsage, "--config="+configPath, "status"); stderr == "" {
+		t.Fatalf("expected an error for an unknown driver")
+	}
+}
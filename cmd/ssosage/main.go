@@ -2,6 +2,7 @@ package main
 
 import (
 	"context"
+	"encoding/base64"
 	"flag"
 	"fmt"
 	"log/slog"
@@ -10,11 +11,23 @@ import (
 	"os/signal"
 	config "ssosage/internal/config/ssosage"
 	"ssosage/internal/helpers"
+	"ssosage/internal/interceptors/auth"
+	"ssosage/internal/interceptors/ratelimit"
+	"ssosage/internal/interceptors/redact"
 	"ssosage/internal/interfaces"
+	"ssosage/internal/notify"
+	"ssosage/internal/secretbox"
 	"ssosage/internal/server"
 	service "ssosage/internal/services/ssosage"
+	"ssosage/internal/storage/cache"
+	"ssosage/internal/storage/memory"
+	"ssosage/internal/storage/postgres"
+	"ssosage/internal/storage/schema"
 	"ssosage/internal/storage/sqlite"
+	"ssosage/internal/tlsconfig"
+	"ssosage/internal/webauthn"
 	"syscall"
+	"time"
 
 	argon2 "ssosage/internal/hasher/argon2"
 	bcrypt "ssosage/internal/hasher/bcrypt"
@@ -24,6 +37,7 @@ import (
 	"github.com/hyperfyodor/ssosage_proto"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
 	"google.golang.org/grpc/status"
 )
 
@@ -34,16 +48,72 @@ func main() {
 
 	cfg := config.MustLoad(configPath)
 	log := setupLogger(cfg.Env)
-	storage, err := sqlite.New(cfg.StoragePath)
+
+	if cfg.AutoMigrate && cfg.StorageDriver != config.StorageMemory {
+		from, to, err := schema.Migrate(cfg.StorageDriver, cfg.StoragePath, cfg.MigrationsTable)
+
+		if err != nil {
+			panic("failed to migrate storage: " + err.Error())
+		}
+
+		log.Info("storage migrated", "from", from, "to", to)
+	}
+
+	storage, err := setupStorage(cfg)
 
 	if err != nil {
-		panic("failed to create storage")
+		panic("failed to create storage: " + err.Error())
 	}
 
 	hasher := setupHasher(cfg.PasswordHasher)
 	log.Info("created hasher", "hasher", fmt.Sprintf("%T", hasher))
 
-	ssosage := service.New(log, storage, storage, storage, storage, hasher)
+	apps := cache.NewApps(storage, storage, storage, cache.Options{
+		TTL:         cfg.AppCache.TTL,
+		NegativeTTL: cfg.AppCache.NegativeTTL,
+		Size:        cfg.AppCache.Size,
+	})
+
+	sealer := setupSealer(log, cfg.TOTP.EncryptionKey)
+	notifier := setupNotifier(log, cfg.Notifier)
+
+	deps := service.StorageDeps(storage)
+	deps.AppSaver, deps.AppProvider, deps.AppUpdater = apps, apps, apps
+	deps.Hasher, deps.Sealer, deps.Notifier = hasher, sealer, notifier
+
+	ssosage := service.New(log, deps, service.Policies{
+		Lockout: service.LockoutPolicy{
+			ClientMaxAttempts: cfg.Lockout.ClientMaxAttempts,
+			IPMaxAttempts:     cfg.Lockout.IPMaxAttempts,
+			BaseDelay:         cfg.Lockout.BaseDelay,
+			MaxDelay:          cfg.Lockout.MaxDelay,
+			LockoutDuration:   cfg.Lockout.LockoutDuration,
+			Window:            cfg.Lockout.Window,
+		},
+		MFA: service.MFAPolicy{
+			Issuer:   cfg.TOTP.Issuer,
+			Skew:     cfg.TOTP.Skew,
+			LoginTTL: cfg.Login.TransactionTTL,
+			WebAuthn: setupRelyingParty(log, cfg.WebAuthn),
+		},
+		PasswordReset: service.PasswordResetPolicy{
+			TokenTTL:     cfg.PasswordReset.TokenTTL,
+			Link:         cfg.PasswordReset.Link,
+			NameFallback: cfg.PasswordReset.NameFallback,
+		},
+		Email: service.EmailPolicy{
+			Key:      setupEmailKey(log, cfg.Email.SigningKey),
+			TokenTTL: cfg.Email.TokenTTL,
+			Link:     cfg.Email.Link,
+		},
+	})
+
+	backgroundCtx, stopBackground := context.WithCancel(context.Background())
+	go purgeLogins(backgroundCtx, ssosage, cfg.Login.TransactionTTL)
+
+	if cfg.AppCache.StatsInterval > 0 {
+		go logCacheStats(backgroundCtx, log, apps, cfg.AppCache.StatsInterval)
+	}
 
 	loggingOpts := []logging.Option{
 		logging.WithLogOnEvents(
@@ -59,10 +129,38 @@ func main() {
 		}),
 	}
 
-	grpcServer := grpc.NewServer(grpc.ChainUnaryInterceptor(
-		recovery.UnaryServerInterceptor(recoveryOpts...),
-		logging.UnaryServerInterceptor(interceptorLogger(log), loggingOpts...),
-	))
+	limiter := setupRateLimiter(cfg.RateLimit)
+	authorizer := setupAuthorizer(log, ssosage, cfg.Auth)
+
+	serverOpts := []grpc.ServerOption{
+		grpc.ChainUnaryInterceptor(
+			recovery.UnaryServerInterceptor(recoveryOpts...),
+			logging.UnaryServerInterceptor(redact.Logger(log, cfg.LogRedactFields), loggingOpts...),
+			limiter.UnaryServerInterceptor(),
+			authorizer.UnaryServerInterceptor(),
+		),
+	}
+
+	if cfg.TLS.CertFile != "" {
+		reloader, err := tlsconfig.NewReloader(log, tlsconfig.Config{
+			CertFile:     cfg.TLS.CertFile,
+			KeyFile:      cfg.TLS.KeyFile,
+			ClientCAFile: cfg.TLS.ClientCAFile,
+			MinVersion:   cfg.TLS.MinVersion,
+		})
+
+		if err != nil {
+			log.Error("failed to load tls files, generate them with `make certs` or leave tls.cert_file empty to serve without tls", helpers.SlErr(err))
+			os.Exit(1)
+		}
+
+		serverOpts = append(serverOpts, grpc.Creds(credentials.NewTLS(reloader.ServerConfig())))
+		log.Info("tls enabled", "mutual", cfg.TLS.ClientCAFile != "")
+	} else {
+		log.Warn("tls is disabled, credentials are sent in cleartext")
+	}
+
+	grpcServer := grpc.NewServer(serverOpts...)
 
 	grpcHadnler := server.New(ssosage)
 
@@ -88,8 +186,10 @@ func main() {
 	signal.Notify(stop, syscall.SIGTERM, syscall.SIGINT)
 
 	<-stop
+	stopBackground()
 	grpcServer.Stop()
 	storage.Stop()
+	log.Info("app cache stats", slog.Any("stats", apps.Stats()))
 	log.Info("Stopped ;)")
 
 }
@@ -115,6 +215,26 @@ func setupLogger(env string) *slog.Logger {
 	return log
 }
 
+func setupStorage(cfg *config.Config) (interfaces.Storage, error) {
+	switch cfg.StorageDriver {
+	case config.StorageSqlite:
+		return sqlite.New(cfg.StoragePath, sqlite.Options{
+			BusyTimeout:        cfg.Sqlite.BusyTimeout,
+			JournalMode:        cfg.Sqlite.JournalMode,
+			DisableForeignKeys: cfg.Sqlite.DisableForeignKeys,
+			MaxOpenConns:       cfg.Sqlite.MaxOpenConns,
+			MaxIdleConns:       cfg.Sqlite.MaxIdleConns,
+			ConnMaxLifetime:    cfg.Sqlite.ConnMaxLifetime,
+		})
+	case config.StoragePostgres:
+		return postgres.New(cfg.StoragePath)
+	case config.StorageMemory:
+		return memory.New(), nil
+	}
+
+	return nil, fmt.Errorf("unknown storage driver %q", cfg.StorageDriver)
+}
+
 func setupHasher(passwordHasher string) interfaces.PasswordHasher {
 	switch passwordHasher {
 	case "bcrypt":
@@ -126,8 +246,144 @@ func setupHasher(passwordHasher string) interfaces.PasswordHasher {
 	return &bcrypt.BcryptHasher{}
 }
 
-func interceptorLogger(l *slog.Logger) logging.Logger {
-	return logging.LoggerFunc(func(ctx context.Context, lvl logging.Level, msg string, fields ...any) {
-		l.Log(ctx, slog.Level(lvl), msg, fields...)
-	})
+// purgeLogins deletes expired login transactions and password resets every interval until ctx is done
+func purgeLogins(ctx context.Context, ssosage *service.Ssosage, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			// failures are logged by the service and retried on the next tick
+			ssosage.PurgeExpiredLogins(ctx)
+			ssosage.PurgeExpiredPasswordResets(ctx)
+		}
+	}
+}
+
+// logCacheStats logs the app cache counters every interval they changed in until ctx is done
+func logCacheStats(ctx context.Context, log *slog.Logger, apps *cache.Apps, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	var last cache.Stats
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if stats := apps.Stats(); stats != last {
+				log.Info("app cache stats", slog.Any("stats", stats))
+				last = stats
+			}
+		}
+	}
+}
+
+// setupSealer returns nil when no key is configured, which leaves totp unavailable
+func setupSealer(log *slog.Logger, key string) interfaces.SecretSealer {
+	if key == "" {
+		log.Warn("totp encryption key is not set, totp is unavailable")
+
+		return nil
+	}
+
+	box, err := secretbox.FromBase64(key)
+
+	if err != nil {
+		panic("invalid totp encryption key: " + err.Error())
+	}
+
+	return box
+}
+
+// setupEmailKey returns nil when no key is configured, which leaves email verification unavailable
+func setupEmailKey(log *slog.Logger, key string) []byte {
+	if key == "" {
+		log.Warn("email signing key is not set, email verification is unavailable")
+
+		return nil
+	}
+
+	decoded, err := base64.StdEncoding.DecodeString(key)
+
+	if err != nil {
+		panic("invalid email signing key: " + err.Error())
+	}
+
+	if len(decoded) < 32 {
+		panic("email signing key is shorter than 32 bytes")
+	}
+
+	return decoded
+}
+
+// setupNotifier returns nil when no notifier is configured, which leaves password resets unavailable
+func setupNotifier(log *slog.Logger, cfg config.Notifier) interfaces.Notifier {
+	switch cfg.Kind {
+	case "":
+		log.Warn("notifier is not set, password resets are unavailable")
+
+		return nil
+	case "smtp":
+		return notify.NewSMTP(notify.SMTPOptions{
+			Host:            cfg.SMTP.Host,
+			Port:            cfg.SMTP.Port,
+			Username:        cfg.SMTP.Username,
+			Password:        cfg.SMTP.Password,
+			From:            cfg.SMTP.From,
+			InsecureSkipTLS: cfg.SMTP.InsecureSkipTLS,
+		})
+	case "file":
+		if cfg.FilePath == "" {
+			panic("notifier file path is not set")
+		}
+
+		log.Warn("notifications are written to a file, for development only", "path", cfg.FilePath)
+
+		return notify.NewFile(cfg.FilePath)
+	case "log":
+		log.Warn("notifications are written to the log, for development only")
+
+		return notify.NewLog(log)
+	}
+
+	panic("unknown notifier kind: " + cfg.Kind)
+}
+
+func setupRelyingParty(log *slog.Logger, cfg config.WebAuthn) webauthn.RelyingParty {
+	if cfg.RPID == "" {
+		log.Warn("webauthn rp id is not set, passkeys are unavailable")
+
+		return webauthn.RelyingParty{}
+	}
+
+	if len(cfg.Origins) == 0 {
+		panic("webauthn origins are not set")
+	}
+
+	return webauthn.RelyingParty{ID: cfg.RPID, Name: cfg.RPName, Origins: cfg.Origins}
+}
+
+func setupRateLimiter(cfg config.RateLimit) *ratelimit.Limiter {
+	methods := make(map[string]ratelimit.Limit, len(cfg.Methods))
+
+	for method, limit := range cfg.Methods {
+		methods[method] = ratelimit.Limit{Rate: limit.Rate, Burst: limit.Burst}
+	}
+
+	return ratelimit.New(ratelimit.Limit{Rate: cfg.Rate, Burst: cfg.Burst}, methods)
+}
+
+func setupAuthorizer(log *slog.Logger, ssosage *service.Ssosage, cfg config.Auth) *auth.Authorizer {
+	methods := make(map[string]auth.Access, len(cfg.Methods))
+
+	for method, access := range cfg.Methods {
+		methods[method] = auth.Access(access)
+	}
+
+	return auth.New(log, ssosage, cfg.AdminAPIKeys, cfg.AdminApp, cfg.AdminRole, methods)
 }
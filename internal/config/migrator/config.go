@@ -1,32 +1,38 @@
 package migrator
 
 import (
+	"errors"
+	"fmt"
 	"os"
 
 	"github.com/ilyakaznacheev/cleanenv"
 )
 
+// StoragePath is a file path for sqlite and a connection url for postgres,
+// empty MigrationsPath means the migrations embedded in the binary
 type Config struct {
-	MigrationsPath  string `json:"migrations_path" env-required:"true"`
+	StorageDriver   string `json:"storage_driver" env-default:"sqlite"`
+	MigrationsPath  string `json:"migrations_path"`
 	StoragePath     string `json:"storage_path" env-required:"true"`
 	MigrationsTable string `json:"migrations_table" env-default:"migrations"`
 }
 
-func MustLoad(configPath string) *Config {
+// Load reads the migrator config, the migrator reports errors itself instead of panicking
+func Load(configPath string) (*Config, error) {
 	if configPath == "" {
-		panic("config path is empty")
+		return nil, errors.New("config path is empty")
 	}
 
 	// check if file exists
 	if _, err := os.Stat(configPath); os.IsNotExist(err) {
-		panic("config file does not exist: " + configPath)
+		return nil, fmt.Errorf("config file does not exist: %s", configPath)
 	}
 
 	var cfg Config
 
 	if err := cleanenv.ReadConfig(configPath, &cfg); err != nil {
-		panic("config path is empty: " + err.Error())
+		return nil, fmt.Errorf("failed to read config: %w", err)
 	}
 
-	return &cfg
+	return &cfg, nil
 }
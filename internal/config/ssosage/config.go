@@ -1,7 +1,9 @@
 package ssosage
 
 import (
+	"errors"
 	"os"
+	"time"
 
 	"github.com/ilyakaznacheev/cleanenv"
 )
@@ -12,11 +14,146 @@ const (
 	EnvProd  = "prod"
 )
 
+const (
+	StorageSqlite   = "sqlite"
+	StoragePostgres = "postgres"
+	StorageMemory   = "memory"
+)
+
+// StoragePath is a file path for sqlite and a connection url for postgres, memory ignores it.
+// AutoMigrate applies the migrations embedded in the binary at startup
 type Config struct {
-	StoragePath    string `json:"storage_path" env-required:"true"`
-	GrpcPort       int    `json:"grpc_port" env-default:"3333"`
-	Env            string `json:"env" env-default:"local"`
-	PasswordHasher string `json:"password_hasher" end-default:"bcrypt"`
+	StorageDriver   string        `json:"storage_driver" env-default:"sqlite"`
+	StoragePath     string        `json:"storage_path" env-required:"true"`
+	AutoMigrate     bool          `json:"auto_migrate"`
+	MigrationsTable string        `json:"migrations_table" env-default:"migrations"`
+	Sqlite          Sqlite        `json:"sqlite"`
+	GrpcPort        int           `json:"grpc_port" env-default:"3333"`
+	Env             string        `json:"env" env-default:"local"`
+	PasswordHasher  string        `json:"password_hasher" end-default:"bcrypt"`
+	Lockout         Lockout       `json:"lockout"`
+	RateLimit       RateLimit     `json:"rate_limit"`
+	Auth            Auth          `json:"auth"`
+	TLS             TLS           `json:"tls"`
+	AppCache        AppCache      `json:"app_cache"`
+	TOTP            TOTP          `json:"totp"`
+	Login           Login         `json:"login"`
+	WebAuthn        WebAuthn      `json:"webauthn"`
+	Notifier        Notifier      `json:"notifier"`
+	PasswordReset   PasswordReset `json:"password_reset"`
+	Email           Email         `json:"email"`
+	// proto field names masked in payload logs in addition to passwords, secrets and tokens
+	LogRedactFields []string `json:"log_redact_fields"`
+}
+
+// Sqlite tunes the sqlite connection pool, zero pool sizes keep database/sql defaults
+type Sqlite struct {
+	BusyTimeout        time.Duration `json:"busy_timeout" env-default:"5s"`
+	JournalMode        string        `json:"journal_mode" env-default:"WAL"`
+	DisableForeignKeys bool          `json:"disable_foreign_keys"`
+	MaxOpenConns       int           `json:"max_open_conns"`
+	MaxIdleConns       int           `json:"max_idle_conns"`
+	ConnMaxLifetime    time.Duration `json:"conn_max_lifetime"`
+}
+
+type Lockout struct {
+	ClientMaxAttempts int           `json:"client_max_attempts" env-default:"5"`
+	IPMaxAttempts     int           `json:"ip_max_attempts" env-default:"20"`
+	BaseDelay         time.Duration `json:"base_delay" env-default:"1s"`
+	MaxDelay          time.Duration `json:"max_delay" env-default:"30s"`
+	LockoutDuration   time.Duration `json:"lockout_duration" env-default:"15m"`
+	Window            time.Duration `json:"window" env-default:"15m"`
+}
+
+// RateLimit is requests per second and burst per peer ip, Methods override it per method name
+type RateLimit struct {
+	Rate    float64          `json:"rate" env-default:"20"`
+	Burst   int              `json:"burst" env-default:"40"`
+	Methods map[string]Limit `json:"methods"`
+}
+
+// Auth configures who may call admin methods, Methods maps method name to "public" or "admin"
+type Auth struct {
+	AdminAPIKeys []string          `json:"admin_api_keys"`
+	AdminApp     string            `json:"admin_app"`
+	AdminRole    string            `json:"admin_role" env-default:"admin"`
+	Methods      map[string]string `json:"methods"`
+}
+
+// TLS is off when cert file is empty, client ca file turns on mutual tls
+type TLS struct {
+	CertFile     string `json:"cert_file"`
+	KeyFile      string `json:"key_file"`
+	ClientCAFile string `json:"client_ca_file"`
+	MinVersion   string `json:"min_version" env-default:"1.2"`
+}
+
+// AppCache bounds the in-process cache of app lookups, zero size turns it off
+type AppCache struct {
+	TTL         time.Duration `json:"ttl" env-default:"1m"`
+	NegativeTTL time.Duration `json:"negative_ttl" env-default:"10s"`
+	Size        int           `json:"size" env-default:"1024"`
+	// the hit and miss counters are logged that often when they changed, zero turns it off
+	StatsInterval time.Duration `json:"stats_interval" env-default:"5m"`
+}
+
+// TOTP is available once EncryptionKey is set, a base64 encoded 32 byte key sealing the stored secrets.
+// Skew is the number of 30 second steps a code may be early or late
+type TOTP struct {
+	Issuer        string `json:"issuer" env-default:"ssosage"`
+	EncryptionKey string `json:"encryption_key" env:"TOTP_ENCRYPTION_KEY"`
+	Skew          int    `json:"skew" env-default:"1"`
+}
+
+// Login bounds multi-step logins, unfinished ones are purged every transaction ttl
+type Login struct {
+	TransactionTTL time.Duration `json:"transaction_ttl" env-default:"5m"`
+}
+
+// WebAuthn enables passkeys once RPID is set, the domain they are bound to.
+// Origins are the urls of the pages allowed to use them, like https://login.example.com
+type WebAuthn struct {
+	RPID    string   `json:"rp_id"`
+	RPName  string   `json:"rp_name" env-default:"ssosage"`
+	Origins []string `json:"origins"`
+}
+
+// Notifier delivers messages to clients, Kind is smtp, file or log. Without one password resets are off.
+// file and log keep the messages, reset tokens included, locally and are meant for development
+type Notifier struct {
+	Kind     string `json:"kind"`
+	FilePath string `json:"file_path"`
+	SMTP     SMTP   `json:"smtp"`
+}
+
+type SMTP struct {
+	Host            string `json:"host"`
+	Port            int    `json:"port" env-default:"587"`
+	Username        string `json:"username"`
+	Password        string `json:"password" env:"SMTP_PASSWORD"`
+	From            string `json:"from"`
+	InsecureSkipTLS bool   `json:"insecure_skip_tls"`
+}
+
+// PasswordReset tokens expire after TokenTTL, Link is sent in their place with {token} replaced.
+// NameFallback sends them to the client name of clients without a verified email
+type PasswordReset struct {
+	TokenTTL     time.Duration `json:"token_ttl" env-default:"30m"`
+	Link         string        `json:"link"`
+	NameFallback bool          `json:"name_fallback"`
+}
+
+// Email verification needs a notifier and SigningKey, a base64 encoded key of at least 32 bytes
+// signing the verification tokens. Link is sent in their place with {token} replaced
+type Email struct {
+	SigningKey string        `json:"signing_key" env:"EMAIL_SIGNING_KEY"`
+	TokenTTL   time.Duration `json:"token_ttl" env-default:"24h"`
+	Link       string        `json:"link"`
+}
+
+type Limit struct {
+	Rate  float64 `json:"rate"`
+	Burst int     `json:"burst"`
 }
 
 func MustLoad(configPath string) *Config {
@@ -35,5 +172,19 @@ func MustLoad(configPath string) *Config {
 		panic("config path is empty: " + err.Error())
 	}
 
+	if err := cfg.validate(); err != nil {
+		panic("invalid config: " + err.Error())
+	}
+
 	return &cfg
 }
+
+// validate refuses values the service can't run with
+func (c *Config) validate() error {
+	// unfinished logins are purged on a ticker of this period
+	if c.Login.TransactionTTL <= 0 {
+		return errors.New("login.transaction_ttl must be positive")
+	}
+
+	return nil
+}
@@ -0,0 +1,51 @@
+package ssosage
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// writeConfig writes a json config to a temp file and returns its path
+func writeConfig(t *testing.T, config string) string {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), "ssosage.json")
+
+	if err := os.WriteFile(path, []byte(config), 0o600); err != nil {
+		t.Fatalf("failed to write config: %v", err)
+	}
+
+	return path
+}
+
+func TestMustLoadDefaults(t *testing.T) {
+	cfg := MustLoad(writeConfig(t, `{"storage_path": "./ssosage.db"}`))
+
+	if cfg.Login.TransactionTTL != 5*time.Minute {
+		t.Fatalf("expected the default transaction ttl, got %v", cfg.Login.TransactionTTL)
+	}
+}
+
+func TestMustLoadRefusesNegativeTransactionTTL(t *testing.T) {
+	path := writeConfig(t, `{"storage_path": "./ssosage.db", "login": {"transaction_ttl": -1}}`)
+
+	defer func() {
+		msg, _ := recover().(string)
+
+		if !strings.Contains(msg, "login.transaction_ttl must be positive") {
+			t.Fatalf("expected a transaction ttl panic, got %q", msg)
+		}
+	}()
+
+	MustLoad(path)
+}
+
+func TestValidateRefusesZeroTransactionTTL(t *testing.T) {
+	// a zero in the file reads as the default, only a config built in code gets here with it
+	if err := (&Config{}).validate(); err == nil {
+		t.Fatal("expected a zero transaction ttl to be refused")
+	}
+}
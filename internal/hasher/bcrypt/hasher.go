@@ -1,6 +1,8 @@
 package hasher
 
 import (
+	"errors"
+
 	"golang.org/x/crypto/bcrypt"
 )
 
@@ -17,6 +19,10 @@ func (b *BcryptHasher) Hash(password string) ([]byte, error) {
 func (b *BcryptHasher) Compare(hash []byte, password string) (bool, error) {
 	err := bcrypt.CompareHashAndPassword(hash, []byte(password))
 
+	if errors.Is(err, bcrypt.ErrMismatchedHashAndPassword) {
+		return false, nil
+	}
+
 	if err != nil {
 		return false, err
 	} else {
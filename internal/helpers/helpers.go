@@ -1,8 +1,12 @@
 package helpers
 
 import (
+	"context"
 	"fmt"
 	"log/slog"
+	"net"
+
+	"google.golang.org/grpc/peer"
 )
 
 func WrapErr(s string, e error) error {
@@ -15,3 +19,20 @@ func SlErr(err error) slog.Attr {
 		Value: slog.StringValue(err.Error()),
 	}
 }
+
+// PeerIP returns the ip of the grpc peer without the port, or "" if there is no peer
+func PeerIP(ctx context.Context) string {
+	p, ok := peer.FromContext(ctx)
+
+	if !ok || p.Addr == nil {
+		return ""
+	}
+
+	host, _, err := net.SplitHostPort(p.Addr.String())
+
+	if err != nil {
+		return p.Addr.String()
+	}
+
+	return host
+}
@@ -0,0 +1,285 @@
+package auth
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"log/slog"
+	"path"
+	"slices"
+	"ssosage/internal/helpers"
+	"ssosage/internal/models"
+	"ssosage/internal/services/ssosage"
+	"strings"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// AuthorizationKey is the request header carrying "Bearer <admin api key or admin token>"
+const AuthorizationKey = "authorization"
+
+type Access string
+
+const (
+	Public Access = "public"
+	Admin  Access = "admin"
+)
+
+// DefaultMethods is the authorization table used for methods absent from the config,
+// methods missing from both require admin rights
+var DefaultMethods = map[string]Access{
+	"RegisterClient":           Public,
+	"GenerateToken":            Public,
+	"BeginLogin":               Public,
+	"BeginPasskeyLogin":        Public,
+	"BeginPasskeyRegistration": Public,
+	"CompleteFactor":           Public,
+	"ChangePassword":           Public,
+	"RequestPasswordReset":     Public,
+	"ConfirmPasswordReset":     Public,
+	"SetEmail":                 Public,
+	"SendEmailVerification":    Public,
+	"VerifyEmail":              Public,
+	"EnrollTOTP":               Public,
+	"ConfirmTOTP":              Public,
+	"DisableTOTP":              Public,
+	"RegenerateRecoveryCodes":  Public,
+	"IntrospectToken":          Public,
+	"RegisterApp":              Admin,
+	"ResetPassword":            Admin,
+	"DisableClient":            Admin,
+	"EnableClient":             Admin,
+	"DeleteClient":             Admin,
+	"ListClients":              Admin,
+	"ListApps":                 Admin,
+	"GetApp":                   Admin,
+	"UpdateApp":                Admin,
+	"DeleteApp":                Admin,
+	"ResetTOTP":                Admin,
+	"ListPasskeys":             Admin,
+	"DeletePasskey":            Admin,
+	"GetProfile":               Admin,
+	"UpdateProfile":            Admin,
+	"RegisterOrganization":     Admin,
+	"DeleteOrganization":       Admin,
+	"RegisterGroup":            Admin,
+	"DeleteGroup":              Admin,
+	"AddGroupMember":           Admin,
+	"RemoveGroupMember":        Admin,
+	"GrantRole":                Admin,
+	"RevokeRole":               Admin,
+	"ClientRoles":              Admin,
+}
+
+type TokenVerifier interface {
+	VerifyToken(ctx context.Context, token string) (models.Token, error)
+}
+
+/*
+Authorizer lets calls to public methods through and requires admin rights for the rest.
+Admin is either one of the admin api keys or a token issued by ssosage for
+the admin app with the admin role. The admin app has to have role_grants and the
+admin role has to be granted explicitly, so registering a client is not enough to
+become an admin, and a revoked grant takes the rights back at once.
+
+An organization admin holds a token for the admin app of its organization, organization/adminApp,
+with the admin role granted. It only gets admin rights for requests whose apps, clients and groups
+are all in that organization. Clients can't register into an organization by themselves, that takes one of its admins.
+*/
+type Authorizer struct {
+	log       *slog.Logger
+	verifier  TokenVerifier
+	apiKeys   [][]byte
+	adminApp  string
+	adminRole string
+	methods   map[string]Access
+}
+
+func New(
+	log *slog.Logger,
+	verifier TokenVerifier,
+	apiKeys []string,
+	adminApp string,
+	adminRole string,
+	methods map[string]Access,
+) *Authorizer {
+	keys := make([][]byte, 0, len(apiKeys))
+
+	for _, key := range apiKeys {
+		if key != "" {
+			keys = append(keys, []byte(key))
+		}
+	}
+
+	table := make(map[string]Access, len(DefaultMethods)+len(methods))
+
+	for method, access := range DefaultMethods {
+		table[method] = access
+	}
+
+	for method, access := range methods {
+		table[method] = access
+	}
+
+	return &Authorizer{
+		log:       log,
+		verifier:  verifier,
+		apiKeys:   keys,
+		adminApp:  adminApp,
+		adminRole: adminRole,
+		methods:   table,
+	}
+}
+
+func (a *Authorizer) UnaryServerInterceptor() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		if err := a.authorize(ctx, path.Base(info.FullMethod), req); err != nil {
+			return nil, err
+		}
+
+		return handler(ctx, req)
+	}
+}
+
+func (a *Authorizer) access(method string, req any) Access {
+	access, ok := a.methods[method]
+
+	if !ok {
+		return Admin
+	}
+
+	// public registration only joins the global namespace
+	if method == "RegisterClient" && access == Public && slices.ContainsFunc(requestOrgs(req), func(org string) bool { return org != "" }) {
+		return Admin
+	}
+
+	return access
+}
+
+func (a *Authorizer) authorize(ctx context.Context, method string, req any) error {
+	const op = "interceptors.auth.authorize"
+
+	log := a.log.With(slog.String("op", op), slog.String("method", method))
+
+	if a.access(method, req) == Public {
+		return nil
+	}
+
+	credential := bearer(ctx)
+
+	if credential == "" {
+		return status.Error(codes.Unauthenticated, "admin credentials required")
+	}
+
+	if a.isAPIKey(credential) {
+		return nil
+	}
+
+	token, err := a.verifier.VerifyToken(ctx, credential)
+
+	if err != nil {
+		if errors.Is(err, ssosage.ErrInvalidToken) {
+			log.Warn("invalid admin credentials")
+
+			return status.Error(codes.Unauthenticated, "invalid admin credentials")
+		}
+
+		log.Error("failed to verify token", helpers.SlErr(err))
+
+		return status.Error(codes.Internal, "failed to verify credentials")
+	}
+
+	// Roles only lists grants, it is empty for apps without role_grants
+	if a.adminApp == "" || token.Role != a.adminRole || !slices.Contains(token.Roles, a.adminRole) {
+		log.Warn("client is not an admin", slog.String("client", token.ClientName))
+
+		return status.Error(codes.PermissionDenied, "admin rights required")
+	}
+
+	if token.AppName == a.adminApp {
+		return nil
+	}
+
+	org, app := models.SplitName(token.AppName)
+
+	if token.Org == "" || org != token.Org || app != a.adminApp || !inOrg(req, token.Org) {
+		log.Warn("client is not an admin", slog.String("client", token.ClientName))
+
+		return status.Error(codes.PermissionDenied, "admin rights required")
+	}
+
+	return nil
+}
+
+// inOrg tells whether a request names any app, client or group and all of them are in org
+func inOrg(req any, org string) bool {
+	orgs := requestOrgs(req)
+
+	return len(orgs) > 0 && !slices.ContainsFunc(orgs, func(named string) bool { return named != org })
+}
+
+// requestOrgs are the organizations of every app, client and group a request names, empty for global names
+func requestOrgs(req any) []string {
+	var names []string
+
+	if r, ok := req.(interface{ GetAppName() string }); ok {
+		names = append(names, r.GetAppName())
+	}
+
+	if r, ok := req.(interface{ GetClientName() string }); ok {
+		names = append(names, r.GetClientName())
+	}
+
+	if r, ok := req.(interface{ GetGroupName() string }); ok {
+		names = append(names, r.GetGroupName())
+	}
+
+	var orgs []string
+
+	for _, name := range names {
+		// requests like GrantRole leave the client or the group empty
+		if name == "" {
+			continue
+		}
+
+		org, _ := models.SplitName(name)
+		orgs = append(orgs, org)
+	}
+
+	return orgs
+}
+
+func (a *Authorizer) isAPIKey(credential string) bool {
+	found := 0
+
+	for _, key := range a.apiKeys {
+		found |= subtle.ConstantTimeCompare(key, []byte(credential))
+	}
+
+	return found == 1
+}
+
+func bearer(ctx context.Context) string {
+	md, ok := metadata.FromIncomingContext(ctx)
+
+	if !ok {
+		return ""
+	}
+
+	values := md.Get(AuthorizationKey)
+
+	if len(values) == 0 {
+		return ""
+	}
+
+	scheme, credential, ok := strings.Cut(values[0], " ")
+
+	if !ok || !strings.EqualFold(scheme, "bearer") {
+		return ""
+	}
+
+	return strings.TrimSpace(credential)
+}
@@ -0,0 +1,141 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"ssosage/internal/models"
+	"ssosage/internal/services/ssosage"
+	"testing"
+
+	"github.com/hyperfyodor/ssosage_proto"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	apiKey    = "admin-key"
+	adminApp  = "console"
+	adminRole = "admin"
+)
+
+// fakeVerifier knows the tokens by their string, anything else is invalid
+type fakeVerifier map[string]models.Token
+
+func (v fakeVerifier) VerifyToken(ctx context.Context, token string) (models.Token, error) {
+	if token == "broken" {
+		return models.Token{}, errors.New("storage is down")
+	}
+
+	verified, ok := v[token]
+
+	if !ok {
+		return models.Token{}, ssosage.ErrInvalidToken
+	}
+
+	return verified, nil
+}
+
+var tokens = fakeVerifier{
+	"admin":           {ClientName: "alice", AppName: adminApp, Role: adminRole, Roles: []string{adminRole}},
+	"ungranted-admin": {ClientName: "mallory", AppName: adminApp, Role: adminRole},
+	"user":            {ClientName: "bob", AppName: adminApp, Role: "user", Roles: []string{"user"}},
+	"other-app":       {ClientName: "alice", AppName: "shop", Role: adminRole, Roles: []string{adminRole}},
+	"org-admin":       {ClientName: "acme/alice", Org: "acme", AppName: "acme/" + adminApp, Role: adminRole, Roles: []string{adminRole}},
+	"ungranted-org":   {ClientName: "acme/mallory", Org: "acme", AppName: "acme/" + adminApp, Role: adminRole},
+	"foreign-org":     {ClientName: "globex/eve", Org: "globex", AppName: "acme/" + adminApp, Role: adminRole, Roles: []string{adminRole}},
+}
+
+func TestAuthorize(t *testing.T) {
+	authorizer := New(slog.New(slog.NewTextHandler(io.Discard, nil)), tokens, []string{apiKey, ""}, adminApp, adminRole, map[string]Access{
+		"ListApps": Public,
+	})
+
+	globalApp := &ssosage_proto.RegisterAppRequest{AppName: "shop"}
+	acmeApp := &ssosage_proto.RegisterAppRequest{AppName: "acme/shop"}
+	globexApp := &ssosage_proto.RegisterAppRequest{AppName: "globex/shop"}
+
+	tests := []struct {
+		name       string
+		method     string
+		req        any
+		credential string
+		code       codes.Code
+	}{
+		{"public method", "GenerateToken", &ssosage_proto.GenerateTokenRequest{}, "", codes.OK},
+		{"public by config", "ListApps", nil, "", codes.OK},
+		{"public registration", "RegisterClient", &ssosage_proto.RegisterClientRequest{ClientName: "bob"}, "", codes.OK},
+		{"organization registration", "RegisterClient", &ssosage_proto.RegisterClientRequest{ClientName: "acme/bob"}, "", codes.Unauthenticated},
+		{"no credentials", "RegisterApp", globalApp, "", codes.Unauthenticated},
+		{"unknown method", "Shutdown", nil, "", codes.Unauthenticated},
+		{"api key", "RegisterApp", globalApp, apiKey, codes.OK},
+		{"empty api key is ignored", "RegisterApp", globalApp, " ", codes.Unauthenticated},
+		{"wrong api key", "RegisterApp", globalApp, "wrong-key", codes.Unauthenticated},
+		{"verifier failure", "RegisterApp", globalApp, "broken", codes.Internal},
+		{"granted admin", "RegisterApp", globalApp, "admin", codes.OK},
+		{"granted admin in an organization", "RegisterApp", acmeApp, "admin", codes.OK},
+		{"admin role without a grant", "RegisterApp", globalApp, "ungranted-admin", codes.PermissionDenied},
+		{"not the admin role", "RegisterApp", globalApp, "user", codes.PermissionDenied},
+		{"not the admin app", "RegisterApp", globalApp, "other-app", codes.PermissionDenied},
+		{"organization admin", "RegisterApp", acmeApp, "org-admin", codes.OK},
+		{"organization registration by its admin", "RegisterClient", &ssosage_proto.RegisterClientRequest{ClientName: "acme/bob"}, "org-admin", codes.OK},
+		{"organization admin on its group", "RegisterGroup", &ssosage_proto.RegisterGroupRequest{GroupName: "acme/devs"}, "org-admin", codes.OK},
+		{"organization admin on a global app", "RegisterApp", globalApp, "org-admin", codes.PermissionDenied},
+		{"organization admin on a group of another organization", "DeleteGroup", &ssosage_proto.DeleteGroupRequest{GroupName: "globex/devs"}, "org-admin", codes.PermissionDenied},
+		{"organization admin on another organization", "RegisterApp", globexApp, "org-admin", codes.PermissionDenied},
+		{"organization admin granting to its group", "GrantRole", &ssosage_proto.GrantRoleRequest{AppName: "acme/app", GroupName: "acme/devs"}, "org-admin", codes.OK},
+		{"organization admin granting to a group of another organization", "GrantRole", &ssosage_proto.GrantRoleRequest{AppName: "acme/app", GroupName: "other/devs"}, "org-admin", codes.PermissionDenied},
+		{"organization admin granting to a global client", "GrantRole", &ssosage_proto.GrantRoleRequest{AppName: "acme/app", ClientName: "bob"}, "org-admin", codes.PermissionDenied},
+		{"organization admin adding a client of another organization", "AddGroupMember", &ssosage_proto.AddGroupMemberRequest{GroupName: "acme/g", ClientName: "other/c"}, "org-admin", codes.PermissionDenied},
+		{"organization admin adding its client", "AddGroupMember", &ssosage_proto.AddGroupMemberRequest{GroupName: "acme/g", ClientName: "acme/c"}, "org-admin", codes.OK},
+		{"organization admin on a request without names", "ListClients", nil, "org-admin", codes.PermissionDenied},
+		{"organization admin role without a grant", "RegisterApp", acmeApp, "ungranted-org", codes.PermissionDenied},
+		{"admin app of another organization", "RegisterApp", acmeApp, "foreign-org", codes.PermissionDenied},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+
+			if tt.credential != "" {
+				ctx = metadata.NewIncomingContext(ctx, metadata.Pairs(AuthorizationKey, "Bearer "+tt.credential))
+			}
+
+			if err := authorizer.authorize(ctx, tt.method, tt.req); status.Code(err) != tt.code {
+				t.Fatalf("expected %v, got: %v", tt.code, err)
+			}
+		})
+	}
+}
+
+func TestAuthorizeWithoutAdminApp(t *testing.T) {
+	authorizer := New(slog.New(slog.NewTextHandler(io.Discard, nil)), tokens, nil, "", adminRole, nil)
+
+	ctx := metadata.NewIncomingContext(context.Background(), metadata.Pairs(AuthorizationKey, "Bearer admin"))
+
+	if err := authorizer.authorize(ctx, "RegisterApp", &ssosage_proto.RegisterAppRequest{AppName: "shop"}); status.Code(err) != codes.PermissionDenied {
+		t.Fatalf("expected PermissionDenied without an admin app, got: %v", err)
+	}
+}
+
+func TestBearer(t *testing.T) {
+	tests := []struct {
+		header string
+		want   string
+	}{
+		{"Bearer key", "key"},
+		{"bearer  key ", "key"},
+		{"Basic key", ""},
+		{"key", ""},
+	}
+
+	for _, tt := range tests {
+		ctx := metadata.NewIncomingContext(context.Background(), metadata.Pairs(AuthorizationKey, tt.header))
+
+		if got := bearer(ctx); got != tt.want {
+			t.Fatalf("%q: expected %q, got %q", tt.header, tt.want, got)
+		}
+	}
+}
@@ -0,0 +1,140 @@
+package ratelimit
+
+import (
+	"context"
+	"math"
+	"path"
+	"ssosage/internal/helpers"
+	"strconv"
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// RetryAfterKey is the response header with the number of seconds to wait before retrying
+const RetryAfterKey = "retry-after"
+
+// buckets that were not used for that long are dropped
+const idleTimeout = 10 * time.Minute
+
+type Limit struct {
+	Rate  float64
+	Burst int
+}
+
+/*
+Limiter keeps a token bucket per peer ip for every method and, for methods
+listed in Methods, an extra bucket per peer ip and method.
+A request has to get a token from every bucket it belongs to.
+*/
+type Limiter struct {
+	peer    Limit
+	methods map[string]Limit
+
+	mu        sync.Mutex
+	buckets   map[string]*bucket
+	lastSweep time.Time
+
+	// now is the clock of the limiter, replaced in tests
+	now func() time.Time
+}
+
+type bucket struct {
+	limiter  *rate.Limiter
+	lastSeen time.Time
+}
+
+// New creates a Limiter, methods are keyed by short method name e.g. "GenerateToken"
+func New(peer Limit, methods map[string]Limit) *Limiter {
+	return &Limiter{
+		peer:      peer,
+		methods:   methods,
+		buckets:   make(map[string]*bucket),
+		lastSweep: time.Now(),
+		now:       time.Now,
+	}
+}
+
+func (l *Limiter) UnaryServerInterceptor() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		if wait := l.reserve(helpers.PeerIP(ctx), path.Base(info.FullMethod), l.now()); wait > 0 {
+			seconds := strconv.Itoa(int(math.Ceil(wait.Seconds())))
+			_ = grpc.SetHeader(ctx, metadata.Pairs(RetryAfterKey, seconds))
+
+			return nil, status.Errorf(codes.ResourceExhausted, "too many requests, retry after %ss", seconds)
+		}
+
+		return handler(ctx, req)
+	}
+}
+
+// reserve takes a token from every bucket of the request, if any of them is empty
+// nothing is taken and the time to wait is returned
+func (l *Limiter) reserve(ip string, method string, now time.Time) time.Duration {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.sweep(now)
+
+	var reservations []*rate.Reservation
+
+	if l.peer.Rate > 0 {
+		reservations = append(reservations, l.bucket("peer:"+ip, l.peer, now).ReserveN(now, 1))
+	}
+
+	if limit, ok := l.methods[method]; ok && limit.Rate > 0 {
+		reservations = append(reservations, l.bucket("method:"+method+":"+ip, limit, now).ReserveN(now, 1))
+	}
+
+	var wait time.Duration
+
+	for _, r := range reservations {
+		if !r.OK() {
+			wait = time.Duration(math.MaxInt64)
+
+			continue
+		}
+
+		wait = max(wait, r.DelayFrom(now))
+	}
+
+	if wait > 0 {
+		for _, r := range reservations {
+			r.CancelAt(now)
+		}
+	}
+
+	return wait
+}
+
+func (l *Limiter) bucket(key string, limit Limit, now time.Time) *rate.Limiter {
+	b, ok := l.buckets[key]
+
+	if !ok {
+		b = &bucket{limiter: rate.NewLimiter(rate.Limit(limit.Rate), max(limit.Burst, 1))}
+		l.buckets[key] = b
+	}
+
+	b.lastSeen = now
+
+	return b.limiter
+}
+
+func (l *Limiter) sweep(now time.Time) {
+	if now.Sub(l.lastSweep) < idleTimeout {
+		return
+	}
+
+	for key, b := range l.buckets {
+		if now.Sub(b.lastSeen) > idleTimeout {
+			delete(l.buckets, key)
+		}
+	}
+
+	l.lastSweep = now
+}
@@ -0,0 +1,166 @@
+package ratelimit
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+type request struct {
+	ip     string
+	method string
+	// at is the offset of the request from the start of the test
+	at time.Duration
+	// wait is the expected time to wait, zero when the request passes
+	wait time.Duration
+}
+
+func TestReserve(t *testing.T) {
+	tests := []struct {
+		name     string
+		peer     Limit
+		methods  map[string]Limit
+		requests []request
+	}{
+		{
+			name: "burst then refill",
+			peer: Limit{Rate: 1, Burst: 2},
+			requests: []request{
+				{ip: "10.0.0.1", method: "GenerateToken"},
+				{ip: "10.0.0.1", method: "GenerateToken"},
+				{ip: "10.0.0.1", method: "GenerateToken", wait: time.Second},
+				{ip: "10.0.0.1", method: "GenerateToken", at: 500 * time.Millisecond, wait: 500 * time.Millisecond},
+				{ip: "10.0.0.1", method: "GenerateToken", at: time.Second},
+				{ip: "10.0.0.1", method: "GenerateToken", at: time.Second, wait: time.Second},
+			},
+		},
+		{
+			name: "buckets per peer",
+			peer: Limit{Rate: 1, Burst: 1},
+			requests: []request{
+				{ip: "10.0.0.1", method: "GenerateToken"},
+				{ip: "10.0.0.1", method: "RegisterClient", wait: time.Second},
+				{ip: "10.0.0.2", method: "GenerateToken"},
+			},
+		},
+		{
+			name:    "buckets per peer and method",
+			peer:    Limit{Rate: 10, Burst: 10},
+			methods: map[string]Limit{"RegisterClient": {Rate: 0.5, Burst: 1}},
+			requests: []request{
+				{ip: "10.0.0.1", method: "RegisterClient"},
+				{ip: "10.0.0.1", method: "RegisterClient", wait: 2 * time.Second},
+				{ip: "10.0.0.1", method: "GenerateToken"},
+				{ip: "10.0.0.2", method: "RegisterClient"},
+				{ip: "10.0.0.1", method: "RegisterClient", at: 2 * time.Second},
+			},
+		},
+		{
+			name: "denied requests take no tokens",
+			peer: Limit{Rate: 1, Burst: 1},
+			requests: []request{
+				{ip: "10.0.0.1", method: "GenerateToken"},
+				{ip: "10.0.0.1", method: "GenerateToken", wait: time.Second},
+				{ip: "10.0.0.1", method: "GenerateToken", wait: time.Second},
+				{ip: "10.0.0.1", method: "GenerateToken", at: time.Second},
+			},
+		},
+		{
+			name:    "zero rate is unlimited",
+			methods: map[string]Limit{"GenerateToken": {}},
+			requests: []request{
+				{ip: "10.0.0.1", method: "GenerateToken"},
+				{ip: "10.0.0.1", method: "GenerateToken"},
+				{ip: "10.0.0.1", method: "GenerateToken"},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			start := time.Now()
+			limiter := New(tt.peer, tt.methods)
+
+			for i, r := range tt.requests {
+				if wait := limiter.reserve(r.ip, r.method, start.Add(r.at)); wait != r.wait {
+					t.Fatalf("request %d: expected to wait %v, got %v", i, r.wait, wait)
+				}
+			}
+		})
+	}
+}
+
+func TestSweepDropsIdleBuckets(t *testing.T) {
+	start := time.Now()
+	limiter := New(Limit{Rate: 1, Burst: 1}, nil)
+
+	limiter.reserve("10.0.0.1", "GenerateToken", start)
+
+	if len(limiter.buckets) != 1 {
+		t.Fatalf("expected one bucket, got %d", len(limiter.buckets))
+	}
+
+	limiter.reserve("10.0.0.2", "GenerateToken", start.Add(2*idleTimeout))
+
+	if _, ok := limiter.buckets["peer:10.0.0.1"]; ok || len(limiter.buckets) != 1 {
+		t.Fatalf("expected the idle bucket to be dropped, got %v", limiter.buckets)
+	}
+}
+
+// headerStream records the headers set by the interceptor
+type headerStream struct {
+	grpc.ServerTransportStream
+	header metadata.MD
+}
+
+func (s *headerStream) Method() string {
+	return "/ssosage.Ssosage/GenerateToken"
+}
+
+func (s *headerStream) SetHeader(md metadata.MD) error {
+	s.header = metadata.Join(s.header, md)
+
+	return nil
+}
+
+func TestInterceptorRetryAfter(t *testing.T) {
+	now := time.Now()
+	limiter := New(Limit{Rate: 0.4, Burst: 1}, nil)
+	limiter.now = func() time.Time { return now }
+
+	interceptor := limiter.UnaryServerInterceptor()
+	info := &grpc.UnaryServerInfo{FullMethod: "/ssosage.Ssosage/GenerateToken"}
+	handler := func(ctx context.Context, req any) (any, error) { return "ok", nil }
+
+	stream := &headerStream{}
+	ctx := grpc.NewContextWithServerTransportStream(context.Background(), stream)
+	ctx = peer.NewContext(ctx, &peer.Peer{Addr: &net.TCPAddr{IP: net.ParseIP("10.0.0.1"), Port: 5000}})
+
+	if _, err := interceptor(ctx, nil, info, handler); err != nil {
+		t.Fatalf("expected the first request to pass, got: %v", err)
+	}
+
+	_, err := interceptor(ctx, nil, info, handler)
+
+	if status.Code(err) != codes.ResourceExhausted {
+		t.Fatalf("expected ResourceExhausted, got: %v", err)
+	}
+
+	// 2.5s to the next token, rounded up
+	if got := stream.header.Get(RetryAfterKey); len(got) != 1 || got[0] != "3" {
+		t.Fatalf("expected retry-after of 3 seconds, got %v", got)
+	}
+
+	now = now.Add(2500 * time.Millisecond)
+
+	if _, err := interceptor(ctx, nil, info, handler); err != nil {
+		t.Fatalf("expected a request after the wait to pass, got: %v", err)
+	}
+}
@@ -0,0 +1,101 @@
+package redact
+
+import (
+	"context"
+	"log/slog"
+	"slices"
+
+	"github.com/grpc-ecosystem/go-grpc-middleware/v2/interceptors/logging"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+)
+
+const Mask = "[REDACTED]"
+
+// DefaultFields are proto field names that never reach the logs
+var DefaultFields = []string{
+	"password",
+	"old_password",
+	"new_password",
+	"app_secret",
+	"secret",
+	"token",
+	"totp_code",
+	"otpauth_uri",
+	"recovery_codes",
+	"challenge",
+	"value",
+}
+
+/*
+Logger adapts slog to the grpc logging interceptor, proto messages among the
+log fields are replaced by copies with the sensitive fields masked.
+Fields are matched by proto name at any nesting depth.
+*/
+func Logger(l *slog.Logger, fields []string) logging.Logger {
+	sensitive := make(map[protoreflect.Name]struct{}, len(DefaultFields)+len(fields))
+
+	for _, name := range slices.Concat(DefaultFields, fields) {
+		sensitive[protoreflect.Name(name)] = struct{}{}
+	}
+
+	return logging.LoggerFunc(func(ctx context.Context, lvl logging.Level, msg string, fields ...any) {
+		for i, field := range fields {
+			if m, ok := field.(proto.Message); ok {
+				fields[i] = Message(m, sensitive)
+			}
+		}
+
+		l.Log(ctx, slog.Level(lvl), msg, fields...)
+	})
+}
+
+// Message returns a copy of m with sensitive string fields masked and other sensitive fields cleared
+func Message(m proto.Message, sensitive map[protoreflect.Name]struct{}) proto.Message {
+	clone := proto.Clone(m)
+
+	redact(clone.ProtoReflect(), sensitive)
+
+	return clone
+}
+
+func redact(m protoreflect.Message, sensitive map[protoreflect.Name]struct{}) {
+	var masked []protoreflect.FieldDescriptor
+
+	m.Range(func(fd protoreflect.FieldDescriptor, v protoreflect.Value) bool {
+		if _, ok := sensitive[fd.Name()]; ok {
+			masked = append(masked, fd)
+
+			return true
+		}
+
+		switch {
+		case fd.IsList() && fd.Message() != nil:
+			list := v.List()
+
+			for i := 0; i < list.Len(); i++ {
+				redact(list.Get(i).Message(), sensitive)
+			}
+		case fd.IsMap() && fd.MapValue().Message() != nil:
+			v.Map().Range(func(_ protoreflect.MapKey, mv protoreflect.Value) bool {
+				redact(mv.Message(), sensitive)
+
+				return true
+			})
+		case !fd.IsList() && !fd.IsMap() && fd.Message() != nil:
+			redact(v.Message(), sensitive)
+		}
+
+		return true
+	})
+
+	for _, fd := range masked {
+		if fd.Kind() == protoreflect.StringKind && !fd.IsList() && !fd.IsMap() {
+			m.Set(fd, protoreflect.ValueOfString(Mask))
+
+			continue
+		}
+
+		m.Clear(fd)
+	}
+}
@@ -0,0 +1,249 @@
+package redact
+
+import (
+	"bytes"
+	"context"
+	"log/slog"
+	"strings"
+	"testing"
+
+	"github.com/grpc-ecosystem/go-grpc-middleware/v2/interceptors/logging"
+	"github.com/hyperfyodor/ssosage_proto"
+	"google.golang.org/grpc"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+)
+
+const secret = "top-secret-value"
+
+// every rpc of the service with request and response carrying the secret in every sensitive field
+var rpcs = map[string]struct {
+	request  proto.Message
+	response proto.Message
+}{
+	"RegisterApp": {
+		request:  &ssosage_proto.RegisterAppRequest{AppName: "app", AppSecret: secret, Roles: []string{"user"}},
+		response: &ssosage_proto.RegisterAppResponse{},
+	},
+	"RegisterClient": {
+		request:  &ssosage_proto.RegisterClientRequest{ClientName: "client", Password: secret},
+		response: &ssosage_proto.RegisterClientResponse{},
+	},
+	"GenerateToken": {
+		request:  &ssosage_proto.GenerateTokenRequest{ClientName: "client", Password: secret, AppName: "app", Role: "user", TotpCode: secret},
+		response: &ssosage_proto.GenerateTokenResponse{Token: secret},
+	},
+	"UnlockClient": {
+		request:  &ssosage_proto.UnlockClientRequest{ClientName: "client"},
+		response: &ssosage_proto.UnlockClientResponse{},
+	},
+	"UnlockAddress": {
+		request:  &ssosage_proto.UnlockAddressRequest{Ip: "10.0.0.1"},
+		response: &ssosage_proto.UnlockAddressResponse{},
+	},
+	"ChangePassword": {
+		request:  &ssosage_proto.ChangePasswordRequest{ClientName: "client", OldPassword: secret, NewPassword: secret, TotpCode: secret},
+		response: &ssosage_proto.ChangePasswordResponse{},
+	},
+	"ResetPassword": {
+		request:  &ssosage_proto.ResetPasswordRequest{ClientName: "client", NewPassword: secret},
+		response: &ssosage_proto.ResetPasswordResponse{},
+	},
+	"DisableClient": {
+		request:  &ssosage_proto.DisableClientRequest{ClientName: "client"},
+		response: &ssosage_proto.DisableClientResponse{},
+	},
+	"EnableClient": {
+		request:  &ssosage_proto.EnableClientRequest{ClientName: "client"},
+		response: &ssosage_proto.EnableClientResponse{},
+	},
+	"DeleteClient": {
+		request:  &ssosage_proto.DeleteClientRequest{ClientName: "client"},
+		response: &ssosage_proto.DeleteClientResponse{},
+	},
+	"IntrospectToken": {
+		request:  &ssosage_proto.IntrospectTokenRequest{Token: secret},
+		response: &ssosage_proto.IntrospectTokenResponse{Active: true, ClientName: "client"},
+	},
+	"ListClients": {
+		request:  &ssosage_proto.ListClientsRequest{Query: &ssosage_proto.ListQuery{NamePrefix: "cl"}},
+		response: &ssosage_proto.ListClientsResponse{Clients: []*ssosage_proto.Client{{Name: "client"}}},
+	},
+	"ListApps": {
+		request:  &ssosage_proto.ListAppsRequest{Query: &ssosage_proto.ListQuery{NamePrefix: "ap"}},
+		response: &ssosage_proto.ListAppsResponse{Apps: []*ssosage_proto.App{{Name: "app"}}},
+	},
+	"GetApp": {
+		request:  &ssosage_proto.GetAppRequest{AppName: "app"},
+		response: &ssosage_proto.GetAppResponse{App: &ssosage_proto.App{Name: "app", Version: 1}},
+	},
+	"UpdateApp": {
+		request:  &ssosage_proto.UpdateAppRequest{AppName: "app", Version: 1, Roles: &ssosage_proto.RoleList{Roles: []string{"user"}}},
+		response: &ssosage_proto.UpdateAppResponse{Version: 2},
+	},
+	"DeleteApp": {
+		request:  &ssosage_proto.DeleteAppRequest{AppName: "app"},
+		response: &ssosage_proto.DeleteAppResponse{},
+	},
+	"EnrollTOTP": {
+		request:  &ssosage_proto.EnrollTOTPRequest{ClientName: "client", Password: secret},
+		response: &ssosage_proto.EnrollTOTPResponse{Secret: secret, OtpauthUri: "otpauth://totp/ssosage:client?secret=" + secret},
+	},
+	"ConfirmTOTP": {
+		request:  &ssosage_proto.ConfirmTOTPRequest{ClientName: "client", Password: secret, TotpCode: secret},
+		response: &ssosage_proto.ConfirmTOTPResponse{RecoveryCodes: []string{secret}},
+	},
+	"DisableTOTP": {
+		request:  &ssosage_proto.DisableTOTPRequest{ClientName: "client", Password: secret, TotpCode: secret},
+		response: &ssosage_proto.DisableTOTPResponse{},
+	},
+	"ResetTOTP": {
+		request:  &ssosage_proto.ResetTOTPRequest{ClientName: "client"},
+		response: &ssosage_proto.ResetTOTPResponse{},
+	},
+	"RegenerateRecoveryCodes": {
+		request:  &ssosage_proto.RegenerateRecoveryCodesRequest{ClientName: "client", Password: secret, TotpCode: secret},
+		response: &ssosage_proto.RegenerateRecoveryCodesResponse{RecoveryCodes: []string{secret, secret}},
+	},
+	"BeginLogin": {
+		request:  &ssosage_proto.BeginLoginRequest{ClientName: "client", AppName: "app", Role: "user"},
+		response: &ssosage_proto.BeginLoginResponse{Step: &ssosage_proto.LoginStep{Challenge: secret, Remaining: []string{"password"}}},
+	},
+	"CompleteFactor": {
+		request:  &ssosage_proto.CompleteFactorRequest{Challenge: secret, Factor: "password", Value: secret},
+		response: &ssosage_proto.CompleteFactorResponse{Step: &ssosage_proto.LoginStep{Token: secret}},
+	},
+	"BeginPasskeyLogin": {
+		request:  &ssosage_proto.BeginPasskeyLoginRequest{ClientName: "client", AppName: "app", Role: "user"},
+		response: &ssosage_proto.BeginPasskeyLoginResponse{Step: &ssosage_proto.LoginStep{Challenge: secret, Remaining: []string{"webauthn"}}},
+	},
+	"BeginPasskeyRegistration": {
+		request:  &ssosage_proto.BeginPasskeyRegistrationRequest{ClientName: "client"},
+		response: &ssosage_proto.BeginPasskeyRegistrationResponse{Step: &ssosage_proto.LoginStep{Challenge: secret, Remaining: []string{"password"}}},
+	},
+	"ListPasskeys": {
+		request:  &ssosage_proto.ListPasskeysRequest{ClientName: "client"},
+		response: &ssosage_proto.ListPasskeysResponse{Passkeys: []*ssosage_proto.Passkey{{Id: []byte("id"), SignCount: 1}}},
+	},
+	"DeletePasskey": {
+		request:  &ssosage_proto.DeletePasskeyRequest{ClientName: "client", Id: []byte("id")},
+		response: &ssosage_proto.DeletePasskeyResponse{},
+	},
+	"RequestPasswordReset": {
+		request:  &ssosage_proto.RequestPasswordResetRequest{ClientName: "client"},
+		response: &ssosage_proto.RequestPasswordResetResponse{},
+	},
+	"ConfirmPasswordReset": {
+		request:  &ssosage_proto.ConfirmPasswordResetRequest{Token: secret, NewPassword: secret},
+		response: &ssosage_proto.ConfirmPasswordResetResponse{},
+	},
+	"SetEmail": {
+		request:  &ssosage_proto.SetEmailRequest{ClientName: "client", Password: secret, TotpCode: secret, Email: "client@example.com"},
+		response: &ssosage_proto.SetEmailResponse{},
+	},
+	"SendEmailVerification": {
+		request:  &ssosage_proto.SendEmailVerificationRequest{ClientName: "client"},
+		response: &ssosage_proto.SendEmailVerificationResponse{},
+	},
+	"VerifyEmail": {
+		request:  &ssosage_proto.VerifyEmailRequest{Token: secret},
+		response: &ssosage_proto.VerifyEmailResponse{},
+	},
+	"GetProfile": {
+		request:  &ssosage_proto.GetProfileRequest{ClientName: "client"},
+		response: &ssosage_proto.GetProfileResponse{Profile: &ssosage_proto.Profile{DisplayName: "Client", Metadata: `{"team":"a"}`}},
+	},
+	"UpdateProfile": {
+		request:  &ssosage_proto.UpdateProfileRequest{ClientName: "client", Locale: proto.String("en")},
+		response: &ssosage_proto.UpdateProfileResponse{Profile: &ssosage_proto.Profile{Locale: "en"}},
+	},
+	"RegisterOrganization": {
+		request:  &ssosage_proto.RegisterOrganizationRequest{Name: "acme"},
+		response: &ssosage_proto.RegisterOrganizationResponse{OrgId: 1},
+	},
+	"DeleteOrganization": {
+		request:  &ssosage_proto.DeleteOrganizationRequest{Name: "acme"},
+		response: &ssosage_proto.DeleteOrganizationResponse{},
+	},
+	"RegisterGroup": {
+		request:  &ssosage_proto.RegisterGroupRequest{GroupName: "devs"},
+		response: &ssosage_proto.RegisterGroupResponse{GroupId: 1},
+	},
+	"DeleteGroup": {
+		request:  &ssosage_proto.DeleteGroupRequest{GroupName: "devs"},
+		response: &ssosage_proto.DeleteGroupResponse{},
+	},
+	"AddGroupMember": {
+		request:  &ssosage_proto.AddGroupMemberRequest{GroupName: "devs", ClientName: "client"},
+		response: &ssosage_proto.AddGroupMemberResponse{},
+	},
+	"RemoveGroupMember": {
+		request:  &ssosage_proto.RemoveGroupMemberRequest{GroupName: "devs", ClientName: "client"},
+		response: &ssosage_proto.RemoveGroupMemberResponse{},
+	},
+	"GrantRole": {
+		request:  &ssosage_proto.GrantRoleRequest{AppName: "app", Role: "admin", GroupName: "devs"},
+		response: &ssosage_proto.GrantRoleResponse{},
+	},
+	"RevokeRole": {
+		request:  &ssosage_proto.RevokeRoleRequest{AppName: "app", Role: "admin", ClientName: "client"},
+		response: &ssosage_proto.RevokeRoleResponse{},
+	},
+	"ClientRoles": {
+		request:  &ssosage_proto.ClientRolesRequest{ClientName: "client", AppName: "app"},
+		response: &ssosage_proto.ClientRolesResponse{Roles: []string{"admin"}},
+	},
+}
+
+func TestNoSecretsInPayloadLogs(t *testing.T) {
+	for _, method := range ssosage_proto.Ssosage_ServiceDesc.Methods {
+		rpc, ok := rpcs[method.MethodName]
+
+		if !ok {
+			t.Errorf("no redaction test case for %s", method.MethodName)
+
+			continue
+		}
+
+		var out bytes.Buffer
+
+		log := slog.New(slog.NewJSONHandler(&out, &slog.HandlerOptions{Level: slog.LevelDebug}))
+
+		interceptor := logging.UnaryServerInterceptor(
+			Logger(log, nil),
+			logging.WithLogOnEvents(logging.PayloadReceived, logging.PayloadSent),
+		)
+
+		info := &grpc.UnaryServerInfo{FullMethod: "/" + ssosage_proto.Ssosage_ServiceDesc.ServiceName + "/" + method.MethodName}
+
+		_, err := interceptor(context.Background(), rpc.request, info, func(ctx context.Context, req any) (any, error) {
+			return rpc.response, nil
+		})
+
+		if err != nil {
+			t.Fatalf("%s: interceptor failed: %v", method.MethodName, err)
+		}
+
+		if !strings.Contains(out.String(), "grpc.request.content") {
+			t.Fatalf("%s: payload was not logged", method.MethodName)
+		}
+
+		if strings.Contains(out.String(), secret) {
+			t.Errorf("%s: secret leaked into logs: %s", method.MethodName, out.String())
+		}
+	}
+}
+
+func TestMessageDoesNotModifyOriginal(t *testing.T) {
+	request := &ssosage_proto.GenerateTokenRequest{ClientName: "client", Password: secret}
+
+	redacted := Message(request, map[protoreflect.Name]struct{}{"password": {}}).(*ssosage_proto.GenerateTokenRequest)
+
+	if request.GetPassword() != secret {
+		t.Fatalf("original message was modified")
+	}
+
+	if redacted.GetPassword() != Mask || redacted.GetClientName() != "client" {
+		t.Fatalf("unexpected redacted message: %v", redacted)
+	}
+}
@@ -3,8 +3,11 @@ package interfaces
 import (
 	"context"
 	"ssosage/internal/models"
+	"time"
 )
 
+// SaveClient and SaveApp put a name like org/name into that organization
+// and return ErrOrganizationNotFound when there is none
 type ClientSaver interface {
 	SaveClient(ctx context.Context, name string, passwordHash []byte) (int64, error)
 }
@@ -13,6 +16,149 @@ type ClientProvider interface {
 	Client(ctx context.Context, name string) (models.Client, error)
 }
 
+// ClientUpdater methods return ErrClientNotFound when there is no such client,
+// updates bump the token version of the client, which revokes its tokens
+type ClientUpdater interface {
+	UpdateClientPassword(ctx context.Context, name string, passwordHash []byte) error
+	SetClientStatus(ctx context.Context, name string, status string) error
+	DeleteClient(ctx context.Context, name string) error
+}
+
+/*
+TOTPManager keeps the second factor of clients, its methods return ErrClientNotFound
+and don't revoke tokens. SetTOTPSecret stores a pending secret and turns totp off until EnableTOTP.
+UseTOTPStep records the step of an accepted code and returns ErrTOTPStepUsed unless it is newer than the last one.
+*/
+type TOTPManager interface {
+	SetTOTPSecret(ctx context.Context, name string, sealedSecret []byte) error
+	EnableTOTP(ctx context.Context, name string) error
+	UseTOTPStep(ctx context.Context, name string, step int64) error
+	ClearTOTP(ctx context.Context, name string) error
+}
+
+/*
+OrganizationStore keeps tenants. SaveOrganization returns ErrOrganizationExists, Organization
+and DeleteOrganization return ErrOrganizationNotFound, and DeleteOrganization returns
+ErrOrganizationNotEmpty while clients, apps or groups are in it.
+*/
+type OrganizationStore interface {
+	SaveOrganization(ctx context.Context, name string) (int64, error)
+	Organization(ctx context.Context, name string) (models.Organization, error)
+	DeleteOrganization(ctx context.Context, name string) error
+}
+
+/*
+GroupStore keeps groups of clients and the roles granted to clients and groups. SaveGroup
+returns ErrGroupExists, the other methods return ErrGroupNotFound, ErrClientNotFound or
+ErrAppNotFound for what they name and is missing. Adding a member or granting a role again
+is no error, neither is removing one that isn't there. ClientGroups and ClientRoles are
+sorted and empty for unknown clients, ClientRoles has the direct and the group grants.
+*/
+type GroupStore interface {
+	SaveGroup(ctx context.Context, name string) (int64, error)
+	Group(ctx context.Context, name string) (models.Group, error)
+	DeleteGroup(ctx context.Context, name string) error
+	AddGroupMember(ctx context.Context, group string, clientName string) error
+	RemoveGroupMember(ctx context.Context, group string, clientName string) error
+	GrantRole(ctx context.Context, grant models.RoleGrant) error
+	RevokeRole(ctx context.Context, grant models.RoleGrant) error
+	ClientGroups(ctx context.Context, clientName string) ([]string, error)
+	ClientRoles(ctx context.Context, clientName string, appName string) ([]string, error)
+}
+
+/*
+EmailManager keeps the email address of clients, its methods return ErrClientNotFound.
+SetClientEmail leaves the address unverified and bumps the token version, as the
+email_verified claim of issued tokens no longer holds. VerifyClientEmail returns
+ErrEmailChanged unless the client still has that address.
+*/
+type EmailManager interface {
+	SetClientEmail(ctx context.Context, name string, email string) error
+	VerifyClientEmail(ctx context.Context, name string, email string) error
+}
+
+/*
+ProfileStore keeps the profiles of clients, its methods return ErrClientNotFound.
+UpdateProfile changes the fields set in update and returns the profile, profile
+changes don't revoke tokens.
+*/
+type ProfileStore interface {
+	Profile(ctx context.Context, name string) (models.Profile, error)
+	UpdateProfile(ctx context.Context, name string, update models.ProfileUpdate) (models.Profile, error)
+}
+
+/*
+RecoveryCodeManager keeps hashed recovery codes of clients, meant to be changed within a transaction.
+RecoveryCodes returns the unused ones, AddRecoveryCodes returns ErrClientNotFound
+and UseRecoveryCode returns ErrRecoveryCodeUsed when the code is gone already.
+*/
+type RecoveryCodeManager interface {
+	RecoveryCodes(ctx context.Context, name string) ([]models.RecoveryCode, error)
+	AddRecoveryCodes(ctx context.Context, name string, hashes [][]byte) error
+	UseRecoveryCode(ctx context.Context, name string, id int64) error
+	DeleteRecoveryCodes(ctx context.Context, name string) error
+}
+
+/*
+WebAuthnCredentialStore keeps the passkeys of clients. Credential ids are unique across
+clients, SaveWebAuthnCredential returns ErrWebAuthnCredentialExists for a taken one
+and ErrClientNotFound. UpdateWebAuthnSignCount and DeleteWebAuthnCredential return
+ErrWebAuthnCredentialNotFound unless the client has that credential.
+*/
+type WebAuthnCredentialStore interface {
+	SaveWebAuthnCredential(ctx context.Context, name string, credential models.WebAuthnCredential) error
+	WebAuthnCredentials(ctx context.Context, name string) ([]models.WebAuthnCredential, error)
+	UpdateWebAuthnSignCount(ctx context.Context, name string, id []byte, signCount uint32) error
+	DeleteWebAuthnCredential(ctx context.Context, name string, id []byte) error
+}
+
+/*
+PasswordResetStore keeps unused password reset tokens by their hash. SavePasswordReset
+returns ErrClientNotFound, PasswordReset and DeletePasswordReset return ErrPasswordResetNotFound.
+Like a login, a reset is claimed by deleting it.
+*/
+type PasswordResetStore interface {
+	SavePasswordReset(ctx context.Context, reset models.PasswordReset) error
+	PasswordReset(ctx context.Context, id string) (models.PasswordReset, error)
+	DeletePasswordReset(ctx context.Context, id string) error
+	DeletePasswordResets(ctx context.Context, name string) error
+	DeleteExpiredPasswordResets(ctx context.Context, now time.Time) (int64, error)
+}
+
+type Notifier interface {
+	Notify(ctx context.Context, notification models.Notification) error
+}
+
+/*
+LoginTransactionStore keeps logins in progress, LoginTransaction, UpdateLoginFactors
+and DeleteLoginTransaction return ErrLoginTransactionNotFound. UpdateLoginFactors sets
+the factors still required along with the completed ones, as later factors are only
+known once the earlier ones are verified.
+Deleting is how a finished login is claimed, so only one caller issues its token.
+*/
+type LoginTransactionStore interface {
+	SaveLoginTransaction(ctx context.Context, transaction models.LoginTransaction) error
+	LoginTransaction(ctx context.Context, id string) (models.LoginTransaction, error)
+	UpdateLoginFactors(ctx context.Context, id string, required []string, completed []string) error
+	DeleteLoginTransaction(ctx context.Context, id string) error
+	DeleteExpiredLoginTransactions(ctx context.Context, now time.Time) (int64, error)
+}
+
+// SecretSealer encrypts secrets kept in storage
+type SecretSealer interface {
+	Seal(plaintext []byte) ([]byte, error)
+	Open(sealed []byte) ([]byte, error)
+}
+
+// listers leave password hashes and secrets empty, next cursor is empty on the last page
+type ClientLister interface {
+	ListClients(ctx context.Context, query models.ListQuery) (clients []models.Client, nextCursor string, err error)
+}
+
+type AppLister interface {
+	ListApps(ctx context.Context, query models.ListQuery) (apps []models.App, nextCursor string, err error)
+}
+
 type AppSaver interface {
 	SaveApp(ctx context.Context, name string, secret string, roles string) (int64, error)
 }
@@ -21,7 +167,56 @@ type AppProvider interface {
 	App(ctx context.Context, name string) (models.App, error)
 }
 
+// UpdateApp changes the fields set in update if the app is still at version,
+// returns the new version, ErrAppConflict on a version mismatch and ErrAppNotFound
+type AppUpdater interface {
+	UpdateApp(ctx context.Context, name string, version int64, update models.AppUpdate) (int64, error)
+	DeleteApp(ctx context.Context, name string) error
+}
+
 type PasswordHasher interface {
 	Hash(password string) ([]byte, error)
 	Compare(hash []byte, password string) (bool, error)
 }
+
+type LoginAttemptsTracker interface {
+	LoginAttempts(ctx context.Context, key string) (models.LoginAttempts, error)
+	IncrementFailedLogins(ctx context.Context, key string, at time.Time, resetBefore time.Time) (models.LoginAttempts, error)
+	LockLogin(ctx context.Context, key string, until time.Time) error
+	ResetLoginAttempts(ctx context.Context, key string) error
+}
+
+// Repository is the set of storage operations, also available inside a transaction
+type Repository interface {
+	ClientSaver
+	ClientProvider
+	ClientUpdater
+	ClientLister
+	OrganizationStore
+	GroupStore
+	TOTPManager
+	EmailManager
+	ProfileStore
+	RecoveryCodeManager
+	WebAuthnCredentialStore
+	LoginTransactionStore
+	PasswordResetStore
+	AppSaver
+	AppProvider
+	AppUpdater
+	AppLister
+	LoginAttemptsTracker
+	Transactor
+}
+
+// Transactor runs fn in a transaction, committed if fn returns nil and rolled back otherwise.
+// WithinTx called on a repo inside fn joins the running transaction.
+type Transactor interface {
+	WithinTx(ctx context.Context, fn func(repo Repository) error) error
+}
+
+// Storage is implemented by every storage backend
+type Storage interface {
+	Repository
+	Stop()
+}
@@ -1,14 +1,215 @@
 package models
 
+import (
+	"strings"
+	"time"
+)
+
+const (
+	ClientActive   = "active"
+	ClientDisabled = "disabled"
+)
+
+// OrgSeparator joins the name of an organization and a name in it, like acme/billing
+const OrgSeparator = "/"
+
+// SplitName returns the organization a client or app name is in and the rest of it,
+// org is empty for names in the global namespace
+func SplitName(name string) (org string, local string) {
+	org, local, ok := strings.Cut(name, OrgSeparator)
+
+	if !ok {
+		return "", name
+	}
+
+	return org, local
+}
+
+// Organization is a tenant, the clients and apps in it are named org/name so names are unique per organization
+type Organization struct {
+	ID        uint64
+	Name      string
+	CreatedAt time.Time
+}
+
+// Group gathers clients that share role grants, in an organization when named org/name
+type Group struct {
+	ID        uint64
+	Name      string
+	CreatedAt time.Time
+}
+
+// RoleGrant gives a role of an app to a client or to every member of a group,
+// exactly one of ClientName and GroupName is set
+type RoleGrant struct {
+	ClientName string
+	GroupName  string
+	AppName    string
+	Role       string
+}
+
+// TokenVersion changes whenever the tokens of a client are revoked.
+// TOTPSecret is sealed and only in use once TOTPEnabled, TOTPStep is the time step of the last accepted code.
+// WebAuthnEnabled tells whether the client has registered a passkey.
+// Email is empty when the client has none, EmailVerified once the client proved it receives mail there
 type Client struct {
-	ID           uint64
-	Name         string
-	PasswordHash []byte
+	ID   uint64
+	Name string
+	// zero for clients in the global namespace
+	OrgID         uint64
+	PasswordHash  []byte
+	Status        string
+	TokenVersion  int64
+	TOTPSecret    []byte
+	TOTPEnabled   bool
+	TOTPStep      int64
+	Email         string
+	EmailVerified bool
+	// read only, derived from the stored credentials
+	WebAuthnEnabled bool
+	CreatedAt       time.Time
+	UpdatedAt       time.Time
+}
+
+// RecoveryCode is an unused one-time code standing in for the second factor of a client
+type RecoveryCode struct {
+	ID   int64
+	Hash []byte
+}
+
+// WebAuthnCredential is a passkey of a client, PublicKey is a cose key.
+// SignCount is the last counter the authenticator reported, zero when it doesn't count
+type WebAuthnCredential struct {
+	ID         []byte
+	PublicKey  []byte
+	SignCount  uint32
+	CreatedAt  time.Time
+	LastUsedAt time.Time
+}
+
+// PasswordReset is an unused reset token of a client, ID is the hash of the token
+type PasswordReset struct {
+	ID         string
+	ClientName string
+	ExpiresAt  time.Time
+	CreatedAt  time.Time
+}
+
+// Notification is a message for a client, To is where the notifier delivers it
+type Notification struct {
+	ClientName string
+	To         string
+	Subject    string
+	Body       string
+}
+
+// Profile holds what apps show about a client, Metadata is a json object of their own attributes.
+// A client that never updated its profile has an empty one
+type Profile struct {
+	DisplayName string
+	Locale      string
+	AvatarURL   string
+	Metadata    string
+	UpdatedAt   time.Time
 }
 
+// ProfileUpdate changes the fields that are set
+type ProfileUpdate struct {
+	DisplayName *string
+	Locale      *string
+	AvatarURL   *string
+	Metadata    *string
+}
+
+// Name identifies an app in tokens and never changes, Settings is a json object.
+// Version grows with every update and guards against lost updates
 type App struct {
-	ID     uint64
-	Name   string
-	Secret string
-	Roles  string
+	ID   uint64
+	Name string
+	// zero for apps anybody may use, the apps of an organization are only for its clients
+	OrgID       uint64
+	DisplayName string
+	Secret      string
+	Roles       string
+	Settings    string
+	RequireMFA  bool
+	// tokens are issued only to clients with a verified email
+	RequireVerifiedEmail bool
+	// comma separated profile attributes put into tokens, like display_name,locale,metadata.team
+	ProfileClaims string
+	// tokens are issued only for roles granted to the client, directly or through its groups
+	RoleGrants bool
+	// tokens list the groups of the client
+	GroupsClaim bool
+	// a global app takes clients of organizations only when set, an organization's app never does
+	OrgClients bool
+	Version    int64
+	CreatedAt  time.Time
+	UpdatedAt  time.Time
+}
+
+// AppUpdate changes the fields that are set
+type AppUpdate struct {
+	DisplayName          *string
+	Roles                *string
+	Settings             *string
+	RequireMFA           *bool
+	RequireVerifiedEmail *bool
+	ProfileClaims        *string
+	RoleGrants           *bool
+	GroupsClaim          *bool
+	OrgClients           *bool
+}
+
+const (
+	SortByName      = "name"
+	SortByCreatedAt = "created_at"
+	SortByUpdatedAt = "updated_at"
+)
+
+// ListQuery selects a page of clients or apps, Cursor is the NextCursor of the previous page
+type ListQuery struct {
+	NamePrefix string
+	SortBy     string
+	Desc       bool
+	Limit      int
+	Cursor     string
+}
+
+type LoginAttempts struct {
+	Key           string
+	Failures      int
+	LastFailureAt time.Time
+	LockedUntil   time.Time
+}
+
+// LoginTransaction is a login in progress, ID is the hash of the challenge handed to the client.
+// Factors are completed in the order of Required. A passkey registration is one too, without an app
+type LoginTransaction struct {
+	ID         string
+	ClientName string
+	AppName    string
+	Role       string
+	Required   []string
+	Completed  []string
+	ExpiresAt  time.Time
+	CreatedAt  time.Time
+}
+
+type Token struct {
+	ClientID   uint64
+	ClientName string
+	// the organization of the client, zero and empty outside of one
+	OrgID         uint64
+	Org           string
+	AppName       string
+	Role          string
+	EmailVerified bool
+	// the roles granted to the client in the app, set for apps with RoleGrants
+	Roles []string
+	// set for apps with GroupsClaim
+	Groups []string
+	// the attributes the app projects into its tokens
+	Profile   Profile
+	ExpiresAt time.Time
 }
@@ -0,0 +1,91 @@
+/*
+notify delivers notifications to clients. SMTP sends them by mail,
+File and Log keep them locally for development and tests.
+*/
+package notify
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"os"
+	"ssosage/internal/helpers"
+	"ssosage/internal/models"
+	"sync"
+	"time"
+)
+
+// Log writes notifications to the log, secrets in them included, so never use it in production
+type Log struct {
+	log *slog.Logger
+}
+
+func NewLog(log *slog.Logger) *Log {
+	return &Log{log: log}
+}
+
+func (l *Log) Notify(ctx context.Context, notification models.Notification) error {
+	l.log.InfoContext(ctx, "notification",
+		slog.String("client", notification.ClientName),
+		slog.String("to", notification.To),
+		slog.String("subject", notification.Subject),
+		slog.String("body", notification.Body),
+	)
+
+	return nil
+}
+
+// File appends notifications to a file as json lines
+type File struct {
+	mu   sync.Mutex
+	path string
+}
+
+func NewFile(path string) *File {
+	return &File{path: path}
+}
+
+type fileEntry struct {
+	Time       time.Time `json:"time"`
+	ClientName string    `json:"client_name"`
+	To         string    `json:"to"`
+	Subject    string    `json:"subject"`
+	Body       string    `json:"body"`
+}
+
+func (f *File) Notify(ctx context.Context, notification models.Notification) error {
+	const op = "notify.File.Notify"
+
+	line, err := json.Marshal(fileEntry{
+		Time:       time.Now(),
+		ClientName: notification.ClientName,
+		To:         notification.To,
+		Subject:    notification.Subject,
+		Body:       notification.Body,
+	})
+
+	if err != nil {
+		return helpers.WrapErr(op, err)
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	file, err := os.OpenFile(f.path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o600)
+
+	if err != nil {
+		return helpers.WrapErr(op, err)
+	}
+
+	if _, err := file.Write(append(line, '\n')); err != nil {
+		file.Close()
+
+		return helpers.WrapErr(op, err)
+	}
+
+	if err := file.Close(); err != nil {
+		return helpers.WrapErr(op, err)
+	}
+
+	return nil
+}
@@ -0,0 +1,92 @@
+package notify
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"ssosage/internal/models"
+	"ssosage/internal/notify/smtptest"
+	"strings"
+	"testing"
+)
+
+var notification = models.Notification{
+	ClientName: "client",
+	To:         "client@example.com",
+	Subject:    "Password reset",
+	Body:       "first line\nsecond line",
+}
+
+func TestSMTP(t *testing.T) {
+	server, err := smtptest.New()
+
+	if err != nil {
+		t.Fatalf("failed to start smtp server: %v", err)
+	}
+
+	defer server.Close()
+
+	notifier := NewSMTP(SMTPOptions{Host: server.Host(), Port: server.Port(), From: "ssosage@example.com"})
+
+	if err := notifier.Notify(context.Background(), notification); err != nil {
+		t.Fatalf("failed to notify: %v", err)
+	}
+
+	messages := server.Messages()
+
+	if len(messages) != 1 {
+		t.Fatalf("expected one message, got %d", len(messages))
+	}
+
+	message := messages[0]
+
+	if message.From != "ssosage@example.com" || len(message.To) != 1 || message.To[0] != "client@example.com" {
+		t.Fatalf("unexpected envelope: %+v", message)
+	}
+
+	if !strings.Contains(message.Data, "Subject: Password reset\n") || !strings.HasSuffix(message.Data, "\nfirst line\nsecond line\n") {
+		t.Fatalf("unexpected message:\n%s", message.Data)
+	}
+
+	injected := notification
+	injected.To = "client@example.com\r\nBcc: someone@example.com"
+
+	if err := notifier.Notify(context.Background(), injected); !errors.Is(err, ErrInvalidHeader) {
+		t.Fatalf("expected ErrInvalidHeader, got: %v", err)
+	}
+}
+
+func TestFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "notifications.jsonl")
+	notifier := NewFile(path)
+
+	for range 2 {
+		if err := notifier.Notify(context.Background(), notification); err != nil {
+			t.Fatalf("failed to notify: %v", err)
+		}
+	}
+
+	data, err := os.ReadFile(path)
+
+	if err != nil {
+		t.Fatalf("failed to read notifications: %v", err)
+	}
+
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+
+	if len(lines) != 2 {
+		t.Fatalf("expected two notifications, got %d", len(lines))
+	}
+
+	var entry fileEntry
+
+	if err := json.Unmarshal([]byte(lines[1]), &entry); err != nil {
+		t.Fatalf("failed to parse notification: %v", err)
+	}
+
+	if entry.To != notification.To || entry.Body != notification.Body || entry.Time.IsZero() {
+		t.Fatalf("unexpected notification: %+v", entry)
+	}
+}
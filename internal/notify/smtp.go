@@ -0,0 +1,127 @@
+package notify
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"mime"
+	"net"
+	"net/smtp"
+	"ssosage/internal/helpers"
+	"ssosage/internal/models"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var ErrInvalidHeader = errors.New("notification header contains a line break")
+
+// SMTPOptions configure the mail server, without a username no authentication is attempted
+type SMTPOptions struct {
+	Host     string
+	Port     int
+	Username string
+	Password string
+	From     string
+	// skips STARTTLS even when the server offers it, only for local stand-ins
+	InsecureSkipTLS bool
+}
+
+// SMTP mails notifications, upgrading the connection with STARTTLS when the server offers it
+type SMTP struct {
+	opts SMTPOptions
+	addr string
+}
+
+func NewSMTP(opts SMTPOptions) *SMTP {
+	return &SMTP{opts: opts, addr: net.JoinHostPort(opts.Host, strconv.Itoa(opts.Port))}
+}
+
+func (s *SMTP) Notify(ctx context.Context, notification models.Notification) error {
+	const op = "notify.SMTP.Notify"
+
+	if strings.ContainsAny(notification.To+notification.Subject+s.opts.From, "\r\n") {
+		return helpers.WrapErr(op, ErrInvalidHeader)
+	}
+
+	conn, err := (&net.Dialer{}).DialContext(ctx, "tcp", s.addr)
+
+	if err != nil {
+		return helpers.WrapErr(op, err)
+	}
+
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+
+	client, err := smtp.NewClient(conn, s.opts.Host)
+
+	if err != nil {
+		conn.Close()
+
+		return helpers.WrapErr(op, err)
+	}
+
+	defer client.Close()
+
+	if err := s.send(client, notification); err != nil {
+		return helpers.WrapErr(op, err)
+	}
+
+	return nil
+}
+
+func (s *SMTP) send(client *smtp.Client, notification models.Notification) error {
+	if ok, _ := client.Extension("STARTTLS"); ok && !s.opts.InsecureSkipTLS {
+		if err := client.StartTLS(&tls.Config{ServerName: s.opts.Host}); err != nil {
+			return err
+		}
+	}
+
+	if s.opts.Username != "" {
+		if err := client.Auth(smtp.PlainAuth("", s.opts.Username, s.opts.Password, s.opts.Host)); err != nil {
+			return err
+		}
+	}
+
+	if err := client.Mail(s.opts.From); err != nil {
+		return err
+	}
+
+	if err := client.Rcpt(notification.To); err != nil {
+		return err
+	}
+
+	w, err := client.Data()
+
+	if err != nil {
+		return err
+	}
+
+	if _, err := w.Write(message(s.opts.From, notification)); err != nil {
+		return err
+	}
+
+	if err := w.Close(); err != nil {
+		return err
+	}
+
+	return client.Quit()
+}
+
+func message(from string, notification models.Notification) []byte {
+	var b strings.Builder
+
+	fmt.Fprintf(&b, "From: %s\r\n", from)
+	fmt.Fprintf(&b, "To: %s\r\n", notification.To)
+	fmt.Fprintf(&b, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", notification.Subject))
+	fmt.Fprintf(&b, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	b.WriteString("MIME-Version: 1.0\r\n")
+	b.WriteString("Content-Type: text/plain; charset=utf-8\r\n")
+	b.WriteString("\r\n")
+	b.WriteString(strings.ReplaceAll(strings.ReplaceAll(notification.Body, "\r\n", "\n"), "\n", "\r\n"))
+	b.WriteString("\r\n")
+
+	return []byte(b.String())
+}
@@ -0,0 +1,147 @@
+/*
+smtptest is an smtp server stand-in for tests, it accepts every message
+without authentication or tls and keeps it in memory.
+*/
+package smtptest
+
+import (
+	"net"
+	"net/textproto"
+	"strings"
+	"sync"
+)
+
+type Message struct {
+	From string
+	To   []string
+	// headers and body as sent, with line endings normalized to \n
+	Data string
+}
+
+type Server struct {
+	listener net.Listener
+	wg       sync.WaitGroup
+
+	mu       sync.Mutex
+	messages []Message
+}
+
+// New starts a server on a random local port, Close stops it
+func New() (*Server, error) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+
+	if err != nil {
+		return nil, err
+	}
+
+	s := &Server{listener: listener}
+
+	s.wg.Add(1)
+	go s.serve()
+
+	return s, nil
+}
+
+// Host and Port are where the server listens
+func (s *Server) Host() string {
+	return s.listener.Addr().(*net.TCPAddr).IP.String()
+}
+
+func (s *Server) Port() int {
+	return s.listener.Addr().(*net.TCPAddr).Port
+}
+
+// Messages returns the messages received so far
+func (s *Server) Messages() []Message {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return append([]Message(nil), s.messages...)
+}
+
+func (s *Server) Close() error {
+	err := s.listener.Close()
+	s.wg.Wait()
+
+	return err
+}
+
+func (s *Server) serve() {
+	defer s.wg.Done()
+
+	for {
+		conn, err := s.listener.Accept()
+
+		if err != nil {
+			return
+		}
+
+		s.wg.Add(1)
+
+		go func() {
+			defer s.wg.Done()
+			defer conn.Close()
+
+			s.handle(textproto.NewConn(conn))
+		}()
+	}
+}
+
+func (s *Server) handle(conn *textproto.Conn) {
+	conn.PrintfLine("220 smtptest ready")
+
+	var message Message
+
+	for {
+		line, err := conn.ReadLine()
+
+		if err != nil {
+			return
+		}
+
+		command, arg, _ := strings.Cut(line, " ")
+
+		switch strings.ToUpper(command) {
+		case "EHLO", "HELO":
+			conn.PrintfLine("250 smtptest")
+		case "MAIL":
+			message = Message{From: address(arg)}
+			conn.PrintfLine("250 ok")
+		case "RCPT":
+			message.To = append(message.To, address(arg))
+			conn.PrintfLine("250 ok")
+		case "DATA":
+			conn.PrintfLine("354 go ahead")
+
+			data, err := conn.ReadDotBytes()
+
+			if err != nil {
+				return
+			}
+
+			message.Data = string(data)
+
+			s.mu.Lock()
+			s.messages = append(s.messages, message)
+			s.mu.Unlock()
+
+			conn.PrintfLine("250 ok")
+		case "RSET", "NOOP":
+			conn.PrintfLine("250 ok")
+		case "QUIT":
+			conn.PrintfLine("221 bye")
+
+			return
+		default:
+			conn.PrintfLine("502 not implemented")
+		}
+	}
+}
+
+// address takes the address out of FROM:<address> and TO:<address>
+func address(arg string) string {
+	_, value, _ := strings.Cut(arg, ":")
+	value, _, _ = strings.Cut(strings.TrimSpace(value), " ")
+
+	return strings.Trim(value, "<>")
+}
@@ -0,0 +1,81 @@
+/*
+secretbox encrypts small secrets before they are stored, with AES-256-GCM.
+A sealed value is the random nonce followed by the ciphertext.
+*/
+package secretbox
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+)
+
+const KeySize = 32
+
+var (
+	ErrInvalidKey    = errors.New("secretbox key must be 32 bytes")
+	ErrInvalidSealed = errors.New("sealed value is corrupted or was sealed with another key")
+)
+
+type Box struct {
+	aead cipher.AEAD
+}
+
+func New(key []byte) (*Box, error) {
+	if len(key) != KeySize {
+		return nil, ErrInvalidKey
+	}
+
+	block, err := aes.NewCipher(key)
+
+	if err != nil {
+		return nil, err
+	}
+
+	aead, err := cipher.NewGCM(block)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &Box{aead: aead}, nil
+}
+
+// FromBase64 creates a box from a key kept in config
+func FromBase64(key string) (*Box, error) {
+	raw, err := base64.StdEncoding.DecodeString(key)
+
+	if err != nil {
+		return nil, ErrInvalidKey
+	}
+
+	return New(raw)
+}
+
+func (b *Box) Seal(plaintext []byte) ([]byte, error) {
+	nonce := make([]byte, b.aead.NonceSize())
+
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+
+	return b.aead.Seal(nonce, nonce, plaintext, nil), nil
+}
+
+func (b *Box) Open(sealed []byte) ([]byte, error) {
+	if len(sealed) < b.aead.NonceSize() {
+		return nil, ErrInvalidSealed
+	}
+
+	nonce, ciphertext := sealed[:b.aead.NonceSize()], sealed[b.aead.NonceSize():]
+
+	plaintext, err := b.aead.Open(nil, nonce, ciphertext, nil)
+
+	if err != nil {
+		return nil, ErrInvalidSealed
+	}
+
+	return plaintext, nil
+}
@@ -0,0 +1,138 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"ssosage/internal/models"
+	"ssosage/internal/services/ssosage"
+	"ssosage/internal/storage"
+	"strings"
+
+	"github.com/hyperfyodor/ssosage_proto"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+func (s *server) ListApps(ctx context.Context, request *ssosage_proto.ListAppsRequest) (*ssosage_proto.ListAppsResponse, error) {
+	apps, next, err := s.ssosage.ListApps(ctx, listQuery(request.GetQuery()))
+
+	if err != nil {
+		if listErr := listError(err); listErr != nil {
+			return nil, listErr
+		}
+
+		return nil, status.Error(codes.Internal, "failed to list apps")
+	}
+
+	response := &ssosage_proto.ListAppsResponse{NextCursor: next}
+
+	for _, app := range apps {
+		response.Apps = append(response.Apps, protoApp(app))
+	}
+
+	return response, nil
+}
+
+func (s *server) GetApp(ctx context.Context, request *ssosage_proto.GetAppRequest) (*ssosage_proto.GetAppResponse, error) {
+	if !nameIsValid(request.GetAppName()) {
+		return nil, status.Error(codes.InvalidArgument, "invalid app name")
+	}
+
+	app, err := s.ssosage.GetApp(ctx, request.GetAppName())
+
+	if err != nil {
+		return nil, appError(err, "failed to get app")
+	}
+
+	return &ssosage_proto.GetAppResponse{App: protoApp(app)}, nil
+}
+
+func (s *server) UpdateApp(ctx context.Context, request *ssosage_proto.UpdateAppRequest) (*ssosage_proto.UpdateAppResponse, error) {
+	if !nameIsValid(request.GetAppName()) {
+		return nil, status.Error(codes.InvalidArgument, "invalid app name")
+	}
+
+	update := models.AppUpdate{
+		DisplayName:          request.DisplayName,
+		Settings:             request.Settings,
+		RequireMFA:           request.RequireMfa,
+		RequireVerifiedEmail: request.RequireVerifiedEmail,
+		ProfileClaims:        request.ProfileClaims,
+		OrgClients:           request.OrgClients,
+		RoleGrants:           request.RoleGrants,
+		GroupsClaim:          request.GroupsClaim,
+	}
+
+	if request.GetRoles() != nil {
+		if !rolesAreValid(request.GetRoles().GetRoles()) {
+			return nil, status.Error(codes.InvalidArgument, "invalid app roles")
+		}
+
+		roles := strings.Join(request.GetRoles().GetRoles(), ",")
+		update.Roles = &roles
+	}
+
+	version, err := s.ssosage.UpdateApp(ctx, request.GetAppName(), request.GetVersion(), update)
+
+	if err != nil {
+		if errors.Is(err, storage.ErrAppConflict) {
+			return nil, status.Error(codes.Aborted, "app was changed by someone else, get it again and retry")
+		}
+
+		if errors.Is(err, ssosage.ErrInvalidRole) {
+			return nil, status.Error(codes.InvalidArgument, "invalid app roles")
+		}
+
+		if errors.Is(err, ssosage.ErrInvalidSettings) {
+			return nil, status.Error(codes.InvalidArgument, "app settings must be a json object")
+		}
+
+		if errors.Is(err, ssosage.ErrInvalidProfileClaims) {
+			return nil, status.Error(codes.InvalidArgument, "invalid profile claims")
+		}
+
+		return nil, appError(err, "failed to update app")
+	}
+
+	return &ssosage_proto.UpdateAppResponse{Version: version}, nil
+}
+
+func (s *server) DeleteApp(ctx context.Context, request *ssosage_proto.DeleteAppRequest) (*ssosage_proto.DeleteAppResponse, error) {
+	if !nameIsValid(request.GetAppName()) {
+		return nil, status.Error(codes.InvalidArgument, "invalid app name")
+	}
+
+	if err := s.ssosage.DeleteApp(ctx, request.GetAppName()); err != nil {
+		return nil, appError(err, "failed to delete app")
+	}
+
+	return &ssosage_proto.DeleteAppResponse{}, nil
+}
+
+func protoApp(app models.App) *ssosage_proto.App {
+	return &ssosage_proto.App{
+		Name:                 app.Name,
+		Roles:                strings.Split(app.Roles, ","),
+		CreatedAt:            timestamppb.New(app.CreatedAt),
+		UpdatedAt:            timestamppb.New(app.UpdatedAt),
+		DisplayName:          app.DisplayName,
+		Settings:             app.Settings,
+		Version:              app.Version,
+		RequireMfa:           app.RequireMFA,
+		RequireVerifiedEmail: app.RequireVerifiedEmail,
+		ProfileClaims:        app.ProfileClaims,
+		OrgClients:           app.OrgClients,
+		RoleGrants:           app.RoleGrants,
+		GroupsClaim:          app.GroupsClaim,
+	}
+}
+
+// appError maps the errors of admin methods naming an app, msg describes anything else
+func appError(err error, msg string) error {
+	if errors.Is(err, storage.ErrAppNotFound) {
+		return status.Error(codes.NotFound, "app not found")
+	}
+
+	return status.Error(codes.Internal, msg)
+}
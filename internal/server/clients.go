@@ -0,0 +1,162 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"ssosage/internal/helpers"
+	"ssosage/internal/models"
+	"ssosage/internal/services/ssosage"
+	"ssosage/internal/storage"
+
+	"github.com/hyperfyodor/ssosage_proto"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+func (s *server) ChangePassword(ctx context.Context, request *ssosage_proto.ChangePasswordRequest) (*ssosage_proto.ChangePasswordResponse, error) {
+	if !nameIsValid(request.GetClientName()) {
+		return nil, status.Error(codes.InvalidArgument, "invalid client name")
+	}
+
+	if !passwordIsValid(request.GetOldPassword()) || !passwordIsValid(request.GetNewPassword()) {
+		return nil, status.Error(codes.InvalidArgument, "invalid password")
+	}
+
+	err := s.ssosage.ChangePassword(ctx, request.GetClientName(), request.GetOldPassword(), request.GetNewPassword(), request.GetTotpCode(), helpers.PeerIP(ctx))
+
+	if err != nil {
+		if authErr := authError(ctx, err); authErr != nil {
+			return nil, authErr
+		}
+
+		return nil, status.Error(codes.Internal, "failed to change password")
+	}
+
+	return &ssosage_proto.ChangePasswordResponse{}, nil
+}
+
+func (s *server) ResetPassword(ctx context.Context, request *ssosage_proto.ResetPasswordRequest) (*ssosage_proto.ResetPasswordResponse, error) {
+	if !nameIsValid(request.GetClientName()) {
+		return nil, status.Error(codes.InvalidArgument, "invalid client name")
+	}
+
+	if !passwordIsValid(request.GetNewPassword()) {
+		return nil, status.Error(codes.InvalidArgument, "invalid password")
+	}
+
+	if err := s.ssosage.ResetPassword(ctx, request.GetClientName(), request.GetNewPassword()); err != nil {
+		return nil, clientError(err, "failed to reset password")
+	}
+
+	return &ssosage_proto.ResetPasswordResponse{}, nil
+}
+
+func (s *server) DisableClient(ctx context.Context, request *ssosage_proto.DisableClientRequest) (*ssosage_proto.DisableClientResponse, error) {
+	if !nameIsValid(request.GetClientName()) {
+		return nil, status.Error(codes.InvalidArgument, "invalid client name")
+	}
+
+	if err := s.ssosage.DisableClient(ctx, request.GetClientName()); err != nil {
+		return nil, clientError(err, "failed to disable client")
+	}
+
+	return &ssosage_proto.DisableClientResponse{}, nil
+}
+
+func (s *server) EnableClient(ctx context.Context, request *ssosage_proto.EnableClientRequest) (*ssosage_proto.EnableClientResponse, error) {
+	if !nameIsValid(request.GetClientName()) {
+		return nil, status.Error(codes.InvalidArgument, "invalid client name")
+	}
+
+	if err := s.ssosage.EnableClient(ctx, request.GetClientName()); err != nil {
+		return nil, clientError(err, "failed to enable client")
+	}
+
+	return &ssosage_proto.EnableClientResponse{}, nil
+}
+
+func (s *server) DeleteClient(ctx context.Context, request *ssosage_proto.DeleteClientRequest) (*ssosage_proto.DeleteClientResponse, error) {
+	if !nameIsValid(request.GetClientName()) {
+		return nil, status.Error(codes.InvalidArgument, "invalid client name")
+	}
+
+	if err := s.ssosage.DeleteClient(ctx, request.GetClientName()); err != nil {
+		return nil, clientError(err, "failed to delete client")
+	}
+
+	return &ssosage_proto.DeleteClientResponse{}, nil
+}
+
+func (s *server) IntrospectToken(ctx context.Context, request *ssosage_proto.IntrospectTokenRequest) (*ssosage_proto.IntrospectTokenResponse, error) {
+	token, err := s.ssosage.VerifyToken(ctx, request.GetToken())
+
+	if err != nil {
+		if errors.Is(err, ssosage.ErrInvalidToken) {
+			return &ssosage_proto.IntrospectTokenResponse{}, nil
+		}
+
+		return nil, status.Error(codes.Internal, "failed to verify token")
+	}
+
+	response := &ssosage_proto.IntrospectTokenResponse{
+		Active:        true,
+		ClientName:    token.ClientName,
+		AppName:       token.AppName,
+		Role:          token.Role,
+		ExpiresAt:     timestamppb.New(token.ExpiresAt),
+		EmailVerified: token.EmailVerified,
+		OrgId:         token.OrgID,
+		Org:           token.Org,
+		Roles:         token.Roles,
+		Groups:        token.Groups,
+	}
+
+	if token.Profile != (models.Profile{}) {
+		response.Profile = protoProfile(token.Profile)
+	}
+
+	return response, nil
+}
+
+func (s *server) ListClients(ctx context.Context, request *ssosage_proto.ListClientsRequest) (*ssosage_proto.ListClientsResponse, error) {
+	clients, next, err := s.ssosage.ListClients(ctx, listQuery(request.GetQuery()))
+
+	if err != nil {
+		if listErr := listError(err); listErr != nil {
+			return nil, listErr
+		}
+
+		return nil, status.Error(codes.Internal, "failed to list clients")
+	}
+
+	response := &ssosage_proto.ListClientsResponse{NextCursor: next}
+
+	for _, client := range clients {
+		response.Clients = append(response.Clients, protoClient(client))
+	}
+
+	return response, nil
+}
+
+func protoClient(client models.Client) *ssosage_proto.Client {
+	return &ssosage_proto.Client{
+		Name:            client.Name,
+		Status:          client.Status,
+		CreatedAt:       timestamppb.New(client.CreatedAt),
+		UpdatedAt:       timestamppb.New(client.UpdatedAt),
+		TotpEnabled:     client.TOTPEnabled,
+		WebauthnEnabled: client.WebAuthnEnabled,
+		Email:           client.Email,
+		EmailVerified:   client.EmailVerified,
+	}
+}
+
+// clientError maps the errors of admin methods naming a client, msg describes anything else
+func clientError(err error, msg string) error {
+	if errors.Is(err, storage.ErrClientNotFound) {
+		return status.Error(codes.NotFound, "client not found")
+	}
+
+	return status.Error(codes.Internal, msg)
+}
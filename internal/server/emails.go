@@ -0,0 +1,72 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"ssosage/internal/helpers"
+	"ssosage/internal/services/ssosage"
+
+	"github.com/hyperfyodor/ssosage_proto"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func (s *server) SetEmail(ctx context.Context, request *ssosage_proto.SetEmailRequest) (*ssosage_proto.SetEmailResponse, error) {
+	if !nameIsValid(request.GetClientName()) {
+		return nil, status.Error(codes.InvalidArgument, "invalid client name")
+	}
+
+	if !passwordIsValid(request.GetPassword()) {
+		return nil, status.Error(codes.InvalidArgument, "invalid password")
+	}
+
+	err := s.ssosage.SetEmail(ctx, request.GetClientName(), request.GetPassword(), request.GetTotpCode(), request.GetEmail(), helpers.PeerIP(ctx))
+
+	if err != nil {
+		return nil, emailError(ctx, err, "failed to set email")
+	}
+
+	return &ssosage_proto.SetEmailResponse{}, nil
+}
+
+func (s *server) SendEmailVerification(ctx context.Context, request *ssosage_proto.SendEmailVerificationRequest) (*ssosage_proto.SendEmailVerificationResponse, error) {
+	if !nameIsValid(request.GetClientName()) {
+		return nil, status.Error(codes.InvalidArgument, "invalid client name")
+	}
+
+	if err := s.ssosage.SendEmailVerification(ctx, request.GetClientName()); err != nil {
+		return nil, emailError(ctx, err, "failed to send email verification")
+	}
+
+	return &ssosage_proto.SendEmailVerificationResponse{}, nil
+}
+
+func (s *server) VerifyEmail(ctx context.Context, request *ssosage_proto.VerifyEmailRequest) (*ssosage_proto.VerifyEmailResponse, error) {
+	if request.GetToken() == "" {
+		return nil, status.Error(codes.InvalidArgument, "invalid verification token")
+	}
+
+	if err := s.ssosage.VerifyEmail(ctx, request.GetToken()); err != nil {
+		return nil, emailError(ctx, err, "failed to verify email")
+	}
+
+	return &ssosage_proto.VerifyEmailResponse{}, nil
+}
+
+// emailError maps the errors of the email methods, msg describes anything else
+func emailError(ctx context.Context, err error, msg string) error {
+	if authErr := authError(ctx, err); authErr != nil {
+		return authErr
+	}
+
+	switch {
+	case errors.Is(err, ssosage.ErrEmailUnavailable):
+		return status.Error(codes.FailedPrecondition, "email verification is not configured")
+	case errors.Is(err, ssosage.ErrInvalidEmail):
+		return status.Error(codes.InvalidArgument, "invalid email address")
+	case errors.Is(err, ssosage.ErrInvalidVerificationToken):
+		return status.Error(codes.InvalidArgument, "invalid or expired email verification token")
+	}
+
+	return status.Error(codes.Internal, msg)
+}
@@ -0,0 +1,161 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"ssosage/internal/models"
+	"ssosage/internal/services/ssosage"
+	"ssosage/internal/storage"
+
+	"github.com/hyperfyodor/ssosage_proto"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func (s *server) RegisterGroup(ctx context.Context, request *ssosage_proto.RegisterGroupRequest) (*ssosage_proto.RegisterGroupResponse, error) {
+	if !nameIsValid(request.GetGroupName()) {
+		return nil, status.Error(codes.InvalidArgument, "invalid group name")
+	}
+
+	id, err := s.ssosage.RegisterGroup(ctx, request.GetGroupName())
+
+	if err != nil {
+		if errors.Is(err, storage.ErrGroupExists) {
+			return nil, status.Error(codes.AlreadyExists, "group already exists")
+		}
+
+		return nil, groupError(err, "failed to register group")
+	}
+
+	return &ssosage_proto.RegisterGroupResponse{GroupId: id}, nil
+}
+
+func (s *server) DeleteGroup(ctx context.Context, request *ssosage_proto.DeleteGroupRequest) (*ssosage_proto.DeleteGroupResponse, error) {
+	if !nameIsValid(request.GetGroupName()) {
+		return nil, status.Error(codes.InvalidArgument, "invalid group name")
+	}
+
+	if err := s.ssosage.DeleteGroup(ctx, request.GetGroupName()); err != nil {
+		return nil, groupError(err, "failed to delete group")
+	}
+
+	return &ssosage_proto.DeleteGroupResponse{}, nil
+}
+
+func (s *server) AddGroupMember(ctx context.Context, request *ssosage_proto.AddGroupMemberRequest) (*ssosage_proto.AddGroupMemberResponse, error) {
+	if !nameIsValid(request.GetGroupName()) {
+		return nil, status.Error(codes.InvalidArgument, "invalid group name")
+	}
+
+	if !nameIsValid(request.GetClientName()) {
+		return nil, status.Error(codes.InvalidArgument, "invalid client name")
+	}
+
+	if err := s.ssosage.AddGroupMember(ctx, request.GetGroupName(), request.GetClientName()); err != nil {
+		return nil, groupError(err, "failed to add group member")
+	}
+
+	return &ssosage_proto.AddGroupMemberResponse{}, nil
+}
+
+func (s *server) RemoveGroupMember(ctx context.Context, request *ssosage_proto.RemoveGroupMemberRequest) (*ssosage_proto.RemoveGroupMemberResponse, error) {
+	if !nameIsValid(request.GetGroupName()) {
+		return nil, status.Error(codes.InvalidArgument, "invalid group name")
+	}
+
+	if !nameIsValid(request.GetClientName()) {
+		return nil, status.Error(codes.InvalidArgument, "invalid client name")
+	}
+
+	if err := s.ssosage.RemoveGroupMember(ctx, request.GetGroupName(), request.GetClientName()); err != nil {
+		return nil, groupError(err, "failed to remove group member")
+	}
+
+	return &ssosage_proto.RemoveGroupMemberResponse{}, nil
+}
+
+func (s *server) GrantRole(ctx context.Context, request *ssosage_proto.GrantRoleRequest) (*ssosage_proto.GrantRoleResponse, error) {
+	if !nameIsValid(request.GetAppName()) {
+		return nil, status.Error(codes.InvalidArgument, "invalid app name")
+	}
+
+	if !roleIsValid(request.GetRole()) {
+		return nil, status.Error(codes.InvalidArgument, "invalid role")
+	}
+
+	err := s.ssosage.GrantRole(ctx, models.RoleGrant{
+		ClientName: request.GetClientName(),
+		GroupName:  request.GetGroupName(),
+		AppName:    request.GetAppName(),
+		Role:       request.GetRole(),
+	})
+
+	if err != nil {
+		return nil, groupError(err, "failed to grant role")
+	}
+
+	return &ssosage_proto.GrantRoleResponse{}, nil
+}
+
+func (s *server) RevokeRole(ctx context.Context, request *ssosage_proto.RevokeRoleRequest) (*ssosage_proto.RevokeRoleResponse, error) {
+	if !nameIsValid(request.GetAppName()) {
+		return nil, status.Error(codes.InvalidArgument, "invalid app name")
+	}
+
+	if !roleIsValid(request.GetRole()) {
+		return nil, status.Error(codes.InvalidArgument, "invalid role")
+	}
+
+	err := s.ssosage.RevokeRole(ctx, models.RoleGrant{
+		ClientName: request.GetClientName(),
+		GroupName:  request.GetGroupName(),
+		AppName:    request.GetAppName(),
+		Role:       request.GetRole(),
+	})
+
+	if err != nil {
+		return nil, groupError(err, "failed to revoke role")
+	}
+
+	return &ssosage_proto.RevokeRoleResponse{}, nil
+}
+
+func (s *server) ClientRoles(ctx context.Context, request *ssosage_proto.ClientRolesRequest) (*ssosage_proto.ClientRolesResponse, error) {
+	if !nameIsValid(request.GetClientName()) {
+		return nil, status.Error(codes.InvalidArgument, "invalid client name")
+	}
+
+	if !nameIsValid(request.GetAppName()) {
+		return nil, status.Error(codes.InvalidArgument, "invalid app name")
+	}
+
+	roles, err := s.ssosage.ClientRoles(ctx, request.GetClientName(), request.GetAppName())
+
+	if err != nil {
+		return nil, groupError(err, "failed to get client roles")
+	}
+
+	return &ssosage_proto.ClientRolesResponse{Roles: roles}, nil
+}
+
+// groupError maps the errors of the group and role grant methods, msg describes anything else
+func groupError(err error, msg string) error {
+	switch {
+	case errors.Is(err, ssosage.ErrInvalidName):
+		return status.Error(codes.InvalidArgument, "invalid group name")
+	case errors.Is(err, ssosage.ErrInvalidGrant):
+		return status.Error(codes.InvalidArgument, "a role is granted to either a client or a group")
+	case errors.Is(err, ssosage.ErrInvalidRole):
+		return status.Error(codes.InvalidArgument, "invalid role")
+	case errors.Is(err, ssosage.ErrOtherOrganization):
+		return status.Error(codes.InvalidArgument, "groups only take clients and apps of their own organization")
+	case errors.Is(err, storage.ErrGroupNotFound):
+		return status.Error(codes.NotFound, "group not found")
+	case errors.Is(err, storage.ErrClientNotFound):
+		return status.Error(codes.NotFound, "client not found")
+	case errors.Is(err, storage.ErrAppNotFound):
+		return status.Error(codes.NotFound, "app not found")
+	}
+
+	return status.Error(codes.Internal, msg)
+}
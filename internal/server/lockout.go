@@ -0,0 +1,37 @@
+package server
+
+import (
+	"context"
+	"net"
+
+	"github.com/hyperfyodor/ssosage_proto"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func (s *server) UnlockClient(ctx context.Context, request *ssosage_proto.UnlockClientRequest) (*ssosage_proto.UnlockClientResponse, error) {
+	if !nameIsValid(request.GetClientName()) {
+		return nil, status.Error(codes.InvalidArgument, "invalid client name")
+	}
+
+	if err := s.ssosage.UnlockClient(ctx, request.GetClientName()); err != nil {
+		return nil, status.Error(codes.Internal, "failed to unlock client")
+	}
+
+	return &ssosage_proto.UnlockClientResponse{}, nil
+}
+
+func (s *server) UnlockAddress(ctx context.Context, request *ssosage_proto.UnlockAddressRequest) (*ssosage_proto.UnlockAddressResponse, error) {
+	ip := net.ParseIP(request.GetIp())
+
+	if ip == nil {
+		return nil, status.Error(codes.InvalidArgument, "invalid ip")
+	}
+
+	// attempts are counted under the ip as the server sees it
+	if err := s.ssosage.UnlockAddress(ctx, ip.String()); err != nil {
+		return nil, status.Error(codes.Internal, "failed to unlock address")
+	}
+
+	return &ssosage_proto.UnlockAddressResponse{}, nil
+}
@@ -0,0 +1,104 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"ssosage/internal/helpers"
+	"ssosage/internal/services/ssosage"
+
+	"github.com/hyperfyodor/ssosage_proto"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+func (s *server) BeginLogin(ctx context.Context, request *ssosage_proto.BeginLoginRequest) (*ssosage_proto.BeginLoginResponse, error) {
+	if !nameIsValid(request.GetClientName()) {
+		return nil, status.Error(codes.InvalidArgument, "invalid client name")
+	}
+
+	if !nameIsValid(request.GetAppName()) {
+		return nil, status.Error(codes.InvalidArgument, "invalid app name")
+	}
+
+	if !roleIsValid(request.GetRole()) {
+		return nil, status.Error(codes.InvalidArgument, "invalid role")
+	}
+
+	step, err := s.ssosage.BeginLogin(ctx, request.GetClientName(), request.GetAppName(), request.GetRole())
+
+	if err != nil {
+		return nil, loginError(ctx, err, "failed to begin login")
+	}
+
+	return &ssosage_proto.BeginLoginResponse{Step: protoLoginStep(step)}, nil
+}
+
+func (s *server) CompleteFactor(ctx context.Context, request *ssosage_proto.CompleteFactorRequest) (*ssosage_proto.CompleteFactorResponse, error) {
+	if request.GetChallenge() == "" {
+		return nil, status.Error(codes.InvalidArgument, "invalid challenge")
+	}
+
+	if request.GetFactor() == "" {
+		return nil, status.Error(codes.InvalidArgument, "invalid factor")
+	}
+
+	if request.GetValue() == "" {
+		return nil, status.Error(codes.InvalidArgument, "invalid factor value")
+	}
+
+	step, err := s.ssosage.CompleteFactor(ctx, request.GetChallenge(), request.GetFactor(), request.GetValue(), helpers.PeerIP(ctx))
+
+	if err != nil {
+		return nil, loginError(ctx, err, "failed to complete factor")
+	}
+
+	return &ssosage_proto.CompleteFactorResponse{Step: protoLoginStep(step)}, nil
+}
+
+// protoLoginStep leaves out the expiry of a finished login
+func protoLoginStep(step ssosage.LoginStep) *ssosage_proto.LoginStep {
+	result := &ssosage_proto.LoginStep{
+		Challenge: step.Challenge,
+		Remaining: step.Remaining,
+		Token:     step.Token,
+	}
+
+	if !step.ExpiresAt.IsZero() {
+		result.ExpiresAt = timestamppb.New(step.ExpiresAt)
+	}
+
+	return result
+}
+
+// loginError maps the errors of the multi-step login, msg describes anything else
+func loginError(ctx context.Context, err error, msg string) error {
+	if authErr := authError(ctx, err); authErr != nil {
+		return authErr
+	}
+
+	switch {
+	case errors.Is(err, ssosage.ErrInvalidChallenge):
+		return status.Error(codes.InvalidArgument, "invalid or expired login challenge")
+	case errors.Is(err, ssosage.ErrUnexpectedFactor):
+		return status.Error(codes.FailedPrecondition, "factor is not the next one required")
+	case errors.Is(err, ssosage.ErrInvalidPasskey):
+		return status.Error(codes.InvalidArgument, "invalid passkey response")
+	case errors.Is(err, ssosage.ErrPasskeyExists):
+		return status.Error(codes.AlreadyExists, "passkey is already registered")
+	case errors.Is(err, ssosage.ErrWebAuthnUnavailable):
+		return status.Error(codes.FailedPrecondition, "webauthn is not configured")
+	case errors.Is(err, ssosage.ErrMFARequired):
+		return status.Error(codes.FailedPrecondition, "app requires two-factor authentication")
+	case errors.Is(err, ssosage.ErrRoleNotGranted):
+		return status.Error(codes.PermissionDenied, "role is not granted to the client")
+	case errors.Is(err, ssosage.ErrEmailNotVerified):
+		return status.Error(codes.FailedPrecondition, "app requires a verified email")
+	case errors.Is(err, ssosage.ErrInvalidRole):
+		return status.Error(codes.InvalidArgument, "invalid role")
+	case errors.Is(err, ssosage.ErrInvalidApp):
+		return status.Error(codes.InvalidArgument, "invalid app")
+	}
+
+	return status.Error(codes.Internal, msg)
+}
@@ -0,0 +1,114 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"ssosage/internal/helpers"
+	"ssosage/internal/services/ssosage"
+
+	"github.com/hyperfyodor/ssosage_proto"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func (s *server) EnrollTOTP(ctx context.Context, request *ssosage_proto.EnrollTOTPRequest) (*ssosage_proto.EnrollTOTPResponse, error) {
+	if !nameIsValid(request.GetClientName()) {
+		return nil, status.Error(codes.InvalidArgument, "invalid client name")
+	}
+
+	if !passwordIsValid(request.GetPassword()) {
+		return nil, status.Error(codes.InvalidArgument, "invalid password")
+	}
+
+	secret, uri, err := s.ssosage.EnrollTOTP(ctx, request.GetClientName(), request.GetPassword(), helpers.PeerIP(ctx))
+
+	if err != nil {
+		return nil, totpError(ctx, err, "failed to enroll totp")
+	}
+
+	return &ssosage_proto.EnrollTOTPResponse{Secret: secret, OtpauthUri: uri}, nil
+}
+
+func (s *server) ConfirmTOTP(ctx context.Context, request *ssosage_proto.ConfirmTOTPRequest) (*ssosage_proto.ConfirmTOTPResponse, error) {
+	if !nameIsValid(request.GetClientName()) {
+		return nil, status.Error(codes.InvalidArgument, "invalid client name")
+	}
+
+	if !passwordIsValid(request.GetPassword()) {
+		return nil, status.Error(codes.InvalidArgument, "invalid password")
+	}
+
+	recoveryCodes, err := s.ssosage.ConfirmTOTP(ctx, request.GetClientName(), request.GetPassword(), request.GetTotpCode(), helpers.PeerIP(ctx))
+
+	if err != nil {
+		return nil, totpError(ctx, err, "failed to confirm totp")
+	}
+
+	return &ssosage_proto.ConfirmTOTPResponse{RecoveryCodes: recoveryCodes}, nil
+}
+
+func (s *server) DisableTOTP(ctx context.Context, request *ssosage_proto.DisableTOTPRequest) (*ssosage_proto.DisableTOTPResponse, error) {
+	if !nameIsValid(request.GetClientName()) {
+		return nil, status.Error(codes.InvalidArgument, "invalid client name")
+	}
+
+	if !passwordIsValid(request.GetPassword()) {
+		return nil, status.Error(codes.InvalidArgument, "invalid password")
+	}
+
+	if err := s.ssosage.DisableTOTP(ctx, request.GetClientName(), request.GetPassword(), request.GetTotpCode(), helpers.PeerIP(ctx)); err != nil {
+		return nil, totpError(ctx, err, "failed to disable totp")
+	}
+
+	return &ssosage_proto.DisableTOTPResponse{}, nil
+}
+
+func (s *server) ResetTOTP(ctx context.Context, request *ssosage_proto.ResetTOTPRequest) (*ssosage_proto.ResetTOTPResponse, error) {
+	if !nameIsValid(request.GetClientName()) {
+		return nil, status.Error(codes.InvalidArgument, "invalid client name")
+	}
+
+	if err := s.ssosage.ResetTOTP(ctx, request.GetClientName()); err != nil {
+		return nil, clientError(err, "failed to reset totp")
+	}
+
+	return &ssosage_proto.ResetTOTPResponse{}, nil
+}
+
+func (s *server) RegenerateRecoveryCodes(ctx context.Context, request *ssosage_proto.RegenerateRecoveryCodesRequest) (*ssosage_proto.RegenerateRecoveryCodesResponse, error) {
+	if !nameIsValid(request.GetClientName()) {
+		return nil, status.Error(codes.InvalidArgument, "invalid client name")
+	}
+
+	if !passwordIsValid(request.GetPassword()) {
+		return nil, status.Error(codes.InvalidArgument, "invalid password")
+	}
+
+	recoveryCodes, err := s.ssosage.RegenerateRecoveryCodes(ctx, request.GetClientName(), request.GetPassword(), request.GetTotpCode(), helpers.PeerIP(ctx))
+
+	if err != nil {
+		return nil, totpError(ctx, err, "failed to regenerate recovery codes")
+	}
+
+	return &ssosage_proto.RegenerateRecoveryCodesResponse{RecoveryCodes: recoveryCodes}, nil
+}
+
+// totpError maps the errors of the methods a client manages its second factor with, msg describes anything else
+func totpError(ctx context.Context, err error, msg string) error {
+	if authErr := authError(ctx, err); authErr != nil {
+		return authErr
+	}
+
+	switch {
+	case errors.Is(err, ssosage.ErrTOTPUnavailable):
+		return status.Error(codes.FailedPrecondition, "totp is not configured")
+	case errors.Is(err, ssosage.ErrTOTPEnabled):
+		return status.Error(codes.FailedPrecondition, "totp is already enabled")
+	case errors.Is(err, ssosage.ErrTOTPNotPending):
+		return status.Error(codes.FailedPrecondition, "no totp enrollment to confirm, call EnrollTOTP first")
+	case errors.Is(err, ssosage.ErrTOTPNotEnabled):
+		return status.Error(codes.FailedPrecondition, "totp is not enabled")
+	}
+
+	return status.Error(codes.Internal, msg)
+}
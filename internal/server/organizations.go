@@ -0,0 +1,54 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"ssosage/internal/services/ssosage"
+	"ssosage/internal/storage"
+
+	"github.com/hyperfyodor/ssosage_proto"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func (s *server) RegisterOrganization(ctx context.Context, request *ssosage_proto.RegisterOrganizationRequest) (*ssosage_proto.RegisterOrganizationResponse, error) {
+	if !nameIsValid(request.GetName()) {
+		return nil, status.Error(codes.InvalidArgument, "invalid organization name")
+	}
+
+	id, err := s.ssosage.RegisterOrganization(ctx, request.GetName())
+
+	if err != nil {
+		if errors.Is(err, ssosage.ErrInvalidOrganization) {
+			return nil, status.Error(codes.InvalidArgument, "invalid organization name")
+		}
+
+		if errors.Is(err, storage.ErrOrganizationExists) {
+			return nil, status.Error(codes.AlreadyExists, "organization already exists")
+		}
+
+		return nil, status.Error(codes.Internal, "failed to register organization")
+	}
+
+	return &ssosage_proto.RegisterOrganizationResponse{OrgId: id}, nil
+}
+
+func (s *server) DeleteOrganization(ctx context.Context, request *ssosage_proto.DeleteOrganizationRequest) (*ssosage_proto.DeleteOrganizationResponse, error) {
+	if !nameIsValid(request.GetName()) {
+		return nil, status.Error(codes.InvalidArgument, "invalid organization name")
+	}
+
+	if err := s.ssosage.DeleteOrganization(ctx, request.GetName()); err != nil {
+		if errors.Is(err, storage.ErrOrganizationNotFound) {
+			return nil, status.Error(codes.NotFound, "organization not found")
+		}
+
+		if errors.Is(err, storage.ErrOrganizationNotEmpty) {
+			return nil, status.Error(codes.FailedPrecondition, "organization still has clients, apps or groups")
+		}
+
+		return nil, status.Error(codes.Internal, "failed to delete organization")
+	}
+
+	return &ssosage_proto.DeleteOrganizationResponse{}, nil
+}
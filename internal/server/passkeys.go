@@ -0,0 +1,104 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"ssosage/internal/models"
+	"ssosage/internal/services/ssosage"
+
+	"github.com/hyperfyodor/ssosage_proto"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+func (s *server) BeginPasskeyLogin(ctx context.Context, request *ssosage_proto.BeginPasskeyLoginRequest) (*ssosage_proto.BeginPasskeyLoginResponse, error) {
+	if !nameIsValid(request.GetClientName()) {
+		return nil, status.Error(codes.InvalidArgument, "invalid client name")
+	}
+
+	if !nameIsValid(request.GetAppName()) {
+		return nil, status.Error(codes.InvalidArgument, "invalid app name")
+	}
+
+	if !roleIsValid(request.GetRole()) {
+		return nil, status.Error(codes.InvalidArgument, "invalid role")
+	}
+
+	step, err := s.ssosage.BeginPasskeyLogin(ctx, request.GetClientName(), request.GetAppName(), request.GetRole())
+
+	if err != nil {
+		return nil, loginError(ctx, err, "failed to begin passkey login")
+	}
+
+	return &ssosage_proto.BeginPasskeyLoginResponse{Step: protoLoginStep(step)}, nil
+}
+
+func (s *server) BeginPasskeyRegistration(ctx context.Context, request *ssosage_proto.BeginPasskeyRegistrationRequest) (*ssosage_proto.BeginPasskeyRegistrationResponse, error) {
+	if !nameIsValid(request.GetClientName()) {
+		return nil, status.Error(codes.InvalidArgument, "invalid client name")
+	}
+
+	step, err := s.ssosage.BeginPasskeyRegistration(ctx, request.GetClientName())
+
+	if err != nil {
+		return nil, loginError(ctx, err, "failed to begin passkey registration")
+	}
+
+	return &ssosage_proto.BeginPasskeyRegistrationResponse{Step: protoLoginStep(step)}, nil
+}
+
+func (s *server) ListPasskeys(ctx context.Context, request *ssosage_proto.ListPasskeysRequest) (*ssosage_proto.ListPasskeysResponse, error) {
+	if !nameIsValid(request.GetClientName()) {
+		return nil, status.Error(codes.InvalidArgument, "invalid client name")
+	}
+
+	credentials, err := s.ssosage.ListPasskeys(ctx, request.GetClientName())
+
+	if err != nil {
+		return nil, status.Error(codes.Internal, "failed to list passkeys")
+	}
+
+	passkeys := make([]*ssosage_proto.Passkey, 0, len(credentials))
+
+	for _, credential := range credentials {
+		passkeys = append(passkeys, protoPasskey(credential))
+	}
+
+	return &ssosage_proto.ListPasskeysResponse{Passkeys: passkeys}, nil
+}
+
+func (s *server) DeletePasskey(ctx context.Context, request *ssosage_proto.DeletePasskeyRequest) (*ssosage_proto.DeletePasskeyResponse, error) {
+	if !nameIsValid(request.GetClientName()) {
+		return nil, status.Error(codes.InvalidArgument, "invalid client name")
+	}
+
+	if len(request.GetId()) == 0 {
+		return nil, status.Error(codes.InvalidArgument, "invalid passkey id")
+	}
+
+	if err := s.ssosage.DeletePasskey(ctx, request.GetClientName(), request.GetId()); err != nil {
+		if errors.Is(err, ssosage.ErrPasskeyNotFound) {
+			return nil, status.Error(codes.NotFound, "passkey not found")
+		}
+
+		return nil, status.Error(codes.Internal, "failed to delete passkey")
+	}
+
+	return &ssosage_proto.DeletePasskeyResponse{}, nil
+}
+
+// protoPasskey leaves last_used_at out for a passkey that never signed in
+func protoPasskey(credential models.WebAuthnCredential) *ssosage_proto.Passkey {
+	passkey := &ssosage_proto.Passkey{
+		Id:        credential.ID,
+		SignCount: credential.SignCount,
+		CreatedAt: timestamppb.New(credential.CreatedAt),
+	}
+
+	if !credential.LastUsedAt.IsZero() {
+		passkey.LastUsedAt = timestamppb.New(credential.LastUsedAt)
+	}
+
+	return passkey
+}
@@ -0,0 +1,73 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"ssosage/internal/models"
+	"ssosage/internal/services/ssosage"
+
+	"github.com/hyperfyodor/ssosage_proto"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+func (s *server) GetProfile(ctx context.Context, request *ssosage_proto.GetProfileRequest) (*ssosage_proto.GetProfileResponse, error) {
+	if !nameIsValid(request.GetClientName()) {
+		return nil, status.Error(codes.InvalidArgument, "invalid client name")
+	}
+
+	profile, err := s.ssosage.GetProfile(ctx, request.GetClientName())
+
+	if err != nil {
+		return nil, clientError(err, "failed to get profile")
+	}
+
+	return &ssosage_proto.GetProfileResponse{Profile: protoProfile(profile)}, nil
+}
+
+func (s *server) UpdateProfile(ctx context.Context, request *ssosage_proto.UpdateProfileRequest) (*ssosage_proto.UpdateProfileResponse, error) {
+	if !nameIsValid(request.GetClientName()) {
+		return nil, status.Error(codes.InvalidArgument, "invalid client name")
+	}
+
+	profile, err := s.ssosage.UpdateProfile(ctx, request.GetClientName(), models.ProfileUpdate{
+		DisplayName: request.DisplayName,
+		Locale:      request.Locale,
+		AvatarURL:   request.AvatarUrl,
+		Metadata:    request.Metadata,
+	})
+
+	if err != nil {
+		switch {
+		case errors.Is(err, ssosage.ErrInvalidDisplayName):
+			return nil, status.Error(codes.InvalidArgument, "invalid display name")
+		case errors.Is(err, ssosage.ErrInvalidLocale):
+			return nil, status.Error(codes.InvalidArgument, "invalid locale")
+		case errors.Is(err, ssosage.ErrInvalidAvatarURL):
+			return nil, status.Error(codes.InvalidArgument, "avatar url must be an absolute http or https url")
+		case errors.Is(err, ssosage.ErrInvalidMetadata):
+			return nil, status.Error(codes.InvalidArgument, "profile metadata must be a json object")
+		}
+
+		return nil, clientError(err, "failed to update profile")
+	}
+
+	return &ssosage_proto.UpdateProfileResponse{Profile: protoProfile(profile)}, nil
+}
+
+// protoProfile leaves updated_at out for a profile that was never updated
+func protoProfile(profile models.Profile) *ssosage_proto.Profile {
+	result := &ssosage_proto.Profile{
+		DisplayName: profile.DisplayName,
+		Locale:      profile.Locale,
+		AvatarUrl:   profile.AvatarURL,
+		Metadata:    profile.Metadata,
+	}
+
+	if !profile.UpdatedAt.IsZero() {
+		result.UpdatedAt = timestamppb.New(profile.UpdatedAt)
+	}
+
+	return result
+}
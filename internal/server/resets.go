@@ -0,0 +1,51 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"ssosage/internal/services/ssosage"
+
+	"github.com/hyperfyodor/ssosage_proto"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func (s *server) RequestPasswordReset(ctx context.Context, request *ssosage_proto.RequestPasswordResetRequest) (*ssosage_proto.RequestPasswordResetResponse, error) {
+	if !nameIsValid(request.GetClientName()) {
+		return nil, status.Error(codes.InvalidArgument, "invalid client name")
+	}
+
+	if err := s.ssosage.RequestPasswordReset(ctx, request.GetClientName()); err != nil {
+		return nil, resetError(err, "failed to request password reset")
+	}
+
+	return &ssosage_proto.RequestPasswordResetResponse{}, nil
+}
+
+func (s *server) ConfirmPasswordReset(ctx context.Context, request *ssosage_proto.ConfirmPasswordResetRequest) (*ssosage_proto.ConfirmPasswordResetResponse, error) {
+	if request.GetToken() == "" {
+		return nil, status.Error(codes.InvalidArgument, "invalid reset token")
+	}
+
+	if !passwordIsValid(request.GetNewPassword()) {
+		return nil, status.Error(codes.InvalidArgument, "invalid password")
+	}
+
+	if err := s.ssosage.ConfirmPasswordReset(ctx, request.GetToken(), request.GetNewPassword()); err != nil {
+		return nil, resetError(err, "failed to reset password")
+	}
+
+	return &ssosage_proto.ConfirmPasswordResetResponse{}, nil
+}
+
+// resetError maps the errors of the password reset methods, msg describes anything else
+func resetError(err error, msg string) error {
+	switch {
+	case errors.Is(err, ssosage.ErrPasswordResetUnavailable):
+		return status.Error(codes.FailedPrecondition, "password reset is not configured")
+	case errors.Is(err, ssosage.ErrInvalidResetToken):
+		return status.Error(codes.InvalidArgument, "invalid or expired password reset token")
+	}
+
+	return status.Error(codes.Internal, msg)
+}
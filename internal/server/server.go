@@ -3,12 +3,20 @@ package server
 import (
 	"context"
 	"errors"
+	"math"
+	"ssosage/internal/helpers"
+	"ssosage/internal/interceptors/ratelimit"
+	"ssosage/internal/models"
 	"ssosage/internal/services/ssosage"
 	"ssosage/internal/storage"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/hyperfyodor/ssosage_proto"
+	"google.golang.org/grpc"
 	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
 	"google.golang.org/grpc/status"
 )
 
@@ -46,6 +54,14 @@ func (s *server) RegisterApp(ctx context.Context, request *ssosage_proto.Registe
 			return nil, status.Error(codes.AlreadyExists, "app already exists")
 		}
 
+		if errors.Is(err, ssosage.ErrInvalidName) {
+			return nil, status.Error(codes.InvalidArgument, "invalid app name")
+		}
+
+		if errors.Is(err, storage.ErrOrganizationNotFound) {
+			return nil, status.Error(codes.InvalidArgument, "organization not found")
+		}
+
 		return nil, status.Error(codes.Internal, "failed to register app")
 	}
 
@@ -68,6 +84,14 @@ func (s *server) RegisterClient(ctx context.Context, request *ssosage_proto.Regi
 			return nil, status.Error(codes.AlreadyExists, "client already exists")
 		}
 
+		if errors.Is(err, ssosage.ErrInvalidName) {
+			return nil, status.Error(codes.InvalidArgument, "invalid client name")
+		}
+
+		if errors.Is(err, storage.ErrOrganizationNotFound) {
+			return nil, status.Error(codes.InvalidArgument, "organization not found")
+		}
+
 		return nil, status.Error(codes.Internal, "failed to register client")
 	}
 
@@ -91,11 +115,23 @@ func (s *server) GenerateToken(ctx context.Context, request *ssosage_proto.Gener
 		return nil, status.Error(codes.InvalidArgument, "invalid role")
 	}
 
-	token, err := s.ssosage.GenerateToken(ctx, request.GetClientName(), request.GetPassword(), request.GetAppName(), request.GetRole())
+	token, err := s.ssosage.GenerateToken(ctx, request.GetClientName(), request.GetPassword(), request.GetTotpCode(), request.GetAppName(), request.GetRole(), helpers.PeerIP(ctx))
 
 	if err != nil {
-		if errors.Is(err, ssosage.ErrInvalidCredentials) {
-			return nil, status.Error(codes.InvalidArgument, "invalid credentials")
+		if authErr := authError(ctx, err); authErr != nil {
+			return nil, authErr
+		}
+
+		if errors.Is(err, ssosage.ErrMFARequired) {
+			return nil, status.Error(codes.FailedPrecondition, "app requires two-factor authentication")
+		}
+
+		if errors.Is(err, ssosage.ErrRoleNotGranted) {
+			return nil, status.Error(codes.PermissionDenied, "role is not granted to the client")
+		}
+
+		if errors.Is(err, ssosage.ErrEmailNotVerified) {
+			return nil, status.Error(codes.FailedPrecondition, "app requires a verified email")
 		}
 
 		if errors.Is(err, ssosage.ErrInvalidRole) {
@@ -134,3 +170,61 @@ func secretIsValid(secret string) bool {
 func roleIsValid(role string) bool {
 	return len(role) > 0
 }
+
+func listQuery(query *ssosage_proto.ListQuery) models.ListQuery {
+	return models.ListQuery{
+		NamePrefix: query.GetNamePrefix(),
+		SortBy:     query.GetSortBy(),
+		Desc:       query.GetDesc(),
+		Limit:      int(query.GetLimit()),
+		Cursor:     query.GetCursor(),
+	}
+}
+
+// listError maps the errors of a bad list query, it is nil for other errors
+func listError(err error) error {
+	switch {
+	case errors.Is(err, storage.ErrInvalidSort):
+		return status.Error(codes.InvalidArgument, "invalid sort field")
+	case errors.Is(err, storage.ErrInvalidCursor):
+		return status.Error(codes.InvalidArgument, "invalid cursor")
+	}
+
+	return nil
+}
+
+// authError maps the errors of proving who a client is, it is nil for other errors
+func authError(ctx context.Context, err error) error {
+	switch {
+	case errors.Is(err, ssosage.ErrLoginLocked):
+		return lockedError(ctx, err)
+	case errors.Is(err, ssosage.ErrInvalidCredentials):
+		return status.Error(codes.InvalidArgument, "invalid credentials")
+	case errors.Is(err, ssosage.ErrTOTPRequired):
+		return status.Error(codes.Unauthenticated, "totp code required")
+	case errors.Is(err, ssosage.ErrInvalidTOTP):
+		return status.Error(codes.InvalidArgument, "invalid totp code")
+	case errors.Is(err, ssosage.ErrInvalidRecoveryCode):
+		return status.Error(codes.InvalidArgument, "invalid recovery code")
+	case errors.Is(err, ssosage.ErrClientDisabled):
+		return status.Error(codes.PermissionDenied, "client is disabled")
+	case errors.Is(err, ssosage.ErrPasskeyRequired):
+		return status.Error(codes.FailedPrecondition, "client signs in with a passkey, use the multi-step login")
+	}
+
+	return nil
+}
+
+// lockedError tells the caller when a locked login opens again, like the rate limiter does
+func lockedError(ctx context.Context, err error) error {
+	var locked *ssosage.LockedError
+
+	if errors.As(err, &locked) {
+		seconds := strconv.Itoa(max(int(math.Ceil(time.Until(locked.Until).Seconds())), 1))
+		_ = grpc.SetHeader(ctx, metadata.Pairs(ratelimit.RetryAfterKey, seconds))
+
+		return status.Errorf(codes.ResourceExhausted, "too many failed attempts, login is locked, retry after %ss", seconds)
+	}
+
+	return status.Error(codes.ResourceExhausted, "too many failed attempts, login is temporarily locked")
+}
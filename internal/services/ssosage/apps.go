@@ -0,0 +1,125 @@
+package ssosage
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"slices"
+	"ssosage/internal/helpers"
+	"ssosage/internal/models"
+	"ssosage/internal/storage"
+	"strings"
+)
+
+var (
+	ErrInvalidSettings = errors.New("app settings must be a JSON object")
+)
+
+// GetApp returns an app without its secret
+func (s *Ssosage) GetApp(ctx context.Context, name string) (models.App, error) {
+	const op = "services.ssosage.GetApp"
+
+	app, err := s.appProvider.App(ctx, name)
+
+	if err != nil {
+		if !errors.Is(err, storage.ErrAppNotFound) {
+			s.logWith(op, name).Error("failed to get app", helpers.SlErr(err))
+		}
+
+		return models.App{}, helpers.WrapErr(op, err)
+	}
+
+	app.Secret = ""
+
+	return app, nil
+}
+
+/*
+UpdateApp changes the display name, roles, settings or policies of an app that is still at version
+and returns the new version. Tokens with a role that is no longer in the app stop verifying.
+The name identifies the app in tokens and can't be changed, rename changes the display name.
+*/
+func (s *Ssosage) UpdateApp(ctx context.Context, name string, version int64, update models.AppUpdate) (int64, error) {
+	const op = "services.ssosage.UpdateApp"
+
+	log := s.logWith(op, name)
+	log.Info("updating app")
+
+	if update.Roles != nil && slices.Contains(strings.Split(*update.Roles, ","), "") {
+		log.Warn("empty role in update", "roles", *update.Roles)
+
+		return 0, helpers.WrapErr(op, ErrInvalidRole)
+	}
+
+	if update.Settings != nil {
+		var settings map[string]any
+
+		if err := json.Unmarshal([]byte(*update.Settings), &settings); err != nil || settings == nil {
+			log.Warn("invalid settings in update")
+
+			return 0, helpers.WrapErr(op, ErrInvalidSettings)
+		}
+	}
+
+	if update.ProfileClaims != nil {
+		if err := validateProfileClaims(*update.ProfileClaims); err != nil {
+			log.Warn("invalid profile claims in update", "profile_claims", *update.ProfileClaims)
+
+			return 0, helpers.WrapErr(op, err)
+		}
+	}
+
+	version, err := s.appUpdater.UpdateApp(ctx, name, version, update)
+
+	if err != nil {
+		if errors.Is(err, storage.ErrAppNotFound) || errors.Is(err, storage.ErrAppConflict) {
+			log.Warn("app not updated", helpers.SlErr(err))
+
+			return 0, helpers.WrapErr(op, err)
+		}
+
+		log.Error("failed to update app", helpers.SlErr(err))
+
+		return 0, helpers.WrapErr(op, err)
+	}
+
+	return version, nil
+}
+
+// DeleteApp removes an app, all tokens issued for it stop verifying
+func (s *Ssosage) DeleteApp(ctx context.Context, name string) error {
+	const op = "services.ssosage.DeleteApp"
+
+	log := s.logWith(op, name)
+	log.Info("deleting app")
+
+	if err := s.appUpdater.DeleteApp(ctx, name); err != nil {
+		log.Error("failed to delete app", helpers.SlErr(err))
+
+		return helpers.WrapErr(op, err)
+	}
+
+	return nil
+}
+
+// ListApps returns a page of apps without secrets
+func (s *Ssosage) ListApps(ctx context.Context, query models.ListQuery) ([]models.App, string, error) {
+	const op = "services.ssosage.ListApps"
+
+	apps, next, err := s.appLister.ListApps(ctx, query)
+
+	if err != nil {
+		if errors.Is(err, storage.ErrInvalidSort) || errors.Is(err, storage.ErrInvalidCursor) {
+			s.log.Warn("invalid list query", slog.String("op", op), helpers.SlErr(err))
+
+			return nil, "", helpers.WrapErr(op, err)
+		}
+
+		s.log.Error("failed to list apps", slog.String("op", op), helpers.SlErr(err))
+
+		return nil, "", helpers.WrapErr(op, err)
+	}
+
+	return apps, next, nil
+}
@@ -0,0 +1,140 @@
+package ssosage
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"ssosage/internal/helpers"
+	"ssosage/internal/interfaces"
+	"ssosage/internal/models"
+	"ssosage/internal/storage"
+)
+
+var (
+	ErrClientDisabled = errors.New("client is disabled")
+)
+
+// ChangePassword sets a new password for a client that knows the current one and its totp code, revoking its tokens
+func (s *Ssosage) ChangePassword(ctx context.Context, clientName string, oldPassword string, newPassword string, totpCode string, sourceIP string) error {
+	const op = "services.ssosage.ChangePassword"
+
+	log := s.logWith(op, clientName)
+	log.Info("changing password")
+
+	if _, err := s.authenticate(ctx, log, clientName, oldPassword, totpCode, sourceIP); err != nil {
+		return helpers.WrapErr(op, err)
+	}
+
+	if err := s.setPassword(ctx, clientName, newPassword); err != nil {
+		log.Error("failed to change password", helpers.SlErr(err))
+
+		return helpers.WrapErr(op, err)
+	}
+
+	return nil
+}
+
+// ResetPassword sets a new password without the current one and revokes the tokens, for admins
+func (s *Ssosage) ResetPassword(ctx context.Context, clientName string, newPassword string) error {
+	const op = "services.ssosage.ResetPassword"
+
+	log := s.logWith(op, clientName)
+	log.Info("resetting password")
+
+	if err := s.setPassword(ctx, clientName, newPassword); err != nil {
+		log.Error("failed to reset password", helpers.SlErr(err))
+
+		return helpers.WrapErr(op, err)
+	}
+
+	s.resetClientLogin(ctx, clientName)
+
+	return nil
+}
+
+// DisableClient revokes the tokens of a client and stops it from getting new ones
+func (s *Ssosage) DisableClient(ctx context.Context, clientName string) error {
+	const op = "services.ssosage.DisableClient"
+
+	log := s.logWith(op, clientName)
+	log.Info("disabling client")
+
+	if err := s.clientUpdater.SetClientStatus(ctx, clientName, models.ClientDisabled); err != nil {
+		log.Error("failed to disable client", helpers.SlErr(err))
+
+		return helpers.WrapErr(op, err)
+	}
+
+	return nil
+}
+
+// EnableClient lets a disabled client log in again, tokens revoked on disable stay revoked
+func (s *Ssosage) EnableClient(ctx context.Context, clientName string) error {
+	const op = "services.ssosage.EnableClient"
+
+	log := s.logWith(op, clientName)
+	log.Info("enabling client")
+
+	if err := s.clientUpdater.SetClientStatus(ctx, clientName, models.ClientActive); err != nil {
+		log.Error("failed to enable client", helpers.SlErr(err))
+
+		return helpers.WrapErr(op, err)
+	}
+
+	return nil
+}
+
+// DeleteClient removes a client along with its failed login attempts, its tokens stop verifying
+func (s *Ssosage) DeleteClient(ctx context.Context, clientName string) error {
+	const op = "services.ssosage.DeleteClient"
+
+	log := s.logWith(op, clientName)
+	log.Info("deleting client")
+
+	err := s.transactor.WithinTx(ctx, func(repo interfaces.Repository) error {
+		if err := repo.DeleteClient(ctx, clientName); err != nil {
+			return err
+		}
+
+		return repo.ResetLoginAttempts(ctx, clientLoginKey(clientName))
+	})
+
+	if err != nil {
+		log.Error("failed to delete client", helpers.SlErr(err))
+
+		return helpers.WrapErr(op, err)
+	}
+
+	return nil
+}
+
+// ListClients returns a page of clients without password hashes
+func (s *Ssosage) ListClients(ctx context.Context, query models.ListQuery) ([]models.Client, string, error) {
+	const op = "services.ssosage.ListClients"
+
+	clients, next, err := s.clientLister.ListClients(ctx, query)
+
+	if err != nil {
+		if errors.Is(err, storage.ErrInvalidSort) || errors.Is(err, storage.ErrInvalidCursor) {
+			s.log.Warn("invalid list query", slog.String("op", op), helpers.SlErr(err))
+
+			return nil, "", helpers.WrapErr(op, err)
+		}
+
+		s.log.Error("failed to list clients", slog.String("op", op), helpers.SlErr(err))
+
+		return nil, "", helpers.WrapErr(op, err)
+	}
+
+	return clients, next, nil
+}
+
+func (s *Ssosage) setPassword(ctx context.Context, clientName string, password string) error {
+	passwordHash, err := s.hasher.Hash(password)
+
+	if err != nil {
+		return err
+	}
+
+	return s.clientUpdater.UpdateClientPassword(ctx, clientName, passwordHash)
+}
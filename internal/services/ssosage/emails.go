@@ -0,0 +1,260 @@
+package ssosage
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/mail"
+	"ssosage/internal/helpers"
+	"ssosage/internal/models"
+	"ssosage/internal/storage"
+	"strings"
+	"time"
+)
+
+var (
+	ErrEmailUnavailable         = errors.New("email verification is not configured")
+	ErrInvalidEmail             = errors.New("invalid email address")
+	ErrInvalidVerificationToken = errors.New("invalid or expired email verification token")
+	ErrEmailNotVerified         = errors.New("app requires a verified email")
+)
+
+const maxEmailLength = 254
+
+/*
+EmailPolicy configures the verification of client emails, which is unavailable without
+a notifier or a Key. Verification tokens are signed with Key instead of being stored,
+they are valid for TokenTTL and only while the client keeps the address they were sent to.
+Link is sent instead of the bare token when set, with {token} replaced.
+*/
+type EmailPolicy struct {
+	Key      []byte
+	TokenTTL time.Duration
+	Link     string
+}
+
+// emailClaims are what a verification token vouches for
+type emailClaims struct {
+	Client    string `json:"c"`
+	Email     string `json:"e"`
+	ExpiresAt int64  `json:"x"`
+}
+
+/*
+SetEmail changes the email of a client that knows its password and totp code and sends a
+verification token to the new address. The address stays unverified, and tokens issued
+before are revoked, until VerifyEmail. An empty email removes it.
+*/
+func (s *Ssosage) SetEmail(ctx context.Context, clientName string, password string, totpCode string, email string, sourceIP string) error {
+	const op = "services.ssosage.SetEmail"
+
+	log := s.logWith(op, clientName)
+	log.Info("setting email")
+
+	if !s.emailAvailable() {
+		return helpers.WrapErr(op, ErrEmailUnavailable)
+	}
+
+	if email != "" && !emailIsValid(email) {
+		return helpers.WrapErr(op, ErrInvalidEmail)
+	}
+
+	client, err := s.authenticate(ctx, log, clientName, password, totpCode, sourceIP)
+
+	if err != nil {
+		return helpers.WrapErr(op, err)
+	}
+
+	// setting the verified address again would only revoke tokens
+	if client.Email == email && client.EmailVerified {
+		return nil
+	}
+
+	if err := s.emails.SetClientEmail(ctx, clientName, email); err != nil {
+		log.Error("failed to set email", helpers.SlErr(err))
+
+		return helpers.WrapErr(op, err)
+	}
+
+	if email == "" {
+		return nil
+	}
+
+	if err := s.sendEmailVerification(ctx, clientName, email); err != nil {
+		log.Error("failed to send email verification", helpers.SlErr(err))
+
+		return helpers.WrapErr(op, err)
+	}
+
+	return nil
+}
+
+/*
+SendEmailVerification sends a new verification token to the unverified email of a client.
+Unknown and disabled clients and clients without an unverified email get nothing and no error.
+*/
+func (s *Ssosage) SendEmailVerification(ctx context.Context, clientName string) error {
+	const op = "services.ssosage.SendEmailVerification"
+
+	log := s.logWith(op, clientName)
+	log.Info("sending email verification")
+
+	if !s.emailAvailable() {
+		return helpers.WrapErr(op, ErrEmailUnavailable)
+	}
+
+	client, err := s.clientProvider.Client(ctx, clientName)
+
+	if err != nil {
+		if errors.Is(err, storage.ErrClientNotFound) {
+			log.Info("email verification for an unknown client")
+
+			return nil
+		}
+
+		log.Error("failed to get client", helpers.SlErr(err))
+
+		return helpers.WrapErr(op, err)
+	}
+
+	if client.Status == models.ClientDisabled || client.Email == "" || client.EmailVerified {
+		log.Info("client has no email to verify")
+
+		return nil
+	}
+
+	if err := s.sendEmailVerification(ctx, clientName, client.Email); err != nil {
+		log.Error("failed to send email verification", helpers.SlErr(err))
+
+		return helpers.WrapErr(op, err)
+	}
+
+	return nil
+}
+
+// VerifyEmail marks the email a verification token was sent to as verified
+func (s *Ssosage) VerifyEmail(ctx context.Context, token string) error {
+	const op = "services.ssosage.VerifyEmail"
+
+	if !s.emailAvailable() {
+		return helpers.WrapErr(op, ErrEmailUnavailable)
+	}
+
+	claims, err := s.parseEmailToken(token)
+
+	if err != nil {
+		return helpers.WrapErr(op, err)
+	}
+
+	log := s.logWith(op, claims.Client)
+	log.Info("verifying email")
+
+	if err := s.emails.VerifyClientEmail(ctx, claims.Client, claims.Email); err != nil {
+		if errors.Is(err, storage.ErrClientNotFound) || errors.Is(err, storage.ErrEmailChanged) {
+			log.Info("email verification is stale", helpers.SlErr(err))
+
+			return helpers.WrapErr(op, ErrInvalidVerificationToken)
+		}
+
+		log.Error("failed to verify email", helpers.SlErr(err))
+
+		return helpers.WrapErr(op, err)
+	}
+
+	return nil
+}
+
+func (s *Ssosage) emailAvailable() bool {
+	return s.notifier != nil && len(s.email.Key) > 0
+}
+
+func (s *Ssosage) sendEmailVerification(ctx context.Context, clientName string, email string) error {
+	claims := emailClaims{Client: clientName, Email: email, ExpiresAt: time.Now().Add(s.email.TokenTTL).Unix()}
+
+	token, err := s.signEmailToken(claims)
+
+	if err != nil {
+		return err
+	}
+
+	secret := token
+
+	if s.email.Link != "" {
+		secret = strings.ReplaceAll(s.email.Link, "{token}", token)
+	}
+
+	return s.notifier.Notify(ctx, models.Notification{
+		ClientName: clientName,
+		To:         email,
+		Subject:    "Email verification",
+		Body: fmt.Sprintf("Somebody set %s as the email of %s. If it was you, confirm it with\n\n%s\n\nbefore %s. Otherwise ignore this message.",
+			email, clientName, secret, time.Unix(claims.ExpiresAt, 0).UTC().Format(time.RFC1123)),
+	})
+}
+
+// signEmailToken encodes claims as base64url json followed by its hmac
+func (s *Ssosage) signEmailToken(claims emailClaims) (string, error) {
+	payload, err := json.Marshal(claims)
+
+	if err != nil {
+		return "", err
+	}
+
+	encoded := base64.RawURLEncoding.EncodeToString(payload)
+
+	return encoded + "." + base64.RawURLEncoding.EncodeToString(s.emailMAC(encoded)), nil
+}
+
+func (s *Ssosage) parseEmailToken(token string) (emailClaims, error) {
+	encoded, signature, ok := strings.Cut(token, ".")
+
+	if !ok {
+		return emailClaims{}, ErrInvalidVerificationToken
+	}
+
+	mac, err := base64.RawURLEncoding.DecodeString(signature)
+
+	if err != nil || !hmac.Equal(mac, s.emailMAC(encoded)) {
+		return emailClaims{}, ErrInvalidVerificationToken
+	}
+
+	payload, err := base64.RawURLEncoding.DecodeString(encoded)
+
+	if err != nil {
+		return emailClaims{}, ErrInvalidVerificationToken
+	}
+
+	var claims emailClaims
+
+	if err := json.Unmarshal(payload, &claims); err != nil || claims.Email == "" {
+		return emailClaims{}, ErrInvalidVerificationToken
+	}
+
+	if !time.Now().Before(time.Unix(claims.ExpiresAt, 0)) {
+		return emailClaims{}, ErrInvalidVerificationToken
+	}
+
+	return claims, nil
+}
+
+func (s *Ssosage) emailMAC(encoded string) []byte {
+	mac := hmac.New(sha256.New, s.email.Key)
+	mac.Write([]byte(encoded))
+
+	return mac.Sum(nil)
+}
+
+// emailIsValid accepts a bare address, without a display name or angle brackets
+func emailIsValid(email string) bool {
+	if len(email) > maxEmailLength {
+		return false
+	}
+
+	address, err := mail.ParseAddress(email)
+
+	return err == nil && address.Address == email
+}
@@ -0,0 +1,261 @@
+package ssosage
+
+import (
+	"context"
+	"errors"
+	"ssosage/internal/models"
+	"ssosage/internal/notify"
+	"ssosage/internal/notify/smtptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// setEmail sets the email of the test client and returns the verification token it was sent
+func setEmail(t *testing.T, sso *Ssosage, email string) string {
+	t.Helper()
+
+	if err := sso.SetEmail(context.Background(), testClient, testPassword, "", email, ""); err != nil {
+		t.Fatalf("failed to set email: %v", err)
+	}
+
+	sent := sso.notifier.(*recordingNotifier).sent()
+
+	if len(sent) == 0 || sent[len(sent)-1].To != email {
+		t.Fatalf("expected a notification to %s, got %+v", email, sent)
+	}
+
+	return notificationToken(t, sent[len(sent)-1].Body)
+}
+
+func TestEmailVerification(t *testing.T) {
+	sso := newTestSsosage(t, LockoutPolicy{})
+	ctx := context.Background()
+
+	before, err := sso.GenerateToken(ctx, testClient, testPassword, "", testApp, "user", "")
+
+	if err != nil {
+		t.Fatalf("failed to generate token: %v", err)
+	}
+
+	for _, email := range []string{"not an email", "Client <client@example.com>", "client@example.com\r\nBcc: x@example.com"} {
+		if err := sso.SetEmail(ctx, testClient, testPassword, "", email, ""); !errors.Is(err, ErrInvalidEmail) {
+			t.Fatalf("%q: expected ErrInvalidEmail, got: %v", email, err)
+		}
+	}
+
+	verification := setEmail(t, sso, "client@example.com")
+
+	// the email_verified claim of tokens issued before may no longer hold
+	if _, err := sso.VerifyToken(ctx, before); !errors.Is(err, ErrInvalidToken) {
+		t.Fatalf("expected ErrInvalidToken, got: %v", err)
+	}
+
+	if err := sso.VerifyEmail(ctx, "made.up"); !errors.Is(err, ErrInvalidVerificationToken) {
+		t.Fatalf("expected ErrInvalidVerificationToken, got: %v", err)
+	}
+
+	if err := sso.VerifyEmail(ctx, verification); err != nil {
+		t.Fatalf("failed to verify email: %v", err)
+	}
+
+	token, err := sso.GenerateToken(ctx, testClient, testPassword, "", testApp, "user", "")
+
+	if err != nil {
+		t.Fatalf("failed to generate token: %v", err)
+	}
+
+	verified, err := sso.VerifyToken(ctx, token)
+
+	if err != nil || !verified.EmailVerified {
+		t.Fatalf("expected a token with a verified email, got %+v, %v", verified, err)
+	}
+
+	// resets go to the verified address from now on
+	if err := sso.RequestPasswordReset(ctx, testClient); err != nil {
+		t.Fatalf("failed to request password reset: %v", err)
+	}
+
+	if sent := sso.notifier.(*recordingNotifier).sent(); sent[len(sent)-1].To != "client@example.com" {
+		t.Fatalf("expected the reset to go to the email, got %+v", sent[len(sent)-1])
+	}
+
+	// setting the verified address again changes nothing
+	if err := sso.SetEmail(ctx, testClient, testPassword, "", "client@example.com", ""); err != nil {
+		t.Fatalf("failed to set email: %v", err)
+	}
+
+	if _, err := sso.VerifyToken(ctx, token); err != nil {
+		t.Fatalf("expected the token to stay valid, got: %v", err)
+	}
+}
+
+func TestEmailVerificationStale(t *testing.T) {
+	sso := newTestSsosage(t, LockoutPolicy{})
+	ctx := context.Background()
+
+	first := setEmail(t, sso, "first@example.com")
+	setEmail(t, sso, "second@example.com")
+
+	// a token is good only for the address the client still has
+	if err := sso.VerifyEmail(ctx, first); !errors.Is(err, ErrInvalidVerificationToken) {
+		t.Fatalf("expected ErrInvalidVerificationToken, got: %v", err)
+	}
+
+	sso.email.TokenTTL = -time.Second
+	expired := setEmail(t, sso, "third@example.com")
+
+	if err := sso.VerifyEmail(ctx, expired); !errors.Is(err, ErrInvalidVerificationToken) {
+		t.Fatalf("expected ErrInvalidVerificationToken, got: %v", err)
+	}
+
+	sso.email.TokenTTL = time.Minute
+	token := setEmail(t, sso, "third@example.com")
+	payload, signature, _ := strings.Cut(token, ".")
+
+	forged, err := sso.signEmailToken(emailClaims{Client: testClient, Email: "third@example.com", ExpiresAt: time.Now().Add(time.Hour).Unix()})
+
+	if err != nil {
+		t.Fatalf("failed to sign token: %v", err)
+	}
+
+	forgedPayload, _, _ := strings.Cut(forged, ".")
+
+	// a payload doesn't go with the signature of another one
+	if err := sso.VerifyEmail(ctx, forgedPayload+"."+signature); !errors.Is(err, ErrInvalidVerificationToken) {
+		t.Fatalf("expected ErrInvalidVerificationToken, got: %v", err)
+	}
+
+	if err := sso.VerifyEmail(ctx, payload+"."+signature); err != nil {
+		t.Fatalf("failed to verify email: %v", err)
+	}
+}
+
+func TestSendEmailVerification(t *testing.T) {
+	sso := newTestSsosage(t, LockoutPolicy{})
+	ctx := context.Background()
+	notifier := sso.notifier.(*recordingNotifier)
+
+	// nothing to verify yet, and nobody learns which clients exist
+	for _, name := range []string{testClient, "nobody"} {
+		if err := sso.SendEmailVerification(ctx, name); err != nil {
+			t.Fatalf("%s: expected no error, got: %v", name, err)
+		}
+	}
+
+	if sent := notifier.sent(); len(sent) != 0 {
+		t.Fatalf("expected no notifications, got %+v", sent)
+	}
+
+	setEmail(t, sso, "client@example.com")
+
+	if err := sso.SendEmailVerification(ctx, testClient); err != nil {
+		t.Fatalf("failed to send email verification: %v", err)
+	}
+
+	sent := notifier.sent()
+
+	if len(sent) != 2 || sent[1].To != "client@example.com" {
+		t.Fatalf("expected a second verification, got %+v", sent)
+	}
+
+	if err := sso.VerifyEmail(ctx, notificationToken(t, sent[1].Body)); err != nil {
+		t.Fatalf("failed to verify email: %v", err)
+	}
+
+	if err := sso.SendEmailVerification(ctx, testClient); err != nil || len(notifier.sent()) != 2 {
+		t.Fatalf("expected nothing sent for a verified email, got: %v", err)
+	}
+}
+
+func TestAppRequiresVerifiedEmail(t *testing.T) {
+	sso := newTestSsosage(t, LockoutPolicy{})
+	ctx := context.Background()
+
+	app, err := sso.GetApp(ctx, testApp)
+
+	if err != nil {
+		t.Fatalf("failed to get app: %v", err)
+	}
+
+	requireVerifiedEmail := true
+
+	if _, err := sso.UpdateApp(ctx, testApp, app.Version, models.AppUpdate{RequireVerifiedEmail: &requireVerifiedEmail}); err != nil {
+		t.Fatalf("failed to update app: %v", err)
+	}
+
+	if _, err := sso.GenerateToken(ctx, testClient, testPassword, "", testApp, "user", ""); !errors.Is(err, ErrEmailNotVerified) {
+		t.Fatalf("expected ErrEmailNotVerified, got: %v", err)
+	}
+
+	step, err := sso.BeginLogin(ctx, testClient, testApp, "user")
+
+	if err != nil {
+		t.Fatalf("failed to begin login: %v", err)
+	}
+
+	if _, err := sso.CompleteFactor(ctx, step.Challenge, FactorPassword, testPassword, ""); !errors.Is(err, ErrEmailNotVerified) {
+		t.Fatalf("expected ErrEmailNotVerified, got: %v", err)
+	}
+
+	if err := sso.VerifyEmail(ctx, setEmail(t, sso, "client@example.com")); err != nil {
+		t.Fatalf("failed to verify email: %v", err)
+	}
+
+	if _, err := sso.GenerateToken(ctx, testClient, testPassword, "", testApp, "user", ""); err != nil {
+		t.Fatalf("failed to generate token: %v", err)
+	}
+}
+
+func TestEmailVerificationOverSMTP(t *testing.T) {
+	server, err := smtptest.New()
+
+	if err != nil {
+		t.Fatalf("failed to start smtp server: %v", err)
+	}
+
+	defer server.Close()
+
+	sso := newTestSsosage(t, LockoutPolicy{})
+	sso.notifier = notify.NewSMTP(notify.SMTPOptions{Host: server.Host(), Port: server.Port(), From: "ssosage@example.com"})
+	sso.email.Link = "https://example.com/verify?token={token}"
+
+	ctx := context.Background()
+
+	if err := sso.SetEmail(ctx, testClient, testPassword, "", "client@example.com", ""); err != nil {
+		t.Fatalf("failed to set email: %v", err)
+	}
+
+	messages := server.Messages()
+
+	if len(messages) != 1 || len(messages[0].To) != 1 || messages[0].To[0] != "client@example.com" {
+		t.Fatalf("expected one message to the client, got %+v", messages)
+	}
+
+	_, link, ok := strings.Cut(messages[0].Data, "https://example.com/verify?token=")
+
+	if !ok {
+		t.Fatalf("no link in message:\n%s", messages[0].Data)
+	}
+
+	token, _, _ := strings.Cut(link, "\n")
+
+	if err := sso.VerifyEmail(ctx, token); err != nil {
+		t.Fatalf("failed to verify email: %v", err)
+	}
+}
+
+func TestEmailUnavailable(t *testing.T) {
+	sso := newTestSsosage(t, LockoutPolicy{})
+	sso.email.Key = nil
+
+	ctx := context.Background()
+
+	if err := sso.SetEmail(ctx, testClient, testPassword, "", "client@example.com", ""); !errors.Is(err, ErrEmailUnavailable) {
+		t.Fatalf("expected ErrEmailUnavailable, got: %v", err)
+	}
+
+	if err := sso.VerifyEmail(ctx, "token"); !errors.Is(err, ErrEmailUnavailable) {
+		t.Fatalf("expected ErrEmailUnavailable, got: %v", err)
+	}
+}
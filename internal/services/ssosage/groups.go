@@ -0,0 +1,209 @@
+package ssosage
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"ssosage/internal/helpers"
+	"ssosage/internal/models"
+	"ssosage/internal/storage"
+)
+
+var (
+	ErrInvalidGrant      = errors.New("a role is granted to either a client or a group")
+	ErrOtherOrganization = errors.New("groups only take clients and apps of their own organization")
+	ErrRoleNotGranted    = errors.New("role is not granted to the client")
+)
+
+// RegisterGroup creates a group, named organization/name for the clients of an organization
+func (s *Ssosage) RegisterGroup(ctx context.Context, name string) (int64, error) {
+	const op = "services.ssosage.RegisterGroup"
+
+	log := s.logWith(op, name)
+	log.Info("registering group")
+
+	if !nameIsValid(name) {
+		return 0, helpers.WrapErr(op, ErrInvalidName)
+	}
+
+	id, err := s.groups.SaveGroup(ctx, name)
+
+	if err != nil {
+		if errors.Is(err, storage.ErrGroupExists) {
+			log.Warn("group already exists", helpers.SlErr(err))
+
+			return 0, helpers.WrapErr(op, err)
+		}
+
+		log.Error("failed to save group", helpers.SlErr(err))
+
+		return 0, helpers.WrapErr(op, err)
+	}
+
+	return id, nil
+}
+
+// DeleteGroup removes a group, its members lose the roles they only had through it
+func (s *Ssosage) DeleteGroup(ctx context.Context, name string) error {
+	const op = "services.ssosage.DeleteGroup"
+
+	log := s.logWith(op, name)
+	log.Info("deleting group")
+
+	if err := s.groups.DeleteGroup(ctx, name); err != nil {
+		log.Error("failed to delete group", helpers.SlErr(err))
+
+		return helpers.WrapErr(op, err)
+	}
+
+	return nil
+}
+
+// AddGroupMember puts a client into a group of its own organization
+func (s *Ssosage) AddGroupMember(ctx context.Context, group string, clientName string) error {
+	const op = "services.ssosage.AddGroupMember"
+
+	log := s.logWith(op, group)
+	log.Info("adding group member", "client", clientName)
+
+	if !sameOrganization(group, clientName) {
+		return helpers.WrapErr(op, ErrOtherOrganization)
+	}
+
+	if err := s.groups.AddGroupMember(ctx, group, clientName); err != nil {
+		log.Warn("group member not added", helpers.SlErr(err))
+
+		return helpers.WrapErr(op, err)
+	}
+
+	return nil
+}
+
+// RemoveGroupMember takes a client out of a group, tokens with a role it only had through the group stop verifying
+func (s *Ssosage) RemoveGroupMember(ctx context.Context, group string, clientName string) error {
+	const op = "services.ssosage.RemoveGroupMember"
+
+	log := s.logWith(op, group)
+	log.Info("removing group member", "client", clientName)
+
+	if err := s.groups.RemoveGroupMember(ctx, group, clientName); err != nil {
+		log.Warn("group member not removed", helpers.SlErr(err))
+
+		return helpers.WrapErr(op, err)
+	}
+
+	return nil
+}
+
+/*
+GrantRole gives a role of an app to a client or to the members of a group. Apps with role_grants
+only issue tokens for granted roles, the others keep taking any of their roles. Grants of a
+client or group in an organization are for global apps or the apps of that organization.
+*/
+func (s *Ssosage) GrantRole(ctx context.Context, grant models.RoleGrant) error {
+	const op = "services.ssosage.GrantRole"
+
+	log := s.logWith(op, grant.AppName)
+	log.Info("granting role", "role", grant.Role, "client", grant.ClientName, "group", grant.GroupName)
+
+	if err := s.checkGrant(ctx, grant); err != nil {
+		log.Warn("invalid grant", helpers.SlErr(err))
+
+		return helpers.WrapErr(op, err)
+	}
+
+	if err := s.groups.GrantRole(ctx, grant); err != nil {
+		log.Warn("role not granted", helpers.SlErr(err))
+
+		return helpers.WrapErr(op, err)
+	}
+
+	return nil
+}
+
+// RevokeRole takes back a grant, tokens with a role the client no longer has stop verifying
+func (s *Ssosage) RevokeRole(ctx context.Context, grant models.RoleGrant) error {
+	const op = "services.ssosage.RevokeRole"
+
+	log := s.logWith(op, grant.AppName)
+	log.Info("revoking role", "role", grant.Role, "client", grant.ClientName, "group", grant.GroupName)
+
+	if (grant.ClientName == "") == (grant.GroupName == "") {
+		return helpers.WrapErr(op, ErrInvalidGrant)
+	}
+
+	if err := s.groups.RevokeRole(ctx, grant); err != nil {
+		log.Warn("role not revoked", helpers.SlErr(err))
+
+		return helpers.WrapErr(op, err)
+	}
+
+	return nil
+}
+
+// ClientRoles returns the roles a client has in an app, granted directly or through its groups
+func (s *Ssosage) ClientRoles(ctx context.Context, clientName string, appName string) ([]string, error) {
+	const op = "services.ssosage.ClientRoles"
+
+	app, err := s.appProvider.App(ctx, appName)
+
+	if err != nil {
+		if !errors.Is(err, storage.ErrAppNotFound) {
+			s.logWith(op, clientName).Error("failed to get app", helpers.SlErr(err))
+		}
+
+		return nil, helpers.WrapErr(op, err)
+	}
+
+	roles, err := s.grantedRoles(ctx, clientName, app)
+
+	if err != nil {
+		s.logWith(op, clientName).Error("failed to get roles", helpers.SlErr(err))
+
+		return nil, helpers.WrapErr(op, err)
+	}
+
+	return roles, nil
+}
+
+func (s *Ssosage) checkGrant(ctx context.Context, grant models.RoleGrant) error {
+	if (grant.ClientName == "") == (grant.GroupName == "") {
+		return ErrInvalidGrant
+	}
+
+	app, err := s.appProvider.App(ctx, grant.AppName)
+
+	if err != nil {
+		return err
+	}
+
+	if !hasRole(app, grant.Role) {
+		return ErrInvalidRole
+	}
+
+	subject := grant.ClientName + grant.GroupName
+
+	if org, _ := models.SplitName(app.Name); org != "" && !sameOrganization(subject, app.Name) {
+		return ErrOtherOrganization
+	}
+
+	return nil
+}
+
+// grantedRoles are the granted roles the app still has
+func (s *Ssosage) grantedRoles(ctx context.Context, clientName string, app models.App) ([]string, error) {
+	roles, err := s.groups.ClientRoles(ctx, clientName, app.Name)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return slices.DeleteFunc(roles, func(role string) bool { return !hasRole(app, role) }), nil
+}
+
+func sameOrganization(first string, second string) bool {
+	firstOrg, _ := models.SplitName(first)
+	secondOrg, _ := models.SplitName(second)
+
+	return firstOrg == secondOrg
+}
@@ -0,0 +1,156 @@
+package ssosage
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"ssosage/internal/models"
+	"ssosage/internal/storage"
+	"testing"
+)
+
+// requireRoleGrants turns on role_grants and groups_claim for the test app
+func requireRoleGrants(t *testing.T, sso *Ssosage) {
+	t.Helper()
+
+	ctx := context.Background()
+
+	app, err := sso.GetApp(ctx, testApp)
+
+	if err != nil {
+		t.Fatalf("failed to get app: %v", err)
+	}
+
+	enabled := true
+
+	if _, err := sso.UpdateApp(ctx, testApp, app.Version, models.AppUpdate{RoleGrants: &enabled, GroupsClaim: &enabled}); err != nil {
+		t.Fatalf("failed to update app: %v", err)
+	}
+}
+
+func TestRoleGrants(t *testing.T) {
+	sso := newTestSsosage(t, LockoutPolicy{})
+	ctx := context.Background()
+
+	// without role_grants any role of the app goes
+	if _, err := sso.GenerateToken(ctx, testClient, testPassword, "", testApp, "admin", ""); err != nil {
+		t.Fatalf("failed to generate token: %v", err)
+	}
+
+	requireRoleGrants(t, sso)
+
+	if _, err := sso.GenerateToken(ctx, testClient, testPassword, "", testApp, "user", ""); !errors.Is(err, ErrRoleNotGranted) {
+		t.Fatalf("expected ErrRoleNotGranted, got: %v", err)
+	}
+
+	if err := sso.GrantRole(ctx, models.RoleGrant{ClientName: testClient, AppName: testApp, Role: "user"}); err != nil {
+		t.Fatalf("failed to grant role: %v", err)
+	}
+
+	if _, err := sso.RegisterGroup(ctx, "admins"); err != nil {
+		t.Fatalf("failed to register group: %v", err)
+	}
+
+	if err := sso.GrantRole(ctx, models.RoleGrant{GroupName: "admins", AppName: testApp, Role: "admin"}); err != nil {
+		t.Fatalf("failed to grant role: %v", err)
+	}
+
+	if _, err := sso.GenerateToken(ctx, testClient, testPassword, "", testApp, "admin", ""); !errors.Is(err, ErrRoleNotGranted) {
+		t.Fatalf("expected ErrRoleNotGranted, got: %v", err)
+	}
+
+	if err := sso.AddGroupMember(ctx, "admins", testClient); err != nil {
+		t.Fatalf("failed to add group member: %v", err)
+	}
+
+	token, err := sso.GenerateToken(ctx, testClient, testPassword, "", testApp, "admin", "")
+
+	if err != nil {
+		t.Fatalf("failed to generate token: %v", err)
+	}
+
+	verified, err := sso.VerifyToken(ctx, token)
+
+	if err != nil {
+		t.Fatalf("failed to verify token: %v", err)
+	}
+
+	if verified.Role != "admin" || !slices.Equal(verified.Roles, []string{"admin", "user"}) || !slices.Equal(verified.Groups, []string{"admins"}) {
+		t.Fatalf("unexpected claims: %+v", verified)
+	}
+
+	// the role came through the group and goes with it
+	if err := sso.RemoveGroupMember(ctx, "admins", testClient); err != nil {
+		t.Fatalf("failed to remove group member: %v", err)
+	}
+
+	if _, err := sso.VerifyToken(ctx, token); !errors.Is(err, ErrInvalidToken) {
+		t.Fatalf("expected ErrInvalidToken, got: %v", err)
+	}
+
+	if roles, err := sso.ClientRoles(ctx, testClient, testApp); err != nil || !slices.Equal(roles, []string{"user"}) {
+		t.Fatalf("expected the direct grant only, got %v, %v", roles, err)
+	}
+
+	step, err := sso.BeginLogin(ctx, testClient, testApp, "admin")
+
+	if err != nil {
+		t.Fatalf("failed to begin login: %v", err)
+	}
+
+	if _, err := sso.CompleteFactor(ctx, step.Challenge, FactorPassword, testPassword, ""); !errors.Is(err, ErrRoleNotGranted) {
+		t.Fatalf("expected ErrRoleNotGranted, got: %v", err)
+	}
+}
+
+func TestRoleGrantsInvalid(t *testing.T) {
+	sso := newTestSsosage(t, LockoutPolicy{})
+	ctx := context.Background()
+
+	if _, err := sso.RegisterGroup(ctx, "admins"); err != nil {
+		t.Fatalf("failed to register group: %v", err)
+	}
+
+	if _, err := sso.RegisterGroup(ctx, "admins"); !errors.Is(err, storage.ErrGroupExists) {
+		t.Fatalf("expected ErrGroupExists, got: %v", err)
+	}
+
+	if _, err := sso.RegisterOrganization(ctx, "acme"); err != nil {
+		t.Fatalf("failed to register organization: %v", err)
+	}
+
+	if _, err := sso.RegisterNewApp(ctx, "acme/"+testApp, testSecret, "user"); err != nil {
+		t.Fatalf("failed to register app: %v", err)
+	}
+
+	tests := []struct {
+		grant models.RoleGrant
+		err   error
+	}{
+		{models.RoleGrant{AppName: testApp, Role: "user"}, ErrInvalidGrant},
+		{models.RoleGrant{ClientName: testClient, GroupName: "admins", AppName: testApp, Role: "user"}, ErrInvalidGrant},
+		{models.RoleGrant{ClientName: testClient, AppName: testApp, Role: "owner"}, ErrInvalidRole},
+		{models.RoleGrant{ClientName: testClient, AppName: "nothing", Role: "user"}, storage.ErrAppNotFound},
+		{models.RoleGrant{GroupName: "nobody", AppName: testApp, Role: "user"}, storage.ErrGroupNotFound},
+		// an organization's apps are for its own clients and groups
+		{models.RoleGrant{GroupName: "admins", AppName: "acme/" + testApp, Role: "user"}, ErrOtherOrganization},
+	}
+
+	for _, tt := range tests {
+		if err := sso.GrantRole(ctx, tt.grant); !errors.Is(err, tt.err) {
+			t.Fatalf("%+v: expected %v, got: %v", tt.grant, tt.err, err)
+		}
+	}
+
+	if _, err := sso.RegisterGroup(ctx, "acme/admins"); err != nil {
+		t.Fatalf("failed to register group: %v", err)
+	}
+
+	if err := sso.AddGroupMember(ctx, "acme/admins", testClient); !errors.Is(err, ErrOtherOrganization) {
+		t.Fatalf("expected ErrOtherOrganization, got: %v", err)
+	}
+
+	if err := sso.GrantRole(ctx, models.RoleGrant{GroupName: "acme/admins", AppName: "acme/" + testApp, Role: "user"}); err != nil {
+		t.Fatalf("failed to grant role: %v", err)
+	}
+}
@@ -0,0 +1,198 @@
+package ssosage
+
+import (
+	"context"
+	"errors"
+	"ssosage/internal/helpers"
+	"ssosage/internal/interfaces"
+	"time"
+)
+
+var (
+	ErrLoginLocked = errors.New("login temporarily locked")
+)
+
+// LockedError is ErrLoginLocked with the time the lock ends
+type LockedError struct {
+	Until time.Time
+}
+
+func (e *LockedError) Error() string {
+	return ErrLoginLocked.Error()
+}
+
+func (e *LockedError) Is(target error) bool {
+	return target == ErrLoginLocked
+}
+
+/*
+LockoutPolicy describes how failed GenerateToken attempts are throttled.
+Every failure locks the client name for an exponentially growing delay starting
+at BaseDelay and capped at MaxDelay. Once the number of failures within Window
+reaches the max attempts the client name or source ip is locked for LockoutDuration.
+Source ips get no progressive delay, so one typo doesn't lock out everyone behind a NAT.
+Max attempts <= 0 disables tracking for that kind of key.
+*/
+type LockoutPolicy struct {
+	ClientMaxAttempts int
+	IPMaxAttempts     int
+	BaseDelay         time.Duration
+	MaxDelay          time.Duration
+	LockoutDuration   time.Duration
+	Window            time.Duration
+}
+
+type loginKey struct {
+	key         string
+	maxAttempts int
+	backoff     bool
+}
+
+func clientLoginKey(clientName string) string {
+	return "client:" + clientName
+}
+
+func ipLoginKey(ip string) string {
+	return "ip:" + ip
+}
+
+func (s *Ssosage) loginKeys(clientName string, ip string) []loginKey {
+	if s.loginAttempts == nil || s.transactor == nil {
+		return nil
+	}
+
+	var keys []loginKey
+
+	if s.lockout.ClientMaxAttempts > 0 {
+		keys = append(keys, loginKey{clientLoginKey(clientName), s.lockout.ClientMaxAttempts, true})
+	}
+
+	if s.lockout.IPMaxAttempts > 0 && ip != "" {
+		keys = append(keys, loginKey{ipLoginKey(ip), s.lockout.IPMaxAttempts, false})
+	}
+
+	return keys
+}
+
+func (s *Ssosage) checkLoginLocked(ctx context.Context, keys []loginKey, now time.Time) error {
+	const op = "services.ssosage.checkLoginLocked"
+
+	for _, k := range keys {
+		attempts, err := s.loginAttempts.LoginAttempts(ctx, k.key)
+
+		if err != nil {
+			return helpers.WrapErr(op, err)
+		}
+
+		if now.Before(attempts.LockedUntil) {
+			s.log.Warn("login is locked", "key", k.key, "locked_until", attempts.LockedUntil)
+
+			return helpers.WrapErr(op, &LockedError{Until: attempts.LockedUntil})
+		}
+	}
+
+	return nil
+}
+
+func (s *Ssosage) registerFailedLogin(ctx context.Context, keys []loginKey, now time.Time) {
+	const op = "services.ssosage.registerFailedLogin"
+
+	log := s.log.With("op", op)
+
+	for _, k := range keys {
+		// the counter and the lock it leads to are written together
+		err := s.transactor.WithinTx(ctx, func(repo interfaces.Repository) error {
+			attempts, err := repo.IncrementFailedLogins(ctx, k.key, now, now.Add(-s.lockout.Window))
+
+			if err != nil {
+				return err
+			}
+
+			delay := s.lockout.lockFor(attempts.Failures, k.maxAttempts, k.backoff)
+
+			if delay <= 0 {
+				return nil
+			}
+
+			// storages keep whole seconds, rounding up keeps a short lock from ending early
+			if err := repo.LockLogin(ctx, k.key, now.Add(delay+time.Second-1).Truncate(time.Second)); err != nil {
+				return err
+			}
+
+			log.Info("login locked", "key", k.key, "failures", attempts.Failures, "delay", delay)
+
+			return nil
+		})
+
+		if err != nil {
+			log.Error("failed to register failed login", "key", k.key, helpers.SlErr(err))
+		}
+	}
+}
+
+func (s *Ssosage) resetClientLogin(ctx context.Context, clientName string) {
+	if s.loginAttempts == nil || s.lockout.ClientMaxAttempts <= 0 {
+		return
+	}
+
+	if err := s.loginAttempts.ResetLoginAttempts(ctx, clientLoginKey(clientName)); err != nil {
+		s.log.Error("failed to reset login attempts", "client", clientName, helpers.SlErr(err))
+	}
+}
+
+// UnlockClient clears failed attempts and lockout of a client
+func (s *Ssosage) UnlockClient(ctx context.Context, clientName string) error {
+	const op = "services.ssosage.UnlockClient"
+
+	return s.unlock(ctx, op, clientLoginKey(clientName))
+}
+
+// UnlockAddress clears failed attempts and lockout of a source ip
+func (s *Ssosage) UnlockAddress(ctx context.Context, ip string) error {
+	const op = "services.ssosage.UnlockAddress"
+
+	return s.unlock(ctx, op, ipLoginKey(ip))
+}
+
+func (s *Ssosage) unlock(ctx context.Context, op string, key string) error {
+	log := s.log.With("op", op, "key", key)
+	log.Info("unlocking login")
+
+	if s.loginAttempts == nil {
+		return nil
+	}
+
+	if err := s.loginAttempts.ResetLoginAttempts(ctx, key); err != nil {
+		log.Error("failed to unlock login", helpers.SlErr(err))
+
+		return helpers.WrapErr(op, err)
+	}
+
+	return nil
+}
+
+func (p LockoutPolicy) lockFor(failures int, maxAttempts int, backoff bool) time.Duration {
+	if failures >= maxAttempts {
+		return p.LockoutDuration
+	}
+
+	if !backoff || p.BaseDelay <= 0 || failures <= 0 {
+		return 0
+	}
+
+	delay := p.BaseDelay
+
+	for i := 1; i < failures; i++ {
+		delay *= 2
+
+		if p.MaxDelay > 0 && delay >= p.MaxDelay {
+			return p.MaxDelay
+		}
+	}
+
+	if p.MaxDelay > 0 && delay > p.MaxDelay {
+		return p.MaxDelay
+	}
+
+	return delay
+}
@@ -0,0 +1,394 @@
+package ssosage
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"log/slog"
+	"slices"
+	"ssosage/internal/helpers"
+	"ssosage/internal/models"
+	"ssosage/internal/storage"
+	"strings"
+	"time"
+)
+
+/*
+factors of a multi-step login, totp also takes a recovery code and webauthn takes
+the json of a passkey assertion. A required step may offer a choice of factors
+separated by FactorChoice, as in "totp|webauthn".
+*/
+const (
+	FactorPassword            = "password"
+	FactorTOTP                = "totp"
+	FactorWebAuthn            = "webauthn"
+	FactorPasskeyRegistration = "webauthn_registration"
+
+	FactorChoice = "|"
+)
+
+var (
+	ErrInvalidChallenge = errors.New("invalid or expired login challenge")
+	ErrUnexpectedFactor = errors.New("factor is not the next one required")
+)
+
+// LoginStep is where a multi-step login stands, Token is set once no factors remain
+type LoginStep struct {
+	Challenge string
+	Remaining []string
+	ExpiresAt time.Time
+	Token     string
+}
+
+/*
+BeginLogin starts a login of a client into an app with a role and returns the challenge
+to pass to CompleteFactor along with the factors to complete, in order.
+Every login starts with the password alone, the second factors of the client only show up
+after it, so BeginLogin tells nothing about the client, not even whether it exists.
+*/
+func (s *Ssosage) BeginLogin(ctx context.Context, clientName string, appName string, role string) (LoginStep, error) {
+	const op = "services.ssosage.BeginLogin"
+
+	log := s.logWith(op, clientName)
+	log.Info("beginning login")
+
+	if _, err := s.loginApp(ctx, log, appName, role); err != nil {
+		return LoginStep{}, helpers.WrapErr(op, err)
+	}
+
+	step, err := s.startLogin(ctx, log, models.LoginTransaction{
+		ClientName: clientName,
+		AppName:    appName,
+		Role:       role,
+		Required:   []string{FactorPassword},
+	})
+
+	if err != nil {
+		return LoginStep{}, helpers.WrapErr(op, err)
+	}
+
+	return step, nil
+}
+
+/*
+CompleteFactor checks the next factor of a login started by BeginLogin or BeginPasskeyLogin,
+the password, a totp or recovery code or a passkey assertion. Failures count against
+the lockout policy and leave the login where it was. The step after the last factor
+carries the token, except for a passkey registration which ends with the passkey stored.
+*/
+func (s *Ssosage) CompleteFactor(ctx context.Context, challenge string, factor string, value string, sourceIP string) (LoginStep, error) {
+	const op = "services.ssosage.CompleteFactor"
+
+	id := challengeID(challenge)
+
+	transaction, err := s.loginTransactions.LoginTransaction(ctx, id)
+
+	if err != nil {
+		if errors.Is(err, storage.ErrLoginTransactionNotFound) {
+			return LoginStep{}, helpers.WrapErr(op, ErrInvalidChallenge)
+		}
+
+		s.log.Error("failed to get login transaction", slog.String("op", op), helpers.SlErr(err))
+
+		return LoginStep{}, helpers.WrapErr(op, err)
+	}
+
+	log := s.logWith(op, transaction.ClientName)
+	now := time.Now()
+
+	if !now.Before(transaction.ExpiresAt) {
+		log.Info("login expired")
+
+		return LoginStep{}, helpers.WrapErr(op, ErrInvalidChallenge)
+	}
+
+	remaining := transaction.Required[len(transaction.Completed):]
+
+	if len(remaining) == 0 || !stepAllows(remaining[0], factor) {
+		log.Warn("unexpected factor", "factor", factor, "remaining", remaining)
+
+		return LoginStep{}, helpers.WrapErr(op, ErrUnexpectedFactor)
+	}
+
+	// only ever the last step
+	if factor == FactorPasskeyRegistration {
+		if err := s.completePasskeyRegistration(ctx, log, transaction, challenge, value); err != nil {
+			return LoginStep{}, helpers.WrapErr(op, err)
+		}
+
+		return LoginStep{}, nil
+	}
+
+	loginKeys := s.loginKeys(transaction.ClientName, sourceIP)
+
+	if err := s.checkLoginLocked(ctx, loginKeys, now); err != nil {
+		return LoginStep{}, helpers.WrapErr(op, err)
+	}
+
+	client, err := s.checkFactor(ctx, log, transaction, challenge, factor, value, loginKeys, now)
+
+	if err != nil {
+		return LoginStep{}, helpers.WrapErr(op, err)
+	}
+
+	if client.Status == models.ClientDisabled {
+		log.Warn("client is disabled")
+
+		return LoginStep{}, helpers.WrapErr(op, ErrClientDisabled)
+	}
+
+	required := transaction.Required
+
+	if factor == FactorPassword {
+		if required, err = s.afterPassword(ctx, log, transaction, client); err != nil {
+			return LoginStep{}, helpers.WrapErr(op, err)
+		}
+	}
+
+	completed := append(slices.Clone(transaction.Completed), factor)
+
+	if len(completed) < len(required) {
+		if err := s.loginTransactions.UpdateLoginFactors(ctx, id, required, completed); err != nil {
+			log.Error("failed to update login transaction", helpers.SlErr(err))
+
+			return LoginStep{}, helpers.WrapErr(op, err)
+		}
+
+		return LoginStep{Challenge: challenge, Remaining: required[len(completed):], ExpiresAt: transaction.ExpiresAt}, nil
+	}
+
+	// claimed by deleting, a concurrent call completing the same login gets nothing
+	if err := s.loginTransactions.DeleteLoginTransaction(ctx, id); err != nil {
+		if errors.Is(err, storage.ErrLoginTransactionNotFound) {
+			return LoginStep{}, helpers.WrapErr(op, ErrInvalidChallenge)
+		}
+
+		log.Error("failed to claim login transaction", helpers.SlErr(err))
+
+		return LoginStep{}, helpers.WrapErr(op, err)
+	}
+
+	s.resetClientLogin(ctx, client.Name)
+
+	app, err := s.appProvider.App(ctx, transaction.AppName)
+
+	if err != nil {
+		if errors.Is(err, storage.ErrAppNotFound) {
+			log.Warn("app was deleted during login", helpers.SlErr(err))
+
+			return LoginStep{}, helpers.WrapErr(op, ErrInvalidApp)
+		}
+
+		log.Error("failed to get app", helpers.SlErr(err))
+
+		return LoginStep{}, helpers.WrapErr(op, err)
+	}
+
+	if err := s.checkAppAccess(log, client, app); err != nil {
+		return LoginStep{}, helpers.WrapErr(op, err)
+	}
+
+	if app.RequireMFA && !slices.ContainsFunc(completed, isSecondFactor) {
+		log.Warn("app requires two-factor authentication", "app", app.Name)
+
+		return LoginStep{}, helpers.WrapErr(op, ErrMFARequired)
+	}
+
+	token, err := s.newToken(ctx, client, app, transaction.Role, tokenDuration)
+
+	if err != nil {
+		return LoginStep{}, helpers.WrapErr(op, err)
+	}
+
+	return LoginStep{Token: token}, nil
+}
+
+// PurgeExpiredLogins deletes logins nobody finished in time
+func (s *Ssosage) PurgeExpiredLogins(ctx context.Context) (int64, error) {
+	const op = "services.ssosage.PurgeExpiredLogins"
+
+	n, err := s.loginTransactions.DeleteExpiredLoginTransactions(ctx, time.Now())
+
+	if err != nil {
+		s.log.Error("failed to purge expired logins", slog.String("op", op), helpers.SlErr(err))
+
+		return 0, helpers.WrapErr(op, err)
+	}
+
+	if n > 0 {
+		s.log.Debug("purged expired logins", slog.String("op", op), slog.Int64("count", n))
+	}
+
+	return n, nil
+}
+
+func (s *Ssosage) checkFactor(ctx context.Context, log *slog.Logger, transaction models.LoginTransaction, challenge string, factor string, value string, loginKeys []loginKey, now time.Time) (models.Client, error) {
+	if factor == FactorPassword {
+		return s.checkPassword(ctx, log, transaction.ClientName, value, loginKeys, now)
+	}
+
+	client, err := s.clientProvider.Client(ctx, transaction.ClientName)
+
+	if err != nil {
+		if errors.Is(err, storage.ErrClientNotFound) {
+			s.registerFailedLogin(ctx, loginKeys, now)
+
+			return models.Client{}, ErrInvalidCredentials
+		}
+
+		log.Error("failed to get client", helpers.SlErr(err))
+
+		return models.Client{}, err
+	}
+
+	if factor == FactorWebAuthn {
+		// a passkey standing alone has to verify the user as well
+		requireUV := len(transaction.Completed) == 0
+
+		if err := s.checkPasskey(ctx, log, client, challenge, value, requireUV, loginKeys, now); err != nil {
+			return models.Client{}, err
+		}
+
+		return client, nil
+	}
+
+	// the app wants totp from a client that has none
+	if !client.TOTPEnabled {
+		log.Warn("app requires two-factor authentication")
+
+		return models.Client{}, ErrMFARequired
+	}
+
+	if err := s.checkSecondFactor(ctx, log, client, value, loginKeys); err != nil {
+		return models.Client{}, err
+	}
+
+	return client, nil
+}
+
+// loginApp returns the app a login is for, if it has the role
+func (s *Ssosage) loginApp(ctx context.Context, log *slog.Logger, appName string, role string) (models.App, error) {
+	app, err := s.appProvider.App(ctx, appName)
+
+	if err != nil {
+		if errors.Is(err, storage.ErrAppNotFound) {
+			log.Warn("app not found", helpers.SlErr(err))
+
+			return models.App{}, ErrInvalidApp
+		}
+
+		log.Error("failed to get app", helpers.SlErr(err))
+
+		return models.App{}, err
+	}
+
+	if !hasRole(app, role) {
+		log.Warn("client wants a role that is absent in that app", "role", role, "app", appName)
+
+		return models.App{}, ErrInvalidRole
+	}
+
+	return app, nil
+}
+
+// afterPassword puts the second factor of the client right after the verified password
+func (s *Ssosage) afterPassword(ctx context.Context, log *slog.Logger, transaction models.LoginTransaction, client models.Client) ([]string, error) {
+	// a passkey registration has no app
+	var app models.App
+
+	if transaction.AppName != "" {
+		var err error
+
+		if app, err = s.appProvider.App(ctx, transaction.AppName); err != nil {
+			if errors.Is(err, storage.ErrAppNotFound) {
+				log.Warn("app was deleted during login", helpers.SlErr(err))
+
+				return nil, ErrInvalidApp
+			}
+
+			log.Error("failed to get app", helpers.SlErr(err))
+
+			return nil, err
+		}
+	}
+
+	position := len(transaction.Completed) + 1
+
+	return slices.Concat(transaction.Required[:position], s.secondFactor(client, app), transaction.Required[position:]), nil
+}
+
+// startLogin saves transaction under a new challenge
+func (s *Ssosage) startLogin(ctx context.Context, log *slog.Logger, transaction models.LoginTransaction) (LoginStep, error) {
+	challenge, err := newChallenge()
+
+	if err != nil {
+		log.Error("failed to generate challenge", helpers.SlErr(err))
+
+		return LoginStep{}, err
+	}
+
+	transaction.ID = challengeID(challenge)
+	transaction.ExpiresAt = time.Now().Add(s.mfa.LoginTTL)
+
+	if err := s.loginTransactions.SaveLoginTransaction(ctx, transaction); err != nil {
+		log.Error("failed to save login transaction", helpers.SlErr(err))
+
+		return LoginStep{}, err
+	}
+
+	return LoginStep{Challenge: challenge, Remaining: transaction.Required, ExpiresAt: transaction.ExpiresAt}, nil
+}
+
+/*
+secondFactor is the step after the password, a choice between the factors the client has.
+An app that requires two factors gets a totp step from a client without any, which fails.
+*/
+func (s *Ssosage) secondFactor(client models.Client, app models.App) []string {
+	var choices []string
+
+	if client.TOTPEnabled {
+		choices = append(choices, FactorTOTP)
+	}
+
+	if client.WebAuthnEnabled && s.mfa.WebAuthn.ID != "" {
+		choices = append(choices, FactorWebAuthn)
+	}
+
+	if len(choices) == 0 && app.RequireMFA {
+		choices = append(choices, FactorTOTP)
+	}
+
+	if len(choices) == 0 {
+		return nil
+	}
+
+	return []string{strings.Join(choices, FactorChoice)}
+}
+
+func stepAllows(step string, factor string) bool {
+	return slices.Contains(strings.Split(step, FactorChoice), factor)
+}
+
+func isSecondFactor(factor string) bool {
+	return factor == FactorTOTP || factor == FactorWebAuthn
+}
+
+// newChallenge returns 32 random bytes, only their hash is stored
+func newChallenge() (string, error) {
+	raw := make([]byte, 32)
+
+	if _, err := rand.Read(raw); err != nil {
+		return "", err
+	}
+
+	return base64.RawURLEncoding.EncodeToString(raw), nil
+}
+
+func challengeID(challenge string) string {
+	sum := sha256.Sum256([]byte(challenge))
+
+	return hex.EncodeToString(sum[:])
+}
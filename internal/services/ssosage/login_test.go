@@ -0,0 +1,196 @@
+package ssosage
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"ssosage/internal/models"
+	"ssosage/internal/totp"
+	"testing"
+	"time"
+)
+
+func TestLoginWithPassword(t *testing.T) {
+	sso := newTestSsosage(t, LockoutPolicy{})
+	ctx := context.Background()
+
+	step, err := sso.BeginLogin(ctx, testClient, testApp, "user")
+
+	if err != nil {
+		t.Fatalf("failed to begin login: %v", err)
+	}
+
+	if !slices.Equal(step.Remaining, []string{FactorPassword}) || step.Token != "" {
+		t.Fatalf("unexpected first step: %+v", step)
+	}
+
+	if _, err := sso.CompleteFactor(ctx, step.Challenge, FactorTOTP, "000000", ""); !errors.Is(err, ErrUnexpectedFactor) {
+		t.Fatalf("expected ErrUnexpectedFactor, got: %v", err)
+	}
+
+	if _, err := sso.CompleteFactor(ctx, step.Challenge, FactorPassword, "wrong", ""); !errors.Is(err, ErrInvalidCredentials) {
+		t.Fatalf("expected ErrInvalidCredentials, got: %v", err)
+	}
+
+	// a failed factor can be retried
+	step, err = sso.CompleteFactor(ctx, step.Challenge, FactorPassword, testPassword, "")
+
+	if err != nil {
+		t.Fatalf("failed to complete password: %v", err)
+	}
+
+	claims, err := sso.VerifyToken(ctx, step.Token)
+
+	if err != nil {
+		t.Fatalf("failed to verify token: %v", err)
+	}
+
+	if claims.ClientName != testClient || claims.AppName != testApp || claims.Role != "user" {
+		t.Fatalf("unexpected claims: %+v", claims)
+	}
+}
+
+func TestLoginWithTOTP(t *testing.T) {
+	sso := newTestSsosage(t, LockoutPolicy{})
+	ctx := context.Background()
+
+	secret, codes := enrollTOTP(t, sso)
+
+	step, err := sso.BeginLogin(ctx, testClient, testApp, "user")
+
+	if err != nil {
+		t.Fatalf("failed to begin login: %v", err)
+	}
+
+	// the second factor only shows up after the password
+	if !slices.Equal(step.Remaining, []string{FactorPassword}) {
+		t.Fatalf("unexpected factors: %v", step.Remaining)
+	}
+
+	challenge := step.Challenge
+
+	if step, err = sso.CompleteFactor(ctx, challenge, FactorPassword, testPassword, ""); err != nil {
+		t.Fatalf("failed to complete password: %v", err)
+	}
+
+	if !slices.Equal(step.Remaining, []string{FactorTOTP}) || step.Token != "" {
+		t.Fatalf("unexpected step after password: %+v", step)
+	}
+
+	if step, err = sso.CompleteFactor(ctx, challenge, FactorTOTP, totp.Code(secret, totp.Step(time.Now())), ""); err != nil {
+		t.Fatalf("failed to complete totp: %v", err)
+	}
+
+	if _, err := sso.VerifyToken(ctx, step.Token); err != nil {
+		t.Fatalf("failed to verify token: %v", err)
+	}
+
+	// a finished login can't be completed again
+	if _, err := sso.CompleteFactor(ctx, challenge, FactorTOTP, codes[0], ""); !errors.Is(err, ErrInvalidChallenge) {
+		t.Fatalf("expected ErrInvalidChallenge, got: %v", err)
+	}
+}
+
+func TestLoginUnknownClient(t *testing.T) {
+	sso := newTestSsosage(t, LockoutPolicy{})
+	ctx := context.Background()
+
+	step, err := sso.BeginLogin(ctx, "nobody", testApp, "user")
+
+	if err != nil {
+		t.Fatalf("expected a challenge for an unknown client, got: %v", err)
+	}
+
+	if _, err := sso.CompleteFactor(ctx, step.Challenge, FactorPassword, testPassword, ""); !errors.Is(err, ErrInvalidCredentials) {
+		t.Fatalf("expected ErrInvalidCredentials, got: %v", err)
+	}
+
+	if _, err := sso.BeginLogin(ctx, testClient, testApp, "root"); !errors.Is(err, ErrInvalidRole) {
+		t.Fatalf("expected ErrInvalidRole, got: %v", err)
+	}
+
+	if _, err := sso.CompleteFactor(ctx, "made up", FactorPassword, testPassword, ""); !errors.Is(err, ErrInvalidChallenge) {
+		t.Fatalf("expected ErrInvalidChallenge, got: %v", err)
+	}
+}
+
+func TestBeginLoginRevealsNothing(t *testing.T) {
+	sso := newTestSsosage(t, LockoutPolicy{})
+	ctx := context.Background()
+
+	enrollTOTP(t, sso)
+
+	// a client with totp and one that doesn't exist start alike
+	for _, client := range []string{testClient, "nobody"} {
+		step, err := sso.BeginLogin(ctx, client, testApp, "user")
+
+		if err != nil {
+			t.Fatalf("%s: failed to begin login: %v", client, err)
+		}
+
+		if !slices.Equal(step.Remaining, []string{FactorPassword}) {
+			t.Fatalf("%s: unexpected factors: %v", client, step.Remaining)
+		}
+
+		if _, err := sso.CompleteFactor(ctx, step.Challenge, FactorPassword, "wrong", ""); !errors.Is(err, ErrInvalidCredentials) {
+			t.Fatalf("%s: expected ErrInvalidCredentials, got: %v", client, err)
+		}
+	}
+}
+
+func TestLoginRequiresMFA(t *testing.T) {
+	sso := newTestSsosage(t, LockoutPolicy{})
+	ctx := context.Background()
+
+	app, err := sso.GetApp(ctx, testApp)
+
+	if err != nil {
+		t.Fatalf("failed to get app: %v", err)
+	}
+
+	requireMFA := true
+
+	if _, err := sso.UpdateApp(ctx, testApp, app.Version, models.AppUpdate{RequireMFA: &requireMFA}); err != nil {
+		t.Fatalf("failed to update app: %v", err)
+	}
+
+	step, err := sso.BeginLogin(ctx, testClient, testApp, "user")
+
+	if err != nil {
+		t.Fatalf("failed to begin login: %v", err)
+	}
+
+	if step, err = sso.CompleteFactor(ctx, step.Challenge, FactorPassword, testPassword, ""); err != nil {
+		t.Fatalf("failed to complete password: %v", err)
+	}
+
+	if !slices.Equal(step.Remaining, []string{FactorTOTP}) {
+		t.Fatalf("unexpected factors: %v", step.Remaining)
+	}
+
+	if _, err := sso.CompleteFactor(ctx, step.Challenge, FactorTOTP, "000000", ""); !errors.Is(err, ErrMFARequired) {
+		t.Fatalf("expected ErrMFARequired, got: %v", err)
+	}
+}
+
+func TestLoginExpires(t *testing.T) {
+	sso := newTestSsosage(t, LockoutPolicy{})
+	sso.mfa.LoginTTL = -time.Second
+	ctx := context.Background()
+
+	step, err := sso.BeginLogin(ctx, testClient, testApp, "user")
+
+	if err != nil {
+		t.Fatalf("failed to begin login: %v", err)
+	}
+
+	if _, err := sso.CompleteFactor(ctx, step.Challenge, FactorPassword, testPassword, ""); !errors.Is(err, ErrInvalidChallenge) {
+		t.Fatalf("expected ErrInvalidChallenge, got: %v", err)
+	}
+
+	n, err := sso.PurgeExpiredLogins(ctx)
+
+	if err != nil || n != 1 {
+		t.Fatalf("expected one purged login, got %d, %v", n, err)
+	}
+}
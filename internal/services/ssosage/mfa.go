@@ -0,0 +1,240 @@
+package ssosage
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"ssosage/internal/helpers"
+	"ssosage/internal/interfaces"
+	"ssosage/internal/models"
+	"ssosage/internal/storage"
+	"ssosage/internal/totp"
+	"ssosage/internal/webauthn"
+	"time"
+)
+
+var (
+	ErrTOTPRequired    = errors.New("totp code required")
+	ErrInvalidTOTP     = errors.New("invalid totp code")
+	ErrMFARequired     = errors.New("app requires two-factor authentication")
+	ErrTOTPUnavailable = errors.New("totp is not configured")
+	ErrTOTPEnabled     = errors.New("totp is already enabled")
+	ErrTOTPNotPending  = errors.New("no totp enrollment to confirm")
+)
+
+/*
+MFAPolicy configures the second factor. Totp secrets are sealed before they are stored,
+so totp is unavailable without a sealer. Skew is the number of time steps accepted
+before and after the current one, to cover clocks that drift apart.
+LoginTTL is how long a multi-step login started by BeginLogin may take.
+Passkeys are unavailable while WebAuthn has no ID.
+*/
+type MFAPolicy struct {
+	Issuer   string
+	Skew     int
+	LoginTTL time.Duration
+	WebAuthn webauthn.RelyingParty
+}
+
+/*
+EnrollTOTP generates a totp secret for a client that knows its password and returns it
+along with the otpauth uri for authenticator apps. The secret is used only after ConfirmTOTP,
+enrolling again before that replaces it.
+*/
+func (s *Ssosage) EnrollTOTP(ctx context.Context, clientName string, password string, sourceIP string) (string, string, error) {
+	const op = "services.ssosage.EnrollTOTP"
+
+	log := s.logWith(op, clientName)
+	log.Info("enrolling totp")
+
+	if s.sealer == nil {
+		return "", "", helpers.WrapErr(op, ErrTOTPUnavailable)
+	}
+
+	if _, err := s.authenticate(ctx, log, clientName, password, "", sourceIP); err != nil {
+		if errors.Is(err, ErrTOTPRequired) {
+			return "", "", helpers.WrapErr(op, ErrTOTPEnabled)
+		}
+
+		return "", "", helpers.WrapErr(op, err)
+	}
+
+	secret, err := totp.GenerateSecret()
+
+	if err != nil {
+		log.Error("failed to generate totp secret", helpers.SlErr(err))
+
+		return "", "", helpers.WrapErr(op, err)
+	}
+
+	sealed, err := s.sealer.Seal(secret)
+
+	if err != nil {
+		log.Error("failed to seal totp secret", helpers.SlErr(err))
+
+		return "", "", helpers.WrapErr(op, err)
+	}
+
+	if err := s.totpManager.SetTOTPSecret(ctx, clientName, sealed); err != nil {
+		log.Error("failed to store totp secret", helpers.SlErr(err))
+
+		return "", "", helpers.WrapErr(op, err)
+	}
+
+	return totp.EncodeSecret(secret), totp.URI(s.mfa.Issuer, clientName, secret), nil
+}
+
+// ConfirmTOTP turns on the secret from EnrollTOTP once the client proves it with a code,
+// and returns recovery codes to use in place of a totp code if the device is lost
+func (s *Ssosage) ConfirmTOTP(ctx context.Context, clientName string, password string, code string, sourceIP string) ([]string, error) {
+	const op = "services.ssosage.ConfirmTOTP"
+
+	log := s.logWith(op, clientName)
+	log.Info("confirming totp")
+
+	client, err := s.authenticate(ctx, log, clientName, password, "", sourceIP)
+
+	if err != nil {
+		if errors.Is(err, ErrTOTPRequired) {
+			return nil, helpers.WrapErr(op, ErrTOTPEnabled)
+		}
+
+		return nil, helpers.WrapErr(op, err)
+	}
+
+	if client.TOTPSecret == nil {
+		return nil, helpers.WrapErr(op, ErrTOTPNotPending)
+	}
+
+	if err := s.checkTOTP(ctx, log, client, code, s.loginKeys(clientName, sourceIP)); err != nil {
+		return nil, helpers.WrapErr(op, err)
+	}
+
+	codes, hashes, err := s.newRecoveryCodes()
+
+	if err != nil {
+		log.Error("failed to generate recovery codes", helpers.SlErr(err))
+
+		return nil, helpers.WrapErr(op, err)
+	}
+
+	err = s.transactor.WithinTx(ctx, func(repo interfaces.Repository) error {
+		if err := repo.EnableTOTP(ctx, clientName); err != nil {
+			return err
+		}
+
+		return replaceRecoveryCodes(ctx, repo, clientName, hashes)
+	})
+
+	if err != nil {
+		log.Error("failed to enable totp", helpers.SlErr(err))
+
+		return nil, helpers.WrapErr(op, err)
+	}
+
+	return codes, nil
+}
+
+// DisableTOTP turns off the second factor and drops the recovery codes of a client that passes both factors
+func (s *Ssosage) DisableTOTP(ctx context.Context, clientName string, password string, code string, sourceIP string) error {
+	const op = "services.ssosage.DisableTOTP"
+
+	log := s.logWith(op, clientName)
+	log.Info("disabling totp")
+
+	if _, err := s.authenticate(ctx, log, clientName, password, code, sourceIP); err != nil {
+		return helpers.WrapErr(op, err)
+	}
+
+	if err := s.clearTOTP(ctx, clientName); err != nil {
+		log.Error("failed to disable totp", helpers.SlErr(err))
+
+		return helpers.WrapErr(op, err)
+	}
+
+	return nil
+}
+
+// ResetTOTP turns off the second factor of a client that lost it, for admins
+func (s *Ssosage) ResetTOTP(ctx context.Context, clientName string) error {
+	const op = "services.ssosage.ResetTOTP"
+
+	log := s.logWith(op, clientName)
+	log.Info("resetting totp")
+
+	if err := s.clearTOTP(ctx, clientName); err != nil {
+		log.Error("failed to reset totp", helpers.SlErr(err))
+
+		return helpers.WrapErr(op, err)
+	}
+
+	return nil
+}
+
+func (s *Ssosage) clearTOTP(ctx context.Context, clientName string) error {
+	return s.transactor.WithinTx(ctx, func(repo interfaces.Repository) error {
+		if err := repo.ClearTOTP(ctx, clientName); err != nil {
+			return err
+		}
+
+		return repo.DeleteRecoveryCodes(ctx, clientName)
+	})
+}
+
+// checkSecondFactor takes a recovery code or a totp code, telling them apart by length
+func (s *Ssosage) checkSecondFactor(ctx context.Context, log *slog.Logger, client models.Client, code string, loginKeys []loginKey) error {
+	if isRecoveryCode(code) {
+		return s.checkRecoveryCode(ctx, log, client, code, loginKeys)
+	}
+
+	return s.checkTOTP(ctx, log, client, code, loginKeys)
+}
+
+// checkTOTP accepts a code of the client secret once, failures count against the lockout policy
+func (s *Ssosage) checkTOTP(ctx context.Context, log *slog.Logger, client models.Client, code string, loginKeys []loginKey) error {
+	const op = "services.ssosage.checkTOTP"
+
+	if code == "" {
+		return helpers.WrapErr(op, ErrTOTPRequired)
+	}
+
+	if s.sealer == nil {
+		log.Error("client has totp but no sealer is configured")
+
+		return helpers.WrapErr(op, ErrTOTPUnavailable)
+	}
+
+	secret, err := s.sealer.Open(client.TOTPSecret)
+
+	if err != nil {
+		log.Error("failed to open totp secret", helpers.SlErr(err))
+
+		return helpers.WrapErr(op, err)
+	}
+
+	now := time.Now()
+
+	step, ok := totp.Validate(secret, code, now, s.mfa.Skew)
+
+	if !ok {
+		log.Info("invalid totp code")
+		s.registerFailedLogin(ctx, loginKeys, now)
+
+		return helpers.WrapErr(op, ErrInvalidTOTP)
+	}
+
+	if err := s.totpManager.UseTOTPStep(ctx, client.Name, step); err != nil {
+		if errors.Is(err, storage.ErrTOTPStepUsed) {
+			log.Warn("totp code replayed")
+			s.registerFailedLogin(ctx, loginKeys, now)
+
+			return helpers.WrapErr(op, ErrInvalidTOTP)
+		}
+
+		log.Error("failed to use totp step", helpers.SlErr(err))
+
+		return helpers.WrapErr(op, err)
+	}
+
+	return nil
+}
@@ -0,0 +1,107 @@
+package ssosage
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"ssosage/internal/helpers"
+	"ssosage/internal/models"
+	"ssosage/internal/storage"
+	"strings"
+)
+
+var (
+	ErrInvalidOrganization = errors.New("invalid organization name")
+	ErrInvalidName         = errors.New("invalid name, expected name or organization/name")
+)
+
+// RegisterOrganization creates a tenant, its clients and apps are then registered as organization/name
+func (s *Ssosage) RegisterOrganization(ctx context.Context, name string) (int64, error) {
+	const op = "services.ssosage.RegisterOrganization"
+
+	log := s.logWith(op, name)
+	log.Info("registering organization")
+
+	if name == "" || strings.Contains(name, models.OrgSeparator) {
+		return 0, helpers.WrapErr(op, ErrInvalidOrganization)
+	}
+
+	id, err := s.organizations.SaveOrganization(ctx, name)
+
+	if err != nil {
+		log.Error("failed to save organization", helpers.SlErr(err))
+
+		return 0, helpers.WrapErr(op, err)
+	}
+
+	return id, nil
+}
+
+// DeleteOrganization removes a tenant that has no clients, apps or groups left
+func (s *Ssosage) DeleteOrganization(ctx context.Context, name string) error {
+	const op = "services.ssosage.DeleteOrganization"
+
+	log := s.logWith(op, name)
+	log.Info("deleting organization")
+
+	if err := s.organizations.DeleteOrganization(ctx, name); err != nil {
+		if errors.Is(err, storage.ErrOrganizationNotFound) || errors.Is(err, storage.ErrOrganizationNotEmpty) {
+			log.Warn("organization not deleted", helpers.SlErr(err))
+
+			return helpers.WrapErr(op, err)
+		}
+
+		log.Error("failed to delete organization", helpers.SlErr(err))
+
+		return helpers.WrapErr(op, err)
+	}
+
+	return nil
+}
+
+// nameIsValid accepts a name in the global namespace or one name inside an organization
+func nameIsValid(name string) bool {
+	org, local := models.SplitName(name)
+
+	if !strings.Contains(name, models.OrgSeparator) {
+		return name != ""
+	}
+
+	return org != "" && local != "" && !strings.Contains(local, models.OrgSeparator)
+}
+
+// checkAppAccess refuses a client the app doesn't take, whatever factors it completed
+func (s *Ssosage) checkAppAccess(log *slog.Logger, client models.Client, app models.App) error {
+	// an organization's apps are invisible to the clients of others
+	if app.OrgID != 0 && app.OrgID != client.OrgID {
+		log.Warn("app belongs to another organization", "app", app.Name)
+
+		return ErrInvalidApp
+	}
+
+	// global apps, the admin app among them, are closed to organizations unless they open up
+	if app.OrgID == 0 && client.OrgID != 0 && !app.OrgClients {
+		log.Warn("app does not take clients of organizations", "app", app.Name)
+
+		return ErrInvalidApp
+	}
+
+	if app.RequireVerifiedEmail && !client.EmailVerified {
+		log.Warn("app requires a verified email", "app", app.Name)
+
+		return ErrEmailNotVerified
+	}
+
+	return nil
+}
+
+// clientOrg is the name of the organization a client is in, empty outside of one
+func clientOrg(client models.Client) string {
+	if client.OrgID == 0 {
+		return ""
+	}
+
+	org, _ := models.SplitName(client.Name)
+
+	return org
+}
@@ -0,0 +1,133 @@
+package ssosage
+
+import (
+	"context"
+	"errors"
+	"ssosage/internal/models"
+	"ssosage/internal/storage"
+	"testing"
+)
+
+func TestOrganizations(t *testing.T) {
+	sso := newTestSsosage(t, LockoutPolicy{})
+	ctx := context.Background()
+
+	for _, org := range []string{"acme", "globex"} {
+		if _, err := sso.RegisterOrganization(ctx, org); err != nil {
+			t.Fatalf("failed to register organization %s: %v", org, err)
+		}
+	}
+
+	if _, err := sso.RegisterOrganization(ctx, "acme"); !errors.Is(err, storage.ErrOrganizationExists) {
+		t.Fatalf("expected ErrOrganizationExists, got: %v", err)
+	}
+
+	// the same names live side by side in different organizations
+	for _, org := range []string{"acme", "globex"} {
+		if _, err := sso.RegisterNewClient(ctx, org+"/"+testClient, testPassword); err != nil {
+			t.Fatalf("failed to register client in %s: %v", org, err)
+		}
+
+		if _, err := sso.RegisterNewApp(ctx, org+"/"+testApp, testSecret, "user"); err != nil {
+			t.Fatalf("failed to register app in %s: %v", org, err)
+		}
+	}
+
+	if _, err := sso.RegisterNewApp(ctx, "acme/"+testApp, testSecret, "user"); !errors.Is(err, storage.ErrAppExists) {
+		t.Fatalf("expected ErrAppExists, got: %v", err)
+	}
+
+	if _, err := sso.RegisterNewClient(ctx, "initech/"+testClient, testPassword); !errors.Is(err, storage.ErrOrganizationNotFound) {
+		t.Fatalf("expected ErrOrganizationNotFound, got: %v", err)
+	}
+
+	token, err := sso.GenerateToken(ctx, "acme/"+testClient, testPassword, "", "acme/"+testApp, "user", "")
+
+	if err != nil {
+		t.Fatalf("failed to generate token: %v", err)
+	}
+
+	verified, err := sso.VerifyToken(ctx, token)
+
+	if err != nil || verified.Org != "acme" || verified.OrgID == 0 {
+		t.Fatalf("expected a token of acme, got %+v, %v", verified, err)
+	}
+
+	// an organization's apps are closed to everybody else, global apps open up with org_clients
+	for _, client := range []string{"globex/" + testClient, testClient} {
+		if _, err := sso.GenerateToken(ctx, client, testPassword, "", "acme/"+testApp, "user", ""); !errors.Is(err, ErrInvalidApp) {
+			t.Fatalf("%s: expected ErrInvalidApp, got: %v", client, err)
+		}
+	}
+
+	if _, err := sso.GenerateToken(ctx, "globex/"+testClient, testPassword, "", testApp, "user", ""); !errors.Is(err, ErrInvalidApp) {
+		t.Fatalf("expected ErrInvalidApp for a global app, got: %v", err)
+	}
+
+	app, err := sso.GetApp(ctx, testApp)
+
+	if err != nil {
+		t.Fatalf("failed to get app: %v", err)
+	}
+
+	orgClients := true
+
+	if _, err := sso.UpdateApp(ctx, testApp, app.Version, models.AppUpdate{OrgClients: &orgClients}); err != nil {
+		t.Fatalf("failed to update app: %v", err)
+	}
+
+	if token, err = sso.GenerateToken(ctx, "globex/"+testClient, testPassword, "", testApp, "user", ""); err != nil {
+		t.Fatalf("failed to generate token for a global app: %v", err)
+	}
+
+	if verified, err = sso.VerifyToken(ctx, token); err != nil || verified.Org != "globex" {
+		t.Fatalf("expected a token of globex, got %+v, %v", verified, err)
+	}
+
+	step, err := sso.BeginLogin(ctx, "globex/"+testClient, "acme/"+testApp, "user")
+
+	if err != nil {
+		t.Fatalf("failed to begin login: %v", err)
+	}
+
+	if _, err := sso.CompleteFactor(ctx, step.Challenge, FactorPassword, testPassword, ""); !errors.Is(err, ErrInvalidApp) {
+		t.Fatalf("expected ErrInvalidApp, got: %v", err)
+	}
+
+	if err := sso.DeleteOrganization(ctx, "acme"); !errors.Is(err, storage.ErrOrganizationNotEmpty) {
+		t.Fatalf("expected ErrOrganizationNotEmpty, got: %v", err)
+	}
+
+	if err := sso.DeleteClient(ctx, "acme/"+testClient); err != nil {
+		t.Fatalf("failed to delete client: %v", err)
+	}
+
+	if err := sso.DeleteApp(ctx, "acme/"+testApp); err != nil {
+		t.Fatalf("failed to delete app: %v", err)
+	}
+
+	if err := sso.DeleteOrganization(ctx, "acme"); err != nil {
+		t.Fatalf("failed to delete organization: %v", err)
+	}
+}
+
+func TestOrganizationInvalidNames(t *testing.T) {
+	sso := newTestSsosage(t, LockoutPolicy{})
+	ctx := context.Background()
+
+	for _, org := range []string{"", "acme/dev"} {
+		if _, err := sso.RegisterOrganization(ctx, org); !errors.Is(err, ErrInvalidOrganization) {
+			t.Fatalf("%q: expected ErrInvalidOrganization, got: %v", org, err)
+		}
+	}
+
+	for _, name := range []string{"/client", "acme/", "acme/dev/client"} {
+		if _, err := sso.RegisterNewClient(ctx, name, testPassword); !errors.Is(err, ErrInvalidName) {
+			t.Fatalf("%q: expected ErrInvalidName, got: %v", name, err)
+		}
+
+		if _, err := sso.RegisterNewApp(ctx, name, testSecret, "user"); !errors.Is(err, ErrInvalidName) {
+			t.Fatalf("%q: expected ErrInvalidName, got: %v", name, err)
+		}
+	}
+}
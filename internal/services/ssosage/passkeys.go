@@ -0,0 +1,240 @@
+package ssosage
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"ssosage/internal/helpers"
+	"ssosage/internal/models"
+	"ssosage/internal/storage"
+	"ssosage/internal/webauthn"
+	"time"
+)
+
+var (
+	ErrWebAuthnUnavailable = errors.New("webauthn is not configured")
+	ErrInvalidPasskey      = errors.New("invalid passkey response")
+	ErrPasskeyExists       = errors.New("passkey is already registered")
+	ErrPasskeyNotFound     = errors.New("passkey not found")
+	ErrPasskeyRequired     = errors.New("client signs in with a passkey, use the multi-step login")
+)
+
+/*
+BeginPasskeyLogin starts a passwordless login, the only factor is an assertion of one of
+the passkeys of the client made with user verification. The challenge is the one to pass
+to navigator.credentials.get, it is base64url like the challenge in the client data.
+*/
+func (s *Ssosage) BeginPasskeyLogin(ctx context.Context, clientName string, appName string, role string) (LoginStep, error) {
+	const op = "services.ssosage.BeginPasskeyLogin"
+
+	log := s.logWith(op, clientName)
+	log.Info("beginning passkey login")
+
+	if s.mfa.WebAuthn.ID == "" {
+		return LoginStep{}, helpers.WrapErr(op, ErrWebAuthnUnavailable)
+	}
+
+	if _, err := s.loginApp(ctx, log, appName, role); err != nil {
+		return LoginStep{}, helpers.WrapErr(op, err)
+	}
+
+	step, err := s.startLogin(ctx, log, models.LoginTransaction{
+		ClientName: clientName,
+		AppName:    appName,
+		Role:       role,
+		Required:   []string{FactorWebAuthn},
+	})
+
+	if err != nil {
+		return LoginStep{}, helpers.WrapErr(op, err)
+	}
+
+	return step, nil
+}
+
+/*
+BeginPasskeyRegistration starts adding a passkey to a client. The client proves who it is
+like in a login, then completes FactorPasskeyRegistration with the response of
+navigator.credentials.create made with the challenge. Nothing is issued at the end.
+*/
+func (s *Ssosage) BeginPasskeyRegistration(ctx context.Context, clientName string) (LoginStep, error) {
+	const op = "services.ssosage.BeginPasskeyRegistration"
+
+	log := s.logWith(op, clientName)
+	log.Info("beginning passkey registration")
+
+	if s.mfa.WebAuthn.ID == "" {
+		return LoginStep{}, helpers.WrapErr(op, ErrWebAuthnUnavailable)
+	}
+
+	// the second factor comes in after the password, like in BeginLogin
+	step, err := s.startLogin(ctx, log, models.LoginTransaction{
+		ClientName: clientName,
+		Required:   []string{FactorPassword, FactorPasskeyRegistration},
+	})
+
+	if err != nil {
+		return LoginStep{}, helpers.WrapErr(op, err)
+	}
+
+	return step, nil
+}
+
+// ListPasskeys returns the passkeys of a client without their keys
+func (s *Ssosage) ListPasskeys(ctx context.Context, clientName string) ([]models.WebAuthnCredential, error) {
+	const op = "services.ssosage.ListPasskeys"
+
+	credentials, err := s.passkeys.WebAuthnCredentials(ctx, clientName)
+
+	if err != nil {
+		s.logWith(op, clientName).Error("failed to get passkeys", helpers.SlErr(err))
+
+		return nil, helpers.WrapErr(op, err)
+	}
+
+	for i := range credentials {
+		credentials[i].PublicKey = nil
+	}
+
+	return credentials, nil
+}
+
+// DeletePasskey removes a passkey of a client, a lost authenticator can't sign in anymore
+func (s *Ssosage) DeletePasskey(ctx context.Context, clientName string, id []byte) error {
+	const op = "services.ssosage.DeletePasskey"
+
+	log := s.logWith(op, clientName)
+	log.Info("deleting passkey")
+
+	if err := s.passkeys.DeleteWebAuthnCredential(ctx, clientName, id); err != nil {
+		if errors.Is(err, storage.ErrWebAuthnCredentialNotFound) {
+			return helpers.WrapErr(op, ErrPasskeyNotFound)
+		}
+
+		log.Error("failed to delete passkey", helpers.SlErr(err))
+
+		return helpers.WrapErr(op, err)
+	}
+
+	return nil
+}
+
+// completePasskeyRegistration checks the attestation and stores the passkey, the last step of a registration
+func (s *Ssosage) completePasskeyRegistration(ctx context.Context, log *slog.Logger, transaction models.LoginTransaction, challenge string, value string) error {
+	var response webauthn.AttestationResponse
+
+	if err := json.Unmarshal([]byte(value), &response); err != nil {
+		log.Warn("malformed passkey registration", helpers.SlErr(err))
+
+		return ErrInvalidPasskey
+	}
+
+	credential, err := s.mfa.WebAuthn.VerifyRegistration(challenge, response, false)
+
+	if err != nil {
+		log.Warn("passkey registration rejected", helpers.SlErr(err))
+
+		return ErrInvalidPasskey
+	}
+
+	// claimed by deleting, like a finished login
+	if err := s.loginTransactions.DeleteLoginTransaction(ctx, transaction.ID); err != nil {
+		if errors.Is(err, storage.ErrLoginTransactionNotFound) {
+			return ErrInvalidChallenge
+		}
+
+		log.Error("failed to claim passkey registration", helpers.SlErr(err))
+
+		return err
+	}
+
+	err = s.passkeys.SaveWebAuthnCredential(ctx, transaction.ClientName, models.WebAuthnCredential{
+		ID:        credential.ID,
+		PublicKey: credential.PublicKey,
+		SignCount: credential.SignCount,
+	})
+
+	if err != nil {
+		if errors.Is(err, storage.ErrWebAuthnCredentialExists) {
+			log.Warn("passkey is already registered")
+
+			return ErrPasskeyExists
+		}
+
+		log.Error("failed to save passkey", helpers.SlErr(err))
+
+		return err
+	}
+
+	log.Info("passkey registered")
+
+	return nil
+}
+
+/*
+checkPasskey verifies an assertion of one of the passkeys of the client, failures count
+against the lockout policy. requireUV is set when the passkey is the only factor.
+*/
+func (s *Ssosage) checkPasskey(ctx context.Context, log *slog.Logger, client models.Client, challenge string, value string, requireUV bool, loginKeys []loginKey, now time.Time) error {
+	const op = "services.ssosage.checkPasskey"
+
+	if s.mfa.WebAuthn.ID == "" {
+		return helpers.WrapErr(op, ErrWebAuthnUnavailable)
+	}
+
+	var response webauthn.AssertionResponse
+
+	if err := json.Unmarshal([]byte(value), &response); err != nil {
+		log.Info("malformed passkey assertion", helpers.SlErr(err))
+		s.registerFailedLogin(ctx, loginKeys, now)
+
+		return helpers.WrapErr(op, ErrInvalidPasskey)
+	}
+
+	credentials, err := s.passkeys.WebAuthnCredentials(ctx, client.Name)
+
+	if err != nil {
+		log.Error("failed to get passkeys", helpers.SlErr(err))
+
+		return helpers.WrapErr(op, err)
+	}
+
+	for _, credential := range credentials {
+		if !bytes.Equal(credential.ID, response.ID) {
+			continue
+		}
+
+		signCount, err := s.mfa.WebAuthn.VerifyAssertion(challenge, response, webauthn.Credential{
+			ID:        credential.ID,
+			PublicKey: credential.PublicKey,
+			SignCount: credential.SignCount,
+		}, requireUV)
+
+		if err != nil {
+			if errors.Is(err, webauthn.ErrSignCountRegressed) {
+				log.Warn("passkey sign count went back, the authenticator may be cloned", "stored", credential.SignCount)
+			} else {
+				log.Info("invalid passkey assertion", helpers.SlErr(err))
+			}
+
+			s.registerFailedLogin(ctx, loginKeys, now)
+
+			return helpers.WrapErr(op, ErrInvalidPasskey)
+		}
+
+		if err := s.passkeys.UpdateWebAuthnSignCount(ctx, client.Name, credential.ID, signCount); err != nil {
+			log.Error("failed to update passkey sign count", helpers.SlErr(err))
+
+			return helpers.WrapErr(op, err)
+		}
+
+		return nil
+	}
+
+	log.Info("unknown passkey")
+	s.registerFailedLogin(ctx, loginKeys, now)
+
+	return helpers.WrapErr(op, ErrInvalidPasskey)
+}
@@ -0,0 +1,352 @@
+package ssosage
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"ssosage/internal/models"
+	"ssosage/internal/totp"
+	"ssosage/internal/webauthn"
+	"ssosage/internal/webauthn/webauthntest"
+	"testing"
+	"time"
+)
+
+// registerPasskey adds a passkey of a new software authenticator to the test client, proving it with factors
+func registerPasskey(t *testing.T, sso *Ssosage, factors ...[2]string) *webauthntest.Authenticator {
+	t.Helper()
+
+	ctx := context.Background()
+	authenticator := webauthntest.New(testRP.ID, testRP.Origins[0])
+
+	step, err := sso.BeginPasskeyRegistration(ctx, testClient)
+
+	if err != nil {
+		t.Fatalf("failed to begin passkey registration: %v", err)
+	}
+
+	if step.Remaining[len(step.Remaining)-1] != FactorPasskeyRegistration {
+		t.Fatalf("expected the registration last, got %v", step.Remaining)
+	}
+
+	challenge := step.Challenge
+
+	for _, factor := range append([][2]string{{FactorPassword, testPassword}}, factors...) {
+		if _, err := sso.CompleteFactor(ctx, challenge, factor[0], factor[1], ""); err != nil {
+			t.Fatalf("failed to complete %s: %v", factor[0], err)
+		}
+	}
+
+	if step, err = sso.CompleteFactor(ctx, challenge, FactorPasskeyRegistration, authenticator.RegisterJSON(challenge), ""); err != nil {
+		t.Fatalf("failed to register passkey: %v", err)
+	}
+
+	if step.Token != "" {
+		t.Fatalf("expected no token from a registration")
+	}
+
+	return authenticator
+}
+
+func TestPasskeyRegistration(t *testing.T) {
+	sso := newTestSsosage(t, LockoutPolicy{})
+	ctx := context.Background()
+
+	step, err := sso.BeginPasskeyRegistration(ctx, testClient)
+
+	if err != nil {
+		t.Fatalf("failed to begin passkey registration: %v", err)
+	}
+
+	if !slices.Equal(step.Remaining, []string{FactorPassword, FactorPasskeyRegistration}) {
+		t.Fatalf("unexpected factors: %v", step.Remaining)
+	}
+
+	// the client proves who it is first
+	if _, err := sso.CompleteFactor(ctx, step.Challenge, FactorPasskeyRegistration, "{}", ""); !errors.Is(err, ErrUnexpectedFactor) {
+		t.Fatalf("expected ErrUnexpectedFactor, got: %v", err)
+	}
+
+	if _, err := sso.CompleteFactor(ctx, step.Challenge, FactorPassword, testPassword, ""); err != nil {
+		t.Fatalf("failed to complete password: %v", err)
+	}
+
+	// a response to another challenge is rejected and the registration goes on
+	other := webauthntest.New(testRP.ID, testRP.Origins[0])
+
+	if _, err := sso.CompleteFactor(ctx, step.Challenge, FactorPasskeyRegistration, other.RegisterJSON("b3RoZXI"), ""); !errors.Is(err, ErrInvalidPasskey) {
+		t.Fatalf("expected ErrInvalidPasskey, got: %v", err)
+	}
+
+	authenticator := webauthntest.New(testRP.ID, testRP.Origins[0])
+
+	if _, err := sso.CompleteFactor(ctx, step.Challenge, FactorPasskeyRegistration, authenticator.RegisterJSON(step.Challenge), ""); err != nil {
+		t.Fatalf("failed to register passkey: %v", err)
+	}
+
+	passkeys, err := sso.ListPasskeys(ctx, testClient)
+
+	if err != nil {
+		t.Fatalf("failed to list passkeys: %v", err)
+	}
+
+	if len(passkeys) != 1 || string(passkeys[0].ID) != string(authenticator.CredentialID) || passkeys[0].PublicKey != nil {
+		t.Fatalf("unexpected passkeys: %+v", passkeys)
+	}
+
+	// another passkey needs the first one too, and the same one can't be added twice
+	if step, err = sso.BeginPasskeyRegistration(ctx, testClient); err != nil {
+		t.Fatalf("failed to begin passkey registration: %v", err)
+	}
+
+	if step, err = sso.CompleteFactor(ctx, step.Challenge, FactorPassword, testPassword, ""); err != nil {
+		t.Fatalf("failed to complete password: %v", err)
+	}
+
+	if !slices.Equal(step.Remaining, []string{FactorWebAuthn, FactorPasskeyRegistration}) {
+		t.Fatalf("unexpected factors: %v", step.Remaining)
+	}
+
+	if _, err := sso.CompleteFactor(ctx, step.Challenge, FactorWebAuthn, authenticator.AssertJSON(step.Challenge), ""); err != nil {
+		t.Fatalf("failed to complete passkey: %v", err)
+	}
+
+	if _, err := sso.CompleteFactor(ctx, step.Challenge, FactorPasskeyRegistration, authenticator.RegisterJSON(step.Challenge), ""); !errors.Is(err, ErrPasskeyExists) {
+		t.Fatalf("expected ErrPasskeyExists, got: %v", err)
+	}
+}
+
+func TestPasskeyAsSecondFactor(t *testing.T) {
+	sso := newTestSsosage(t, LockoutPolicy{})
+	ctx := context.Background()
+
+	authenticator := registerPasskey(t, sso)
+
+	// the passkey can't be checked in a single call
+	if _, err := sso.GenerateToken(ctx, testClient, testPassword, "", testApp, "user", ""); !errors.Is(err, ErrPasskeyRequired) {
+		t.Fatalf("expected ErrPasskeyRequired, got: %v", err)
+	}
+
+	step, err := sso.BeginLogin(ctx, testClient, testApp, "user")
+
+	if err != nil {
+		t.Fatalf("failed to begin login: %v", err)
+	}
+
+	challenge := step.Challenge
+
+	if step, err = sso.CompleteFactor(ctx, challenge, FactorPassword, testPassword, ""); err != nil {
+		t.Fatalf("failed to complete password: %v", err)
+	}
+
+	if !slices.Equal(step.Remaining, []string{FactorWebAuthn}) {
+		t.Fatalf("unexpected factors: %v", step.Remaining)
+	}
+
+	// presence is enough after the password
+	authenticator.UserVerified = false
+
+	if step, err = sso.CompleteFactor(ctx, challenge, FactorWebAuthn, authenticator.AssertJSON(challenge), ""); err != nil {
+		t.Fatalf("failed to complete passkey: %v", err)
+	}
+
+	if _, err := sso.VerifyToken(ctx, step.Token); err != nil {
+		t.Fatalf("failed to verify token: %v", err)
+	}
+
+	passkeys, err := sso.ListPasskeys(ctx, testClient)
+
+	if err != nil || len(passkeys) != 1 || passkeys[0].SignCount != authenticator.SignCount || passkeys[0].LastUsedAt.IsZero() {
+		t.Fatalf("expected the sign count to be stored, got %+v, %v", passkeys, err)
+	}
+}
+
+func TestPasskeyOnlyClientNeedsThePasskey(t *testing.T) {
+	sso := newTestSsosage(t, LockoutPolicy{})
+	ctx := context.Background()
+
+	registerPasskey(t, sso)
+
+	// the password alone proves nothing about a client that signs in with a passkey
+	if _, err := sso.GenerateToken(ctx, testClient, testPassword, "", testApp, "user", ""); !errors.Is(err, ErrPasskeyRequired) {
+		t.Fatalf("expected ErrPasskeyRequired from GenerateToken, got: %v", err)
+	}
+
+	if err := sso.ChangePassword(ctx, testClient, testPassword, "new-password", "", ""); !errors.Is(err, ErrPasskeyRequired) {
+		t.Fatalf("expected ErrPasskeyRequired from ChangePassword, got: %v", err)
+	}
+
+	if err := sso.SetEmail(ctx, testClient, testPassword, "", "client@example.com", ""); !errors.Is(err, ErrPasskeyRequired) {
+		t.Fatalf("expected ErrPasskeyRequired from SetEmail, got: %v", err)
+	}
+
+	if _, _, err := sso.EnrollTOTP(ctx, testClient, testPassword, ""); !errors.Is(err, ErrPasskeyRequired) {
+		t.Fatalf("expected ErrPasskeyRequired from EnrollTOTP, got: %v", err)
+	}
+
+	if _, err := sso.ConfirmTOTP(ctx, testClient, testPassword, "123456", ""); !errors.Is(err, ErrPasskeyRequired) {
+		t.Fatalf("expected ErrPasskeyRequired from ConfirmTOTP, got: %v", err)
+	}
+}
+
+func TestPasskeyOrTOTP(t *testing.T) {
+	sso := newTestSsosage(t, LockoutPolicy{})
+	ctx := context.Background()
+
+	secret, codes := enrollTOTP(t, sso)
+	authenticator := registerPasskey(t, sso, [2]string{FactorTOTP, codes[0]})
+
+	step, err := sso.BeginLogin(ctx, testClient, testApp, "user")
+
+	if err != nil {
+		t.Fatalf("failed to begin login: %v", err)
+	}
+
+	if step, err = sso.CompleteFactor(ctx, step.Challenge, FactorPassword, testPassword, ""); err != nil {
+		t.Fatalf("failed to complete password: %v", err)
+	}
+
+	if !slices.Equal(step.Remaining, []string{FactorTOTP + FactorChoice + FactorWebAuthn}) {
+		t.Fatalf("unexpected factors: %v", step.Remaining)
+	}
+
+	if step, err = sso.CompleteFactor(ctx, step.Challenge, FactorWebAuthn, authenticator.AssertJSON(step.Challenge), ""); err != nil || step.Token == "" {
+		t.Fatalf("expected a token after the passkey, got %+v, %v", step, err)
+	}
+
+	// totp still works in a single call
+	if _, err := sso.GenerateToken(ctx, testClient, testPassword, totp.Code(secret, totp.Step(time.Now())), testApp, "user", ""); err != nil {
+		t.Fatalf("failed to generate token with totp: %v", err)
+	}
+}
+
+func TestPasswordlessLogin(t *testing.T) {
+	sso := newTestSsosage(t, LockoutPolicy{})
+	ctx := context.Background()
+
+	authenticator := registerPasskey(t, sso)
+
+	app, err := sso.GetApp(ctx, testApp)
+
+	if err != nil {
+		t.Fatalf("failed to get app: %v", err)
+	}
+
+	requireMFA := true
+
+	if _, err := sso.UpdateApp(ctx, testApp, app.Version, models.AppUpdate{RequireMFA: &requireMFA}); err != nil {
+		t.Fatalf("failed to update app: %v", err)
+	}
+
+	step, err := sso.BeginPasskeyLogin(ctx, testClient, testApp, "user")
+
+	if err != nil {
+		t.Fatalf("failed to begin passkey login: %v", err)
+	}
+
+	if !slices.Equal(step.Remaining, []string{FactorWebAuthn}) {
+		t.Fatalf("unexpected factors: %v", step.Remaining)
+	}
+
+	// a passkey standing alone has to verify the user
+	authenticator.UserVerified = false
+
+	if _, err := sso.CompleteFactor(ctx, step.Challenge, FactorWebAuthn, authenticator.AssertJSON(step.Challenge), ""); !errors.Is(err, ErrInvalidPasskey) {
+		t.Fatalf("expected ErrInvalidPasskey, got: %v", err)
+	}
+
+	authenticator.UserVerified = true
+
+	// a verified passkey counts as two factors
+	if step, err = sso.CompleteFactor(ctx, step.Challenge, FactorWebAuthn, authenticator.AssertJSON(step.Challenge), ""); err != nil {
+		t.Fatalf("failed to complete passkey: %v", err)
+	}
+
+	claims, err := sso.VerifyToken(ctx, step.Token)
+
+	if err != nil || claims.ClientName != testClient {
+		t.Fatalf("unexpected claims %+v, %v", claims, err)
+	}
+
+	// unknown clients get a challenge they can't complete
+	if step, err = sso.BeginPasskeyLogin(ctx, "nobody", testApp, "user"); err != nil {
+		t.Fatalf("expected a challenge for an unknown client, got: %v", err)
+	}
+
+	if _, err := sso.CompleteFactor(ctx, step.Challenge, FactorWebAuthn, authenticator.AssertJSON(step.Challenge), ""); !errors.Is(err, ErrInvalidCredentials) {
+		t.Fatalf("expected ErrInvalidCredentials, got: %v", err)
+	}
+}
+
+func TestPasskeyRejected(t *testing.T) {
+	sso := newTestSsosage(t, LockoutPolicy{ClientMaxAttempts: 2, LockoutDuration: time.Minute, Window: time.Minute})
+	ctx := context.Background()
+
+	authenticator := registerPasskey(t, sso)
+
+	step, err := sso.BeginPasskeyLogin(ctx, testClient, testApp, "user")
+
+	if err != nil {
+		t.Fatalf("failed to begin passkey login: %v", err)
+	}
+
+	if _, err := sso.CompleteFactor(ctx, step.Challenge, FactorWebAuthn, authenticator.AssertJSON("b3RoZXI"), ""); !errors.Is(err, ErrInvalidPasskey) {
+		t.Fatalf("expected ErrInvalidPasskey for another challenge, got: %v", err)
+	}
+
+	// a cloned authenticator repeats a counter the original already used
+	clone := *authenticator
+
+	if _, err := sso.CompleteFactor(ctx, step.Challenge, FactorWebAuthn, authenticator.AssertJSON(step.Challenge), ""); err != nil {
+		t.Fatalf("failed to complete passkey: %v", err)
+	}
+
+	if step, err = sso.BeginPasskeyLogin(ctx, testClient, testApp, "user"); err != nil {
+		t.Fatalf("failed to begin passkey login: %v", err)
+	}
+
+	if _, err := sso.CompleteFactor(ctx, step.Challenge, FactorWebAuthn, clone.AssertJSON(step.Challenge), ""); !errors.Is(err, ErrInvalidPasskey) {
+		t.Fatalf("expected ErrInvalidPasskey for a repeated sign count, got: %v", err)
+	}
+
+	// failures count against the lockout policy
+	if _, err := sso.CompleteFactor(ctx, step.Challenge, FactorWebAuthn, "not json", ""); !errors.Is(err, ErrInvalidPasskey) {
+		t.Fatalf("expected ErrInvalidPasskey, got: %v", err)
+	}
+
+	if _, err := sso.CompleteFactor(ctx, step.Challenge, FactorWebAuthn, authenticator.AssertJSON(step.Challenge), ""); !errors.Is(err, ErrLoginLocked) {
+		t.Fatalf("expected ErrLoginLocked, got: %v", err)
+	}
+
+	if err := sso.UnlockClient(ctx, testClient); err != nil {
+		t.Fatalf("failed to unlock client: %v", err)
+	}
+
+	// a deleted passkey is no longer a factor
+	if err := sso.DeletePasskey(ctx, testClient, authenticator.CredentialID); err != nil {
+		t.Fatalf("failed to delete passkey: %v", err)
+	}
+
+	if err := sso.DeletePasskey(ctx, testClient, authenticator.CredentialID); !errors.Is(err, ErrPasskeyNotFound) {
+		t.Fatalf("expected ErrPasskeyNotFound, got: %v", err)
+	}
+
+	if step, err = sso.BeginLogin(ctx, testClient, testApp, "user"); err != nil || !slices.Equal(step.Remaining, []string{FactorPassword}) {
+		t.Fatalf("expected a password only login, got %+v, %v", step, err)
+	}
+}
+
+func TestPasskeysUnavailable(t *testing.T) {
+	sso := newTestSsosage(t, LockoutPolicy{})
+	sso.mfa.WebAuthn = webauthn.RelyingParty{}
+
+	ctx := context.Background()
+
+	if _, err := sso.BeginPasskeyLogin(ctx, testClient, testApp, "user"); !errors.Is(err, ErrWebAuthnUnavailable) {
+		t.Fatalf("expected ErrWebAuthnUnavailable, got: %v", err)
+	}
+
+	if _, err := sso.BeginPasskeyRegistration(ctx, testClient); !errors.Is(err, ErrWebAuthnUnavailable) {
+		t.Fatalf("expected ErrWebAuthnUnavailable, got: %v", err)
+	}
+}
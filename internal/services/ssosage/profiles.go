@@ -0,0 +1,212 @@
+package ssosage
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/url"
+	"regexp"
+	"ssosage/internal/helpers"
+	"ssosage/internal/models"
+	"ssosage/internal/storage"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+var (
+	ErrInvalidDisplayName   = errors.New("invalid display name")
+	ErrInvalidLocale        = errors.New("invalid locale")
+	ErrInvalidAvatarURL     = errors.New("avatar url must be an absolute http or https url")
+	ErrInvalidMetadata      = errors.New("profile metadata must be a JSON object")
+	ErrInvalidProfileClaims = errors.New("invalid profile claims")
+)
+
+const (
+	ProfileDisplayName = "display_name"
+	ProfileLocale      = "locale"
+	ProfileAvatarURL   = "avatar_url"
+	ProfileMetadata    = "metadata"
+)
+
+const (
+	maxDisplayNameLength = 128
+	maxAvatarURLLength   = 2048
+	maxMetadataSize      = 16 << 10
+)
+
+// a language tag like en or pt-BR
+var localePattern = regexp.MustCompile(`^[a-zA-Z]{2,3}(-[a-zA-Z0-9]{1,8})*$`)
+
+// profileClaimNames are the token claims of the standard attributes, named like their openid connect counterparts
+var profileClaimNames = map[string]string{
+	ProfileDisplayName: "name",
+	ProfileLocale:      "locale",
+	ProfileAvatarURL:   "picture",
+}
+
+// GetProfile returns the profile of a client, an empty one if it was never updated
+func (s *Ssosage) GetProfile(ctx context.Context, clientName string) (models.Profile, error) {
+	const op = "services.ssosage.GetProfile"
+
+	profile, err := s.profiles.Profile(ctx, clientName)
+
+	if err != nil {
+		if !errors.Is(err, storage.ErrClientNotFound) {
+			s.logWith(op, clientName).Error("failed to get profile", helpers.SlErr(err))
+		}
+
+		return models.Profile{}, helpers.WrapErr(op, err)
+	}
+
+	return profile, nil
+}
+
+/*
+UpdateProfile changes the fields of a client profile that are set and returns the profile.
+An empty string clears a field, metadata is replaced as a whole. Tokens issued before
+keep the attributes they were issued with.
+*/
+func (s *Ssosage) UpdateProfile(ctx context.Context, clientName string, update models.ProfileUpdate) (models.Profile, error) {
+	const op = "services.ssosage.UpdateProfile"
+
+	log := s.logWith(op, clientName)
+	log.Info("updating profile")
+
+	if err := validateProfile(update); err != nil {
+		log.Warn("invalid profile update", helpers.SlErr(err))
+
+		return models.Profile{}, helpers.WrapErr(op, err)
+	}
+
+	profile, err := s.profiles.UpdateProfile(ctx, clientName, update)
+
+	if err != nil {
+		if errors.Is(err, storage.ErrClientNotFound) {
+			log.Warn("profile not updated", helpers.SlErr(err))
+
+			return models.Profile{}, helpers.WrapErr(op, err)
+		}
+
+		log.Error("failed to update profile", helpers.SlErr(err))
+
+		return models.Profile{}, helpers.WrapErr(op, err)
+	}
+
+	return profile, nil
+}
+
+func validateProfile(update models.ProfileUpdate) error {
+	if name := update.DisplayName; name != nil {
+		if !utf8.ValidString(*name) || utf8.RuneCountInString(*name) > maxDisplayNameLength || strings.ContainsFunc(*name, unicode.IsControl) {
+			return ErrInvalidDisplayName
+		}
+	}
+
+	if locale := update.Locale; locale != nil && *locale != "" && !localePattern.MatchString(*locale) {
+		return ErrInvalidLocale
+	}
+
+	if avatar := update.AvatarURL; avatar != nil && *avatar != "" {
+		u, err := url.Parse(*avatar)
+
+		if err != nil || len(*avatar) > maxAvatarURLLength || (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" {
+			return ErrInvalidAvatarURL
+		}
+	}
+
+	if update.Metadata != nil {
+		var metadata map[string]any
+
+		if len(*update.Metadata) > maxMetadataSize || json.Unmarshal([]byte(*update.Metadata), &metadata) != nil || metadata == nil {
+			return ErrInvalidMetadata
+		}
+	}
+
+	return nil
+}
+
+// validateProfileClaims accepts a comma separated list of profile attributes and metadata.<key> entries
+func validateProfileClaims(claims string) error {
+	if claims == "" {
+		return nil
+	}
+
+	for _, attribute := range strings.Split(claims, ",") {
+		key, isMetadataKey := strings.CutPrefix(attribute, ProfileMetadata+".")
+
+		if isMetadataKey && key != "" {
+			continue
+		}
+
+		if _, ok := profileClaimNames[attribute]; !ok && attribute != ProfileMetadata {
+			return ErrInvalidProfileClaims
+		}
+	}
+
+	return nil
+}
+
+/*
+projectProfile adds the attributes an app asks for to the claims of a token. Empty attributes
+are left out, metadata goes into a metadata claim, whole or with the selected keys only.
+*/
+func projectProfile(claims map[string]any, profile models.Profile, attributes string) error {
+	var metadata map[string]any
+
+	if err := json.Unmarshal([]byte(profile.Metadata), &metadata); err != nil {
+		return err
+	}
+
+	projected := make(map[string]any)
+
+	for _, attribute := range strings.Split(attributes, ",") {
+		switch attribute {
+		case ProfileDisplayName:
+			if profile.DisplayName != "" {
+				claims[profileClaimNames[attribute]] = profile.DisplayName
+			}
+		case ProfileLocale:
+			if profile.Locale != "" {
+				claims[profileClaimNames[attribute]] = profile.Locale
+			}
+		case ProfileAvatarURL:
+			if profile.AvatarURL != "" {
+				claims[profileClaimNames[attribute]] = profile.AvatarURL
+			}
+		case ProfileMetadata:
+			for key, value := range metadata {
+				projected[key] = value
+			}
+		default:
+			key, _ := strings.CutPrefix(attribute, ProfileMetadata+".")
+
+			if value, ok := metadata[key]; ok {
+				projected[key] = value
+			}
+		}
+	}
+
+	if len(projected) > 0 {
+		claims[ProfileMetadata] = projected
+	}
+
+	return nil
+}
+
+// tokenProfile reads back the attributes projectProfile put into claims
+func tokenProfile(claims map[string]any) models.Profile {
+	var profile models.Profile
+
+	profile.DisplayName, _ = claims[profileClaimNames[ProfileDisplayName]].(string)
+	profile.Locale, _ = claims[profileClaimNames[ProfileLocale]].(string)
+	profile.AvatarURL, _ = claims[profileClaimNames[ProfileAvatarURL]].(string)
+
+	if metadata, ok := claims[ProfileMetadata].(map[string]any); ok {
+		if encoded, err := json.Marshal(metadata); err == nil {
+			profile.Metadata = string(encoded)
+		}
+	}
+
+	return profile
+}
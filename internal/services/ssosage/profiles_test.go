@@ -0,0 +1,143 @@
+package ssosage
+
+import (
+	"context"
+	"errors"
+	"ssosage/internal/models"
+	"ssosage/internal/storage"
+	"strings"
+	"testing"
+)
+
+func TestProfile(t *testing.T) {
+	sso := newTestSsosage(t, LockoutPolicy{})
+	ctx := context.Background()
+
+	profile, err := sso.GetProfile(ctx, testClient)
+
+	if err != nil || profile.DisplayName != "" || profile.Metadata != "{}" {
+		t.Fatalf("expected an empty profile, got %+v, %v", profile, err)
+	}
+
+	displayName := "Test Client"
+	locale := "pt-BR"
+	avatarURL := "https://example.com/avatar.png"
+	metadata := `{"team":"core","seat":12}`
+
+	profile, err = sso.UpdateProfile(ctx, testClient, models.ProfileUpdate{
+		DisplayName: &displayName,
+		Locale:      &locale,
+		AvatarURL:   &avatarURL,
+		Metadata:    &metadata,
+	})
+
+	if err != nil {
+		t.Fatalf("failed to update profile: %v", err)
+	}
+
+	if profile.DisplayName != displayName || profile.Locale != locale || profile.AvatarURL != avatarURL || profile.Metadata != metadata {
+		t.Fatalf("unexpected profile: %+v", profile)
+	}
+
+	empty := ""
+
+	// an empty string clears a field
+	if profile, err = sso.UpdateProfile(ctx, testClient, models.ProfileUpdate{AvatarURL: &empty}); err != nil || profile.AvatarURL != "" || profile.Locale != locale {
+		t.Fatalf("expected the avatar to be cleared, got %+v, %v", profile, err)
+	}
+
+	if _, err := sso.GetProfile(ctx, "nobody"); !errors.Is(err, storage.ErrClientNotFound) {
+		t.Fatalf("expected ErrClientNotFound, got: %v", err)
+	}
+}
+
+func TestProfileInvalid(t *testing.T) {
+	sso := newTestSsosage(t, LockoutPolicy{})
+	ctx := context.Background()
+
+	long := strings.Repeat("a", maxDisplayNameLength+1)
+	control := "line\nbreak"
+	locale := "english please"
+	relative := "/avatar.png"
+	script := "javascript:alert(1)"
+	array := `["not","an","object"]`
+	broken := `{"team":`
+
+	tests := []struct {
+		update models.ProfileUpdate
+		err    error
+	}{
+		{models.ProfileUpdate{DisplayName: &long}, ErrInvalidDisplayName},
+		{models.ProfileUpdate{DisplayName: &control}, ErrInvalidDisplayName},
+		{models.ProfileUpdate{Locale: &locale}, ErrInvalidLocale},
+		{models.ProfileUpdate{AvatarURL: &relative}, ErrInvalidAvatarURL},
+		{models.ProfileUpdate{AvatarURL: &script}, ErrInvalidAvatarURL},
+		{models.ProfileUpdate{Metadata: &array}, ErrInvalidMetadata},
+		{models.ProfileUpdate{Metadata: &broken}, ErrInvalidMetadata},
+	}
+
+	for _, tt := range tests {
+		if _, err := sso.UpdateProfile(ctx, testClient, tt.update); !errors.Is(err, tt.err) {
+			t.Fatalf("%+v: expected %v, got: %v", tt.update, tt.err, err)
+		}
+	}
+}
+
+func TestProfileClaims(t *testing.T) {
+	sso := newTestSsosage(t, LockoutPolicy{})
+	ctx := context.Background()
+
+	displayName := "Test Client"
+	avatarURL := "https://example.com/avatar.png"
+	metadata := `{"team":"core","salary":100}`
+
+	if _, err := sso.UpdateProfile(ctx, testClient, models.ProfileUpdate{DisplayName: &displayName, AvatarURL: &avatarURL, Metadata: &metadata}); err != nil {
+		t.Fatalf("failed to update profile: %v", err)
+	}
+
+	token, err := sso.GenerateToken(ctx, testClient, testPassword, "", testApp, "user", "")
+
+	if err != nil {
+		t.Fatalf("failed to generate token: %v", err)
+	}
+
+	// apps get nothing they didn't ask for
+	if verified, err := sso.VerifyToken(ctx, token); err != nil || verified.Profile != (models.Profile{}) {
+		t.Fatalf("expected no profile in the token, got %+v, %v", verified, err)
+	}
+
+	app, err := sso.GetApp(ctx, testApp)
+
+	if err != nil {
+		t.Fatalf("failed to get app: %v", err)
+	}
+
+	for _, invalid := range []string{"display_name,email", "metadata.", "display_name,"} {
+		if _, err := sso.UpdateApp(ctx, testApp, app.Version, models.AppUpdate{ProfileClaims: &invalid}); !errors.Is(err, ErrInvalidProfileClaims) {
+			t.Fatalf("%q: expected ErrInvalidProfileClaims, got: %v", invalid, err)
+		}
+	}
+
+	profileClaims := "display_name,locale,metadata.team,metadata.missing"
+
+	if _, err := sso.UpdateApp(ctx, testApp, app.Version, models.AppUpdate{ProfileClaims: &profileClaims}); err != nil {
+		t.Fatalf("failed to update app: %v", err)
+	}
+
+	if token, err = sso.GenerateToken(ctx, testClient, testPassword, "", testApp, "user", ""); err != nil {
+		t.Fatalf("failed to generate token: %v", err)
+	}
+
+	verified, err := sso.VerifyToken(ctx, token)
+
+	if err != nil {
+		t.Fatalf("failed to verify token: %v", err)
+	}
+
+	// the empty locale is left out along with the attributes not asked for
+	expected := models.Profile{DisplayName: displayName, Metadata: `{"team":"core"}`}
+
+	if verified.Profile != expected {
+		t.Fatalf("expected %+v in the token, got %+v", expected, verified.Profile)
+	}
+}
@@ -0,0 +1,158 @@
+package ssosage
+
+import (
+	"context"
+	"crypto/rand"
+	"errors"
+	"log/slog"
+	"ssosage/internal/helpers"
+	"ssosage/internal/interfaces"
+	"ssosage/internal/models"
+	"ssosage/internal/storage"
+	"strings"
+	"time"
+)
+
+var (
+	ErrInvalidRecoveryCode = errors.New("invalid recovery code")
+	ErrTOTPNotEnabled      = errors.New("totp is not enabled")
+)
+
+const (
+	recoveryCodeCount = 10
+	recoveryCodeSize  = 10
+	// crockford base32, no letters that are easy to mistake for digits
+	recoveryCodeAlphabet = "0123456789abcdefghjkmnpqrstvwxyz"
+)
+
+// RegenerateRecoveryCodes replaces the recovery codes of a client that passes both factors
+func (s *Ssosage) RegenerateRecoveryCodes(ctx context.Context, clientName string, password string, code string, sourceIP string) ([]string, error) {
+	const op = "services.ssosage.RegenerateRecoveryCodes"
+
+	log := s.logWith(op, clientName)
+	log.Info("regenerating recovery codes")
+
+	client, err := s.authenticate(ctx, log, clientName, password, code, sourceIP)
+
+	if err != nil {
+		return nil, helpers.WrapErr(op, err)
+	}
+
+	if !client.TOTPEnabled {
+		return nil, helpers.WrapErr(op, ErrTOTPNotEnabled)
+	}
+
+	codes, hashes, err := s.newRecoveryCodes()
+
+	if err != nil {
+		log.Error("failed to generate recovery codes", helpers.SlErr(err))
+
+		return nil, helpers.WrapErr(op, err)
+	}
+
+	err = s.transactor.WithinTx(ctx, func(repo interfaces.Repository) error {
+		return replaceRecoveryCodes(ctx, repo, clientName, hashes)
+	})
+
+	if err != nil {
+		log.Error("failed to store recovery codes", helpers.SlErr(err))
+
+		return nil, helpers.WrapErr(op, err)
+	}
+
+	return codes, nil
+}
+
+// newRecoveryCodes returns the codes to show once and their hashes to store
+func (s *Ssosage) newRecoveryCodes() ([]string, [][]byte, error) {
+	codes := make([]string, recoveryCodeCount)
+	hashes := make([][]byte, recoveryCodeCount)
+
+	for i := range codes {
+		raw := make([]byte, recoveryCodeSize)
+
+		if _, err := rand.Read(raw); err != nil {
+			return nil, nil, err
+		}
+
+		for j, b := range raw {
+			raw[j] = recoveryCodeAlphabet[int(b)%len(recoveryCodeAlphabet)]
+		}
+
+		hash, err := s.hasher.Hash(string(raw))
+
+		if err != nil {
+			return nil, nil, err
+		}
+
+		codes[i] = string(raw[:recoveryCodeSize/2]) + "-" + string(raw[recoveryCodeSize/2:])
+		hashes[i] = hash
+	}
+
+	return codes, hashes, nil
+}
+
+func replaceRecoveryCodes(ctx context.Context, repo interfaces.Repository, clientName string, hashes [][]byte) error {
+	if err := repo.DeleteRecoveryCodes(ctx, clientName); err != nil {
+		return err
+	}
+
+	return repo.AddRecoveryCodes(ctx, clientName, hashes)
+}
+
+// normalizeRecoveryCode drops the dash and spaces people type along with the code
+func normalizeRecoveryCode(code string) string {
+	return strings.ToLower(strings.NewReplacer("-", "", " ", "").Replace(code))
+}
+
+func isRecoveryCode(code string) bool {
+	return len(normalizeRecoveryCode(code)) == recoveryCodeSize
+}
+
+// checkRecoveryCode accepts an unused recovery code of the client once, failures count against the lockout policy
+func (s *Ssosage) checkRecoveryCode(ctx context.Context, log *slog.Logger, client models.Client, code string, loginKeys []loginKey) error {
+	const op = "services.ssosage.checkRecoveryCode"
+
+	codes, err := s.recoveryCodes.RecoveryCodes(ctx, client.Name)
+
+	if err != nil {
+		log.Error("failed to get recovery codes", helpers.SlErr(err))
+
+		return helpers.WrapErr(op, err)
+	}
+
+	code = normalizeRecoveryCode(code)
+
+	for _, stored := range codes {
+		ok, err := s.hasher.Compare(stored.Hash, code)
+
+		if err != nil {
+			log.Error("failed to compare recovery code", helpers.SlErr(err))
+
+			return helpers.WrapErr(op, err)
+		}
+
+		if !ok {
+			continue
+		}
+
+		if err := s.recoveryCodes.UseRecoveryCode(ctx, client.Name, stored.ID); err != nil {
+			if errors.Is(err, storage.ErrRecoveryCodeUsed) {
+				break
+			}
+
+			log.Error("failed to use recovery code", helpers.SlErr(err))
+
+			return helpers.WrapErr(op, err)
+		}
+
+		log.Warn("recovery code used", "left", len(codes)-1)
+
+		return nil
+	}
+
+	log.Info("invalid recovery code")
+	s.registerFailedLogin(ctx, loginKeys, time.Now())
+
+	return helpers.WrapErr(op, ErrInvalidRecoveryCode)
+}
@@ -0,0 +1,201 @@
+package ssosage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"ssosage/internal/helpers"
+	"ssosage/internal/models"
+	"ssosage/internal/storage"
+	"strings"
+	"time"
+)
+
+var (
+	ErrPasswordResetUnavailable = errors.New("password reset is not configured")
+	ErrInvalidResetToken        = errors.New("invalid or expired password reset token")
+)
+
+/*
+PasswordResetPolicy configures resets of forgotten passwords, they are unavailable without
+a notifier. TokenTTL is how long a reset token is valid. Link is sent instead of the bare
+token when set, with {token} replaced, like https://example.com/reset?token={token}.
+Resets go to verified emails only, NameFallback sends them to the client name of clients
+without one, for deployments whose client names are addresses the notifier delivers to.
+*/
+type PasswordResetPolicy struct {
+	TokenTTL     time.Duration
+	Link         string
+	NameFallback bool
+}
+
+/*
+RequestPasswordReset sends a single-use reset token to a client through the notifier.
+Unknown and disabled clients, clients with nowhere to send the token to and failed deliveries
+get no error, so the call doesn't tell which clients exist.
+*/
+func (s *Ssosage) RequestPasswordReset(ctx context.Context, clientName string) error {
+	const op = "services.ssosage.RequestPasswordReset"
+
+	log := s.logWith(op, clientName)
+	log.Info("requesting password reset")
+
+	if s.notifier == nil {
+		return helpers.WrapErr(op, ErrPasswordResetUnavailable)
+	}
+
+	client, err := s.clientProvider.Client(ctx, clientName)
+
+	if err != nil {
+		if errors.Is(err, storage.ErrClientNotFound) {
+			log.Info("password reset for an unknown client")
+
+			return nil
+		}
+
+		log.Error("failed to get client", helpers.SlErr(err))
+
+		return helpers.WrapErr(op, err)
+	}
+
+	if client.Status == models.ClientDisabled {
+		log.Info("password reset for a disabled client")
+
+		return nil
+	}
+
+	if !client.EmailVerified && !s.reset.NameFallback {
+		log.Info("password reset for a client without a verified email")
+
+		return nil
+	}
+
+	token, err := newChallenge()
+
+	if err != nil {
+		log.Error("failed to generate reset token", helpers.SlErr(err))
+
+		return helpers.WrapErr(op, err)
+	}
+
+	reset := models.PasswordReset{
+		ID:         challengeID(token),
+		ClientName: clientName,
+		ExpiresAt:  time.Now().Add(s.reset.TokenTTL),
+	}
+
+	if err := s.resets.SavePasswordReset(ctx, reset); err != nil {
+		log.Error("failed to save password reset", helpers.SlErr(err))
+
+		return helpers.WrapErr(op, err)
+	}
+
+	// the token expires unused, an error would only be returned for clients that exist
+	if err := s.notifier.Notify(ctx, s.resetNotification(client, token, reset.ExpiresAt)); err != nil {
+		log.Error("failed to send password reset", helpers.SlErr(err))
+	}
+
+	return nil
+}
+
+// ConfirmPasswordReset sets a new password with a reset token and revokes the tokens of the client
+func (s *Ssosage) ConfirmPasswordReset(ctx context.Context, token string, newPassword string) error {
+	const op = "services.ssosage.ConfirmPasswordReset"
+
+	id := challengeID(token)
+
+	reset, err := s.resets.PasswordReset(ctx, id)
+
+	if err != nil {
+		if errors.Is(err, storage.ErrPasswordResetNotFound) {
+			return helpers.WrapErr(op, ErrInvalidResetToken)
+		}
+
+		s.log.Error("failed to get password reset", slog.String("op", op), helpers.SlErr(err))
+
+		return helpers.WrapErr(op, err)
+	}
+
+	log := s.logWith(op, reset.ClientName)
+	log.Info("confirming password reset")
+
+	if !time.Now().Before(reset.ExpiresAt) {
+		log.Info("password reset expired")
+
+		return helpers.WrapErr(op, ErrInvalidResetToken)
+	}
+
+	// claimed by deleting, a token works once
+	if err := s.resets.DeletePasswordReset(ctx, id); err != nil {
+		if errors.Is(err, storage.ErrPasswordResetNotFound) {
+			return helpers.WrapErr(op, ErrInvalidResetToken)
+		}
+
+		log.Error("failed to claim password reset", helpers.SlErr(err))
+
+		return helpers.WrapErr(op, err)
+	}
+
+	if err := s.setPassword(ctx, reset.ClientName, newPassword); err != nil {
+		if errors.Is(err, storage.ErrClientNotFound) {
+			return helpers.WrapErr(op, ErrInvalidResetToken)
+		}
+
+		log.Error("failed to reset password", helpers.SlErr(err))
+
+		return helpers.WrapErr(op, err)
+	}
+
+	// other tokens sent before are void too
+	if err := s.resets.DeletePasswordResets(ctx, reset.ClientName); err != nil {
+		log.Error("failed to delete password resets", helpers.SlErr(err))
+	}
+
+	s.resetClientLogin(ctx, reset.ClientName)
+
+	return nil
+}
+
+// PurgeExpiredPasswordResets deletes reset tokens nobody used in time
+func (s *Ssosage) PurgeExpiredPasswordResets(ctx context.Context) (int64, error) {
+	const op = "services.ssosage.PurgeExpiredPasswordResets"
+
+	n, err := s.resets.DeleteExpiredPasswordResets(ctx, time.Now())
+
+	if err != nil {
+		s.log.Error("failed to purge expired password resets", slog.String("op", op), helpers.SlErr(err))
+
+		return 0, helpers.WrapErr(op, err)
+	}
+
+	if n > 0 {
+		s.log.Debug("purged expired password resets", slog.String("op", op), slog.Int64("count", n))
+	}
+
+	return n, nil
+}
+
+// resetNotification goes to the verified email of the client, or else to its name with NameFallback.
+// An unverified email may belong to anybody
+func (s *Ssosage) resetNotification(client models.Client, token string, expiresAt time.Time) models.Notification {
+	to := client.Name
+
+	if client.EmailVerified {
+		to = client.Email
+	}
+
+	secret := token
+
+	if s.reset.Link != "" {
+		secret = strings.ReplaceAll(s.reset.Link, "{token}", token)
+	}
+
+	return models.Notification{
+		ClientName: client.Name,
+		To:         to,
+		Subject:    "Password reset",
+		Body: fmt.Sprintf("Somebody asked to reset the password of %s. If it was you, use\n\n%s\n\nbefore %s. Otherwise ignore this message.",
+			client.Name, secret, expiresAt.UTC().Format(time.RFC1123)),
+	}
+}
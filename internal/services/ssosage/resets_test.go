@@ -0,0 +1,217 @@
+package ssosage
+
+import (
+	"context"
+	"errors"
+	"ssosage/internal/models"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+type recordingNotifier struct {
+	mu            sync.Mutex
+	notifications []models.Notification
+}
+
+func (n *recordingNotifier) Notify(ctx context.Context, notification models.Notification) error {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	n.notifications = append(n.notifications, notification)
+
+	return nil
+}
+
+func (n *recordingNotifier) sent() []models.Notification {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	return append([]models.Notification(nil), n.notifications...)
+}
+
+// requestReset asks for a reset of the test client and returns the token it was sent
+func requestReset(t *testing.T, sso *Ssosage) string {
+	t.Helper()
+
+	notifier := sso.notifier.(*recordingNotifier)
+	before := len(notifier.sent())
+
+	if err := sso.RequestPasswordReset(context.Background(), testClient); err != nil {
+		t.Fatalf("failed to request password reset: %v", err)
+	}
+
+	sent := notifier.sent()
+
+	if len(sent) != before+1 || sent[len(sent)-1].To != testClient {
+		t.Fatalf("expected a notification to the client, got %+v", sent)
+	}
+
+	return notificationToken(t, sent[len(sent)-1].Body)
+}
+
+// notificationToken returns the token in a notification body, the line of its own
+func notificationToken(t *testing.T, body string) string {
+	t.Helper()
+
+	for _, line := range strings.Split(body, "\n") {
+		if line != "" && !strings.Contains(line, " ") {
+			return line
+		}
+	}
+
+	t.Fatalf("no token in %q", body)
+
+	return ""
+}
+
+func TestPasswordReset(t *testing.T) {
+	sso := newTestSsosage(t, LockoutPolicy{})
+	ctx := context.Background()
+
+	token, err := sso.GenerateToken(ctx, testClient, testPassword, "", testApp, "user", "")
+
+	if err != nil {
+		t.Fatalf("failed to generate token: %v", err)
+	}
+
+	first := requestReset(t, sso)
+	second := requestReset(t, sso)
+
+	if first == second {
+		t.Fatalf("expected a new token for every request")
+	}
+
+	if err := sso.ConfirmPasswordReset(ctx, "made up", "new password"); !errors.Is(err, ErrInvalidResetToken) {
+		t.Fatalf("expected ErrInvalidResetToken, got: %v", err)
+	}
+
+	if err := sso.ConfirmPasswordReset(ctx, first, "new password"); err != nil {
+		t.Fatalf("failed to reset password: %v", err)
+	}
+
+	// the tokens issued before are revoked
+	if _, err := sso.VerifyToken(ctx, token); !errors.Is(err, ErrInvalidToken) {
+		t.Fatalf("expected ErrInvalidToken, got: %v", err)
+	}
+
+	if _, err := sso.GenerateToken(ctx, testClient, "new password", "", testApp, "user", ""); err != nil {
+		t.Fatalf("failed to generate token with the new password: %v", err)
+	}
+
+	// reset tokens work once and the others sent before are void
+	for _, used := range []string{first, second} {
+		if err := sso.ConfirmPasswordReset(ctx, used, "another password"); !errors.Is(err, ErrInvalidResetToken) {
+			t.Fatalf("expected ErrInvalidResetToken, got: %v", err)
+		}
+	}
+}
+
+func TestPasswordResetUnknownClient(t *testing.T) {
+	sso := newTestSsosage(t, LockoutPolicy{})
+	ctx := context.Background()
+
+	if err := sso.RequestPasswordReset(ctx, "nobody"); err != nil {
+		t.Fatalf("expected no error for an unknown client, got: %v", err)
+	}
+
+	if err := sso.DisableClient(ctx, testClient); err != nil {
+		t.Fatalf("failed to disable client: %v", err)
+	}
+
+	if err := sso.RequestPasswordReset(ctx, testClient); err != nil {
+		t.Fatalf("expected no error for a disabled client, got: %v", err)
+	}
+
+	if sent := sso.notifier.(*recordingNotifier).sent(); len(sent) != 0 {
+		t.Fatalf("expected no notifications, got %+v", sent)
+	}
+}
+
+func TestPasswordResetNeedsVerifiedEmail(t *testing.T) {
+	sso := newTestSsosage(t, LockoutPolicy{})
+	sso.reset.NameFallback = false
+
+	ctx := context.Background()
+	notifier := sso.notifier.(*recordingNotifier)
+
+	if err := sso.RequestPasswordReset(ctx, testClient); err != nil {
+		t.Fatalf("expected no error for a client without a verified email, got: %v", err)
+	}
+
+	if sent := notifier.sent(); len(sent) != 0 {
+		t.Fatalf("expected no notifications, got %+v", sent)
+	}
+
+	if err := sso.VerifyEmail(ctx, setEmail(t, sso, "client@example.com")); err != nil {
+		t.Fatalf("failed to verify email: %v", err)
+	}
+
+	if err := sso.RequestPasswordReset(ctx, testClient); err != nil {
+		t.Fatalf("failed to request password reset: %v", err)
+	}
+
+	if sent := notifier.sent(); sent[len(sent)-1].To != "client@example.com" || sent[len(sent)-1].Subject != "Password reset" {
+		t.Fatalf("expected the reset to go to the email, got %+v", sent[len(sent)-1])
+	}
+}
+
+type failingNotifier struct{}
+
+func (failingNotifier) Notify(ctx context.Context, notification models.Notification) error {
+	return errors.New("mail server is down")
+}
+
+func TestPasswordResetDeliveryFailure(t *testing.T) {
+	sso := newTestSsosage(t, LockoutPolicy{})
+	sso.notifier = failingNotifier{}
+
+	// a known client gets the same answer as an unknown one
+	for _, client := range []string{testClient, "nobody"} {
+		if err := sso.RequestPasswordReset(context.Background(), client); err != nil {
+			t.Fatalf("%s: expected no error, got: %v", client, err)
+		}
+	}
+}
+
+func TestPasswordResetExpires(t *testing.T) {
+	sso := newTestSsosage(t, LockoutPolicy{})
+	sso.reset.TokenTTL = -time.Second
+
+	ctx := context.Background()
+	token := requestReset(t, sso)
+
+	if err := sso.ConfirmPasswordReset(ctx, token, "new password"); !errors.Is(err, ErrInvalidResetToken) {
+		t.Fatalf("expected ErrInvalidResetToken, got: %v", err)
+	}
+
+	if n, err := sso.PurgeExpiredPasswordResets(ctx); err != nil || n != 1 {
+		t.Fatalf("expected the expired reset to be purged, got %d, %v", n, err)
+	}
+}
+
+func TestPasswordResetLink(t *testing.T) {
+	sso := newTestSsosage(t, LockoutPolicy{})
+	sso.reset.Link = "https://example.com/reset?token={token}"
+
+	link := requestReset(t, sso)
+	token, ok := strings.CutPrefix(link, "https://example.com/reset?token=")
+
+	if !ok {
+		t.Fatalf("expected a link, got %q", link)
+	}
+
+	if err := sso.ConfirmPasswordReset(context.Background(), token, "new password"); err != nil {
+		t.Fatalf("failed to reset password: %v", err)
+	}
+}
+
+func TestPasswordResetUnavailable(t *testing.T) {
+	sso := newTestSsosage(t, LockoutPolicy{})
+	sso.notifier = nil
+
+	if err := sso.RequestPasswordReset(context.Background(), testClient); !errors.Is(err, ErrPasswordResetUnavailable) {
+		t.Fatalf("expected ErrPasswordResetUnavailable, got: %v", err)
+	}
+}
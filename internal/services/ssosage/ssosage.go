@@ -19,37 +19,139 @@ var (
 	ErrInvalidCredentials = errors.New("invalid credentials")
 	ErrInvalidApp         = errors.New("invalid app")
 	ErrInvalidRole        = errors.New("invalid role")
+	ErrInvalidToken       = errors.New("invalid token")
 )
 
+const tokenDuration = 5 * time.Hour
+
 type Ssosage struct {
-	log            *slog.Logger
-	clientSaver    interfaces.ClientSaver
-	clientProvider interfaces.ClientProvider
-	appSaver       interfaces.AppSaver
-	appProvider    interfaces.AppProvider
-	hasher         interfaces.PasswordHasher
+	log               *slog.Logger
+	clientSaver       interfaces.ClientSaver
+	clientProvider    interfaces.ClientProvider
+	clientUpdater     interfaces.ClientUpdater
+	clientLister      interfaces.ClientLister
+	organizations     interfaces.OrganizationStore
+	groups            interfaces.GroupStore
+	totpManager       interfaces.TOTPManager
+	emails            interfaces.EmailManager
+	profiles          interfaces.ProfileStore
+	recoveryCodes     interfaces.RecoveryCodeManager
+	passkeys          interfaces.WebAuthnCredentialStore
+	loginTransactions interfaces.LoginTransactionStore
+	resets            interfaces.PasswordResetStore
+	appSaver          interfaces.AppSaver
+	appProvider       interfaces.AppProvider
+	appUpdater        interfaces.AppUpdater
+	appLister         interfaces.AppLister
+	hasher            interfaces.PasswordHasher
+	sealer            interfaces.SecretSealer
+	notifier          interfaces.Notifier
+	loginAttempts     interfaces.LoginAttemptsTracker
+	transactor        interfaces.Transactor
+	lockout           LockoutPolicy
+	mfa               MFAPolicy
+	reset             PasswordResetPolicy
+	email             EmailPolicy
+}
+
+/*
+Deps are the collaborators of Ssosage. A storage backend implements every store, so the
+fields usually hold the same value; they are separate to keep each part of the service
+on the narrow interface it needs. Sealer and Notifier may be nil, which turns totp and
+the features sending notifications off.
+*/
+type Deps struct {
+	ClientSaver       interfaces.ClientSaver
+	ClientProvider    interfaces.ClientProvider
+	ClientUpdater     interfaces.ClientUpdater
+	ClientLister      interfaces.ClientLister
+	Organizations     interfaces.OrganizationStore
+	Groups            interfaces.GroupStore
+	TOTPManager       interfaces.TOTPManager
+	Emails            interfaces.EmailManager
+	Profiles          interfaces.ProfileStore
+	RecoveryCodes     interfaces.RecoveryCodeManager
+	Passkeys          interfaces.WebAuthnCredentialStore
+	LoginTransactions interfaces.LoginTransactionStore
+	Resets            interfaces.PasswordResetStore
+	AppSaver          interfaces.AppSaver
+	AppProvider       interfaces.AppProvider
+	AppUpdater        interfaces.AppUpdater
+	AppLister         interfaces.AppLister
+	Hasher            interfaces.PasswordHasher
+	Sealer            interfaces.SecretSealer
+	Notifier          interfaces.Notifier
+	LoginAttempts     interfaces.LoginAttemptsTracker
+	Transactor        interfaces.Transactor
+}
+
+// StorageDeps fills the stores of Deps with one storage backend
+func StorageDeps(s interfaces.Repository) Deps {
+	return Deps{
+		ClientSaver:       s,
+		ClientProvider:    s,
+		ClientUpdater:     s,
+		ClientLister:      s,
+		Organizations:     s,
+		Groups:            s,
+		TOTPManager:       s,
+		Emails:            s,
+		Profiles:          s,
+		RecoveryCodes:     s,
+		Passkeys:          s,
+		LoginTransactions: s,
+		Resets:            s,
+		AppSaver:          s,
+		AppProvider:       s,
+		AppUpdater:        s,
+		AppLister:         s,
+		LoginAttempts:     s,
+		Transactor:        s,
+	}
+}
+
+// Policies configure the features of Ssosage, their zero values leave them at their most restrictive or off
+type Policies struct {
+	Lockout       LockoutPolicy
+	MFA           MFAPolicy
+	PasswordReset PasswordResetPolicy
+	Email         EmailPolicy
 }
 
-func New(
-	log *slog.Logger,
-	clientSaver interfaces.ClientSaver,
-	clientProvider interfaces.ClientProvider,
-	appSaver interfaces.AppSaver,
-	appProvider interfaces.AppProvider,
-	hasher interfaces.PasswordHasher,
-) *Ssosage {
+func New(log *slog.Logger, deps Deps, policies Policies) *Ssosage {
 
 	if log == nil {
 		panic("can't create Ssosage structure - *logger is nil ")
 	}
 
 	return &Ssosage{
-		log:            log,
-		clientSaver:    clientSaver,
-		clientProvider: clientProvider,
-		appSaver:       appSaver,
-		appProvider:    appProvider,
-		hasher:         hasher,
+		log:               log,
+		clientSaver:       deps.ClientSaver,
+		clientProvider:    deps.ClientProvider,
+		clientUpdater:     deps.ClientUpdater,
+		clientLister:      deps.ClientLister,
+		organizations:     deps.Organizations,
+		groups:            deps.Groups,
+		totpManager:       deps.TOTPManager,
+		emails:            deps.Emails,
+		profiles:          deps.Profiles,
+		recoveryCodes:     deps.RecoveryCodes,
+		passkeys:          deps.Passkeys,
+		loginTransactions: deps.LoginTransactions,
+		resets:            deps.Resets,
+		appSaver:          deps.AppSaver,
+		appProvider:       deps.AppProvider,
+		appUpdater:        deps.AppUpdater,
+		appLister:         deps.AppLister,
+		hasher:            deps.Hasher,
+		sealer:            deps.Sealer,
+		notifier:          deps.Notifier,
+		loginAttempts:     deps.LoginAttempts,
+		transactor:        deps.Transactor,
+		lockout:           policies.Lockout,
+		mfa:               policies.MFA,
+		reset:             policies.PasswordReset,
+		email:             policies.Email,
 	}
 
 }
@@ -61,6 +163,10 @@ func (s *Ssosage) RegisterNewClient(ctx context.Context, name string, password s
 	log := s.logWith(op, name)
 	log.Info("registering client")
 
+	if !nameIsValid(name) {
+		return 0, helpers.WrapErr(op, ErrInvalidName)
+	}
+
 	passwordHash, err := s.hasher.Hash(password)
 
 	if err != nil {
@@ -89,6 +195,10 @@ func (s *Ssosage) RegisterNewApp(ctx context.Context, name string, secret string
 	log := s.logWith(op, name)
 	log.Info("registering app")
 
+	if !nameIsValid(name) {
+		return 0, helpers.WrapErr(op, ErrInvalidName)
+	}
+
 	id, err := s.appSaver.SaveApp(ctx, name, secret, roles)
 
 	if err != nil {
@@ -102,7 +212,9 @@ func (s *Ssosage) RegisterNewApp(ctx context.Context, name string, secret string
 
 }
 
-func (s *Ssosage) GenerateToken(ctx context.Context, clientName string, password string, appName string, role string) (string, error) {
+// GenerateToken needs a totp or recovery code from clients with totp enabled, apps may refuse clients without it
+// or without a verified email. Clients whose only second factor is a passkey use the multi-step login instead
+func (s *Ssosage) GenerateToken(ctx context.Context, clientName string, password string, totpCode string, appName string, role string, sourceIP string) (string, error) {
 
 	const op = "services.ssosage.GenerateToken"
 
@@ -110,56 +222,126 @@ func (s *Ssosage) GenerateToken(ctx context.Context, clientName string, password
 
 	log.Info("logging in")
 
-	client, err := s.clientProvider.Client(ctx, clientName)
+	client, err := s.authenticate(ctx, log, clientName, password, totpCode, sourceIP)
 
 	if err != nil {
-		if errors.Is(err, storage.ErrClientNotFound) {
-			log.Warn("client not found", helpers.SlErr(err))
+		return "", helpers.WrapErr(op, err)
+	}
 
-			return "", helpers.WrapErr(op, ErrInvalidCredentials)
+	app, err := s.appProvider.App(ctx, appName)
+
+	if err != nil {
+		if errors.Is(err, storage.ErrAppNotFound) {
+			log.Warn("app not found", helpers.SlErr(err))
+
+			return "", helpers.WrapErr(op, ErrInvalidApp)
 		}
 
-		log.Error("failed to get client", helpers.SlErr(err))
+		return "", helpers.WrapErr(op, err)
+	}
 
+	if err := s.checkAppAccess(log, client, app); err != nil {
 		return "", helpers.WrapErr(op, err)
 	}
 
-	ok, err := s.hasher.Compare(client.PasswordHash, password)
+	if app.RequireMFA && !client.TOTPEnabled {
+		log.Warn("app requires two-factor authentication", "app", appName)
+
+		return "", helpers.WrapErr(op, ErrMFARequired)
+	}
+
+	token, err := s.newToken(ctx, client, app, role, tokenDuration)
 
 	if err != nil {
-		log.Error("failed to campare hash", helpers.SlErr(err))
+		log.Info("failed to generate token", helpers.SlErr(err))
 
 		return "", helpers.WrapErr(op, err)
 	}
 
-	if !ok {
-		log.Info("invalid credentials", helpers.SlErr(err))
+	return token, nil
+
+}
+
+// authenticate checks the password and the totp or recovery code, if the client has totp enabled, of an active client,
+// counting failures against the lockout policy. Clients with a passkey as their only second factor are refused
+func (s *Ssosage) authenticate(ctx context.Context, log *slog.Logger, clientName string, password string, totpCode string, sourceIP string) (models.Client, error) {
+
+	const op = "services.ssosage.authenticate"
 
-		return "", helpers.WrapErr(op, ErrInvalidCredentials)
+	now := time.Now()
+	loginKeys := s.loginKeys(clientName, sourceIP)
+
+	if err := s.checkLoginLocked(ctx, loginKeys, now); err != nil {
+		return models.Client{}, helpers.WrapErr(op, err)
 	}
 
-	app, err := s.appProvider.App(ctx, appName)
+	client, err := s.checkPassword(ctx, log, clientName, password, loginKeys, now)
 
 	if err != nil {
-		if errors.Is(err, storage.ErrAppNotFound) {
-			log.Warn("app not found", helpers.SlErr(err))
+		return models.Client{}, helpers.WrapErr(op, err)
+	}
 
-			return "", helpers.WrapErr(op, ErrInvalidApp)
+	if client.TOTPEnabled {
+		if err := s.checkSecondFactor(ctx, log, client, totpCode, loginKeys); err != nil {
+			return models.Client{}, helpers.WrapErr(op, err)
 		}
+	}
 
-		return "", helpers.WrapErr(op, err)
+	s.resetClientLogin(ctx, clientName)
+
+	// only reported to somebody who knows the password
+	if client.Status == models.ClientDisabled {
+		log.Warn("client is disabled")
+
+		return models.Client{}, helpers.WrapErr(op, ErrClientDisabled)
 	}
 
-	token, err := s.newToken(client, app, role, 5*time.Hour)
+	// a passkey can't be checked in a single call, such clients only get in through the multi-step login
+	if !client.TOTPEnabled && client.WebAuthnEnabled {
+		log.Info("client has only a passkey as second factor")
+
+		return models.Client{}, helpers.WrapErr(op, ErrPasskeyRequired)
+	}
+
+	return client, nil
+}
+
+// checkPassword returns the client if the password matches, failures count against the lockout policy
+func (s *Ssosage) checkPassword(ctx context.Context, log *slog.Logger, clientName string, password string, loginKeys []loginKey, now time.Time) (models.Client, error) {
+
+	const op = "services.ssosage.checkPassword"
+
+	client, err := s.clientProvider.Client(ctx, clientName)
 
 	if err != nil {
-		log.Info("failed to generate token", helpers.SlErr(err))
+		if errors.Is(err, storage.ErrClientNotFound) {
+			log.Warn("client not found", helpers.SlErr(err))
+			s.registerFailedLogin(ctx, loginKeys, now)
 
-		return "", helpers.WrapErr(op, err)
+			return models.Client{}, helpers.WrapErr(op, ErrInvalidCredentials)
+		}
+
+		log.Error("failed to get client", helpers.SlErr(err))
+
+		return models.Client{}, helpers.WrapErr(op, err)
 	}
 
-	return token, nil
+	ok, err := s.hasher.Compare(client.PasswordHash, password)
 
+	if err != nil {
+		log.Error("failed to campare hash", helpers.SlErr(err))
+
+		return models.Client{}, helpers.WrapErr(op, err)
+	}
+
+	if !ok {
+		log.Info("invalid credentials")
+		s.registerFailedLogin(ctx, loginKeys, now)
+
+		return models.Client{}, helpers.WrapErr(op, ErrInvalidCredentials)
+	}
+
+	return client, nil
 }
 
 func (s *Ssosage) logWith(op string, name string) *slog.Logger {
@@ -169,7 +351,8 @@ func (s *Ssosage) logWith(op string, name string) *slog.Logger {
 	)
 }
 
-func (s *Ssosage) newToken(client models.Client, app models.App, role string, duration time.Duration) (string, error) {
+// newToken signs a token for the app, with the profile attributes, granted roles and groups the app asks for
+func (s *Ssosage) newToken(ctx context.Context, client models.Client, app models.App, role string, duration time.Duration) (string, error) {
 
 	const op = "services.ssosage.newToken"
 
@@ -177,9 +360,7 @@ func (s *Ssosage) newToken(client models.Client, app models.App, role string, du
 
 	token := jwt.New(jwt.SigningMethodHS256)
 
-	app_roles := strings.Split(app.Roles, ",")
-
-	if ok := slices.ContainsFunc[[]string, string](app_roles, func(r string) bool { return r == role }); !ok {
+	if !hasRole(app, role) {
 		log.Warn("client wants a role that is absent in that app", "role", role, "app", app.Name)
 
 		return "", helpers.WrapErr(op, ErrInvalidRole)
@@ -189,10 +370,61 @@ func (s *Ssosage) newToken(client models.Client, app models.App, role string, du
 
 	claims["client_id"] = client.ID
 	claims["client_name"] = client.Name
+	claims["org_id"] = client.OrgID
+	claims["org"] = clientOrg(client)
 	claims["app_name"] = app.Name
+	claims["app_id"] = app.ID
 	claims["role"] = role
+	claims["email_verified"] = client.EmailVerified
+	claims["ver"] = client.TokenVersion
 	claims["exp"] = time.Now().Add(duration).Unix()
 
+	if app.RoleGrants {
+		roles, err := s.grantedRoles(ctx, client.Name, app)
+
+		if err != nil {
+			log.Error("failed to get roles", helpers.SlErr(err))
+
+			return "", helpers.WrapErr(op, err)
+		}
+
+		if !slices.Contains(roles, role) {
+			log.Warn("role is not granted to the client", "role", role, "app", app.Name)
+
+			return "", helpers.WrapErr(op, ErrRoleNotGranted)
+		}
+
+		claims["roles"] = roles
+	}
+
+	if app.GroupsClaim {
+		groups, err := s.groups.ClientGroups(ctx, client.Name)
+
+		if err != nil {
+			log.Error("failed to get groups", helpers.SlErr(err))
+
+			return "", helpers.WrapErr(op, err)
+		}
+
+		claims["groups"] = groups
+	}
+
+	if app.ProfileClaims != "" {
+		profile, err := s.profiles.Profile(ctx, client.Name)
+
+		if err != nil {
+			log.Error("failed to get profile", helpers.SlErr(err))
+
+			return "", helpers.WrapErr(op, err)
+		}
+
+		if err := projectProfile(claims, profile, app.ProfileClaims); err != nil {
+			log.Error("failed to project profile", helpers.SlErr(err))
+
+			return "", helpers.WrapErr(op, err)
+		}
+	}
+
 	tokenString, err := token.SignedString([]byte(app.Secret))
 
 	if err != nil {
@@ -204,3 +436,137 @@ func (s *Ssosage) newToken(client models.Client, app models.App, role string, du
 
 	return tokenString, nil
 }
+
+// VerifyToken checks signature and expiration of a token issued by GenerateToken,
+// that its client still exists, is active and had no tokens revoked since the token was issued,
+// and that its app was not deleted and still has the role
+func (s *Ssosage) VerifyToken(ctx context.Context, tokenString string) (models.Token, error) {
+
+	const op = "services.ssosage.VerifyToken"
+
+	var app models.App
+	var lookupErr error
+
+	parsed, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
+		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
+			return nil, ErrInvalidToken
+		}
+
+		appName, _ := token.Claims.(jwt.MapClaims)["app_name"].(string)
+
+		var err error
+
+		app, err = s.appProvider.App(ctx, appName)
+
+		if err != nil {
+			lookupErr = err
+
+			return nil, err
+		}
+
+		return []byte(app.Secret), nil
+	})
+
+	if err != nil {
+		if lookupErr != nil && !errors.Is(lookupErr, storage.ErrAppNotFound) {
+			s.log.Error("failed to get app", slog.String("op", op), helpers.SlErr(lookupErr))
+
+			return models.Token{}, helpers.WrapErr(op, lookupErr)
+		}
+
+		return models.Token{}, helpers.WrapErr(op, ErrInvalidToken)
+	}
+
+	claims := parsed.Claims.(jwt.MapClaims)
+
+	if !claims.VerifyExpiresAt(time.Now().Unix(), true) {
+		return models.Token{}, helpers.WrapErr(op, ErrInvalidToken)
+	}
+
+	clientID, _ := claims["client_id"].(float64)
+	clientName, _ := claims["client_name"].(string)
+	orgID, _ := claims["org_id"].(float64)
+	org, _ := claims["org"].(string)
+	appID, _ := claims["app_id"].(float64)
+	appName, _ := claims["app_name"].(string)
+	role, _ := claims["role"].(string)
+	emailVerified, _ := claims["email_verified"].(bool)
+	version, _ := claims["ver"].(float64)
+	exp, _ := claims["exp"].(float64)
+
+	// a deleted app may have been registered again, and removed roles take their tokens with them
+	if app.ID != uint64(appID) || !hasRole(app, role) {
+		return models.Token{}, helpers.WrapErr(op, ErrInvalidToken)
+	}
+
+	client, err := s.clientProvider.Client(ctx, clientName)
+
+	if err != nil {
+		if errors.Is(err, storage.ErrClientNotFound) {
+			return models.Token{}, helpers.WrapErr(op, ErrInvalidToken)
+		}
+
+		s.log.Error("failed to get client", slog.String("op", op), helpers.SlErr(err))
+
+		return models.Token{}, helpers.WrapErr(op, err)
+	}
+
+	// a deleted client name may have been registered again
+	if client.ID != uint64(clientID) || client.Status != models.ClientActive || int64(version) != client.TokenVersion {
+		return models.Token{}, helpers.WrapErr(op, ErrInvalidToken)
+	}
+
+	var roles []string
+
+	// revoked grants take their tokens with them, the roles are the ones granted now
+	if app.RoleGrants {
+		roles, err = s.grantedRoles(ctx, clientName, app)
+
+		if err != nil {
+			s.log.Error("failed to get roles", slog.String("op", op), helpers.SlErr(err))
+
+			return models.Token{}, helpers.WrapErr(op, err)
+		}
+
+		if !slices.Contains(roles, role) {
+			return models.Token{}, helpers.WrapErr(op, ErrInvalidToken)
+		}
+	}
+
+	return models.Token{
+		ClientID:      uint64(clientID),
+		ClientName:    clientName,
+		OrgID:         uint64(orgID),
+		Org:           org,
+		AppName:       appName,
+		Role:          role,
+		EmailVerified: emailVerified,
+		Roles:         roles,
+		Groups:        claimStrings(claims["groups"]),
+		Profile:       tokenProfile(claims),
+		ExpiresAt:     time.Unix(int64(exp), 0),
+	}, nil
+}
+
+// claimStrings reads a list of strings out of a claim, nil if the claim is absent
+func claimStrings(claim any) []string {
+	values, ok := claim.([]any)
+
+	if !ok {
+		return nil
+	}
+
+	strs := make([]string, 0, len(values))
+
+	for _, value := range values {
+		if str, ok := value.(string); ok {
+			strs = append(strs, str)
+		}
+	}
+
+	return strs
+}
+
+func hasRole(app models.App, role string) bool {
+	return slices.Contains(strings.Split(app.Roles, ","), role)
+}
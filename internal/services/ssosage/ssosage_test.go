@@ -0,0 +1,583 @@
+package ssosage
+
+import (
+	"bytes"
+	"context"
+	"encoding/base32"
+	"errors"
+	"io"
+	"log/slog"
+	"ssosage/internal/models"
+	"ssosage/internal/secretbox"
+	"ssosage/internal/storage"
+	"ssosage/internal/storage/memory"
+	"ssosage/internal/totp"
+	"ssosage/internal/webauthn"
+	"strings"
+	"testing"
+	"time"
+)
+
+// plainHasher keeps the tests fast, real hashers are slow on purpose
+type plainHasher struct{}
+
+func (plainHasher) Hash(password string) ([]byte, error) {
+	return []byte("plain:" + password), nil
+}
+
+func (plainHasher) Compare(hash []byte, password string) (bool, error) {
+	return bytes.Equal(hash, []byte("plain:"+password)), nil
+}
+
+const (
+	testClient   = "client"
+	testPassword = "password"
+	testApp      = "app"
+	testSecret   = "secret"
+)
+
+var testRP = webauthn.RelyingParty{ID: "example.com", Name: "Example", Origins: []string{"https://example.com"}}
+
+func newTestSsosage(t *testing.T, lockout LockoutPolicy) *Ssosage {
+	t.Helper()
+
+	s := memory.New()
+	log := slog.New(slog.NewTextHandler(io.Discard, nil))
+
+	sealer, err := secretbox.New(bytes.Repeat([]byte{1}, secretbox.KeySize))
+
+	if err != nil {
+		t.Fatalf("failed to create sealer: %v", err)
+	}
+
+	deps := StorageDeps(s)
+	deps.Hasher, deps.Sealer, deps.Notifier = plainHasher{}, sealer, &recordingNotifier{}
+
+	sso := New(log, deps, Policies{
+		Lockout:       lockout,
+		MFA:           MFAPolicy{Issuer: "ssosage", Skew: 1, LoginTTL: time.Minute, WebAuthn: testRP},
+		PasswordReset: PasswordResetPolicy{TokenTTL: time.Minute, NameFallback: true},
+		Email:         EmailPolicy{Key: bytes.Repeat([]byte{2}, 32), TokenTTL: time.Minute},
+	})
+
+	ctx := context.Background()
+
+	if _, err := sso.RegisterNewClient(ctx, testClient, testPassword); err != nil {
+		t.Fatalf("failed to register client: %v", err)
+	}
+
+	if _, err := sso.RegisterNewApp(ctx, testApp, testSecret, "user,admin"); err != nil {
+		t.Fatalf("failed to register app: %v", err)
+	}
+
+	return sso
+}
+
+func TestGenerateToken(t *testing.T) {
+	sso := newTestSsosage(t, LockoutPolicy{})
+	ctx := context.Background()
+
+	token, err := sso.GenerateToken(ctx, testClient, testPassword, "", testApp, "admin", "")
+
+	if err != nil {
+		t.Fatalf("failed to generate token: %v", err)
+	}
+
+	claims, err := sso.VerifyToken(ctx, token)
+
+	if err != nil {
+		t.Fatalf("failed to verify token: %v", err)
+	}
+
+	if claims.ClientName != testClient || claims.AppName != testApp || claims.Role != "admin" || claims.Roles != nil || !claims.ExpiresAt.After(time.Now()) {
+		t.Fatalf("unexpected claims: %+v", claims)
+	}
+}
+
+func TestGenerateTokenErrors(t *testing.T) {
+	sso := newTestSsosage(t, LockoutPolicy{})
+
+	tests := []struct {
+		name     string
+		client   string
+		password string
+		app      string
+		role     string
+		want     error
+	}{
+		{"wrong password", testClient, "wrong", testApp, "user", ErrInvalidCredentials},
+		{"unknown client", "nobody", testPassword, testApp, "user", ErrInvalidCredentials},
+		{"unknown app", testClient, testPassword, "nothing", "user", ErrInvalidApp},
+		{"unknown role", testClient, testPassword, testApp, "root", ErrInvalidRole},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := sso.GenerateToken(context.Background(), tt.client, tt.password, "", tt.app, tt.role, "")
+
+			if !errors.Is(err, tt.want) {
+				t.Fatalf("expected %v, got: %v", tt.want, err)
+			}
+		})
+	}
+}
+
+func TestRegisterExistingClient(t *testing.T) {
+	sso := newTestSsosage(t, LockoutPolicy{})
+
+	_, err := sso.RegisterNewClient(context.Background(), testClient, "other password")
+
+	if !errors.Is(err, storage.ErrClientExists) {
+		t.Fatalf("expected ErrClientExists, got: %v", err)
+	}
+}
+
+func TestVerifyForgedToken(t *testing.T) {
+	sso := newTestSsosage(t, LockoutPolicy{})
+	ctx := context.Background()
+
+	token, err := sso.GenerateToken(ctx, testClient, testPassword, "", testApp, "user", "")
+
+	if err != nil {
+		t.Fatalf("failed to generate token: %v", err)
+	}
+
+	if _, err := sso.VerifyToken(ctx, token+"x"); !errors.Is(err, ErrInvalidToken) {
+		t.Fatalf("expected ErrInvalidToken, got: %v", err)
+	}
+}
+
+func TestLockout(t *testing.T) {
+	sso := newTestSsosage(t, LockoutPolicy{
+		ClientMaxAttempts: 2,
+		IPMaxAttempts:     10,
+		LockoutDuration:   time.Hour,
+		Window:            time.Hour,
+	})
+	ctx := context.Background()
+
+	for i := 0; i < 2; i++ {
+		_, err := sso.GenerateToken(ctx, testClient, "wrong", "", testApp, "user", "10.0.0.1")
+
+		if !errors.Is(err, ErrInvalidCredentials) {
+			t.Fatalf("expected ErrInvalidCredentials, got: %v", err)
+		}
+	}
+
+	_, err := sso.GenerateToken(ctx, testClient, testPassword, "", testApp, "user", "10.0.0.2")
+
+	if !errors.Is(err, ErrLoginLocked) {
+		t.Fatalf("expected ErrLoginLocked, got: %v", err)
+	}
+
+	var locked *LockedError
+
+	if !errors.As(err, &locked) || time.Until(locked.Until) < 59*time.Minute {
+		t.Fatalf("expected the lock to end in an hour, got: %v", err)
+	}
+
+	if err := sso.UnlockClient(ctx, testClient); err != nil {
+		t.Fatalf("failed to unlock client: %v", err)
+	}
+
+	if _, err := sso.GenerateToken(ctx, testClient, testPassword, "", testApp, "user", "10.0.0.2"); err != nil {
+		t.Fatalf("failed to generate token after unlock: %v", err)
+	}
+}
+
+func TestLockFor(t *testing.T) {
+	p := LockoutPolicy{BaseDelay: time.Second, MaxDelay: 5 * time.Second, LockoutDuration: time.Hour}
+
+	tests := []struct {
+		failures int
+		backoff  bool
+		want     time.Duration
+	}{
+		{1, true, time.Second},
+		{2, true, 2 * time.Second},
+		{3, true, 4 * time.Second},
+		{4, true, 5 * time.Second},
+		{3, false, 0},
+		{10, false, time.Hour},
+	}
+
+	for _, tt := range tests {
+		if got := p.lockFor(tt.failures, 10, tt.backoff); got != tt.want {
+			t.Errorf("lockFor(%d, %v) = %v, want %v", tt.failures, tt.backoff, got, tt.want)
+		}
+	}
+}
+
+func TestChangePassword(t *testing.T) {
+	sso := newTestSsosage(t, LockoutPolicy{})
+	ctx := context.Background()
+
+	token, err := sso.GenerateToken(ctx, testClient, testPassword, "", testApp, "user", "")
+
+	if err != nil {
+		t.Fatalf("failed to generate token: %v", err)
+	}
+
+	if err := sso.ChangePassword(ctx, testClient, "wrong", "new password", "", ""); !errors.Is(err, ErrInvalidCredentials) {
+		t.Fatalf("expected ErrInvalidCredentials, got: %v", err)
+	}
+
+	if err := sso.ChangePassword(ctx, testClient, testPassword, "new password", "", ""); err != nil {
+		t.Fatalf("failed to change password: %v", err)
+	}
+
+	if _, err := sso.VerifyToken(ctx, token); !errors.Is(err, ErrInvalidToken) {
+		t.Fatalf("expected the old token to be revoked, got: %v", err)
+	}
+
+	if _, err := sso.GenerateToken(ctx, testClient, testPassword, "", testApp, "user", ""); !errors.Is(err, ErrInvalidCredentials) {
+		t.Fatalf("expected ErrInvalidCredentials for the old password, got: %v", err)
+	}
+
+	token, err = sso.GenerateToken(ctx, testClient, "new password", "", testApp, "user", "")
+
+	if err != nil {
+		t.Fatalf("failed to generate token with the new password: %v", err)
+	}
+
+	if _, err := sso.VerifyToken(ctx, token); err != nil {
+		t.Fatalf("failed to verify new token: %v", err)
+	}
+}
+
+func TestResetPassword(t *testing.T) {
+	sso := newTestSsosage(t, LockoutPolicy{})
+	ctx := context.Background()
+
+	if err := sso.ResetPassword(ctx, testClient, "new password"); err != nil {
+		t.Fatalf("failed to reset password: %v", err)
+	}
+
+	if _, err := sso.GenerateToken(ctx, testClient, "new password", "", testApp, "user", ""); err != nil {
+		t.Fatalf("failed to generate token with the new password: %v", err)
+	}
+
+	if err := sso.ResetPassword(ctx, "nobody", "new password"); !errors.Is(err, storage.ErrClientNotFound) {
+		t.Fatalf("expected ErrClientNotFound, got: %v", err)
+	}
+}
+
+func TestDisableClient(t *testing.T) {
+	sso := newTestSsosage(t, LockoutPolicy{})
+	ctx := context.Background()
+
+	token, err := sso.GenerateToken(ctx, testClient, testPassword, "", testApp, "user", "")
+
+	if err != nil {
+		t.Fatalf("failed to generate token: %v", err)
+	}
+
+	if err := sso.DisableClient(ctx, testClient); err != nil {
+		t.Fatalf("failed to disable client: %v", err)
+	}
+
+	if _, err := sso.GenerateToken(ctx, testClient, testPassword, "", testApp, "user", ""); !errors.Is(err, ErrClientDisabled) {
+		t.Fatalf("expected ErrClientDisabled, got: %v", err)
+	}
+
+	if err := sso.EnableClient(ctx, testClient); err != nil {
+		t.Fatalf("failed to enable client: %v", err)
+	}
+
+	// enabling doesn't bring back tokens revoked on disable
+	if _, err := sso.VerifyToken(ctx, token); !errors.Is(err, ErrInvalidToken) {
+		t.Fatalf("expected ErrInvalidToken, got: %v", err)
+	}
+
+	if _, err := sso.GenerateToken(ctx, testClient, testPassword, "", testApp, "user", ""); err != nil {
+		t.Fatalf("failed to generate token after enable: %v", err)
+	}
+}
+
+func TestDeleteClient(t *testing.T) {
+	sso := newTestSsosage(t, LockoutPolicy{})
+	ctx := context.Background()
+
+	token, err := sso.GenerateToken(ctx, testClient, testPassword, "", testApp, "user", "")
+
+	if err != nil {
+		t.Fatalf("failed to generate token: %v", err)
+	}
+
+	if err := sso.DeleteClient(ctx, testClient); err != nil {
+		t.Fatalf("failed to delete client: %v", err)
+	}
+
+	if err := sso.DeleteClient(ctx, testClient); !errors.Is(err, storage.ErrClientNotFound) {
+		t.Fatalf("expected ErrClientNotFound, got: %v", err)
+	}
+
+	// a client registered under the same name doesn't inherit the tokens
+	if _, err := sso.RegisterNewClient(ctx, testClient, testPassword); err != nil {
+		t.Fatalf("failed to register client again: %v", err)
+	}
+
+	if _, err := sso.VerifyToken(ctx, token); !errors.Is(err, ErrInvalidToken) {
+		t.Fatalf("expected ErrInvalidToken, got: %v", err)
+	}
+}
+
+func TestUpdateApp(t *testing.T) {
+	sso := newTestSsosage(t, LockoutPolicy{})
+	ctx := context.Background()
+
+	adminToken, err := sso.GenerateToken(ctx, testClient, testPassword, "", testApp, "admin", "")
+
+	if err != nil {
+		t.Fatalf("failed to generate token: %v", err)
+	}
+
+	userToken, err := sso.GenerateToken(ctx, testClient, testPassword, "", testApp, "user", "")
+
+	if err != nil {
+		t.Fatalf("failed to generate token: %v", err)
+	}
+
+	app, err := sso.GetApp(ctx, testApp)
+
+	if err != nil {
+		t.Fatalf("failed to get app: %v", err)
+	}
+
+	if app.Secret != "" {
+		t.Fatalf("expected secret to be hidden, got %q", app.Secret)
+	}
+
+	roles := "user"
+	displayName := "Renamed"
+
+	version, err := sso.UpdateApp(ctx, testApp, app.Version, models.AppUpdate{Roles: &roles, DisplayName: &displayName})
+
+	if err != nil {
+		t.Fatalf("failed to update app: %v", err)
+	}
+
+	// the removed role takes its tokens with it
+	if _, err := sso.VerifyToken(ctx, adminToken); !errors.Is(err, ErrInvalidToken) {
+		t.Fatalf("expected ErrInvalidToken, got: %v", err)
+	}
+
+	if _, err := sso.VerifyToken(ctx, userToken); err != nil {
+		t.Fatalf("failed to verify token with a kept role: %v", err)
+	}
+
+	if _, err := sso.UpdateApp(ctx, testApp, app.Version, models.AppUpdate{Roles: &roles}); !errors.Is(err, storage.ErrAppConflict) {
+		t.Fatalf("expected ErrAppConflict, got: %v", err)
+	}
+
+	for _, settings := range []string{"[]", "null", "{"} {
+		if _, err := sso.UpdateApp(ctx, testApp, version, models.AppUpdate{Settings: &settings}); !errors.Is(err, ErrInvalidSettings) {
+			t.Fatalf("expected ErrInvalidSettings for %q, got: %v", settings, err)
+		}
+	}
+
+	empty := "user,"
+
+	if _, err := sso.UpdateApp(ctx, testApp, version, models.AppUpdate{Roles: &empty}); !errors.Is(err, ErrInvalidRole) {
+		t.Fatalf("expected ErrInvalidRole, got: %v", err)
+	}
+}
+
+func TestDeleteApp(t *testing.T) {
+	sso := newTestSsosage(t, LockoutPolicy{})
+	ctx := context.Background()
+
+	token, err := sso.GenerateToken(ctx, testClient, testPassword, "", testApp, "user", "")
+
+	if err != nil {
+		t.Fatalf("failed to generate token: %v", err)
+	}
+
+	if err := sso.DeleteApp(ctx, testApp); err != nil {
+		t.Fatalf("failed to delete app: %v", err)
+	}
+
+	if err := sso.DeleteApp(ctx, testApp); !errors.Is(err, storage.ErrAppNotFound) {
+		t.Fatalf("expected ErrAppNotFound, got: %v", err)
+	}
+
+	// an app registered under the same name with the same secret doesn't inherit the tokens
+	if _, err := sso.RegisterNewApp(ctx, testApp, testSecret, "user"); err != nil {
+		t.Fatalf("failed to register app again: %v", err)
+	}
+
+	if _, err := sso.VerifyToken(ctx, token); !errors.Is(err, ErrInvalidToken) {
+		t.Fatalf("expected ErrInvalidToken, got: %v", err)
+	}
+}
+
+// enrollTOTP turns on totp for the test client and returns its secret and recovery codes
+func enrollTOTP(t *testing.T, sso *Ssosage) ([]byte, []string) {
+	t.Helper()
+
+	ctx := context.Background()
+
+	encoded, uri, err := sso.EnrollTOTP(ctx, testClient, testPassword, "")
+
+	if err != nil {
+		t.Fatalf("failed to enroll totp: %v", err)
+	}
+
+	if !strings.HasPrefix(uri, "otpauth://totp/ssosage:"+testClient) {
+		t.Fatalf("unexpected otpauth uri: %s", uri)
+	}
+
+	secret, err := base32.StdEncoding.WithPadding(base32.NoPadding).DecodeString(encoded)
+
+	if err != nil {
+		t.Fatalf("failed to decode totp secret: %v", err)
+	}
+
+	if _, err := sso.ConfirmTOTP(ctx, testClient, testPassword, "000000", ""); !errors.Is(err, ErrInvalidTOTP) {
+		t.Fatalf("expected ErrInvalidTOTP, got: %v", err)
+	}
+
+	codes, err := sso.ConfirmTOTP(ctx, testClient, testPassword, totp.Code(secret, totp.Step(time.Now())-1), "")
+
+	if err != nil {
+		t.Fatalf("failed to confirm totp: %v", err)
+	}
+
+	if len(codes) != recoveryCodeCount {
+		t.Fatalf("expected %d recovery codes, got %d", recoveryCodeCount, len(codes))
+	}
+
+	return secret, codes
+}
+
+func TestTOTP(t *testing.T) {
+	sso := newTestSsosage(t, LockoutPolicy{})
+	ctx := context.Background()
+
+	secret, _ := enrollTOTP(t, sso)
+
+	if _, err := sso.GenerateToken(ctx, testClient, testPassword, "", testApp, "user", ""); !errors.Is(err, ErrTOTPRequired) {
+		t.Fatalf("expected ErrTOTPRequired, got: %v", err)
+	}
+
+	code := totp.Code(secret, totp.Step(time.Now()))
+
+	if _, err := sso.GenerateToken(ctx, testClient, testPassword, code, testApp, "user", ""); err != nil {
+		t.Fatalf("failed to generate token with totp: %v", err)
+	}
+
+	// a code works once, and so does any earlier one
+	if _, err := sso.GenerateToken(ctx, testClient, testPassword, code, testApp, "user", ""); !errors.Is(err, ErrInvalidTOTP) {
+		t.Fatalf("expected ErrInvalidTOTP for a replayed code, got: %v", err)
+	}
+
+	if _, _, err := sso.EnrollTOTP(ctx, testClient, testPassword, ""); !errors.Is(err, ErrTOTPEnabled) {
+		t.Fatalf("expected ErrTOTPEnabled, got: %v", err)
+	}
+
+	if err := sso.ResetTOTP(ctx, testClient); err != nil {
+		t.Fatalf("failed to reset totp: %v", err)
+	}
+
+	if _, err := sso.GenerateToken(ctx, testClient, testPassword, "", testApp, "user", ""); err != nil {
+		t.Fatalf("failed to generate token after reset: %v", err)
+	}
+
+	if _, _, err := sso.EnrollTOTP(ctx, testClient, testPassword, ""); err != nil {
+		t.Fatalf("failed to enroll totp again: %v", err)
+	}
+
+	// a secret is not in use until confirmed
+	if _, err := sso.GenerateToken(ctx, testClient, testPassword, "", testApp, "user", ""); err != nil {
+		t.Fatalf("failed to generate token before confirmation: %v", err)
+	}
+}
+
+func TestTOTPFailuresLockLogin(t *testing.T) {
+	sso := newTestSsosage(t, LockoutPolicy{ClientMaxAttempts: 2, LockoutDuration: time.Minute, Window: time.Minute})
+	ctx := context.Background()
+
+	enrollTOTP(t, sso)
+
+	for range 2 {
+		if _, err := sso.GenerateToken(ctx, testClient, testPassword, "000000", testApp, "user", ""); !errors.Is(err, ErrInvalidTOTP) && !errors.Is(err, ErrLoginLocked) {
+			t.Fatalf("expected ErrInvalidTOTP, got: %v", err)
+		}
+	}
+
+	if _, err := sso.GenerateToken(ctx, testClient, testPassword, "000000", testApp, "user", ""); !errors.Is(err, ErrLoginLocked) {
+		t.Fatalf("expected ErrLoginLocked, got: %v", err)
+	}
+}
+
+func TestAppRequiresMFA(t *testing.T) {
+	sso := newTestSsosage(t, LockoutPolicy{})
+	ctx := context.Background()
+
+	app, err := sso.GetApp(ctx, testApp)
+
+	if err != nil {
+		t.Fatalf("failed to get app: %v", err)
+	}
+
+	requireMFA := true
+
+	if _, err := sso.UpdateApp(ctx, testApp, app.Version, models.AppUpdate{RequireMFA: &requireMFA}); err != nil {
+		t.Fatalf("failed to update app: %v", err)
+	}
+
+	if _, err := sso.GenerateToken(ctx, testClient, testPassword, "", testApp, "user", ""); !errors.Is(err, ErrMFARequired) {
+		t.Fatalf("expected ErrMFARequired, got: %v", err)
+	}
+
+	secret, _ := enrollTOTP(t, sso)
+
+	if _, err := sso.GenerateToken(ctx, testClient, testPassword, totp.Code(secret, totp.Step(time.Now())), testApp, "user", ""); err != nil {
+		t.Fatalf("failed to generate token with totp: %v", err)
+	}
+}
+
+func TestRecoveryCodes(t *testing.T) {
+	sso := newTestSsosage(t, LockoutPolicy{})
+	ctx := context.Background()
+
+	secret, codes := enrollTOTP(t, sso)
+
+	// typed in upper case and without the dash
+	code := strings.ToUpper(strings.ReplaceAll(codes[0], "-", ""))
+
+	if _, err := sso.GenerateToken(ctx, testClient, testPassword, code, testApp, "user", ""); err != nil {
+		t.Fatalf("failed to generate token with a recovery code: %v", err)
+	}
+
+	if _, err := sso.GenerateToken(ctx, testClient, testPassword, codes[0], testApp, "user", ""); !errors.Is(err, ErrInvalidRecoveryCode) {
+		t.Fatalf("expected ErrInvalidRecoveryCode for a used code, got: %v", err)
+	}
+
+	if _, err := sso.RegenerateRecoveryCodes(ctx, testClient, testPassword, "", ""); !errors.Is(err, ErrTOTPRequired) {
+		t.Fatalf("expected ErrTOTPRequired, got: %v", err)
+	}
+
+	regenerated, err := sso.RegenerateRecoveryCodes(ctx, testClient, testPassword, totp.Code(secret, totp.Step(time.Now())), "")
+
+	if err != nil {
+		t.Fatalf("failed to regenerate recovery codes: %v", err)
+	}
+
+	// the old codes are gone
+	if _, err := sso.GenerateToken(ctx, testClient, testPassword, codes[1], testApp, "user", ""); !errors.Is(err, ErrInvalidRecoveryCode) {
+		t.Fatalf("expected ErrInvalidRecoveryCode for a replaced code, got: %v", err)
+	}
+
+	if _, err := sso.GenerateToken(ctx, testClient, testPassword, regenerated[0], testApp, "user", ""); err != nil {
+		t.Fatalf("failed to generate token with a regenerated code: %v", err)
+	}
+
+	if err := sso.DisableTOTP(ctx, testClient, testPassword, regenerated[1], ""); err != nil {
+		t.Fatalf("failed to disable totp with a recovery code: %v", err)
+	}
+
+	if _, err := sso.RegenerateRecoveryCodes(ctx, testClient, testPassword, "", ""); !errors.Is(err, ErrTOTPNotEnabled) {
+		t.Fatalf("expected ErrTOTPNotEnabled, got: %v", err)
+	}
+}
@@ -0,0 +1,257 @@
+/*
+cache decorates storage lookups that are hot and rarely change.
+The cache is local to the process, so with several instances an updated app
+is seen by the others only once its entry expires.
+*/
+package cache
+
+import (
+	"container/list"
+	"context"
+	"errors"
+	"log/slog"
+	"ssosage/internal/helpers"
+	"ssosage/internal/interfaces"
+	"ssosage/internal/models"
+	"ssosage/internal/storage"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+type Options struct {
+	// how long a found app is served from the cache
+	TTL time.Duration
+	// how long an unknown app name is remembered, zero disables negative caching
+	NegativeTTL time.Duration
+	// max number of cached names, the least recently used one is evicted first
+	Size int
+}
+
+var DefaultOptions = Options{
+	TTL:         time.Minute,
+	NegativeTTL: 10 * time.Second,
+	Size:        1024,
+}
+
+// Stats are counters since the cache was created
+type Stats struct {
+	Hits         uint64
+	NegativeHits uint64
+	Misses       uint64
+	Evictions    uint64
+}
+
+func (s Stats) LogValue() slog.Value {
+	return slog.GroupValue(
+		slog.Uint64("hits", s.Hits),
+		slog.Uint64("negative_hits", s.NegativeHits),
+		slog.Uint64("misses", s.Misses),
+		slog.Uint64("evictions", s.Evictions),
+	)
+}
+
+/*
+Apps implements AppSaver, AppProvider and AppUpdater on top of another storage.
+Writes go straight to the storage and drop the cached name,
+so an app registered after a failed lookup is found right away.
+*/
+type Apps struct {
+	saver    interfaces.AppSaver
+	provider interfaces.AppProvider
+	updater  interfaces.AppUpdater
+	opts     Options
+	now      func() time.Time
+
+	mu      sync.Mutex
+	entries map[string]*list.Element
+	// front is the most recently used entry
+	lru *list.List
+	// bumped by Invalidate, so a lookup racing with it doesn't cache what it read before
+	generation uint64
+
+	hits         atomic.Uint64
+	negativeHits atomic.Uint64
+	misses       atomic.Uint64
+	evictions    atomic.Uint64
+}
+
+type entry struct {
+	name      string
+	app       models.App
+	found     bool
+	expiresAt time.Time
+}
+
+func NewApps(saver interfaces.AppSaver, provider interfaces.AppProvider, updater interfaces.AppUpdater, opts Options) *Apps {
+	return &Apps{
+		saver:    saver,
+		provider: provider,
+		updater:  updater,
+		opts:     opts,
+		now:      time.Now,
+		entries:  make(map[string]*list.Element),
+		lru:      list.New(),
+	}
+}
+
+func (c *Apps) SaveApp(ctx context.Context, name string, secret string, roles string) (int64, error) {
+	const op = "storage.cache.SaveApp"
+
+	id, err := c.saver.SaveApp(ctx, name, secret, roles)
+
+	c.Invalidate(name)
+
+	if err != nil {
+		return 0, helpers.WrapErr(op, err)
+	}
+
+	return id, nil
+}
+
+func (c *Apps) UpdateApp(ctx context.Context, name string, version int64, update models.AppUpdate) (int64, error) {
+	const op = "storage.cache.UpdateApp"
+
+	version, err := c.updater.UpdateApp(ctx, name, version, update)
+
+	c.Invalidate(name)
+
+	if err != nil {
+		return 0, helpers.WrapErr(op, err)
+	}
+
+	return version, nil
+}
+
+func (c *Apps) DeleteApp(ctx context.Context, name string) error {
+	const op = "storage.cache.DeleteApp"
+
+	err := c.updater.DeleteApp(ctx, name)
+
+	c.Invalidate(name)
+
+	if err != nil {
+		return helpers.WrapErr(op, err)
+	}
+
+	return nil
+}
+
+func (c *Apps) App(ctx context.Context, name string) (models.App, error) {
+	const op = "storage.cache.App"
+
+	if e, ok := c.get(name); ok {
+		if !e.found {
+			c.negativeHits.Add(1)
+
+			return models.App{}, helpers.WrapErr(op, storage.ErrAppNotFound)
+		}
+
+		c.hits.Add(1)
+
+		return e.app, nil
+	}
+
+	c.misses.Add(1)
+
+	generation := c.currentGeneration()
+
+	app, err := c.provider.App(ctx, name)
+
+	if err != nil {
+		if errors.Is(err, storage.ErrAppNotFound) && c.opts.NegativeTTL > 0 {
+			c.put(entry{name: name, expiresAt: c.now().Add(c.opts.NegativeTTL)}, generation)
+		}
+
+		return models.App{}, helpers.WrapErr(op, err)
+	}
+
+	if c.opts.TTL > 0 {
+		c.put(entry{name: name, app: app, found: true, expiresAt: c.now().Add(c.opts.TTL)}, generation)
+	}
+
+	return app, nil
+}
+
+// Invalidate drops a cached app, call it whenever the app is changed or its secret rotated
+func (c *Apps) Invalidate(name string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.generation++
+
+	if el, ok := c.entries[name]; ok {
+		c.remove(el)
+	}
+}
+
+func (c *Apps) Stats() Stats {
+	return Stats{
+		Hits:         c.hits.Load(),
+		NegativeHits: c.negativeHits.Load(),
+		Misses:       c.misses.Load(),
+		Evictions:    c.evictions.Load(),
+	}
+}
+
+func (c *Apps) get(name string) (entry, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	el, ok := c.entries[name]
+
+	if !ok {
+		return entry{}, false
+	}
+
+	e := el.Value.(entry)
+
+	if !c.now().Before(e.expiresAt) {
+		c.remove(el)
+
+		return entry{}, false
+	}
+
+	c.lru.MoveToFront(el)
+
+	return e, true
+}
+
+func (c *Apps) currentGeneration() uint64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.generation
+}
+
+func (c *Apps) put(e entry, generation uint64) {
+	if c.opts.Size <= 0 {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if generation != c.generation {
+		return
+	}
+
+	if el, ok := c.entries[e.name]; ok {
+		el.Value = e
+		c.lru.MoveToFront(el)
+
+		return
+	}
+
+	c.entries[e.name] = c.lru.PushFront(e)
+
+	for c.lru.Len() > c.opts.Size {
+		c.remove(c.lru.Back())
+		c.evictions.Add(1)
+	}
+}
+
+func (c *Apps) remove(el *list.Element) {
+	c.lru.Remove(el)
+	delete(c.entries, el.Value.(entry).name)
+}
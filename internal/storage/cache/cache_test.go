@@ -0,0 +1,213 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"ssosage/internal/models"
+	"ssosage/internal/storage"
+	"ssosage/internal/storage/memory"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// countingProvider counts lookups that reach the storage
+type countingProvider struct {
+	*memory.Storage
+	calls atomic.Int64
+}
+
+func (p *countingProvider) App(ctx context.Context, name string) (models.App, error) {
+	p.calls.Add(1)
+
+	return p.Storage.App(ctx, name)
+}
+
+type clock struct {
+	now time.Time
+}
+
+func (c *clock) Now() time.Time {
+	return c.now
+}
+
+func newTestApps(t *testing.T, opts Options) (*Apps, *countingProvider, *clock) {
+	t.Helper()
+
+	p := &countingProvider{Storage: memory.New()}
+	c := &clock{now: time.Unix(1_000_000, 0)}
+
+	apps := NewApps(p, p, p, opts)
+	apps.now = c.Now
+
+	return apps, p, c
+}
+
+func TestHit(t *testing.T) {
+	apps, p, _ := newTestApps(t, DefaultOptions)
+	ctx := context.Background()
+
+	if _, err := apps.SaveApp(ctx, "app", "secret", "user"); err != nil {
+		t.Fatalf("failed to save app: %v", err)
+	}
+
+	for i := 0; i < 3; i++ {
+		app, err := apps.App(ctx, "app")
+
+		if err != nil {
+			t.Fatalf("failed to get app: %v", err)
+		}
+
+		if app.Secret != "secret" {
+			t.Fatalf("unexpected app: %+v", app)
+		}
+	}
+
+	if calls := p.calls.Load(); calls != 1 {
+		t.Fatalf("expected 1 storage lookup, got %d", calls)
+	}
+
+	if stats := apps.Stats(); stats.Hits != 2 || stats.Misses != 1 {
+		t.Fatalf("unexpected stats: %+v", stats)
+	}
+}
+
+func TestNegativeCaching(t *testing.T) {
+	apps, p, _ := newTestApps(t, DefaultOptions)
+	ctx := context.Background()
+
+	for i := 0; i < 2; i++ {
+		if _, err := apps.App(ctx, "app"); !errors.Is(err, storage.ErrAppNotFound) {
+			t.Fatalf("expected ErrAppNotFound, got: %v", err)
+		}
+	}
+
+	if calls := p.calls.Load(); calls != 1 {
+		t.Fatalf("expected 1 storage lookup, got %d", calls)
+	}
+
+	if stats := apps.Stats(); stats.NegativeHits != 1 {
+		t.Fatalf("unexpected stats: %+v", stats)
+	}
+
+	// saving the app drops the negative entry
+	if _, err := apps.SaveApp(ctx, "app", "secret", "user"); err != nil {
+		t.Fatalf("failed to save app: %v", err)
+	}
+
+	if _, err := apps.App(ctx, "app"); err != nil {
+		t.Fatalf("failed to get app after save: %v", err)
+	}
+}
+
+func TestExpiry(t *testing.T) {
+	apps, p, c := newTestApps(t, Options{TTL: time.Minute, NegativeTTL: time.Second, Size: 10})
+	ctx := context.Background()
+
+	if _, err := apps.SaveApp(ctx, "app", "secret", "user"); err != nil {
+		t.Fatalf("failed to save app: %v", err)
+	}
+
+	apps.App(ctx, "app")
+	apps.App(ctx, "missing")
+
+	c.now = c.now.Add(2 * time.Second)
+
+	apps.App(ctx, "app")
+	apps.App(ctx, "missing")
+
+	if calls := p.calls.Load(); calls != 3 {
+		t.Fatalf("expected the negative entry only to expire, got %d lookups", calls)
+	}
+
+	c.now = c.now.Add(time.Minute)
+
+	apps.App(ctx, "app")
+
+	if calls := p.calls.Load(); calls != 4 {
+		t.Fatalf("expected the app entry to expire, got %d lookups", calls)
+	}
+}
+
+func TestInvalidate(t *testing.T) {
+	apps, p, _ := newTestApps(t, DefaultOptions)
+	ctx := context.Background()
+
+	if _, err := apps.SaveApp(ctx, "app", "secret", "user"); err != nil {
+		t.Fatalf("failed to save app: %v", err)
+	}
+
+	apps.App(ctx, "app")
+	apps.Invalidate("app")
+	apps.App(ctx, "app")
+
+	if calls := p.calls.Load(); calls != 2 {
+		t.Fatalf("expected 2 storage lookups, got %d", calls)
+	}
+}
+
+func TestEviction(t *testing.T) {
+	apps, p, _ := newTestApps(t, Options{TTL: time.Minute, Size: 2})
+	ctx := context.Background()
+
+	for _, name := range []string{"a", "b", "c"} {
+		if _, err := apps.SaveApp(ctx, name, "secret", "user"); err != nil {
+			t.Fatalf("failed to save app: %v", err)
+		}
+	}
+
+	apps.App(ctx, "a")
+	apps.App(ctx, "b")
+	// a is the most recently used, so c evicts b
+	apps.App(ctx, "a")
+	apps.App(ctx, "c")
+
+	apps.App(ctx, "a")
+
+	if calls := p.calls.Load(); calls != 3 {
+		t.Fatalf("expected a to stay cached, got %d lookups", calls)
+	}
+
+	apps.App(ctx, "b")
+
+	if calls := p.calls.Load(); calls != 4 {
+		t.Fatalf("expected b to be evicted, got %d lookups", calls)
+	}
+
+	if stats := apps.Stats(); stats.Evictions != 2 {
+		t.Fatalf("unexpected stats: %+v", stats)
+	}
+}
+
+func TestUpdateInvalidates(t *testing.T) {
+	apps, _, _ := newTestApps(t, DefaultOptions)
+	ctx := context.Background()
+
+	if _, err := apps.SaveApp(ctx, "app", "secret", "user"); err != nil {
+		t.Fatalf("failed to save app: %v", err)
+	}
+
+	app, err := apps.App(ctx, "app")
+
+	if err != nil {
+		t.Fatalf("failed to get app: %v", err)
+	}
+
+	roles := "user,admin"
+
+	if _, err := apps.UpdateApp(ctx, "app", app.Version, models.AppUpdate{Roles: &roles}); err != nil {
+		t.Fatalf("failed to update app: %v", err)
+	}
+
+	if app, err = apps.App(ctx, "app"); err != nil || app.Roles != "user,admin" {
+		t.Fatalf("expected updated roles, got %+v, err: %v", app, err)
+	}
+
+	if err := apps.DeleteApp(ctx, "app"); err != nil {
+		t.Fatalf("failed to delete app: %v", err)
+	}
+
+	if _, err := apps.App(ctx, "app"); !errors.Is(err, storage.ErrAppNotFound) {
+		t.Fatalf("expected ErrAppNotFound, got: %v", err)
+	}
+}
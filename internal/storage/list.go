@@ -0,0 +1,109 @@
+package storage
+
+import (
+	"encoding/base64"
+	"fmt"
+	"ssosage/internal/models"
+	"strconv"
+	"strings"
+	"time"
+)
+
+/*
+Cursor is the position right after the last item of a page.
+Items are ordered by the sort column and then by id, so a cursor holds both.
+Names compare bytewise in every backend.
+*/
+type Cursor struct {
+	ID   uint64
+	Name string
+	At   int64
+}
+
+const (
+	DefaultPageLimit = 50
+	MaxPageLimit     = 500
+)
+
+// PageLimit is the limit of q, defaulted and capped
+func PageLimit(q models.ListQuery) int {
+	switch {
+	case q.Limit <= 0:
+		return DefaultPageLimit
+	case q.Limit > MaxPageLimit:
+		return MaxPageLimit
+	}
+
+	return q.Limit
+}
+
+// SortColumn returns the column q is ordered by, it's safe to put in a query
+func SortColumn(q models.ListQuery) (string, error) {
+	switch q.SortBy {
+	case "", models.SortByName:
+		return "name", nil
+	case models.SortByCreatedAt:
+		return "created_at", nil
+	case models.SortByUpdatedAt:
+		return "updated_at", nil
+	}
+
+	return "", fmt.Errorf("%w: %q", ErrInvalidSort, q.SortBy)
+}
+
+// NewCursor encodes the position after an item, sort and direction are kept to reject a cursor used with another query
+func NewCursor(q models.ListQuery, id uint64, name string, createdAt time.Time, updatedAt time.Time) string {
+	column, _ := SortColumn(q)
+
+	var at int64
+
+	switch column {
+	case "created_at":
+		at = createdAt.Unix()
+	case "updated_at":
+		at = updatedAt.Unix()
+	}
+
+	raw := fmt.Sprintf("%s|%t|%d|%d|%s", column, q.Desc, id, at, name)
+
+	return base64.RawURLEncoding.EncodeToString([]byte(raw))
+}
+
+// DecodeCursor returns false when q starts from the first page
+func DecodeCursor(q models.ListQuery) (Cursor, bool, error) {
+	if q.Cursor == "" {
+		return Cursor{}, false, nil
+	}
+
+	raw, err := base64.RawURLEncoding.DecodeString(q.Cursor)
+
+	if err != nil {
+		return Cursor{}, false, ErrInvalidCursor
+	}
+
+	column, err := SortColumn(q)
+
+	if err != nil {
+		return Cursor{}, false, err
+	}
+
+	parts := strings.SplitN(string(raw), "|", 5)
+
+	if len(parts) != 5 || parts[0] != column || parts[1] != strconv.FormatBool(q.Desc) {
+		return Cursor{}, false, ErrInvalidCursor
+	}
+
+	id, err := strconv.ParseUint(parts[2], 10, 64)
+
+	if err != nil {
+		return Cursor{}, false, ErrInvalidCursor
+	}
+
+	at, err := strconv.ParseInt(parts[3], 10, 64)
+
+	if err != nil {
+		return Cursor{}, false, ErrInvalidCursor
+	}
+
+	return Cursor{ID: id, At: at, Name: parts[4]}, true, nil
+}
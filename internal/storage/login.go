@@ -0,0 +1,16 @@
+package storage
+
+import "strings"
+
+// JoinFactors stores factor names of a login transaction in one column
+func JoinFactors(factors []string) string {
+	return strings.Join(factors, ",")
+}
+
+func SplitFactors(column string) []string {
+	if column == "" {
+		return nil
+	}
+
+	return strings.Split(column, ",")
+}
@@ -0,0 +1,1568 @@
+package memory
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"maps"
+	"slices"
+	"ssosage/internal/helpers"
+	"ssosage/internal/interfaces"
+	"ssosage/internal/models"
+	"ssosage/internal/storage"
+	"strings"
+	"sync"
+	"time"
+)
+
+/*
+implements ClientSaver, ClientProvider, AppSaver, AppProvider, LoginAttemptsTracker, Transactor
+keeps everything in memory, for tests and ephemeral dev mode
+transactions are serialized and work on a copy of the data that replaces it on commit,
+so reads outside of them never see uncommitted writes
+*/
+type Storage struct {
+	*state
+	inTx bool
+}
+
+type state struct {
+	// txMu serializes transactions and the writes made outside of them
+	txMu sync.Mutex
+	mu   sync.RWMutex
+	*data
+}
+
+type data struct {
+	lastID        uint64
+	clients       map[string]models.Client
+	apps          map[string]models.App
+	loginAttempts map[string]models.LoginAttempts
+	// unused recovery codes by client name, slices are replaced rather than changed in place
+	recoveryCodes map[string][]models.RecoveryCode
+	// passkeys by client name, replaced like recovery codes
+	credentials map[string][]models.WebAuthnCredential
+	logins      map[string]models.LoginTransaction
+	resets      map[string]models.PasswordReset
+	profiles    map[string]models.Profile
+	orgs        map[string]models.Organization
+	groups      map[string]models.Group
+	// memberships and role grants by ids, so they never carry over to a name registered again
+	members map[membership]struct{}
+	grants  map[roleGrant]struct{}
+}
+
+type membership struct {
+	groupID  uint64
+	clientID uint64
+}
+
+// roleGrant has one of clientID and groupID
+type roleGrant struct {
+	clientID uint64
+	groupID  uint64
+	appID    uint64
+	role     string
+}
+
+func New() *Storage {
+	return &Storage{
+		state: &state{
+			data: &data{
+				clients:       make(map[string]models.Client),
+				apps:          make(map[string]models.App),
+				loginAttempts: make(map[string]models.LoginAttempts),
+				recoveryCodes: make(map[string][]models.RecoveryCode),
+				credentials:   make(map[string][]models.WebAuthnCredential),
+				logins:        make(map[string]models.LoginTransaction),
+				resets:        make(map[string]models.PasswordReset),
+				profiles:      make(map[string]models.Profile),
+				orgs:          make(map[string]models.Organization),
+				groups:        make(map[string]models.Group),
+				members:       make(map[membership]struct{}),
+				grants:        make(map[roleGrant]struct{}),
+			},
+		},
+	}
+}
+
+func (s *Storage) WithinTx(ctx context.Context, fn func(repo interfaces.Repository) error) error {
+	const op = "storage.memory.WithinTx"
+
+	if s.inTx {
+		return fn(s)
+	}
+
+	if err := ctx.Err(); err != nil {
+		return helpers.WrapErr(op, err)
+	}
+
+	s.txMu.Lock()
+	defer s.txMu.Unlock()
+
+	s.mu.RLock()
+	snapshot := s.data.clone()
+	s.mu.RUnlock()
+
+	if err := fn(&Storage{state: &state{data: snapshot}, inTx: true}); err != nil {
+		return err
+	}
+
+	// nothing else wrote while txMu was held, the copy is the whole new state
+	s.mu.Lock()
+	s.data = snapshot
+	s.mu.Unlock()
+
+	return nil
+}
+
+// clone copies the maps, their values are never changed in place
+func (d *data) clone() *data {
+	return &data{
+		lastID:        d.lastID,
+		clients:       maps.Clone(d.clients),
+		apps:          maps.Clone(d.apps),
+		loginAttempts: maps.Clone(d.loginAttempts),
+		recoveryCodes: maps.Clone(d.recoveryCodes),
+		credentials:   maps.Clone(d.credentials),
+		logins:        maps.Clone(d.logins),
+		resets:        maps.Clone(d.resets),
+		profiles:      maps.Clone(d.profiles),
+		orgs:          maps.Clone(d.orgs),
+		groups:        maps.Clone(d.groups),
+		members:       maps.Clone(d.members),
+		grants:        maps.Clone(d.grants),
+	}
+}
+
+// lockWrite waits for the running transaction, unless the write is a part of it
+func (s *Storage) lockWrite() func() {
+	if s.inTx {
+		return func() {}
+	}
+
+	s.txMu.Lock()
+
+	return s.txMu.Unlock
+}
+
+func (s *Storage) SaveClient(ctx context.Context, name string, passwordHash []byte) (int64, error) {
+	const op = "storage.memory.SaveClient"
+
+	if err := ctx.Err(); err != nil {
+		return 0, helpers.WrapErr(op, err)
+	}
+
+	defer s.lockWrite()()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.clients[name]; ok {
+		return 0, helpers.WrapErr(op, storage.ErrClientExists)
+	}
+
+	orgID, err := s.orgID(name)
+
+	if err != nil {
+		return 0, helpers.WrapErr(op, err)
+	}
+
+	s.lastID++
+	now := time.Unix(time.Now().Unix(), 0)
+
+	s.clients[name] = models.Client{
+		ID:           s.lastID,
+		Name:         name,
+		OrgID:        orgID,
+		PasswordHash: bytes.Clone(passwordHash),
+		Status:       models.ClientActive,
+		CreatedAt:    now,
+		UpdatedAt:    now,
+	}
+
+	return int64(s.lastID), nil
+}
+
+func (s *Storage) Client(ctx context.Context, name string) (models.Client, error) {
+	const op = "storage.memory.Client"
+
+	if err := ctx.Err(); err != nil {
+		return models.Client{}, helpers.WrapErr(op, err)
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	client, ok := s.clients[name]
+
+	if !ok {
+		return models.Client{}, helpers.WrapErr(op, storage.ErrClientNotFound)
+	}
+
+	client.PasswordHash = bytes.Clone(client.PasswordHash)
+	client.TOTPSecret = bytes.Clone(client.TOTPSecret)
+	client.WebAuthnEnabled = len(s.credentials[name]) > 0
+
+	return client, nil
+}
+
+func (s *Storage) UpdateClientPassword(ctx context.Context, name string, passwordHash []byte) error {
+	const op = "storage.memory.UpdateClientPassword"
+
+	return s.updateClient(ctx, op, name, func(client *models.Client) {
+		client.PasswordHash = bytes.Clone(passwordHash)
+	})
+}
+
+func (s *Storage) SetClientStatus(ctx context.Context, name string, status string) error {
+	const op = "storage.memory.SetClientStatus"
+
+	return s.updateClient(ctx, op, name, func(client *models.Client) {
+		client.Status = status
+	})
+}
+
+func (s *Storage) DeleteClient(ctx context.Context, name string) error {
+	const op = "storage.memory.DeleteClient"
+
+	if err := ctx.Err(); err != nil {
+		return helpers.WrapErr(op, err)
+	}
+
+	defer s.lockWrite()()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	client, ok := s.clients[name]
+
+	if !ok {
+		return helpers.WrapErr(op, storage.ErrClientNotFound)
+	}
+
+	delete(s.clients, name)
+	maps.DeleteFunc(s.members, func(m membership, _ struct{}) bool { return m.clientID == client.ID })
+	maps.DeleteFunc(s.grants, func(g roleGrant, _ struct{}) bool { return g.clientID == client.ID })
+	delete(s.recoveryCodes, name)
+	delete(s.credentials, name)
+	delete(s.profiles, name)
+
+	for id, reset := range s.resets {
+		if reset.ClientName == name {
+			delete(s.resets, id)
+		}
+	}
+
+	return nil
+}
+
+func (s *Storage) SaveOrganization(ctx context.Context, name string) (int64, error) {
+	const op = "storage.memory.SaveOrganization"
+
+	if err := ctx.Err(); err != nil {
+		return 0, helpers.WrapErr(op, err)
+	}
+
+	defer s.lockWrite()()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.orgs[name]; ok {
+		return 0, helpers.WrapErr(op, storage.ErrOrganizationExists)
+	}
+
+	s.lastID++
+
+	s.orgs[name] = models.Organization{
+		ID:        s.lastID,
+		Name:      name,
+		CreatedAt: time.Unix(time.Now().Unix(), 0),
+	}
+
+	return int64(s.lastID), nil
+}
+
+func (s *Storage) Organization(ctx context.Context, name string) (models.Organization, error) {
+	const op = "storage.memory.Organization"
+
+	if err := ctx.Err(); err != nil {
+		return models.Organization{}, helpers.WrapErr(op, err)
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	org, ok := s.orgs[name]
+
+	if !ok {
+		return models.Organization{}, helpers.WrapErr(op, storage.ErrOrganizationNotFound)
+	}
+
+	return org, nil
+}
+
+func (s *Storage) DeleteOrganization(ctx context.Context, name string) error {
+	const op = "storage.memory.DeleteOrganization"
+
+	if err := ctx.Err(); err != nil {
+		return helpers.WrapErr(op, err)
+	}
+
+	defer s.lockWrite()()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	org, ok := s.orgs[name]
+
+	if !ok {
+		return helpers.WrapErr(op, storage.ErrOrganizationNotFound)
+	}
+
+	for _, client := range s.clients {
+		if client.OrgID == org.ID {
+			return helpers.WrapErr(op, storage.ErrOrganizationNotEmpty)
+		}
+	}
+
+	for _, app := range s.apps {
+		if app.OrgID == org.ID {
+			return helpers.WrapErr(op, storage.ErrOrganizationNotEmpty)
+		}
+	}
+
+	// groups belong to an organization by their name only
+	for groupName := range s.groups {
+		if strings.HasPrefix(groupName, name+"/") {
+			return helpers.WrapErr(op, storage.ErrOrganizationNotEmpty)
+		}
+	}
+
+	delete(s.orgs, name)
+
+	return nil
+}
+
+func (s *Storage) SaveGroup(ctx context.Context, name string) (int64, error) {
+	const op = "storage.memory.SaveGroup"
+
+	if err := ctx.Err(); err != nil {
+		return 0, helpers.WrapErr(op, err)
+	}
+
+	defer s.lockWrite()()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.groups[name]; ok {
+		return 0, helpers.WrapErr(op, storage.ErrGroupExists)
+	}
+
+	s.lastID++
+
+	s.groups[name] = models.Group{
+		ID:        s.lastID,
+		Name:      name,
+		CreatedAt: time.Unix(time.Now().Unix(), 0),
+	}
+
+	return int64(s.lastID), nil
+}
+
+func (s *Storage) Group(ctx context.Context, name string) (models.Group, error) {
+	const op = "storage.memory.Group"
+
+	if err := ctx.Err(); err != nil {
+		return models.Group{}, helpers.WrapErr(op, err)
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	group, ok := s.groups[name]
+
+	if !ok {
+		return models.Group{}, helpers.WrapErr(op, storage.ErrGroupNotFound)
+	}
+
+	return group, nil
+}
+
+func (s *Storage) DeleteGroup(ctx context.Context, name string) error {
+	const op = "storage.memory.DeleteGroup"
+
+	if err := ctx.Err(); err != nil {
+		return helpers.WrapErr(op, err)
+	}
+
+	defer s.lockWrite()()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	group, ok := s.groups[name]
+
+	if !ok {
+		return helpers.WrapErr(op, storage.ErrGroupNotFound)
+	}
+
+	delete(s.groups, name)
+	maps.DeleteFunc(s.members, func(m membership, _ struct{}) bool { return m.groupID == group.ID })
+	maps.DeleteFunc(s.grants, func(g roleGrant, _ struct{}) bool { return g.groupID == group.ID })
+
+	return nil
+}
+
+func (s *Storage) AddGroupMember(ctx context.Context, group string, clientName string) error {
+	const op = "storage.memory.AddGroupMember"
+
+	return s.modifyMembership(ctx, op, group, clientName, func(m membership) {
+		s.members[m] = struct{}{}
+	})
+}
+
+func (s *Storage) RemoveGroupMember(ctx context.Context, group string, clientName string) error {
+	const op = "storage.memory.RemoveGroupMember"
+
+	return s.modifyMembership(ctx, op, group, clientName, func(m membership) {
+		delete(s.members, m)
+	})
+}
+
+func (s *Storage) GrantRole(ctx context.Context, grant models.RoleGrant) error {
+	const op = "storage.memory.GrantRole"
+
+	return s.modifyGrant(ctx, op, grant, func(g roleGrant) {
+		s.grants[g] = struct{}{}
+	})
+}
+
+func (s *Storage) RevokeRole(ctx context.Context, grant models.RoleGrant) error {
+	const op = "storage.memory.RevokeRole"
+
+	return s.modifyGrant(ctx, op, grant, func(g roleGrant) {
+		delete(s.grants, g)
+	})
+}
+
+func (s *Storage) ClientGroups(ctx context.Context, clientName string) ([]string, error) {
+	const op = "storage.memory.ClientGroups"
+
+	if err := ctx.Err(); err != nil {
+		return nil, helpers.WrapErr(op, err)
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	names := []string{}
+	client, ok := s.clients[clientName]
+
+	if !ok {
+		return names, nil
+	}
+
+	for _, group := range s.groups {
+		if _, ok := s.members[membership{group.ID, client.ID}]; ok {
+			names = append(names, group.Name)
+		}
+	}
+
+	slices.Sort(names)
+
+	return names, nil
+}
+
+func (s *Storage) ClientRoles(ctx context.Context, clientName string, appName string) ([]string, error) {
+	const op = "storage.memory.ClientRoles"
+
+	if err := ctx.Err(); err != nil {
+		return nil, helpers.WrapErr(op, err)
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	roles := []string{}
+	client, clientOK := s.clients[clientName]
+	app, appOK := s.apps[appName]
+
+	if !clientOK || !appOK {
+		return roles, nil
+	}
+
+	for grant := range s.grants {
+		if grant.appID != app.ID {
+			continue
+		}
+
+		if _, member := s.members[membership{grant.groupID, client.ID}]; grant.clientID == client.ID || member {
+			roles = append(roles, grant.role)
+		}
+	}
+
+	slices.Sort(roles)
+
+	return slices.Compact(roles), nil
+}
+
+// modifyMembership applies fn to the membership of an existing client in an existing group
+func (s *Storage) modifyMembership(ctx context.Context, op string, group string, clientName string, fn func(m membership)) error {
+	if err := ctx.Err(); err != nil {
+		return helpers.WrapErr(op, err)
+	}
+
+	defer s.lockWrite()()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	g, ok := s.groups[group]
+
+	if !ok {
+		return helpers.WrapErr(op, storage.ErrGroupNotFound)
+	}
+
+	client, ok := s.clients[clientName]
+
+	if !ok {
+		return helpers.WrapErr(op, storage.ErrClientNotFound)
+	}
+
+	fn(membership{g.ID, client.ID})
+
+	return nil
+}
+
+// modifyGrant applies fn to a grant once its client or group and its app are found
+func (s *Storage) modifyGrant(ctx context.Context, op string, grant models.RoleGrant, fn func(g roleGrant)) error {
+	if err := ctx.Err(); err != nil {
+		return helpers.WrapErr(op, err)
+	}
+
+	defer s.lockWrite()()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	g := roleGrant{role: grant.Role}
+
+	if grant.GroupName != "" {
+		group, ok := s.groups[grant.GroupName]
+
+		if !ok {
+			return helpers.WrapErr(op, storage.ErrGroupNotFound)
+		}
+
+		g.groupID = group.ID
+	} else {
+		client, ok := s.clients[grant.ClientName]
+
+		if !ok {
+			return helpers.WrapErr(op, storage.ErrClientNotFound)
+		}
+
+		g.clientID = client.ID
+	}
+
+	app, ok := s.apps[grant.AppName]
+
+	if !ok {
+		return helpers.WrapErr(op, storage.ErrAppNotFound)
+	}
+
+	g.appID = app.ID
+	fn(g)
+
+	return nil
+}
+
+// orgID returns the id of the organization a name is in, zero for the global namespace, s.mu must be held
+func (s *Storage) orgID(name string) (uint64, error) {
+	orgName, _ := models.SplitName(name)
+
+	if orgName == "" {
+		return 0, nil
+	}
+
+	org, ok := s.orgs[orgName]
+
+	if !ok {
+		return 0, storage.ErrOrganizationNotFound
+	}
+
+	return org.ID, nil
+}
+
+func (s *Storage) SetTOTPSecret(ctx context.Context, name string, sealedSecret []byte) error {
+	const op = "storage.memory.SetTOTPSecret"
+
+	return s.modifyClient(ctx, op, name, func(client *models.Client) error {
+		client.TOTPSecret = bytes.Clone(sealedSecret)
+		client.TOTPEnabled = false
+		client.TOTPStep = 0
+		client.UpdatedAt = time.Unix(time.Now().Unix(), 0)
+
+		return nil
+	})
+}
+
+func (s *Storage) EnableTOTP(ctx context.Context, name string) error {
+	const op = "storage.memory.EnableTOTP"
+
+	return s.modifyClient(ctx, op, name, func(client *models.Client) error {
+		client.TOTPEnabled = true
+		client.UpdatedAt = time.Unix(time.Now().Unix(), 0)
+
+		return nil
+	})
+}
+
+func (s *Storage) UseTOTPStep(ctx context.Context, name string, step int64) error {
+	const op = "storage.memory.UseTOTPStep"
+
+	return s.modifyClient(ctx, op, name, func(client *models.Client) error {
+		if step <= client.TOTPStep {
+			return storage.ErrTOTPStepUsed
+		}
+
+		client.TOTPStep = step
+
+		return nil
+	})
+}
+
+func (s *Storage) ClearTOTP(ctx context.Context, name string) error {
+	const op = "storage.memory.ClearTOTP"
+
+	return s.modifyClient(ctx, op, name, func(client *models.Client) error {
+		client.TOTPSecret = nil
+		client.TOTPEnabled = false
+		client.TOTPStep = 0
+		client.UpdatedAt = time.Unix(time.Now().Unix(), 0)
+
+		return nil
+	})
+}
+
+func (s *Storage) SetClientEmail(ctx context.Context, name string, email string) error {
+	const op = "storage.memory.SetClientEmail"
+
+	return s.updateClient(ctx, op, name, func(client *models.Client) {
+		client.Email = email
+		client.EmailVerified = false
+	})
+}
+
+func (s *Storage) VerifyClientEmail(ctx context.Context, name string, email string) error {
+	const op = "storage.memory.VerifyClientEmail"
+
+	return s.modifyClient(ctx, op, name, func(client *models.Client) error {
+		if client.Email != email {
+			return storage.ErrEmailChanged
+		}
+
+		client.EmailVerified = true
+		client.UpdatedAt = time.Unix(time.Now().Unix(), 0)
+
+		return nil
+	})
+}
+
+func (s *Storage) Profile(ctx context.Context, name string) (models.Profile, error) {
+	const op = "storage.memory.Profile"
+
+	if err := ctx.Err(); err != nil {
+		return models.Profile{}, helpers.WrapErr(op, err)
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if _, ok := s.clients[name]; !ok {
+		return models.Profile{}, helpers.WrapErr(op, storage.ErrClientNotFound)
+	}
+
+	return s.profile(name), nil
+}
+
+func (s *Storage) UpdateProfile(ctx context.Context, name string, update models.ProfileUpdate) (models.Profile, error) {
+	const op = "storage.memory.UpdateProfile"
+
+	if err := ctx.Err(); err != nil {
+		return models.Profile{}, helpers.WrapErr(op, err)
+	}
+
+	defer s.lockWrite()()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.clients[name]; !ok {
+		return models.Profile{}, helpers.WrapErr(op, storage.ErrClientNotFound)
+	}
+
+	profile := s.profile(name)
+
+	if update.DisplayName != nil {
+		profile.DisplayName = *update.DisplayName
+	}
+
+	if update.Locale != nil {
+		profile.Locale = *update.Locale
+	}
+
+	if update.AvatarURL != nil {
+		profile.AvatarURL = *update.AvatarURL
+	}
+
+	if update.Metadata != nil {
+		profile.Metadata = *update.Metadata
+	}
+
+	profile.UpdatedAt = time.Unix(time.Now().Unix(), 0)
+	s.profiles[name] = profile
+
+	return profile, nil
+}
+
+// profile returns the stored profile of a client or an empty one, s.mu must be held
+func (s *Storage) profile(name string) models.Profile {
+	profile, ok := s.profiles[name]
+
+	if !ok {
+		profile.Metadata = "{}"
+	}
+
+	return profile
+}
+
+func (s *Storage) RecoveryCodes(ctx context.Context, name string) ([]models.RecoveryCode, error) {
+	const op = "storage.memory.RecoveryCodes"
+
+	if err := ctx.Err(); err != nil {
+		return nil, helpers.WrapErr(op, err)
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	codes := slices.Clone(s.recoveryCodes[name])
+
+	for i := range codes {
+		codes[i].Hash = bytes.Clone(codes[i].Hash)
+	}
+
+	return codes, nil
+}
+
+func (s *Storage) AddRecoveryCodes(ctx context.Context, name string, hashes [][]byte) error {
+	const op = "storage.memory.AddRecoveryCodes"
+
+	if err := ctx.Err(); err != nil {
+		return helpers.WrapErr(op, err)
+	}
+
+	defer s.lockWrite()()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.clients[name]; !ok {
+		return helpers.WrapErr(op, storage.ErrClientNotFound)
+	}
+
+	codes := slices.Clone(s.recoveryCodes[name])
+
+	for _, hash := range hashes {
+		s.lastID++
+		codes = append(codes, models.RecoveryCode{ID: int64(s.lastID), Hash: bytes.Clone(hash)})
+	}
+
+	s.recoveryCodes[name] = codes
+
+	return nil
+}
+
+func (s *Storage) UseRecoveryCode(ctx context.Context, name string, id int64) error {
+	const op = "storage.memory.UseRecoveryCode"
+
+	if err := ctx.Err(); err != nil {
+		return helpers.WrapErr(op, err)
+	}
+
+	defer s.lockWrite()()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	codes := s.recoveryCodes[name]
+
+	i := slices.IndexFunc(codes, func(code models.RecoveryCode) bool { return code.ID == id })
+
+	if i < 0 {
+		return helpers.WrapErr(op, storage.ErrRecoveryCodeUsed)
+	}
+
+	s.recoveryCodes[name] = slices.Delete(slices.Clone(codes), i, i+1)
+
+	return nil
+}
+
+func (s *Storage) DeleteRecoveryCodes(ctx context.Context, name string) error {
+	const op = "storage.memory.DeleteRecoveryCodes"
+
+	if err := ctx.Err(); err != nil {
+		return helpers.WrapErr(op, err)
+	}
+
+	defer s.lockWrite()()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.recoveryCodes, name)
+
+	return nil
+}
+
+func (s *Storage) SaveWebAuthnCredential(ctx context.Context, name string, credential models.WebAuthnCredential) error {
+	const op = "storage.memory.SaveWebAuthnCredential"
+
+	if err := ctx.Err(); err != nil {
+		return helpers.WrapErr(op, err)
+	}
+
+	defer s.lockWrite()()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.clients[name]; !ok {
+		return helpers.WrapErr(op, storage.ErrClientNotFound)
+	}
+
+	for _, credentials := range s.credentials {
+		if slices.ContainsFunc(credentials, func(c models.WebAuthnCredential) bool { return bytes.Equal(c.ID, credential.ID) }) {
+			return helpers.WrapErr(op, storage.ErrWebAuthnCredentialExists)
+		}
+	}
+
+	s.credentials[name] = append(slices.Clone(s.credentials[name]), models.WebAuthnCredential{
+		ID:        bytes.Clone(credential.ID),
+		PublicKey: bytes.Clone(credential.PublicKey),
+		SignCount: credential.SignCount,
+		CreatedAt: time.Unix(time.Now().Unix(), 0),
+	})
+
+	return nil
+}
+
+func (s *Storage) WebAuthnCredentials(ctx context.Context, name string) ([]models.WebAuthnCredential, error) {
+	const op = "storage.memory.WebAuthnCredentials"
+
+	if err := ctx.Err(); err != nil {
+		return nil, helpers.WrapErr(op, err)
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	credentials := slices.Clone(s.credentials[name])
+
+	for i := range credentials {
+		credentials[i].ID = bytes.Clone(credentials[i].ID)
+		credentials[i].PublicKey = bytes.Clone(credentials[i].PublicKey)
+	}
+
+	return credentials, nil
+}
+
+func (s *Storage) UpdateWebAuthnSignCount(ctx context.Context, name string, id []byte, signCount uint32) error {
+	const op = "storage.memory.UpdateWebAuthnSignCount"
+
+	return s.modifyCredentials(ctx, op, name, id, func(credentials []models.WebAuthnCredential, i int) []models.WebAuthnCredential {
+		credentials[i].SignCount = signCount
+		credentials[i].LastUsedAt = time.Unix(time.Now().Unix(), 0)
+
+		return credentials
+	})
+}
+
+func (s *Storage) DeleteWebAuthnCredential(ctx context.Context, name string, id []byte) error {
+	const op = "storage.memory.DeleteWebAuthnCredential"
+
+	return s.modifyCredentials(ctx, op, name, id, func(credentials []models.WebAuthnCredential, i int) []models.WebAuthnCredential {
+		return slices.Delete(credentials, i, i+1)
+	})
+}
+
+// modifyCredentials stores what modify makes of a copy of the credentials of a client, i is the one with id
+func (s *Storage) modifyCredentials(ctx context.Context, op string, name string, id []byte, modify func(credentials []models.WebAuthnCredential, i int) []models.WebAuthnCredential) error {
+	if err := ctx.Err(); err != nil {
+		return helpers.WrapErr(op, err)
+	}
+
+	defer s.lockWrite()()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	credentials := s.credentials[name]
+
+	i := slices.IndexFunc(credentials, func(c models.WebAuthnCredential) bool { return bytes.Equal(c.ID, id) })
+
+	if i < 0 {
+		return helpers.WrapErr(op, storage.ErrWebAuthnCredentialNotFound)
+	}
+
+	credentials = modify(slices.Clone(credentials), i)
+
+	if len(credentials) == 0 {
+		delete(s.credentials, name)
+		delete(s.profiles, name)
+	} else {
+		s.credentials[name] = credentials
+	}
+
+	return nil
+}
+
+func (s *Storage) SaveLoginTransaction(ctx context.Context, transaction models.LoginTransaction) error {
+	const op = "storage.memory.SaveLoginTransaction"
+
+	if err := ctx.Err(); err != nil {
+		return helpers.WrapErr(op, err)
+	}
+
+	defer s.lockWrite()()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	transaction.Required = slices.Clone(transaction.Required)
+	transaction.Completed = slices.Clone(transaction.Completed)
+	transaction.ExpiresAt = time.Unix(transaction.ExpiresAt.Unix(), 0)
+	transaction.CreatedAt = time.Unix(time.Now().Unix(), 0)
+	s.logins[transaction.ID] = transaction
+
+	return nil
+}
+
+func (s *Storage) LoginTransaction(ctx context.Context, id string) (models.LoginTransaction, error) {
+	const op = "storage.memory.LoginTransaction"
+
+	if err := ctx.Err(); err != nil {
+		return models.LoginTransaction{}, helpers.WrapErr(op, err)
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	transaction, ok := s.logins[id]
+
+	if !ok {
+		return models.LoginTransaction{}, helpers.WrapErr(op, storage.ErrLoginTransactionNotFound)
+	}
+
+	transaction.Required = slices.Clone(transaction.Required)
+	transaction.Completed = slices.Clone(transaction.Completed)
+
+	return transaction, nil
+}
+
+func (s *Storage) UpdateLoginFactors(ctx context.Context, id string, required []string, completed []string) error {
+	const op = "storage.memory.UpdateLoginFactors"
+
+	if err := ctx.Err(); err != nil {
+		return helpers.WrapErr(op, err)
+	}
+
+	defer s.lockWrite()()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	transaction, ok := s.logins[id]
+
+	if !ok {
+		return helpers.WrapErr(op, storage.ErrLoginTransactionNotFound)
+	}
+
+	transaction.Required = slices.Clone(required)
+	transaction.Completed = slices.Clone(completed)
+	s.logins[id] = transaction
+
+	return nil
+}
+
+func (s *Storage) DeleteLoginTransaction(ctx context.Context, id string) error {
+	const op = "storage.memory.DeleteLoginTransaction"
+
+	if err := ctx.Err(); err != nil {
+		return helpers.WrapErr(op, err)
+	}
+
+	defer s.lockWrite()()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.logins[id]; !ok {
+		return helpers.WrapErr(op, storage.ErrLoginTransactionNotFound)
+	}
+
+	delete(s.logins, id)
+
+	return nil
+}
+
+func (s *Storage) DeleteExpiredLoginTransactions(ctx context.Context, now time.Time) (int64, error) {
+	const op = "storage.memory.DeleteExpiredLoginTransactions"
+
+	if err := ctx.Err(); err != nil {
+		return 0, helpers.WrapErr(op, err)
+	}
+
+	defer s.lockWrite()()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var n int64
+
+	for id, transaction := range s.logins {
+		if !now.Before(transaction.ExpiresAt) {
+			delete(s.logins, id)
+			n++
+		}
+	}
+
+	return n, nil
+}
+
+func (s *Storage) SavePasswordReset(ctx context.Context, reset models.PasswordReset) error {
+	const op = "storage.memory.SavePasswordReset"
+
+	if err := ctx.Err(); err != nil {
+		return helpers.WrapErr(op, err)
+	}
+
+	defer s.lockWrite()()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.clients[reset.ClientName]; !ok {
+		return helpers.WrapErr(op, storage.ErrClientNotFound)
+	}
+
+	reset.ExpiresAt = time.Unix(reset.ExpiresAt.Unix(), 0)
+	reset.CreatedAt = time.Unix(time.Now().Unix(), 0)
+	s.resets[reset.ID] = reset
+
+	return nil
+}
+
+func (s *Storage) PasswordReset(ctx context.Context, id string) (models.PasswordReset, error) {
+	const op = "storage.memory.PasswordReset"
+
+	if err := ctx.Err(); err != nil {
+		return models.PasswordReset{}, helpers.WrapErr(op, err)
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	reset, ok := s.resets[id]
+
+	if !ok {
+		return models.PasswordReset{}, helpers.WrapErr(op, storage.ErrPasswordResetNotFound)
+	}
+
+	return reset, nil
+}
+
+func (s *Storage) DeletePasswordReset(ctx context.Context, id string) error {
+	const op = "storage.memory.DeletePasswordReset"
+
+	if err := ctx.Err(); err != nil {
+		return helpers.WrapErr(op, err)
+	}
+
+	defer s.lockWrite()()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.resets[id]; !ok {
+		return helpers.WrapErr(op, storage.ErrPasswordResetNotFound)
+	}
+
+	delete(s.resets, id)
+
+	return nil
+}
+
+func (s *Storage) DeletePasswordResets(ctx context.Context, name string) error {
+	const op = "storage.memory.DeletePasswordResets"
+
+	if err := ctx.Err(); err != nil {
+		return helpers.WrapErr(op, err)
+	}
+
+	defer s.lockWrite()()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for id, reset := range s.resets {
+		if reset.ClientName == name {
+			delete(s.resets, id)
+		}
+	}
+
+	return nil
+}
+
+func (s *Storage) DeleteExpiredPasswordResets(ctx context.Context, now time.Time) (int64, error) {
+	const op = "storage.memory.DeleteExpiredPasswordResets"
+
+	if err := ctx.Err(); err != nil {
+		return 0, helpers.WrapErr(op, err)
+	}
+
+	defer s.lockWrite()()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var n int64
+
+	for id, reset := range s.resets {
+		if !now.Before(reset.ExpiresAt) {
+			delete(s.resets, id)
+			n++
+		}
+	}
+
+	return n, nil
+}
+
+// updateClient applies update and revokes the tokens of the client
+func (s *Storage) updateClient(ctx context.Context, op string, name string, update func(client *models.Client)) error {
+	return s.modifyClient(ctx, op, name, func(client *models.Client) error {
+		update(client)
+		client.TokenVersion++
+		client.UpdatedAt = time.Unix(time.Now().Unix(), 0)
+
+		return nil
+	})
+}
+
+// modifyClient stores the client changed by modify unless it fails
+func (s *Storage) modifyClient(ctx context.Context, op string, name string, modify func(client *models.Client) error) error {
+	if err := ctx.Err(); err != nil {
+		return helpers.WrapErr(op, err)
+	}
+
+	defer s.lockWrite()()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	client, ok := s.clients[name]
+
+	if !ok {
+		return helpers.WrapErr(op, storage.ErrClientNotFound)
+	}
+
+	if err := modify(&client); err != nil {
+		return helpers.WrapErr(op, err)
+	}
+
+	s.clients[name] = client
+
+	return nil
+}
+
+func (s *Storage) SaveApp(ctx context.Context, name string, secret string, roles string) (int64, error) {
+	const op = "storage.memory.SaveApp"
+
+	if err := ctx.Err(); err != nil {
+		return 0, helpers.WrapErr(op, err)
+	}
+
+	defer s.lockWrite()()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.apps[name]; ok {
+		return 0, helpers.WrapErr(op, storage.ErrAppExists)
+	}
+
+	orgID, err := s.orgID(name)
+
+	if err != nil {
+		return 0, helpers.WrapErr(op, err)
+	}
+
+	s.lastID++
+	now := time.Unix(time.Now().Unix(), 0)
+
+	s.apps[name] = models.App{
+		ID:        s.lastID,
+		Name:      name,
+		OrgID:     orgID,
+		Secret:    secret,
+		Roles:     roles,
+		Settings:  "{}",
+		Version:   1,
+		CreatedAt: now,
+		UpdatedAt: now,
+	}
+
+	return int64(s.lastID), nil
+}
+
+func (s *Storage) App(ctx context.Context, name string) (models.App, error) {
+	const op = "storage.memory.App"
+
+	if err := ctx.Err(); err != nil {
+		return models.App{}, helpers.WrapErr(op, err)
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	app, ok := s.apps[name]
+
+	if !ok {
+		return models.App{}, helpers.WrapErr(op, storage.ErrAppNotFound)
+	}
+
+	return app, nil
+}
+
+func (s *Storage) UpdateApp(ctx context.Context, name string, version int64, update models.AppUpdate) (int64, error) {
+	const op = "storage.memory.UpdateApp"
+
+	if err := ctx.Err(); err != nil {
+		return 0, helpers.WrapErr(op, err)
+	}
+
+	defer s.lockWrite()()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	app, ok := s.apps[name]
+
+	if !ok {
+		return 0, helpers.WrapErr(op, storage.ErrAppNotFound)
+	}
+
+	if app.Version != version {
+		return 0, helpers.WrapErr(op, storage.ErrAppConflict)
+	}
+
+	if update.DisplayName != nil {
+		app.DisplayName = *update.DisplayName
+	}
+
+	if update.Roles != nil {
+		app.Roles = *update.Roles
+	}
+
+	if update.Settings != nil {
+		app.Settings = *update.Settings
+	}
+
+	if update.RequireMFA != nil {
+		app.RequireMFA = *update.RequireMFA
+	}
+
+	if update.RequireVerifiedEmail != nil {
+		app.RequireVerifiedEmail = *update.RequireVerifiedEmail
+	}
+
+	if update.ProfileClaims != nil {
+		app.ProfileClaims = *update.ProfileClaims
+	}
+
+	if update.RoleGrants != nil {
+		app.RoleGrants = *update.RoleGrants
+	}
+
+	if update.GroupsClaim != nil {
+		app.GroupsClaim = *update.GroupsClaim
+	}
+
+	if update.OrgClients != nil {
+		app.OrgClients = *update.OrgClients
+	}
+
+	app.Version++
+	app.UpdatedAt = time.Unix(time.Now().Unix(), 0)
+	s.apps[name] = app
+
+	return app.Version, nil
+}
+
+func (s *Storage) DeleteApp(ctx context.Context, name string) error {
+	const op = "storage.memory.DeleteApp"
+
+	if err := ctx.Err(); err != nil {
+		return helpers.WrapErr(op, err)
+	}
+
+	defer s.lockWrite()()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	app, ok := s.apps[name]
+
+	if !ok {
+		return helpers.WrapErr(op, storage.ErrAppNotFound)
+	}
+
+	delete(s.apps, name)
+	maps.DeleteFunc(s.grants, func(g roleGrant, _ struct{}) bool { return g.appID == app.ID })
+
+	return nil
+}
+
+func (s *Storage) ListClients(ctx context.Context, q models.ListQuery) ([]models.Client, string, error) {
+	const op = "storage.memory.ListClients"
+
+	if err := ctx.Err(); err != nil {
+		return nil, "", helpers.WrapErr(op, err)
+	}
+
+	s.mu.RLock()
+	clients := slices.Collect(maps.Values(s.clients))
+
+	for i := range clients {
+		clients[i].WebAuthnEnabled = len(s.credentials[clients[i].Name]) > 0
+	}
+
+	s.mu.RUnlock()
+
+	clients, next, err := page(clients, q, func(client models.Client) listKey {
+		return listKey{client.ID, client.Name, client.CreatedAt, client.UpdatedAt}
+	})
+
+	if err != nil {
+		return nil, "", helpers.WrapErr(op, err)
+	}
+
+	for i := range clients {
+		clients[i].PasswordHash = nil
+		clients[i].TOTPSecret = nil
+		clients[i].TOTPStep = 0
+	}
+
+	return clients, next, nil
+}
+
+func (s *Storage) ListApps(ctx context.Context, q models.ListQuery) ([]models.App, string, error) {
+	const op = "storage.memory.ListApps"
+
+	if err := ctx.Err(); err != nil {
+		return nil, "", helpers.WrapErr(op, err)
+	}
+
+	s.mu.RLock()
+	apps := slices.Collect(maps.Values(s.apps))
+	s.mu.RUnlock()
+
+	apps, next, err := page(apps, q, func(app models.App) listKey {
+		return listKey{app.ID, app.Name, app.CreatedAt, app.UpdatedAt}
+	})
+
+	if err != nil {
+		return nil, "", helpers.WrapErr(op, err)
+	}
+
+	for i := range apps {
+		apps[i].Secret = ""
+	}
+
+	return apps, next, nil
+}
+
+type listKey struct {
+	id        uint64
+	name      string
+	createdAt time.Time
+	updatedAt time.Time
+}
+
+// page does what the WHERE, ORDER BY and LIMIT of the sql backends do
+func page[T any](items []T, q models.ListQuery, key func(T) listKey) ([]T, string, error) {
+	column, err := storage.SortColumn(q)
+
+	if err != nil {
+		return nil, "", err
+	}
+
+	cursor, ok, err := storage.DecodeCursor(q)
+
+	if err != nil {
+		return nil, "", err
+	}
+
+	compare := func(k listKey, name string, at int64, id uint64) int {
+		var c int
+
+		switch column {
+		case "created_at":
+			c = cmp.Compare(k.createdAt.Unix(), at)
+		case "updated_at":
+			c = cmp.Compare(k.updatedAt.Unix(), at)
+		default:
+			c = strings.Compare(k.name, name)
+		}
+
+		if c == 0 {
+			c = cmp.Compare(k.id, id)
+		}
+
+		if q.Desc {
+			return -c
+		}
+
+		return c
+	}
+
+	items = slices.DeleteFunc(items, func(item T) bool {
+		k := key(item)
+
+		return !strings.HasPrefix(k.name, q.NamePrefix) || ok && compare(k, cursor.Name, cursor.At, cursor.ID) <= 0
+	})
+
+	slices.SortFunc(items, func(a, b T) int {
+		kb := key(b)
+
+		return compare(key(a), kb.name, sortTime(column, kb), kb.id)
+	})
+
+	limit := storage.PageLimit(q)
+
+	if len(items) <= limit {
+		return items, "", nil
+	}
+
+	items = items[:limit]
+	last := key(items[limit-1])
+
+	return items, storage.NewCursor(q, last.id, last.name, last.createdAt, last.updatedAt), nil
+}
+
+func sortTime(column string, k listKey) int64 {
+	if column == "updated_at" {
+		return k.updatedAt.Unix()
+	}
+
+	return k.createdAt.Unix()
+}
+
+func (s *Storage) LoginAttempts(ctx context.Context, key string) (models.LoginAttempts, error) {
+	const op = "storage.memory.LoginAttempts"
+
+	if err := ctx.Err(); err != nil {
+		return models.LoginAttempts{}, helpers.WrapErr(op, err)
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	attempts, ok := s.loginAttempts[key]
+
+	if !ok {
+		return models.LoginAttempts{Key: key}, nil
+	}
+
+	return attempts, nil
+}
+
+func (s *Storage) IncrementFailedLogins(ctx context.Context, key string, at time.Time, resetBefore time.Time) (models.LoginAttempts, error) {
+	const op = "storage.memory.IncrementFailedLogins"
+
+	if err := ctx.Err(); err != nil {
+		return models.LoginAttempts{}, helpers.WrapErr(op, err)
+	}
+
+	defer s.lockWrite()()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	attempts, ok := s.loginAttempts[key]
+
+	if !ok {
+		attempts = models.LoginAttempts{Key: key, LockedUntil: time.Unix(0, 0)}
+	}
+
+	// same second precision as the sql backends
+	at = time.Unix(at.Unix(), 0)
+
+	if attempts.LastFailureAt.Unix() < resetBefore.Unix() {
+		attempts.Failures = 1
+	} else {
+		attempts.Failures++
+	}
+
+	attempts.LastFailureAt = at
+	s.loginAttempts[key] = attempts
+
+	return attempts, nil
+}
+
+func (s *Storage) LockLogin(ctx context.Context, key string, until time.Time) error {
+	const op = "storage.memory.LockLogin"
+
+	if err := ctx.Err(); err != nil {
+		return helpers.WrapErr(op, err)
+	}
+
+	defer s.lockWrite()()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	attempts, ok := s.loginAttempts[key]
+
+	if !ok {
+		attempts = models.LoginAttempts{Key: key, LastFailureAt: time.Unix(0, 0)}
+	}
+
+	attempts.LockedUntil = time.Unix(until.Unix(), 0)
+	s.loginAttempts[key] = attempts
+
+	return nil
+}
+
+func (s *Storage) ResetLoginAttempts(ctx context.Context, key string) error {
+	const op = "storage.memory.ResetLoginAttempts"
+
+	if err := ctx.Err(); err != nil {
+		return helpers.WrapErr(op, err)
+	}
+
+	defer s.lockWrite()()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.loginAttempts, key)
+
+	return nil
+}
+
+func (s *Storage) Stop() {}
@@ -0,0 +1,58 @@
+package memory
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"ssosage/internal/interfaces"
+	"ssosage/internal/storage"
+	"ssosage/internal/storage/storagetest"
+	"testing"
+)
+
+func TestConformance(t *testing.T) {
+	storagetest.Run(t, func(t *testing.T) interfaces.Storage {
+		return New()
+	})
+}
+
+func TestUncommittedWritesAreInvisible(t *testing.T) {
+	s := New()
+	ctx := context.Background()
+
+	rollback := errors.New("rollback")
+
+	for _, want := range []error{nil, rollback} {
+		name := fmt.Sprintf("client-%v", want)
+
+		err := s.WithinTx(ctx, func(repo interfaces.Repository) error {
+			if _, err := repo.SaveClient(ctx, name, []byte("hash")); err != nil {
+				return err
+			}
+
+			if _, err := repo.Client(ctx, name); err != nil {
+				t.Fatalf("expected the transaction to see its own write, got: %v", err)
+			}
+
+			if _, err := s.Client(ctx, name); !errors.Is(err, storage.ErrClientNotFound) {
+				t.Fatalf("expected the write to be invisible outside the transaction, got: %v", err)
+			}
+
+			return want
+		})
+
+		if !errors.Is(err, want) {
+			t.Fatalf("expected %v, got: %v", want, err)
+		}
+
+		_, err = s.Client(ctx, name)
+
+		if want == nil && err != nil {
+			t.Fatalf("expected the committed client, got: %v", err)
+		}
+
+		if want != nil && !errors.Is(err, storage.ErrClientNotFound) {
+			t.Fatalf("expected the rolled back client to be gone, got: %v", err)
+		}
+	}
+}
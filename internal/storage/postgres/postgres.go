@@ -0,0 +1,1250 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"ssosage/internal/helpers"
+	"ssosage/internal/interfaces"
+	"ssosage/internal/models"
+	"ssosage/internal/storage"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgconn"
+	_ "github.com/jackc/pgx/v5/stdlib"
+)
+
+const uniqueViolation = "23505"
+
+/*
+implements ClientSaver, ClientProvider, AppSaver, AppProvider, LoginAttemptsTracker, Transactor
+*/
+type Storage struct {
+	db *sql.DB
+	tx *sql.Tx
+}
+
+// querier is what *sql.DB and *sql.Tx have in common
+type querier interface {
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+}
+
+func New(dsn string) (*Storage, error) {
+
+	const op = "storage.postgres.New"
+
+	db, err := sql.Open("pgx", dsn)
+
+	if err != nil {
+		return nil, helpers.WrapErr(op, err)
+	}
+
+	return &Storage{db: db}, nil
+
+}
+
+func (s *Storage) WithinTx(ctx context.Context, fn func(repo interfaces.Repository) error) error {
+	const op = "storage.postgres.WithinTx"
+
+	if s.tx != nil {
+		return fn(s)
+	}
+
+	tx, err := s.db.BeginTx(ctx, nil)
+
+	if err != nil {
+		return helpers.WrapErr(op, err)
+	}
+
+	defer tx.Rollback()
+
+	if err := fn(&Storage{db: s.db, tx: tx}); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return helpers.WrapErr(op, err)
+	}
+
+	return nil
+}
+
+// q returns the running transaction, if there is one
+func (s *Storage) q() querier {
+	if s.tx != nil {
+		return s.tx
+	}
+
+	return s.db
+}
+
+func (s *Storage) SaveClient(ctx context.Context, name string, passwordHash []byte) (int64, error) {
+
+	const op = "storage.postgres.SaveClient"
+
+	var id int64
+
+	org, _ := models.SplitName(name)
+
+	err := s.q().QueryRowContext(ctx, `INSERT INTO clients(name, password_hash, org_id, created_at, updated_at)
+		SELECT $1, $2, o.id, $3, $3 FROM (SELECT 1) AS one LEFT JOIN organizations o ON o.name = $4 WHERE $4 = '' OR o.id IS NOT NULL
+		RETURNING id`, name, passwordHash, time.Now().Unix(), org).Scan(&id)
+
+	if err != nil {
+		if isUniqueViolation(err) {
+			return 0, helpers.WrapErr(op, storage.ErrClientExists)
+		}
+
+		if errors.Is(err, sql.ErrNoRows) {
+			return 0, helpers.WrapErr(op, storage.ErrOrganizationNotFound)
+		}
+
+		return 0, helpers.WrapErr(op, err)
+	}
+
+	return id, nil
+
+}
+
+func (s *Storage) Client(ctx context.Context, name string) (models.Client, error) {
+	const op = "storage.postgres.Client"
+
+	row := s.q().QueryRowContext(ctx, `SELECT id, name, COALESCE(org_id, 0), password_hash, status, token_version, totp_secret, totp_enabled, totp_step, email, email_verified,
+			EXISTS(SELECT 1 FROM webauthn_credentials w WHERE w.client_id = clients.id), created_at, updated_at
+		FROM clients WHERE name = $1`, name)
+
+	var client models.Client
+	var createdAt, updatedAt int64
+
+	err := row.Scan(&client.ID, &client.Name, &client.OrgID, &client.PasswordHash, &client.Status, &client.TokenVersion,
+		&client.TOTPSecret, &client.TOTPEnabled, &client.TOTPStep, &client.Email, &client.EmailVerified, &client.WebAuthnEnabled, &createdAt, &updatedAt)
+
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return models.Client{}, helpers.WrapErr(op, storage.ErrClientNotFound)
+		}
+
+		return models.Client{}, helpers.WrapErr(op, err)
+	}
+
+	client.CreatedAt, client.UpdatedAt = time.Unix(createdAt, 0), time.Unix(updatedAt, 0)
+
+	return client, nil
+}
+
+func (s *Storage) UpdateClientPassword(ctx context.Context, name string, passwordHash []byte) error {
+	const op = "storage.postgres.UpdateClientPassword"
+
+	res, err := s.q().ExecContext(ctx, "UPDATE clients SET password_hash = $1, token_version = token_version + 1, updated_at = $2 WHERE name = $3", passwordHash, time.Now().Unix(), name)
+
+	return clientAffected(op, res, err)
+}
+
+func (s *Storage) SetClientStatus(ctx context.Context, name string, status string) error {
+	const op = "storage.postgres.SetClientStatus"
+
+	res, err := s.q().ExecContext(ctx, "UPDATE clients SET status = $1, token_version = token_version + 1, updated_at = $2 WHERE name = $3", status, time.Now().Unix(), name)
+
+	return clientAffected(op, res, err)
+}
+
+func (s *Storage) DeleteClient(ctx context.Context, name string) error {
+	const op = "storage.postgres.DeleteClient"
+
+	res, err := s.q().ExecContext(ctx, "DELETE FROM clients WHERE name = $1", name)
+
+	return clientAffected(op, res, err)
+}
+
+func (s *Storage) SaveOrganization(ctx context.Context, name string) (int64, error) {
+	const op = "storage.postgres.SaveOrganization"
+
+	var id int64
+
+	err := s.q().QueryRowContext(ctx, "INSERT INTO organizations(name, created_at) VALUES($1, $2) RETURNING id", name, time.Now().Unix()).Scan(&id)
+
+	if err != nil {
+		if isUniqueViolation(err) {
+			return 0, helpers.WrapErr(op, storage.ErrOrganizationExists)
+		}
+
+		return 0, helpers.WrapErr(op, err)
+	}
+
+	return id, nil
+}
+
+func (s *Storage) Organization(ctx context.Context, name string) (models.Organization, error) {
+	const op = "storage.postgres.Organization"
+
+	var org models.Organization
+	var createdAt int64
+
+	err := s.q().QueryRowContext(ctx, "SELECT id, name, created_at FROM organizations WHERE name = $1", name).Scan(&org.ID, &org.Name, &createdAt)
+
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return models.Organization{}, helpers.WrapErr(op, storage.ErrOrganizationNotFound)
+		}
+
+		return models.Organization{}, helpers.WrapErr(op, err)
+	}
+
+	org.CreatedAt = time.Unix(createdAt, 0)
+
+	return org, nil
+}
+
+func (s *Storage) DeleteOrganization(ctx context.Context, name string) error {
+	const op = "storage.postgres.DeleteOrganization"
+
+	res, err := s.q().ExecContext(ctx, `DELETE FROM organizations WHERE name = $1
+		AND NOT EXISTS(SELECT 1 FROM clients WHERE org_id = organizations.id) AND NOT EXISTS(SELECT 1 FROM apps WHERE org_id = organizations.id)
+		AND NOT EXISTS(SELECT 1 FROM client_groups WHERE starts_with(client_groups.name, organizations.name || '/'))`, name)
+
+	if err != nil {
+		return helpers.WrapErr(op, err)
+	}
+
+	n, err := res.RowsAffected()
+
+	if err != nil {
+		return helpers.WrapErr(op, err)
+	}
+
+	if n == 0 {
+		// either there is no such organization or it still has members
+		if _, err := s.Organization(ctx, name); err != nil {
+			return helpers.WrapErr(op, err)
+		}
+
+		return helpers.WrapErr(op, storage.ErrOrganizationNotEmpty)
+	}
+
+	return nil
+}
+
+func (s *Storage) SaveGroup(ctx context.Context, name string) (int64, error) {
+	const op = "storage.postgres.SaveGroup"
+
+	var id int64
+
+	err := s.q().QueryRowContext(ctx, "INSERT INTO client_groups(name, created_at) VALUES($1, $2) RETURNING id", name, time.Now().Unix()).Scan(&id)
+
+	if err != nil {
+		if isUniqueViolation(err) {
+			return 0, helpers.WrapErr(op, storage.ErrGroupExists)
+		}
+
+		return 0, helpers.WrapErr(op, err)
+	}
+
+	return id, nil
+}
+
+func (s *Storage) Group(ctx context.Context, name string) (models.Group, error) {
+	const op = "storage.postgres.Group"
+
+	var group models.Group
+	var createdAt int64
+
+	err := s.q().QueryRowContext(ctx, "SELECT id, name, created_at FROM client_groups WHERE name = $1", name).Scan(&group.ID, &group.Name, &createdAt)
+
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return models.Group{}, helpers.WrapErr(op, storage.ErrGroupNotFound)
+		}
+
+		return models.Group{}, helpers.WrapErr(op, err)
+	}
+
+	group.CreatedAt = time.Unix(createdAt, 0)
+
+	return group, nil
+}
+
+// DeleteGroup takes the memberships and the role grants of the group with it
+func (s *Storage) DeleteGroup(ctx context.Context, name string) error {
+	const op = "storage.postgres.DeleteGroup"
+
+	res, err := s.q().ExecContext(ctx, "DELETE FROM client_groups WHERE name = $1", name)
+
+	return groupAffected(op, res, err)
+}
+
+func (s *Storage) AddGroupMember(ctx context.Context, group string, clientName string) error {
+	const op = "storage.postgres.AddGroupMember"
+
+	res, err := s.q().ExecContext(ctx, `INSERT INTO group_members(group_id, client_id)
+		SELECT g.id, c.id FROM client_groups g, clients c WHERE g.name = $1 AND c.name = $2 ON CONFLICT DO NOTHING`, group, clientName)
+
+	return s.membershipAffected(ctx, op, group, clientName, res, err)
+}
+
+func (s *Storage) RemoveGroupMember(ctx context.Context, group string, clientName string) error {
+	const op = "storage.postgres.RemoveGroupMember"
+
+	res, err := s.q().ExecContext(ctx, `DELETE FROM group_members
+		WHERE group_id = (SELECT id FROM client_groups WHERE name = $1) AND client_id = (SELECT id FROM clients WHERE name = $2)`, group, clientName)
+
+	return s.membershipAffected(ctx, op, group, clientName, res, err)
+}
+
+func (s *Storage) GrantRole(ctx context.Context, grant models.RoleGrant) error {
+	const op = "storage.postgres.GrantRole"
+
+	query, subject := `INSERT INTO client_roles(client_id, app_id, role)
+		SELECT c.id, a.id, $1 FROM clients c, apps a WHERE c.name = $2 AND a.name = $3 ON CONFLICT DO NOTHING`, grant.ClientName
+
+	if grant.GroupName != "" {
+		query, subject = `INSERT INTO group_roles(group_id, app_id, role)
+			SELECT g.id, a.id, $1 FROM client_groups g, apps a WHERE g.name = $2 AND a.name = $3 ON CONFLICT DO NOTHING`, grant.GroupName
+	}
+
+	res, err := s.q().ExecContext(ctx, query, grant.Role, subject, grant.AppName)
+
+	return s.grantAffected(ctx, op, grant, res, err)
+}
+
+func (s *Storage) RevokeRole(ctx context.Context, grant models.RoleGrant) error {
+	const op = "storage.postgres.RevokeRole"
+
+	query, subject := `DELETE FROM client_roles WHERE role = $1
+		AND client_id = (SELECT id FROM clients WHERE name = $2) AND app_id = (SELECT id FROM apps WHERE name = $3)`, grant.ClientName
+
+	if grant.GroupName != "" {
+		query, subject = `DELETE FROM group_roles WHERE role = $1
+			AND group_id = (SELECT id FROM client_groups WHERE name = $2) AND app_id = (SELECT id FROM apps WHERE name = $3)`, grant.GroupName
+	}
+
+	res, err := s.q().ExecContext(ctx, query, grant.Role, subject, grant.AppName)
+
+	return s.grantAffected(ctx, op, grant, res, err)
+}
+
+func (s *Storage) ClientGroups(ctx context.Context, clientName string) ([]string, error) {
+	const op = "storage.postgres.ClientGroups"
+
+	rows, err := s.q().QueryContext(ctx, `SELECT g.name FROM client_groups g JOIN group_members m ON m.group_id = g.id
+		JOIN clients c ON c.id = m.client_id WHERE c.name = $1 ORDER BY g.name`, clientName)
+
+	names, err := scanNames(rows, err)
+
+	if err != nil {
+		return nil, helpers.WrapErr(op, err)
+	}
+
+	return names, nil
+}
+
+func (s *Storage) ClientRoles(ctx context.Context, clientName string, appName string) ([]string, error) {
+	const op = "storage.postgres.ClientRoles"
+
+	rows, err := s.q().QueryContext(ctx, `SELECT r.role FROM client_roles r JOIN clients c ON c.id = r.client_id JOIN apps a ON a.id = r.app_id
+			WHERE c.name = $1 AND a.name = $2
+		UNION SELECT r.role FROM group_roles r JOIN group_members m ON m.group_id = r.group_id
+			JOIN clients c ON c.id = m.client_id JOIN apps a ON a.id = r.app_id WHERE c.name = $1 AND a.name = $2
+		ORDER BY 1`, clientName, appName)
+
+	roles, err := scanNames(rows, err)
+
+	if err != nil {
+		return nil, helpers.WrapErr(op, err)
+	}
+
+	return roles, nil
+}
+
+// membershipAffected tells a missing group or client from a membership that was already there or gone
+func (s *Storage) membershipAffected(ctx context.Context, op string, group string, clientName string, res sql.Result, err error) error {
+	if err := groupAffected(op, res, err); err == nil || !errors.Is(err, storage.ErrGroupNotFound) {
+		return err
+	}
+
+	if _, err := s.Group(ctx, group); err != nil {
+		return helpers.WrapErr(op, err)
+	}
+
+	if _, err := s.Client(ctx, clientName); err != nil {
+		return helpers.WrapErr(op, err)
+	}
+
+	return nil
+}
+
+// grantAffected tells a missing client, group or app from a grant that was already there or gone
+func (s *Storage) grantAffected(ctx context.Context, op string, grant models.RoleGrant, res sql.Result, err error) error {
+	if err := groupAffected(op, res, err); err == nil || !errors.Is(err, storage.ErrGroupNotFound) {
+		return err
+	}
+
+	if grant.GroupName != "" {
+		if _, err := s.Group(ctx, grant.GroupName); err != nil {
+			return helpers.WrapErr(op, err)
+		}
+	} else if _, err := s.Client(ctx, grant.ClientName); err != nil {
+		return helpers.WrapErr(op, err)
+	}
+
+	if _, err := s.App(ctx, grant.AppName); err != nil {
+		return helpers.WrapErr(op, err)
+	}
+
+	return nil
+}
+
+func scanNames(rows *sql.Rows, err error) ([]string, error) {
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	names := []string{}
+
+	for rows.Next() {
+		var name string
+
+		if err := rows.Scan(&name); err != nil {
+			return nil, err
+		}
+
+		names = append(names, name)
+	}
+
+	return names, rows.Err()
+}
+
+func (s *Storage) SetTOTPSecret(ctx context.Context, name string, sealedSecret []byte) error {
+	const op = "storage.postgres.SetTOTPSecret"
+
+	res, err := s.q().ExecContext(ctx, "UPDATE clients SET totp_secret = $1, totp_enabled = false, totp_step = 0, updated_at = $2 WHERE name = $3", sealedSecret, time.Now().Unix(), name)
+
+	return clientAffected(op, res, err)
+}
+
+func (s *Storage) EnableTOTP(ctx context.Context, name string) error {
+	const op = "storage.postgres.EnableTOTP"
+
+	res, err := s.q().ExecContext(ctx, "UPDATE clients SET totp_enabled = true, updated_at = $1 WHERE name = $2", time.Now().Unix(), name)
+
+	return clientAffected(op, res, err)
+}
+
+func (s *Storage) UseTOTPStep(ctx context.Context, name string, step int64) error {
+	const op = "storage.postgres.UseTOTPStep"
+
+	res, err := s.q().ExecContext(ctx, "UPDATE clients SET totp_step = $1 WHERE name = $2 AND totp_step < $1", step, name)
+
+	if err := clientAffected(op, res, err); err != nil {
+		if !errors.Is(err, storage.ErrClientNotFound) {
+			return err
+		}
+
+		// either there is no such client or the step was already used
+		if _, err := s.Client(ctx, name); err != nil {
+			return helpers.WrapErr(op, err)
+		}
+
+		return helpers.WrapErr(op, storage.ErrTOTPStepUsed)
+	}
+
+	return nil
+}
+
+func (s *Storage) ClearTOTP(ctx context.Context, name string) error {
+	const op = "storage.postgres.ClearTOTP"
+
+	res, err := s.q().ExecContext(ctx, "UPDATE clients SET totp_secret = NULL, totp_enabled = false, totp_step = 0, updated_at = $1 WHERE name = $2", time.Now().Unix(), name)
+
+	return clientAffected(op, res, err)
+}
+
+func (s *Storage) SetClientEmail(ctx context.Context, name string, email string) error {
+	const op = "storage.postgres.SetClientEmail"
+
+	res, err := s.q().ExecContext(ctx, "UPDATE clients SET email = $1, email_verified = false, token_version = token_version + 1, updated_at = $2 WHERE name = $3",
+		email, time.Now().Unix(), name)
+
+	return clientAffected(op, res, err)
+}
+
+func (s *Storage) VerifyClientEmail(ctx context.Context, name string, email string) error {
+	const op = "storage.postgres.VerifyClientEmail"
+
+	res, err := s.q().ExecContext(ctx, "UPDATE clients SET email_verified = true, updated_at = $1 WHERE name = $2 AND email = $3", time.Now().Unix(), name, email)
+
+	if err := clientAffected(op, res, err); err != nil {
+		if !errors.Is(err, storage.ErrClientNotFound) {
+			return err
+		}
+
+		// either there is no such client or its email is another one by now
+		if _, err := s.Client(ctx, name); err != nil {
+			return helpers.WrapErr(op, err)
+		}
+
+		return helpers.WrapErr(op, storage.ErrEmailChanged)
+	}
+
+	return nil
+}
+
+func (s *Storage) Profile(ctx context.Context, name string) (models.Profile, error) {
+	const op = "storage.postgres.Profile"
+
+	row := s.q().QueryRowContext(ctx, `SELECT COALESCE(p.display_name, ''), COALESCE(p.locale, ''), COALESCE(p.avatar_url, ''),
+			COALESCE(p.metadata, '{}'), COALESCE(p.updated_at, 0)
+		FROM clients c LEFT JOIN client_profiles p ON p.client_id = c.id WHERE c.name = $1`, name)
+
+	profile, err := scanProfile(row)
+
+	if err != nil {
+		return models.Profile{}, helpers.WrapErr(op, err)
+	}
+
+	return profile, nil
+}
+
+func (s *Storage) UpdateProfile(ctx context.Context, name string, update models.ProfileUpdate) (models.Profile, error) {
+	const op = "storage.postgres.UpdateProfile"
+
+	row := s.q().QueryRowContext(ctx, `INSERT INTO client_profiles(client_id, display_name, locale, avatar_url, metadata, updated_at)
+			SELECT id, COALESCE($1, ''), COALESCE($2, ''), COALESCE($3, ''), COALESCE($4, '{}'), $5 FROM clients WHERE name = $6
+		ON CONFLICT (client_id) DO UPDATE SET display_name = COALESCE($1, client_profiles.display_name),
+			locale = COALESCE($2, client_profiles.locale), avatar_url = COALESCE($3, client_profiles.avatar_url),
+			metadata = COALESCE($4, client_profiles.metadata), updated_at = $5
+		RETURNING display_name, locale, avatar_url, metadata, updated_at`,
+		update.DisplayName, update.Locale, update.AvatarURL, update.Metadata, time.Now().Unix(), name)
+
+	profile, err := scanProfile(row)
+
+	if err != nil {
+		return models.Profile{}, helpers.WrapErr(op, err)
+	}
+
+	return profile, nil
+}
+
+// scanProfile returns ErrClientNotFound for no row, a profile never updated has no update time
+func scanProfile(row *sql.Row) (models.Profile, error) {
+	var profile models.Profile
+	var updatedAt int64
+
+	if err := row.Scan(&profile.DisplayName, &profile.Locale, &profile.AvatarURL, &profile.Metadata, &updatedAt); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return models.Profile{}, storage.ErrClientNotFound
+		}
+
+		return models.Profile{}, err
+	}
+
+	if updatedAt > 0 {
+		profile.UpdatedAt = time.Unix(updatedAt, 0)
+	}
+
+	return profile, nil
+}
+
+func (s *Storage) RecoveryCodes(ctx context.Context, name string) ([]models.RecoveryCode, error) {
+	const op = "storage.postgres.RecoveryCodes"
+
+	rows, err := s.q().QueryContext(ctx, `SELECT r.id, r.code_hash FROM recovery_codes r JOIN clients c ON c.id = r.client_id
+		WHERE c.name = $1 AND r.used_at IS NULL ORDER BY r.id`, name)
+
+	if err != nil {
+		return nil, helpers.WrapErr(op, err)
+	}
+
+	defer rows.Close()
+
+	var codes []models.RecoveryCode
+
+	for rows.Next() {
+		var code models.RecoveryCode
+
+		if err := rows.Scan(&code.ID, &code.Hash); err != nil {
+			return nil, helpers.WrapErr(op, err)
+		}
+
+		codes = append(codes, code)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, helpers.WrapErr(op, err)
+	}
+
+	return codes, nil
+}
+
+func (s *Storage) AddRecoveryCodes(ctx context.Context, name string, hashes [][]byte) error {
+	const op = "storage.postgres.AddRecoveryCodes"
+
+	now := time.Now().Unix()
+
+	for _, hash := range hashes {
+		res, err := s.q().ExecContext(ctx, "INSERT INTO recovery_codes(client_id, code_hash, created_at) SELECT id, $1, $2 FROM clients WHERE name = $3", hash, now, name)
+
+		if err := clientAffected(op, res, err); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (s *Storage) UseRecoveryCode(ctx context.Context, name string, id int64) error {
+	const op = "storage.postgres.UseRecoveryCode"
+
+	res, err := s.q().ExecContext(ctx, `UPDATE recovery_codes SET used_at = $1
+		WHERE id = $2 AND used_at IS NULL AND client_id = (SELECT id FROM clients WHERE name = $3)`, time.Now().Unix(), id, name)
+
+	return recoveryCodeAffected(op, res, err)
+}
+
+func (s *Storage) DeleteRecoveryCodes(ctx context.Context, name string) error {
+	const op = "storage.postgres.DeleteRecoveryCodes"
+
+	if _, err := s.q().ExecContext(ctx, "DELETE FROM recovery_codes WHERE client_id = (SELECT id FROM clients WHERE name = $1)", name); err != nil {
+		return helpers.WrapErr(op, err)
+	}
+
+	return nil
+}
+
+func (s *Storage) SaveWebAuthnCredential(ctx context.Context, name string, credential models.WebAuthnCredential) error {
+	const op = "storage.postgres.SaveWebAuthnCredential"
+
+	res, err := s.q().ExecContext(ctx, `INSERT INTO webauthn_credentials(client_id, credential_id, public_key, sign_count, created_at)
+		SELECT id, $1, $2, $3, $4 FROM clients WHERE name = $5`, credential.ID, credential.PublicKey, int64(credential.SignCount), time.Now().Unix(), name)
+
+	if isUniqueViolation(err) {
+		return helpers.WrapErr(op, storage.ErrWebAuthnCredentialExists)
+	}
+
+	return clientAffected(op, res, err)
+}
+
+func (s *Storage) WebAuthnCredentials(ctx context.Context, name string) ([]models.WebAuthnCredential, error) {
+	const op = "storage.postgres.WebAuthnCredentials"
+
+	rows, err := s.q().QueryContext(ctx, `SELECT w.credential_id, w.public_key, w.sign_count, w.created_at, w.last_used_at
+		FROM webauthn_credentials w JOIN clients c ON c.id = w.client_id WHERE c.name = $1 ORDER BY w.id`, name)
+
+	if err != nil {
+		return nil, helpers.WrapErr(op, err)
+	}
+
+	defer rows.Close()
+
+	var credentials []models.WebAuthnCredential
+
+	for rows.Next() {
+		var credential models.WebAuthnCredential
+		var createdAt, lastUsedAt int64
+
+		if err := rows.Scan(&credential.ID, &credential.PublicKey, &credential.SignCount, &createdAt, &lastUsedAt); err != nil {
+			return nil, helpers.WrapErr(op, err)
+		}
+
+		credential.CreatedAt = time.Unix(createdAt, 0)
+
+		if lastUsedAt != 0 {
+			credential.LastUsedAt = time.Unix(lastUsedAt, 0)
+		}
+
+		credentials = append(credentials, credential)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, helpers.WrapErr(op, err)
+	}
+
+	return credentials, nil
+}
+
+func (s *Storage) UpdateWebAuthnSignCount(ctx context.Context, name string, id []byte, signCount uint32) error {
+	const op = "storage.postgres.UpdateWebAuthnSignCount"
+
+	res, err := s.q().ExecContext(ctx, `UPDATE webauthn_credentials SET sign_count = $1, last_used_at = $2
+		WHERE credential_id = $3 AND client_id = (SELECT id FROM clients WHERE name = $4)`, int64(signCount), time.Now().Unix(), id, name)
+
+	return credentialAffected(op, res, err)
+}
+
+func (s *Storage) DeleteWebAuthnCredential(ctx context.Context, name string, id []byte) error {
+	const op = "storage.postgres.DeleteWebAuthnCredential"
+
+	res, err := s.q().ExecContext(ctx, `DELETE FROM webauthn_credentials
+		WHERE credential_id = $1 AND client_id = (SELECT id FROM clients WHERE name = $2)`, id, name)
+
+	return credentialAffected(op, res, err)
+}
+
+func (s *Storage) SaveLoginTransaction(ctx context.Context, transaction models.LoginTransaction) error {
+	const op = "storage.postgres.SaveLoginTransaction"
+
+	_, err := s.q().ExecContext(ctx, `INSERT INTO login_transactions(id, client_name, app_name, role, required, completed, expires_at, created_at)
+		VALUES($1, $2, $3, $4, $5, $6, $7, $8)`, transaction.ID, transaction.ClientName, transaction.AppName, transaction.Role,
+		storage.JoinFactors(transaction.Required), storage.JoinFactors(transaction.Completed), transaction.ExpiresAt.Unix(), time.Now().Unix())
+
+	if err != nil {
+		return helpers.WrapErr(op, err)
+	}
+
+	return nil
+}
+
+func (s *Storage) LoginTransaction(ctx context.Context, id string) (models.LoginTransaction, error) {
+	const op = "storage.postgres.LoginTransaction"
+
+	row := s.q().QueryRowContext(ctx, `SELECT id, client_name, app_name, role, required, completed, expires_at, created_at
+		FROM login_transactions WHERE id = $1`, id)
+
+	var transaction models.LoginTransaction
+	var required, completed string
+	var expiresAt, createdAt int64
+
+	err := row.Scan(&transaction.ID, &transaction.ClientName, &transaction.AppName, &transaction.Role, &required, &completed, &expiresAt, &createdAt)
+
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return models.LoginTransaction{}, helpers.WrapErr(op, storage.ErrLoginTransactionNotFound)
+		}
+
+		return models.LoginTransaction{}, helpers.WrapErr(op, err)
+	}
+
+	transaction.Required, transaction.Completed = storage.SplitFactors(required), storage.SplitFactors(completed)
+	transaction.ExpiresAt, transaction.CreatedAt = time.Unix(expiresAt, 0), time.Unix(createdAt, 0)
+
+	return transaction, nil
+}
+
+func (s *Storage) UpdateLoginFactors(ctx context.Context, id string, required []string, completed []string) error {
+	const op = "storage.postgres.UpdateLoginFactors"
+
+	res, err := s.q().ExecContext(ctx, "UPDATE login_transactions SET required = $1, completed = $2 WHERE id = $3",
+		storage.JoinFactors(required), storage.JoinFactors(completed), id)
+
+	return loginAffected(op, res, err)
+}
+
+func (s *Storage) DeleteLoginTransaction(ctx context.Context, id string) error {
+	const op = "storage.postgres.DeleteLoginTransaction"
+
+	res, err := s.q().ExecContext(ctx, "DELETE FROM login_transactions WHERE id = $1", id)
+
+	return loginAffected(op, res, err)
+}
+
+func (s *Storage) DeleteExpiredLoginTransactions(ctx context.Context, now time.Time) (int64, error) {
+	const op = "storage.postgres.DeleteExpiredLoginTransactions"
+
+	res, err := s.q().ExecContext(ctx, "DELETE FROM login_transactions WHERE expires_at <= $1", now.Unix())
+
+	if err != nil {
+		return 0, helpers.WrapErr(op, err)
+	}
+
+	n, err := res.RowsAffected()
+
+	if err != nil {
+		return 0, helpers.WrapErr(op, err)
+	}
+
+	return n, nil
+}
+
+func (s *Storage) SavePasswordReset(ctx context.Context, reset models.PasswordReset) error {
+	const op = "storage.postgres.SavePasswordReset"
+
+	res, err := s.q().ExecContext(ctx, "INSERT INTO password_resets(id, client_id, expires_at, created_at) SELECT $1, id, $2, $3 FROM clients WHERE name = $4",
+		reset.ID, reset.ExpiresAt.Unix(), time.Now().Unix(), reset.ClientName)
+
+	return clientAffected(op, res, err)
+}
+
+func (s *Storage) PasswordReset(ctx context.Context, id string) (models.PasswordReset, error) {
+	const op = "storage.postgres.PasswordReset"
+
+	var reset models.PasswordReset
+	var expiresAt, createdAt int64
+
+	err := s.q().QueryRowContext(ctx, `SELECT r.id, c.name, r.expires_at, r.created_at FROM password_resets r JOIN clients c ON c.id = r.client_id
+		WHERE r.id = $1`, id).Scan(&reset.ID, &reset.ClientName, &expiresAt, &createdAt)
+
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return models.PasswordReset{}, helpers.WrapErr(op, storage.ErrPasswordResetNotFound)
+		}
+
+		return models.PasswordReset{}, helpers.WrapErr(op, err)
+	}
+
+	reset.ExpiresAt, reset.CreatedAt = time.Unix(expiresAt, 0), time.Unix(createdAt, 0)
+
+	return reset, nil
+}
+
+func (s *Storage) DeletePasswordReset(ctx context.Context, id string) error {
+	const op = "storage.postgres.DeletePasswordReset"
+
+	res, err := s.q().ExecContext(ctx, "DELETE FROM password_resets WHERE id = $1", id)
+
+	return resetAffected(op, res, err)
+}
+
+func (s *Storage) DeletePasswordResets(ctx context.Context, name string) error {
+	const op = "storage.postgres.DeletePasswordResets"
+
+	if _, err := s.q().ExecContext(ctx, "DELETE FROM password_resets WHERE client_id = (SELECT id FROM clients WHERE name = $1)", name); err != nil {
+		return helpers.WrapErr(op, err)
+	}
+
+	return nil
+}
+
+func (s *Storage) DeleteExpiredPasswordResets(ctx context.Context, now time.Time) (int64, error) {
+	const op = "storage.postgres.DeleteExpiredPasswordResets"
+
+	res, err := s.q().ExecContext(ctx, "DELETE FROM password_resets WHERE expires_at <= $1", now.Unix())
+
+	if err != nil {
+		return 0, helpers.WrapErr(op, err)
+	}
+
+	n, err := res.RowsAffected()
+
+	if err != nil {
+		return 0, helpers.WrapErr(op, err)
+	}
+
+	return n, nil
+}
+
+func (s *Storage) SaveApp(ctx context.Context, name string, secret string, roles string) (int64, error) {
+
+	const op = "storage.postgres.SaveApp"
+
+	var id int64
+
+	org, _ := models.SplitName(name)
+
+	err := s.q().QueryRowContext(ctx, `INSERT INTO apps(name, secret, roles, org_id, created_at, updated_at)
+		SELECT $1, $2, $3, o.id, $4, $4 FROM (SELECT 1) AS one LEFT JOIN organizations o ON o.name = $5 WHERE $5 = '' OR o.id IS NOT NULL
+		RETURNING id`, name, secret, roles, time.Now().Unix(), org).Scan(&id)
+
+	if err != nil {
+		if isUniqueViolation(err) {
+			return 0, helpers.WrapErr(op, storage.ErrAppExists)
+		}
+
+		if errors.Is(err, sql.ErrNoRows) {
+			return 0, helpers.WrapErr(op, storage.ErrOrganizationNotFound)
+		}
+
+		return 0, helpers.WrapErr(op, err)
+	}
+
+	return id, nil
+}
+
+func (s *Storage) App(ctx context.Context, name string) (models.App, error) {
+	const op = "storage.postgres.App"
+
+	row := s.q().QueryRowContext(ctx, "SELECT id, name, COALESCE(org_id, 0), display_name, secret, roles, settings, require_mfa, require_verified_email, profile_claims, role_grants, groups_claim, org_clients, version, created_at, updated_at FROM apps WHERE name = $1", name)
+
+	var app models.App
+	var createdAt, updatedAt int64
+
+	err := row.Scan(&app.ID, &app.Name, &app.OrgID, &app.DisplayName, &app.Secret, &app.Roles, &app.Settings, &app.RequireMFA, &app.RequireVerifiedEmail, &app.ProfileClaims, &app.RoleGrants, &app.GroupsClaim, &app.OrgClients, &app.Version, &createdAt, &updatedAt)
+
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return models.App{}, helpers.WrapErr(op, storage.ErrAppNotFound)
+		}
+
+		return models.App{}, helpers.WrapErr(op, err)
+	}
+
+	app.CreatedAt, app.UpdatedAt = time.Unix(createdAt, 0), time.Unix(updatedAt, 0)
+
+	return app, nil
+}
+
+func (s *Storage) UpdateApp(ctx context.Context, name string, version int64, update models.AppUpdate) (int64, error) {
+	const op = "storage.postgres.UpdateApp"
+
+	row := s.q().QueryRowContext(ctx, `UPDATE apps SET display_name = COALESCE($1, display_name), roles = COALESCE($2, roles),
+			settings = COALESCE($3, settings), require_mfa = COALESCE($4, require_mfa),
+			require_verified_email = COALESCE($5, require_verified_email), profile_claims = COALESCE($6, profile_claims),
+			role_grants = COALESCE($7, role_grants), groups_claim = COALESCE($8, groups_claim),
+			org_clients = COALESCE($9, org_clients), version = version + 1, updated_at = $10
+		WHERE name = $11 AND version = $12 RETURNING version`,
+		update.DisplayName, update.Roles, update.Settings, update.RequireMFA, update.RequireVerifiedEmail, update.ProfileClaims,
+		update.RoleGrants, update.GroupsClaim, update.OrgClients, time.Now().Unix(), name, version)
+
+	err := row.Scan(&version)
+
+	if err != nil {
+		if !errors.Is(err, sql.ErrNoRows) {
+			return 0, helpers.WrapErr(op, err)
+		}
+
+		// either there is no such app or the version has moved on
+		if _, err := s.App(ctx, name); err != nil {
+			return 0, helpers.WrapErr(op, err)
+		}
+
+		return 0, helpers.WrapErr(op, storage.ErrAppConflict)
+	}
+
+	return version, nil
+}
+
+func (s *Storage) DeleteApp(ctx context.Context, name string) error {
+	const op = "storage.postgres.DeleteApp"
+
+	res, err := s.q().ExecContext(ctx, "DELETE FROM apps WHERE name = $1", name)
+
+	if err != nil {
+		return helpers.WrapErr(op, err)
+	}
+
+	n, err := res.RowsAffected()
+
+	if err != nil {
+		return helpers.WrapErr(op, err)
+	}
+
+	if n == 0 {
+		return helpers.WrapErr(op, storage.ErrAppNotFound)
+	}
+
+	return nil
+}
+
+func (s *Storage) ListClients(ctx context.Context, q models.ListQuery) ([]models.Client, string, error) {
+	const op = "storage.postgres.ListClients"
+
+	var clients []models.Client
+
+	next, err := s.list(ctx, `SELECT id, name, COALESCE(org_id, 0), status, token_version, totp_enabled, email, email_verified,
+			EXISTS(SELECT 1 FROM webauthn_credentials w WHERE w.client_id = clients.id), created_at, updated_at FROM clients`, q, func(rows *sql.Rows) (string, error) {
+		var client models.Client
+		var createdAt, updatedAt int64
+
+		if err := rows.Scan(&client.ID, &client.Name, &client.OrgID, &client.Status, &client.TokenVersion, &client.TOTPEnabled, &client.Email, &client.EmailVerified,
+			&client.WebAuthnEnabled, &createdAt, &updatedAt); err != nil {
+			return "", err
+		}
+
+		client.CreatedAt, client.UpdatedAt = time.Unix(createdAt, 0), time.Unix(updatedAt, 0)
+		clients = append(clients, client)
+
+		return storage.NewCursor(q, client.ID, client.Name, client.CreatedAt, client.UpdatedAt), nil
+	})
+
+	if err != nil {
+		return nil, "", helpers.WrapErr(op, err)
+	}
+
+	return clients, next, nil
+}
+
+func (s *Storage) ListApps(ctx context.Context, q models.ListQuery) ([]models.App, string, error) {
+	const op = "storage.postgres.ListApps"
+
+	var apps []models.App
+
+	next, err := s.list(ctx, "SELECT id, name, COALESCE(org_id, 0), display_name, roles, settings, require_mfa, require_verified_email, profile_claims, role_grants, groups_claim, org_clients, version, created_at, updated_at FROM apps", q, func(rows *sql.Rows) (string, error) {
+		var app models.App
+		var createdAt, updatedAt int64
+
+		if err := rows.Scan(&app.ID, &app.Name, &app.OrgID, &app.DisplayName, &app.Roles, &app.Settings, &app.RequireMFA, &app.RequireVerifiedEmail, &app.ProfileClaims, &app.RoleGrants, &app.GroupsClaim, &app.OrgClients, &app.Version, &createdAt, &updatedAt); err != nil {
+			return "", err
+		}
+
+		app.CreatedAt, app.UpdatedAt = time.Unix(createdAt, 0), time.Unix(updatedAt, 0)
+		apps = append(apps, app)
+
+		return storage.NewCursor(q, app.ID, app.Name, app.CreatedAt, app.UpdatedAt), nil
+	})
+
+	if err != nil {
+		return nil, "", helpers.WrapErr(op, err)
+	}
+
+	return apps, next, nil
+}
+
+// list runs a page query built from q, scan reads one row and returns the cursor after it.
+// Names are compared with the C collation to get the bytewise order of the other backends.
+func (s *Storage) list(ctx context.Context, selectFrom string, q models.ListQuery, scan func(rows *sql.Rows) (string, error)) (string, error) {
+	column, err := storage.SortColumn(q)
+
+	if err != nil {
+		return "", err
+	}
+
+	cursor, ok, err := storage.DecodeCursor(q)
+
+	if err != nil {
+		return "", err
+	}
+
+	if column == "name" {
+		column = `name COLLATE "C"`
+	}
+
+	query := selectFrom + " WHERE starts_with(name, $1)"
+	args := []any{q.NamePrefix}
+
+	direction, compare := "ASC", ">"
+
+	if q.Desc {
+		direction, compare = "DESC", "<"
+	}
+
+	if ok {
+		var value any = cursor.At
+
+		if column != "created_at" && column != "updated_at" {
+			value = cursor.Name
+		}
+
+		query += fmt.Sprintf(" AND (%[1]s %[2]s $2 OR (%[1]s = $2 AND id %[2]s $3))", column, compare)
+		args = append(args, value, cursor.ID)
+	}
+
+	limit := storage.PageLimit(q)
+
+	query += fmt.Sprintf(" ORDER BY %[1]s %[2]s, id %[2]s LIMIT $%[3]d", column, direction, len(args)+1)
+	args = append(args, limit+1)
+
+	rows, err := s.q().QueryContext(ctx, query, args...)
+
+	if err != nil {
+		return "", err
+	}
+
+	defer rows.Close()
+
+	var next string
+
+	for i := 0; rows.Next(); i++ {
+		// the extra row only tells there is another page
+		if i == limit {
+			return next, rows.Err()
+		}
+
+		if next, err = scan(rows); err != nil {
+			return "", err
+		}
+	}
+
+	return "", rows.Err()
+}
+
+func (s *Storage) LoginAttempts(ctx context.Context, key string) (models.LoginAttempts, error) {
+	const op = "storage.postgres.LoginAttempts"
+
+	row := s.q().QueryRowContext(ctx, "SELECT failures, last_failure_at, locked_until FROM login_attempts WHERE key = $1", key)
+
+	attempts, err := scanLoginAttempts(key, row)
+
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return models.LoginAttempts{Key: key}, nil
+		}
+
+		return models.LoginAttempts{}, helpers.WrapErr(op, err)
+	}
+
+	return attempts, nil
+}
+
+func (s *Storage) IncrementFailedLogins(ctx context.Context, key string, at time.Time, resetBefore time.Time) (models.LoginAttempts, error) {
+	const op = "storage.postgres.IncrementFailedLogins"
+
+	row := s.q().QueryRowContext(ctx, `INSERT INTO login_attempts(key, failures, last_failure_at) VALUES($1, 1, $2)
+		ON CONFLICT(key) DO UPDATE SET
+			failures = CASE WHEN login_attempts.last_failure_at < $3 THEN 1 ELSE login_attempts.failures + 1 END,
+			last_failure_at = excluded.last_failure_at
+		RETURNING failures, last_failure_at, locked_until`, key, at.Unix(), resetBefore.Unix())
+
+	attempts, err := scanLoginAttempts(key, row)
+
+	if err != nil {
+		return models.LoginAttempts{}, helpers.WrapErr(op, err)
+	}
+
+	return attempts, nil
+}
+
+func (s *Storage) LockLogin(ctx context.Context, key string, until time.Time) error {
+	const op = "storage.postgres.LockLogin"
+
+	_, err := s.q().ExecContext(ctx, `INSERT INTO login_attempts(key, locked_until) VALUES($1, $2)
+		ON CONFLICT(key) DO UPDATE SET locked_until = excluded.locked_until`, key, until.Unix())
+
+	if err != nil {
+		return helpers.WrapErr(op, err)
+	}
+
+	return nil
+}
+
+func (s *Storage) ResetLoginAttempts(ctx context.Context, key string) error {
+	const op = "storage.postgres.ResetLoginAttempts"
+
+	if _, err := s.q().ExecContext(ctx, "DELETE FROM login_attempts WHERE key = $1", key); err != nil {
+		return helpers.WrapErr(op, err)
+	}
+
+	return nil
+}
+
+// clientAffected turns an update of no rows into ErrClientNotFound
+func clientAffected(op string, res sql.Result, err error) error {
+	if err != nil {
+		return helpers.WrapErr(op, err)
+	}
+
+	n, err := res.RowsAffected()
+
+	if err != nil {
+		return helpers.WrapErr(op, err)
+	}
+
+	if n == 0 {
+		return helpers.WrapErr(op, storage.ErrClientNotFound)
+	}
+
+	return nil
+}
+
+// groupAffected turns a change of no rows into ErrGroupNotFound
+func groupAffected(op string, res sql.Result, err error) error {
+	if err != nil {
+		return helpers.WrapErr(op, err)
+	}
+
+	n, err := res.RowsAffected()
+
+	if err != nil {
+		return helpers.WrapErr(op, err)
+	}
+
+	if n == 0 {
+		return helpers.WrapErr(op, storage.ErrGroupNotFound)
+	}
+
+	return nil
+}
+
+// recoveryCodeAffected turns an update of no rows into ErrRecoveryCodeUsed
+func recoveryCodeAffected(op string, res sql.Result, err error) error {
+	if err != nil {
+		return helpers.WrapErr(op, err)
+	}
+
+	n, err := res.RowsAffected()
+
+	if err != nil {
+		return helpers.WrapErr(op, err)
+	}
+
+	if n == 0 {
+		return helpers.WrapErr(op, storage.ErrRecoveryCodeUsed)
+	}
+
+	return nil
+}
+
+// resetAffected turns a change of no rows into ErrPasswordResetNotFound
+func resetAffected(op string, res sql.Result, err error) error {
+	if err != nil {
+		return helpers.WrapErr(op, err)
+	}
+
+	n, err := res.RowsAffected()
+
+	if err != nil {
+		return helpers.WrapErr(op, err)
+	}
+
+	if n == 0 {
+		return helpers.WrapErr(op, storage.ErrPasswordResetNotFound)
+	}
+
+	return nil
+}
+
+// credentialAffected turns a change of no rows into ErrWebAuthnCredentialNotFound
+func credentialAffected(op string, res sql.Result, err error) error {
+	if err != nil {
+		return helpers.WrapErr(op, err)
+	}
+
+	n, err := res.RowsAffected()
+
+	if err != nil {
+		return helpers.WrapErr(op, err)
+	}
+
+	if n == 0 {
+		return helpers.WrapErr(op, storage.ErrWebAuthnCredentialNotFound)
+	}
+
+	return nil
+}
+
+// loginAffected turns a change of no rows into ErrLoginTransactionNotFound
+func loginAffected(op string, res sql.Result, err error) error {
+	if err != nil {
+		return helpers.WrapErr(op, err)
+	}
+
+	n, err := res.RowsAffected()
+
+	if err != nil {
+		return helpers.WrapErr(op, err)
+	}
+
+	if n == 0 {
+		return helpers.WrapErr(op, storage.ErrLoginTransactionNotFound)
+	}
+
+	return nil
+}
+
+func scanLoginAttempts(key string, row *sql.Row) (models.LoginAttempts, error) {
+	var failures int
+	var lastFailureAt, lockedUntil int64
+
+	if err := row.Scan(&failures, &lastFailureAt, &lockedUntil); err != nil {
+		return models.LoginAttempts{}, err
+	}
+
+	return models.LoginAttempts{
+		Key:           key,
+		Failures:      failures,
+		LastFailureAt: time.Unix(lastFailureAt, 0),
+		LockedUntil:   time.Unix(lockedUntil, 0),
+	}, nil
+}
+
+func isUniqueViolation(err error) bool {
+	var pgErr *pgconn.PgError
+
+	return errors.As(err, &pgErr) && pgErr.Code == uniqueViolation
+}
+
+func (s *Storage) Stop() {
+	s.db.Close()
+}
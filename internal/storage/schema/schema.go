@@ -0,0 +1,226 @@
+/*
+schema opens golang-migrate on the migrations embedded in the binary
+or on a migrations directory, and applies them at startup.
+*/
+package schema
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"ssosage/internal/helpers"
+	"ssosage/migrations"
+	"strings"
+
+	"github.com/golang-migrate/migrate/v4"
+	_ "github.com/golang-migrate/migrate/v4/database/pgx/v5"
+	_ "github.com/golang-migrate/migrate/v4/database/sqlite"
+	"github.com/golang-migrate/migrate/v4/source"
+	"github.com/golang-migrate/migrate/v4/source/iofs"
+	_ "github.com/jackc/pgx/v5/stdlib"
+	_ "modernc.org/sqlite"
+)
+
+var (
+	ErrSchemaTooNew = errors.New("database schema is newer than this binary")
+	ErrDirty        = errors.New("database schema is dirty")
+)
+
+// Source reads migrations from dir, or from the ones embedded for driver when dir is empty
+func Source(driver string, dir string) (source.Driver, error) {
+	const op = "storage.schema.Source"
+
+	var fsys fs.FS = migrations.FS
+	path := driver
+
+	if dir != "" {
+		fsys, path = os.DirFS(dir), "."
+	}
+
+	src, err := iofs.New(fsys, path)
+
+	if err != nil {
+		return nil, helpers.WrapErr(op, err)
+	}
+
+	return src, nil
+}
+
+func New(driver string, storagePath string, table string, dir string) (*migrate.Migrate, error) {
+	const op = "storage.schema.New"
+
+	dbURL, err := DatabaseURL(driver, storagePath, table)
+
+	if err != nil {
+		return nil, helpers.WrapErr(op, err)
+	}
+
+	src, err := Source(driver, dir)
+
+	if err != nil {
+		return nil, helpers.WrapErr(op, err)
+	}
+
+	m, err := migrate.NewWithSourceInstance("iofs", src, dbURL)
+
+	if err != nil {
+		src.Close()
+
+		return nil, helpers.WrapErr(op, err)
+	}
+
+	return m, nil
+}
+
+/*
+Migrate applies pending embedded migrations.
+golang-migrate holds its lock while migrating: an advisory lock on postgres,
+on sqlite the lock only covers this process.
+It refuses to touch a dirty schema or a schema newer than the embedded migrations.
+*/
+func Migrate(driver string, storagePath string, table string) (from uint, to uint, err error) {
+	const op = "storage.schema.Migrate"
+
+	latest, err := latestVersion(driver)
+
+	if err != nil {
+		return 0, 0, helpers.WrapErr(op, err)
+	}
+
+	m, err := New(driver, storagePath, table, "")
+
+	if err != nil {
+		return 0, 0, helpers.WrapErr(op, err)
+	}
+
+	defer m.Close()
+
+	current, dirty, err := m.Version()
+
+	if err != nil && !errors.Is(err, migrate.ErrNilVersion) {
+		return 0, 0, helpers.WrapErr(op, err)
+	}
+
+	if dirty {
+		return current, current, helpers.WrapErr(op, fmt.Errorf("%w at version %d", ErrDirty, current))
+	}
+
+	if current > latest {
+		return current, current, helpers.WrapErr(op, fmt.Errorf("%w: version %d, latest known %d", ErrSchemaTooNew, current, latest))
+	}
+
+	if err := m.Up(); err != nil && !errors.Is(err, migrate.ErrNoChange) {
+		return current, current, helpers.WrapErr(op, err)
+	}
+
+	return current, latest, nil
+}
+
+/*
+ReadVersion reads the version golang-migrate recorded without creating anything: a missing sqlite file
+or a missing migrations table read as migrate.ErrNilVersion, an existing sqlite file is opened read-only.
+*/
+func ReadVersion(driver string, storagePath string, table string) (version uint, dirty bool, err error) {
+	const op = "storage.schema.ReadVersion"
+
+	var db *sql.DB
+	var exists string
+
+	switch driver {
+	case "sqlite":
+		if _, err := os.Stat(storagePath); errors.Is(err, os.ErrNotExist) {
+			return 0, false, migrate.ErrNilVersion
+		}
+
+		db, err = sql.Open("sqlite", "file:"+storagePath+"?mode=ro")
+		exists = "SELECT EXISTS (SELECT 1 FROM sqlite_master WHERE type = 'table' AND name = ?)"
+	case "postgres":
+		db, err = sql.Open("pgx", storagePath)
+		exists = "SELECT to_regclass(quote_ident($1)) IS NOT NULL"
+	default:
+		return 0, false, helpers.WrapErr(op, fmt.Errorf("unknown storage driver: %q", driver))
+	}
+
+	if err != nil {
+		return 0, false, helpers.WrapErr(op, err)
+	}
+
+	defer db.Close()
+
+	var found bool
+
+	if err := db.QueryRow(exists, table).Scan(&found); err != nil {
+		return 0, false, helpers.WrapErr(op, err)
+	}
+
+	if !found {
+		return 0, false, migrate.ErrNilVersion
+	}
+
+	var v int64
+
+	err = db.QueryRow(fmt.Sprintf(`SELECT version, dirty FROM "%s" LIMIT 1`, strings.ReplaceAll(table, `"`, `""`))).Scan(&v, &dirty)
+
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, false, migrate.ErrNilVersion
+	}
+
+	if err != nil {
+		return 0, false, helpers.WrapErr(op, err)
+	}
+
+	// postgres keeps a dirty nil version as -1
+	if v < 0 {
+		return 0, dirty, migrate.ErrNilVersion
+	}
+
+	return uint(v), dirty, nil
+}
+
+func DatabaseURL(driver string, storagePath string, table string) (string, error) {
+	switch driver {
+	case "sqlite":
+		return fmt.Sprintf("sqlite://%s?x-migrations-table=%s", storagePath, table), nil
+	case "postgres":
+		_, rest, _ := strings.Cut(storagePath, "://")
+
+		separator := "?"
+		if strings.Contains(rest, "?") {
+			separator = "&"
+		}
+
+		return fmt.Sprintf("pgx5://%s%sx-migrations-table=%s", rest, separator, table), nil
+	}
+
+	return "", fmt.Errorf("unknown storage driver: %q", driver)
+}
+
+func latestVersion(driver string) (uint, error) {
+	src, err := Source(driver, "")
+
+	if err != nil {
+		return 0, err
+	}
+
+	defer src.Close()
+
+	v, err := src.First()
+
+	for err == nil {
+		var next uint
+
+		next, err = src.Next(v)
+
+		if err == nil {
+			v = next
+		}
+	}
+
+	if !errors.Is(err, os.ErrNotExist) {
+		return 0, err
+	}
+
+	return v, nil
+}
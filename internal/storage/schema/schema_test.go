@@ -0,0 +1,125 @@
+package schema
+
+import (
+	"database/sql"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/golang-migrate/migrate/v4"
+)
+
+func TestMigrate(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "ssosage.db")
+
+	latest, err := latestVersion("sqlite")
+
+	if err != nil {
+		t.Fatalf("failed to read embedded migrations: %v", err)
+	}
+
+	from, to, err := Migrate("sqlite", path, "migrations")
+
+	if err != nil {
+		t.Fatalf("failed to migrate: %v", err)
+	}
+
+	if from != 0 || to != latest {
+		t.Fatalf("expected 0 -> %d, got %d -> %d", latest, from, to)
+	}
+
+	// nothing left to apply
+	if from, to, err = Migrate("sqlite", path, "migrations"); err != nil || from != latest || to != latest {
+		t.Fatalf("expected %d -> %d, got %d -> %d, err: %v", latest, latest, from, to, err)
+	}
+}
+
+func TestMigrateRefusesNewerSchema(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "ssosage.db")
+
+	if _, _, err := Migrate("sqlite", path, "migrations"); err != nil {
+		t.Fatalf("failed to migrate: %v", err)
+	}
+
+	latest, err := latestVersion("sqlite")
+
+	if err != nil {
+		t.Fatalf("failed to read embedded migrations: %v", err)
+	}
+
+	m, err := New("sqlite", path, "migrations", "")
+
+	if err != nil {
+		t.Fatalf("failed to open migrations: %v", err)
+	}
+
+	if err := m.Force(int(latest) + 1); err != nil {
+		t.Fatalf("failed to force version: %v", err)
+	}
+
+	m.Close()
+
+	if _, _, err := Migrate("sqlite", path, "migrations"); !errors.Is(err, ErrSchemaTooNew) {
+		t.Fatalf("expected ErrSchemaTooNew, got: %v", err)
+	}
+}
+
+func TestMigrateRefusesDirtySchema(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "ssosage.db")
+
+	m, err := New("sqlite", path, "migrations", "")
+
+	if err != nil {
+		t.Fatalf("failed to open migrations: %v", err)
+	}
+
+	// a force leaves the version clean, so mark it dirty the way a failed migration would
+	if err := m.Force(1); err != nil {
+		t.Fatalf("failed to force version: %v", err)
+	}
+
+	m.Close()
+
+	db, err := sql.Open("sqlite", path)
+
+	if err != nil {
+		t.Fatalf("failed to open db: %v", err)
+	}
+
+	if _, err := db.Exec("UPDATE migrations SET dirty = 1"); err != nil {
+		t.Fatalf("failed to mark schema dirty: %v", err)
+	}
+
+	db.Close()
+
+	if _, _, err := Migrate("sqlite", path, "migrations"); !errors.Is(err, ErrDirty) {
+		t.Fatalf("expected ErrDirty, got: %v", err)
+	}
+}
+
+func TestReadVersion(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "ssosage.db")
+
+	if _, _, err := ReadVersion("sqlite", path, "migrations"); !errors.Is(err, migrate.ErrNilVersion) {
+		t.Fatalf("expected ErrNilVersion for a missing database, got: %v", err)
+	}
+
+	if _, err := os.Stat(path); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("expected the database to stay uncreated, got: %v", err)
+	}
+
+	if _, _, err := Migrate("sqlite", path, "migrations"); err != nil {
+		t.Fatalf("failed to migrate: %v", err)
+	}
+
+	latest, err := latestVersion("sqlite")
+
+	if err != nil {
+		t.Fatalf("failed to read embedded migrations: %v", err)
+	}
+
+	if v, dirty, err := ReadVersion("sqlite", path, "migrations"); err != nil || v != latest || dirty {
+		t.Fatalf("expected clean version %d, got %d (dirty %v), err: %v", latest, v, dirty, err)
+	}
+}
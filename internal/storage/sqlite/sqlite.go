@@ -4,46 +4,313 @@ import (
 	"context"
 	"database/sql"
 	"errors"
+	"fmt"
 	"ssosage/internal/helpers"
+	"ssosage/internal/interfaces"
 	"ssosage/internal/models"
 	"ssosage/internal/storage"
+	"strings"
+	"time"
 
 	"modernc.org/sqlite"
 	sqlite3 "modernc.org/sqlite/lib"
 )
 
+type Options struct {
+	// how long a connection waits for a lock held by another one
+	BusyTimeout time.Duration
+	// journal_mode pragma, WAL lets readers work while somebody writes
+	JournalMode        string
+	DisableForeignKeys bool
+	// zero means database/sql defaults
+	MaxOpenConns    int
+	MaxIdleConns    int
+	ConnMaxLifetime time.Duration
+}
+
+var DefaultOptions = Options{
+	BusyTimeout: 5 * time.Second,
+	JournalMode: "WAL",
+}
+
 /*
-implements UserSaver, UserProvider
+implements Repository
+statements are prepared once in New, so the schema has to be migrated before that
 */
 type Storage struct {
-	db *sql.DB
+	db         *sql.DB
+	tx         *sql.Tx
+	statements []*sql.Stmt
+
+	saveClient            *sql.Stmt
+	client                *sql.Stmt
+	updateClientPassword  *sql.Stmt
+	setClientStatus       *sql.Stmt
+	deleteClient          *sql.Stmt
+	setTOTPSecret         *sql.Stmt
+	enableTOTP            *sql.Stmt
+	useTOTPStep           *sql.Stmt
+	clearTOTP             *sql.Stmt
+	setClientEmail        *sql.Stmt
+	verifyClientEmail     *sql.Stmt
+	profile               *sql.Stmt
+	updateProfile         *sql.Stmt
+	recoveryCodes         *sql.Stmt
+	addRecoveryCode       *sql.Stmt
+	useRecoveryCode       *sql.Stmt
+	deleteRecoveryCodes   *sql.Stmt
+	saveCredential        *sql.Stmt
+	credentials           *sql.Stmt
+	updateSignCount       *sql.Stmt
+	deleteCredential      *sql.Stmt
+	saveLogin             *sql.Stmt
+	login                 *sql.Stmt
+	updateLoginFactors    *sql.Stmt
+	deleteLogin           *sql.Stmt
+	deleteExpiredLogins   *sql.Stmt
+	saveReset             *sql.Stmt
+	reset                 *sql.Stmt
+	deleteReset           *sql.Stmt
+	deleteClientResets    *sql.Stmt
+	deleteExpiredResets   *sql.Stmt
+	saveApp               *sql.Stmt
+	saveOrganization      *sql.Stmt
+	organization          *sql.Stmt
+	deleteOrganization    *sql.Stmt
+	saveGroup             *sql.Stmt
+	group                 *sql.Stmt
+	deleteGroup           *sql.Stmt
+	addGroupMember        *sql.Stmt
+	removeGroupMember     *sql.Stmt
+	grantClientRole       *sql.Stmt
+	grantGroupRole        *sql.Stmt
+	revokeClientRole      *sql.Stmt
+	revokeGroupRole       *sql.Stmt
+	clientGroups          *sql.Stmt
+	clientRoles           *sql.Stmt
+	app                   *sql.Stmt
+	updateApp             *sql.Stmt
+	deleteApp             *sql.Stmt
+	loginAttempts         *sql.Stmt
+	incrementFailedLogins *sql.Stmt
+	lockLogin             *sql.Stmt
+	resetLoginAttempts    *sql.Stmt
 }
 
-func New(storagePath string) (*Storage, error) {
+func New(storagePath string, opts Options) (*Storage, error) {
 
 	const op = "storage.sqlite.New"
 
-	db, err := sql.Open("sqlite", storagePath)
+	db, err := sql.Open("sqlite", dsn(storagePath, opts))
 
 	if err != nil {
 		return nil, helpers.WrapErr(op, err)
 	}
 
-	return &Storage{db}, nil
+	if opts.MaxOpenConns > 0 {
+		db.SetMaxOpenConns(opts.MaxOpenConns)
+	}
+
+	if opts.MaxIdleConns > 0 {
+		db.SetMaxIdleConns(opts.MaxIdleConns)
+	}
+
+	if opts.ConnMaxLifetime > 0 {
+		db.SetConnMaxLifetime(opts.ConnMaxLifetime)
+	}
+
+	s := &Storage{db: db}
+
+	statements := []struct {
+		stmt  **sql.Stmt
+		query string
+	}{
+		{&s.saveClient, `INSERT INTO clients(name, password_hash, org_id, created_at, updated_at)
+			SELECT ?1, ?2, o.id, ?3, ?3 FROM (SELECT 1) LEFT JOIN organizations o ON o.name = ?4 WHERE ?4 = '' OR o.id IS NOT NULL`},
+		{&s.client, `SELECT id, name, COALESCE(org_id, 0), password_hash, status, token_version, totp_secret, totp_enabled, totp_step, email, email_verified,
+				EXISTS(SELECT 1 FROM webauthn_credentials w WHERE w.client_id = clients.id), created_at, updated_at
+			FROM clients WHERE name = ?`},
+		{&s.updateClientPassword, "UPDATE clients SET password_hash = ?, token_version = token_version + 1, updated_at = ? WHERE name = ?"},
+		{&s.setClientStatus, "UPDATE clients SET status = ?, token_version = token_version + 1, updated_at = ? WHERE name = ?"},
+		{&s.deleteClient, "DELETE FROM clients WHERE name = ?"},
+		{&s.setTOTPSecret, "UPDATE clients SET totp_secret = ?, totp_enabled = 0, totp_step = 0, updated_at = ? WHERE name = ?"},
+		{&s.enableTOTP, "UPDATE clients SET totp_enabled = 1, updated_at = ? WHERE name = ?"},
+		{&s.useTOTPStep, "UPDATE clients SET totp_step = ? WHERE name = ? AND totp_step < ?"},
+		{&s.clearTOTP, "UPDATE clients SET totp_secret = NULL, totp_enabled = 0, totp_step = 0, updated_at = ? WHERE name = ?"},
+		{&s.setClientEmail, "UPDATE clients SET email = ?, email_verified = 0, token_version = token_version + 1, updated_at = ? WHERE name = ?"},
+		{&s.verifyClientEmail, "UPDATE clients SET email_verified = 1, updated_at = ? WHERE name = ? AND email = ?"},
+		{&s.profile, `SELECT COALESCE(p.display_name, ''), COALESCE(p.locale, ''), COALESCE(p.avatar_url, ''),
+				COALESCE(p.metadata, '{}'), COALESCE(p.updated_at, 0)
+			FROM clients c LEFT JOIN client_profiles p ON p.client_id = c.id WHERE c.name = ?`},
+		{&s.updateProfile, `INSERT INTO client_profiles(client_id, display_name, locale, avatar_url, metadata, updated_at)
+				SELECT id, COALESCE(?1, ''), COALESCE(?2, ''), COALESCE(?3, ''), COALESCE(?4, '{}'), ?5 FROM clients WHERE name = ?6
+			ON CONFLICT(client_id) DO UPDATE SET display_name = COALESCE(?1, display_name), locale = COALESCE(?2, locale),
+				avatar_url = COALESCE(?3, avatar_url), metadata = COALESCE(?4, metadata), updated_at = ?5
+			RETURNING display_name, locale, avatar_url, metadata, updated_at`},
+		{&s.recoveryCodes, `SELECT r.id, r.code_hash FROM recovery_codes r JOIN clients c ON c.id = r.client_id
+			WHERE c.name = ? AND r.used_at IS NULL ORDER BY r.id`},
+		{&s.addRecoveryCode, "INSERT INTO recovery_codes(client_id, code_hash, created_at) SELECT id, ?, ? FROM clients WHERE name = ?"},
+		{&s.useRecoveryCode, `UPDATE recovery_codes SET used_at = ?
+			WHERE id = ? AND used_at IS NULL AND client_id = (SELECT id FROM clients WHERE name = ?)`},
+		{&s.deleteRecoveryCodes, "DELETE FROM recovery_codes WHERE client_id = (SELECT id FROM clients WHERE name = ?)"},
+		{&s.saveCredential, `INSERT INTO webauthn_credentials(client_id, credential_id, public_key, sign_count, created_at)
+			SELECT id, ?, ?, ?, ? FROM clients WHERE name = ?`},
+		{&s.credentials, `SELECT w.credential_id, w.public_key, w.sign_count, w.created_at, w.last_used_at
+			FROM webauthn_credentials w JOIN clients c ON c.id = w.client_id WHERE c.name = ? ORDER BY w.id`},
+		{&s.updateSignCount, `UPDATE webauthn_credentials SET sign_count = ?, last_used_at = ?
+			WHERE credential_id = ? AND client_id = (SELECT id FROM clients WHERE name = ?)`},
+		{&s.deleteCredential, `DELETE FROM webauthn_credentials
+			WHERE credential_id = ? AND client_id = (SELECT id FROM clients WHERE name = ?)`},
+		{&s.saveLogin, `INSERT INTO login_transactions(id, client_name, app_name, role, required, completed, expires_at, created_at)
+			VALUES(?, ?, ?, ?, ?, ?, ?, ?)`},
+		{&s.login, `SELECT id, client_name, app_name, role, required, completed, expires_at, created_at
+			FROM login_transactions WHERE id = ?`},
+		{&s.updateLoginFactors, "UPDATE login_transactions SET required = ?, completed = ? WHERE id = ?"},
+		{&s.deleteLogin, "DELETE FROM login_transactions WHERE id = ?"},
+		{&s.deleteExpiredLogins, "DELETE FROM login_transactions WHERE expires_at <= ?"},
+		{&s.saveReset, "INSERT INTO password_resets(id, client_id, expires_at, created_at) SELECT ?, id, ?, ? FROM clients WHERE name = ?"},
+		{&s.reset, `SELECT r.id, c.name, r.expires_at, r.created_at FROM password_resets r JOIN clients c ON c.id = r.client_id
+			WHERE r.id = ?`},
+		{&s.deleteReset, "DELETE FROM password_resets WHERE id = ?"},
+		{&s.deleteClientResets, "DELETE FROM password_resets WHERE client_id = (SELECT id FROM clients WHERE name = ?)"},
+		{&s.deleteExpiredResets, "DELETE FROM password_resets WHERE expires_at <= ?"},
+		{&s.saveApp, `INSERT INTO apps(name, secret, roles, org_id, created_at, updated_at)
+			SELECT ?1, ?2, ?3, o.id, ?4, ?4 FROM (SELECT 1) LEFT JOIN organizations o ON o.name = ?5 WHERE ?5 = '' OR o.id IS NOT NULL`},
+		{&s.saveOrganization, "INSERT INTO organizations(name, created_at) VALUES(?, ?)"},
+		{&s.organization, "SELECT id, name, created_at FROM organizations WHERE name = ?"},
+		{&s.deleteOrganization, `DELETE FROM organizations WHERE name = ?
+			AND NOT EXISTS(SELECT 1 FROM clients WHERE org_id = organizations.id) AND NOT EXISTS(SELECT 1 FROM apps WHERE org_id = organizations.id)
+			AND NOT EXISTS(SELECT 1 FROM client_groups WHERE substr(client_groups.name, 1, length(organizations.name) + 1) = organizations.name || '/')`},
+		{&s.saveGroup, "INSERT INTO client_groups(name, created_at) VALUES(?, ?)"},
+		{&s.group, "SELECT id, name, created_at FROM client_groups WHERE name = ?"},
+		{&s.deleteGroup, "DELETE FROM client_groups WHERE name = ?"},
+		{&s.addGroupMember, `INSERT INTO group_members(group_id, client_id)
+			SELECT g.id, c.id FROM client_groups g, clients c WHERE g.name = ? AND c.name = ? ON CONFLICT DO NOTHING`},
+		{&s.removeGroupMember, `DELETE FROM group_members
+			WHERE group_id = (SELECT id FROM client_groups WHERE name = ?) AND client_id = (SELECT id FROM clients WHERE name = ?)`},
+		{&s.grantClientRole, `INSERT INTO client_roles(client_id, app_id, role)
+			SELECT c.id, a.id, ? FROM clients c, apps a WHERE c.name = ? AND a.name = ? ON CONFLICT DO NOTHING`},
+		{&s.grantGroupRole, `INSERT INTO group_roles(group_id, app_id, role)
+			SELECT g.id, a.id, ? FROM client_groups g, apps a WHERE g.name = ? AND a.name = ? ON CONFLICT DO NOTHING`},
+		{&s.revokeClientRole, `DELETE FROM client_roles WHERE role = ?
+			AND client_id = (SELECT id FROM clients WHERE name = ?) AND app_id = (SELECT id FROM apps WHERE name = ?)`},
+		{&s.revokeGroupRole, `DELETE FROM group_roles WHERE role = ?
+			AND group_id = (SELECT id FROM client_groups WHERE name = ?) AND app_id = (SELECT id FROM apps WHERE name = ?)`},
+		{&s.clientGroups, `SELECT g.name FROM client_groups g JOIN group_members m ON m.group_id = g.id JOIN clients c ON c.id = m.client_id
+			WHERE c.name = ? ORDER BY g.name`},
+		{&s.clientRoles, `SELECT r.role FROM client_roles r JOIN clients c ON c.id = r.client_id JOIN apps a ON a.id = r.app_id
+				WHERE c.name = ?1 AND a.name = ?2
+			UNION SELECT r.role FROM group_roles r JOIN group_members m ON m.group_id = r.group_id
+				JOIN clients c ON c.id = m.client_id JOIN apps a ON a.id = r.app_id WHERE c.name = ?1 AND a.name = ?2
+			ORDER BY 1`},
+		{&s.app, "SELECT id, name, COALESCE(org_id, 0), display_name, secret, roles, settings, require_mfa, require_verified_email, profile_claims, role_grants, groups_claim, org_clients, version, created_at, updated_at FROM apps WHERE name = ?"},
+		{&s.updateApp, `UPDATE apps SET display_name = COALESCE(?, display_name), roles = COALESCE(?, roles),
+				settings = COALESCE(?, settings), require_mfa = COALESCE(?, require_mfa),
+				require_verified_email = COALESCE(?, require_verified_email), profile_claims = COALESCE(?, profile_claims),
+				role_grants = COALESCE(?, role_grants), groups_claim = COALESCE(?, groups_claim),
+				org_clients = COALESCE(?, org_clients), version = version + 1, updated_at = ?
+			WHERE name = ? AND version = ? RETURNING version`},
+		{&s.deleteApp, "DELETE FROM apps WHERE name = ?"},
+		{&s.loginAttempts, "SELECT failures, last_failure_at, locked_until FROM login_attempts WHERE key = ?"},
+		{&s.incrementFailedLogins, `INSERT INTO login_attempts(key, failures, last_failure_at) VALUES(?, 1, ?)
+			ON CONFLICT(key) DO UPDATE SET
+				failures = CASE WHEN last_failure_at < ? THEN 1 ELSE failures + 1 END,
+				last_failure_at = excluded.last_failure_at
+			RETURNING failures, last_failure_at, locked_until`},
+		{&s.lockLogin, `INSERT INTO login_attempts(key, locked_until) VALUES(?, ?)
+			ON CONFLICT(key) DO UPDATE SET locked_until = excluded.locked_until`},
+		{&s.resetLoginAttempts, "DELETE FROM login_attempts WHERE key = ?"},
+	}
+
+	for _, statement := range statements {
+		*statement.stmt, err = db.Prepare(statement.query)
+
+		if err != nil {
+			s.Stop()
+
+			return nil, helpers.WrapErr(op, err)
+		}
+
+		s.statements = append(s.statements, *statement.stmt)
+	}
+
+	return s, nil
 
 }
 
-func (s *Storage) SaveClient(ctx context.Context, name string, passwordHash []byte) (int64, error) {
+// dsn passes the pragmas in the connection string, so every connection of the pool gets them
+func dsn(storagePath string, opts Options) string {
+	var pragmas []string
 
-	const op = "storage.sqlite.SaveClient"
+	if opts.BusyTimeout > 0 {
+		pragmas = append(pragmas, fmt.Sprintf("_pragma=busy_timeout(%d)", opts.BusyTimeout.Milliseconds()))
+	}
+
+	if opts.JournalMode != "" {
+		pragmas = append(pragmas, fmt.Sprintf("_pragma=journal_mode(%s)", opts.JournalMode))
+	}
+
+	if !opts.DisableForeignKeys {
+		pragmas = append(pragmas, "_pragma=foreign_keys(1)")
+	}
+
+	// transactions take the write lock right away instead of failing to upgrade a read lock later
+	pragmas = append(pragmas, "_txlock=immediate")
+
+	separator := "?"
+
+	if strings.Contains(storagePath, "?") {
+		separator = "&"
+	}
+
+	return storagePath + separator + strings.Join(pragmas, "&")
+}
+
+func (s *Storage) WithinTx(ctx context.Context, fn func(repo interfaces.Repository) error) error {
+	const op = "storage.sqlite.WithinTx"
+
+	if s.tx != nil {
+		return fn(s)
+	}
 
-	query, err := s.db.Prepare("INSERT INTO clients(name,password_hash) VALUES(?, ?)")
+	tx, err := s.db.BeginTx(ctx, nil)
 
 	if err != nil {
-		return 0, helpers.WrapErr(op, err)
+		return helpers.WrapErr(op, err)
+	}
+
+	defer tx.Rollback()
+
+	txStorage := *s
+	txStorage.tx = tx
+
+	if err := fn(&txStorage); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return helpers.WrapErr(op, err)
+	}
+
+	return nil
+}
+
+// stmt binds a prepared statement to the running transaction, if there is one
+func (s *Storage) stmt(ctx context.Context, stmt *sql.Stmt) *sql.Stmt {
+	if s.tx == nil {
+		return stmt
 	}
 
-	res, err := query.ExecContext(ctx, name, passwordHash)
+	return s.tx.StmtContext(ctx, stmt)
+}
+
+func (s *Storage) SaveClient(ctx context.Context, name string, passwordHash []byte) (int64, error) {
+
+	const op = "storage.sqlite.SaveClient"
+
+	org, _ := models.SplitName(name)
+
+	res, err := s.stmt(ctx, s.saveClient).ExecContext(ctx, name, passwordHash, time.Now().Unix(), org)
 
 	if err != nil {
 		if liteErr, ok := err.(*sqlite.Error); ok {
@@ -56,6 +323,10 @@ func (s *Storage) SaveClient(ctx context.Context, name string, passwordHash []by
 		return 0, helpers.WrapErr(op, err)
 	}
 
+	if err := organizationAffected(op, res); err != nil {
+		return 0, err
+	}
+
 	id, err := res.LastInsertId()
 
 	if err != nil {
@@ -69,17 +340,13 @@ func (s *Storage) SaveClient(ctx context.Context, name string, passwordHash []by
 func (s *Storage) Client(ctx context.Context, name string) (models.Client, error) {
 	const op = "storage.sqlite.Client"
 
-	query, err := s.db.Prepare("SELECT id, name, password_hash FROM clients WHERE name = ?")
-
-	if err != nil {
-		return models.Client{}, helpers.WrapErr(op, err)
-	}
-
-	row := query.QueryRowContext(ctx, name)
+	row := s.stmt(ctx, s.client).QueryRowContext(ctx, name)
 
 	var client models.Client
+	var createdAt, updatedAt int64
 
-	err = row.Scan(&client.ID, &client.Name, &client.PasswordHash)
+	err := row.Scan(&client.ID, &client.Name, &client.OrgID, &client.PasswordHash, &client.Status, &client.TokenVersion,
+		&client.TOTPSecret, &client.TOTPEnabled, &client.TOTPStep, &client.Email, &client.EmailVerified, &client.WebAuthnEnabled, &createdAt, &updatedAt)
 
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
@@ -89,27 +356,107 @@ func (s *Storage) Client(ctx context.Context, name string) (models.Client, error
 		return models.Client{}, helpers.WrapErr(op, err)
 	}
 
+	client.CreatedAt, client.UpdatedAt = time.Unix(createdAt, 0), time.Unix(updatedAt, 0)
+
 	return client, nil
 }
 
-func (s *Storage) SaveApp(ctx context.Context, name string, secret string, roles string) (int64, error) {
+func (s *Storage) UpdateClientPassword(ctx context.Context, name string, passwordHash []byte) error {
+	const op = "storage.sqlite.UpdateClientPassword"
 
-	const op = "storage.sqlite.SaveApp"
+	res, err := s.stmt(ctx, s.updateClientPassword).ExecContext(ctx, passwordHash, time.Now().Unix(), name)
 
-	query, err := s.db.Prepare("INSERT INTO apps(name,secret,roles) VALUES(?, ?, ?)")
+	return clientAffected(op, res, err)
+}
+
+func (s *Storage) SetClientStatus(ctx context.Context, name string, status string) error {
+	const op = "storage.sqlite.SetClientStatus"
+
+	res, err := s.stmt(ctx, s.setClientStatus).ExecContext(ctx, status, time.Now().Unix(), name)
+
+	return clientAffected(op, res, err)
+}
+
+func (s *Storage) DeleteClient(ctx context.Context, name string) error {
+	const op = "storage.sqlite.DeleteClient"
+
+	res, err := s.stmt(ctx, s.deleteClient).ExecContext(ctx, name)
+
+	return clientAffected(op, res, err)
+}
+
+func (s *Storage) SaveOrganization(ctx context.Context, name string) (int64, error) {
+	const op = "storage.sqlite.SaveOrganization"
+
+	res, err := s.stmt(ctx, s.saveOrganization).ExecContext(ctx, name, time.Now().Unix())
 
 	if err != nil {
+		if liteErr, ok := err.(*sqlite.Error); ok && liteErr.Code() == sqlite3.SQLITE_CONSTRAINT_UNIQUE {
+			return 0, helpers.WrapErr(op, storage.ErrOrganizationExists)
+		}
+
 		return 0, helpers.WrapErr(op, err)
 	}
 
-	res, err := query.ExecContext(ctx, name, secret, roles)
+	id, err := res.LastInsertId()
 
 	if err != nil {
-		if liteErr, ok := err.(*sqlite.Error); ok {
-			code := liteErr.Code()
-			if code == sqlite3.SQLITE_CONSTRAINT_UNIQUE {
-				return 0, helpers.WrapErr(op, storage.ErrAppExists)
-			}
+		return 0, helpers.WrapErr(op, err)
+	}
+
+	return id, nil
+}
+
+func (s *Storage) Organization(ctx context.Context, name string) (models.Organization, error) {
+	const op = "storage.sqlite.Organization"
+
+	var org models.Organization
+	var createdAt int64
+
+	err := s.stmt(ctx, s.organization).QueryRowContext(ctx, name).Scan(&org.ID, &org.Name, &createdAt)
+
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return models.Organization{}, helpers.WrapErr(op, storage.ErrOrganizationNotFound)
+		}
+
+		return models.Organization{}, helpers.WrapErr(op, err)
+	}
+
+	org.CreatedAt = time.Unix(createdAt, 0)
+
+	return org, nil
+}
+
+func (s *Storage) DeleteOrganization(ctx context.Context, name string) error {
+	const op = "storage.sqlite.DeleteOrganization"
+
+	res, err := s.stmt(ctx, s.deleteOrganization).ExecContext(ctx, name)
+
+	if err != nil {
+		return helpers.WrapErr(op, err)
+	}
+
+	if err := organizationAffected(op, res); err != nil {
+		// either there is no such organization or it still has members
+		if _, err := s.Organization(ctx, name); err != nil {
+			return helpers.WrapErr(op, err)
+		}
+
+		return helpers.WrapErr(op, storage.ErrOrganizationNotEmpty)
+	}
+
+	return nil
+}
+
+func (s *Storage) SaveGroup(ctx context.Context, name string) (int64, error) {
+	const op = "storage.sqlite.SaveGroup"
+
+	res, err := s.stmt(ctx, s.saveGroup).ExecContext(ctx, name, time.Now().Unix())
+
+	if err != nil {
+		if liteErr, ok := err.(*sqlite.Error); ok && liteErr.Code() == sqlite3.SQLITE_CONSTRAINT_UNIQUE {
+			return 0, helpers.WrapErr(op, storage.ErrGroupExists)
 		}
 
 		return 0, helpers.WrapErr(op, err)
@@ -124,32 +471,984 @@ func (s *Storage) SaveApp(ctx context.Context, name string, secret string, roles
 	return id, nil
 }
 
-func (s *Storage) App(ctx context.Context, name string) (models.App, error) {
-	const op = "storage.sqlite.App"
+func (s *Storage) Group(ctx context.Context, name string) (models.Group, error) {
+	const op = "storage.sqlite.Group"
+
+	var group models.Group
+	var createdAt int64
 
-	query, err := s.db.Prepare("SELECT id, name, secret, roles FROM apps WHERE name = ?")
+	err := s.stmt(ctx, s.group).QueryRowContext(ctx, name).Scan(&group.ID, &group.Name, &createdAt)
 
 	if err != nil {
-		return models.App{}, helpers.WrapErr(op, err)
+		if errors.Is(err, sql.ErrNoRows) {
+			return models.Group{}, helpers.WrapErr(op, storage.ErrGroupNotFound)
+		}
+
+		return models.Group{}, helpers.WrapErr(op, err)
 	}
 
-	row := query.QueryRowContext(ctx, name)
+	group.CreatedAt = time.Unix(createdAt, 0)
 
-	var app models.App
+	return group, nil
+}
+
+// DeleteGroup takes the memberships and the role grants of the group with it
+func (s *Storage) DeleteGroup(ctx context.Context, name string) error {
+	const op = "storage.sqlite.DeleteGroup"
+
+	res, err := s.stmt(ctx, s.deleteGroup).ExecContext(ctx, name)
+
+	return groupAffected(op, res, err)
+}
 
-	err = row.Scan(&app.ID, &app.Name, &app.Secret, &app.Roles)
+func (s *Storage) AddGroupMember(ctx context.Context, group string, clientName string) error {
+	const op = "storage.sqlite.AddGroupMember"
+
+	res, err := s.stmt(ctx, s.addGroupMember).ExecContext(ctx, group, clientName)
+
+	return s.membershipAffected(ctx, op, group, clientName, res, err)
+}
+
+func (s *Storage) RemoveGroupMember(ctx context.Context, group string, clientName string) error {
+	const op = "storage.sqlite.RemoveGroupMember"
+
+	res, err := s.stmt(ctx, s.removeGroupMember).ExecContext(ctx, group, clientName)
+
+	return s.membershipAffected(ctx, op, group, clientName, res, err)
+}
+
+func (s *Storage) GrantRole(ctx context.Context, grant models.RoleGrant) error {
+	const op = "storage.sqlite.GrantRole"
+
+	stmt, subject := s.grantClientRole, grant.ClientName
+
+	if grant.GroupName != "" {
+		stmt, subject = s.grantGroupRole, grant.GroupName
+	}
+
+	res, err := s.stmt(ctx, stmt).ExecContext(ctx, grant.Role, subject, grant.AppName)
+
+	return s.grantAffected(ctx, op, grant, res, err)
+}
+
+func (s *Storage) RevokeRole(ctx context.Context, grant models.RoleGrant) error {
+	const op = "storage.sqlite.RevokeRole"
+
+	stmt, subject := s.revokeClientRole, grant.ClientName
+
+	if grant.GroupName != "" {
+		stmt, subject = s.revokeGroupRole, grant.GroupName
+	}
+
+	res, err := s.stmt(ctx, stmt).ExecContext(ctx, grant.Role, subject, grant.AppName)
+
+	return s.grantAffected(ctx, op, grant, res, err)
+}
+
+func (s *Storage) ClientGroups(ctx context.Context, clientName string) ([]string, error) {
+	const op = "storage.sqlite.ClientGroups"
+
+	rows, err := s.stmt(ctx, s.clientGroups).QueryContext(ctx, clientName)
+
+	names, err := scanNames(rows, err)
+
+	if err != nil {
+		return nil, helpers.WrapErr(op, err)
+	}
+
+	return names, nil
+}
+
+func (s *Storage) ClientRoles(ctx context.Context, clientName string, appName string) ([]string, error) {
+	const op = "storage.sqlite.ClientRoles"
+
+	rows, err := s.stmt(ctx, s.clientRoles).QueryContext(ctx, clientName, appName)
+
+	roles, err := scanNames(rows, err)
+
+	if err != nil {
+		return nil, helpers.WrapErr(op, err)
+	}
+
+	return roles, nil
+}
+
+// membershipAffected tells a missing group or client from a membership that was already there or gone
+func (s *Storage) membershipAffected(ctx context.Context, op string, group string, clientName string, res sql.Result, err error) error {
+	if err := groupAffected(op, res, err); err == nil || !errors.Is(err, storage.ErrGroupNotFound) {
+		return err
+	}
+
+	if _, err := s.Group(ctx, group); err != nil {
+		return helpers.WrapErr(op, err)
+	}
+
+	if _, err := s.Client(ctx, clientName); err != nil {
+		return helpers.WrapErr(op, err)
+	}
+
+	return nil
+}
+
+// grantAffected tells a missing client, group or app from a grant that was already there or gone
+func (s *Storage) grantAffected(ctx context.Context, op string, grant models.RoleGrant, res sql.Result, err error) error {
+	if err := groupAffected(op, res, err); err == nil || !errors.Is(err, storage.ErrGroupNotFound) {
+		return err
+	}
+
+	if grant.GroupName != "" {
+		if _, err := s.Group(ctx, grant.GroupName); err != nil {
+			return helpers.WrapErr(op, err)
+		}
+	} else if _, err := s.Client(ctx, grant.ClientName); err != nil {
+		return helpers.WrapErr(op, err)
+	}
+
+	if _, err := s.App(ctx, grant.AppName); err != nil {
+		return helpers.WrapErr(op, err)
+	}
+
+	return nil
+}
+
+func scanNames(rows *sql.Rows, err error) ([]string, error) {
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	names := []string{}
+
+	for rows.Next() {
+		var name string
+
+		if err := rows.Scan(&name); err != nil {
+			return nil, err
+		}
+
+		names = append(names, name)
+	}
+
+	return names, rows.Err()
+}
+
+func (s *Storage) SetTOTPSecret(ctx context.Context, name string, sealedSecret []byte) error {
+	const op = "storage.sqlite.SetTOTPSecret"
+
+	res, err := s.stmt(ctx, s.setTOTPSecret).ExecContext(ctx, sealedSecret, time.Now().Unix(), name)
+
+	return clientAffected(op, res, err)
+}
+
+func (s *Storage) EnableTOTP(ctx context.Context, name string) error {
+	const op = "storage.sqlite.EnableTOTP"
+
+	res, err := s.stmt(ctx, s.enableTOTP).ExecContext(ctx, time.Now().Unix(), name)
+
+	return clientAffected(op, res, err)
+}
+
+func (s *Storage) UseTOTPStep(ctx context.Context, name string, step int64) error {
+	const op = "storage.sqlite.UseTOTPStep"
+
+	res, err := s.stmt(ctx, s.useTOTPStep).ExecContext(ctx, step, name, step)
+
+	if err := clientAffected(op, res, err); err != nil {
+		if !errors.Is(err, storage.ErrClientNotFound) {
+			return err
+		}
+
+		// either there is no such client or the step was already used
+		if _, err := s.Client(ctx, name); err != nil {
+			return helpers.WrapErr(op, err)
+		}
+
+		return helpers.WrapErr(op, storage.ErrTOTPStepUsed)
+	}
+
+	return nil
+}
+
+func (s *Storage) ClearTOTP(ctx context.Context, name string) error {
+	const op = "storage.sqlite.ClearTOTP"
+
+	res, err := s.stmt(ctx, s.clearTOTP).ExecContext(ctx, time.Now().Unix(), name)
+
+	return clientAffected(op, res, err)
+}
+
+func (s *Storage) SetClientEmail(ctx context.Context, name string, email string) error {
+	const op = "storage.sqlite.SetClientEmail"
+
+	res, err := s.stmt(ctx, s.setClientEmail).ExecContext(ctx, email, time.Now().Unix(), name)
+
+	return clientAffected(op, res, err)
+}
+
+func (s *Storage) VerifyClientEmail(ctx context.Context, name string, email string) error {
+	const op = "storage.sqlite.VerifyClientEmail"
+
+	res, err := s.stmt(ctx, s.verifyClientEmail).ExecContext(ctx, time.Now().Unix(), name, email)
+
+	if err := clientAffected(op, res, err); err != nil {
+		if !errors.Is(err, storage.ErrClientNotFound) {
+			return err
+		}
+
+		// either there is no such client or its email is another one by now
+		if _, err := s.Client(ctx, name); err != nil {
+			return helpers.WrapErr(op, err)
+		}
+
+		return helpers.WrapErr(op, storage.ErrEmailChanged)
+	}
+
+	return nil
+}
+
+func (s *Storage) Profile(ctx context.Context, name string) (models.Profile, error) {
+	const op = "storage.sqlite.Profile"
+
+	profile, err := scanProfile(s.stmt(ctx, s.profile).QueryRowContext(ctx, name))
+
+	if err != nil {
+		return models.Profile{}, helpers.WrapErr(op, err)
+	}
+
+	return profile, nil
+}
+
+func (s *Storage) UpdateProfile(ctx context.Context, name string, update models.ProfileUpdate) (models.Profile, error) {
+	const op = "storage.sqlite.UpdateProfile"
+
+	row := s.stmt(ctx, s.updateProfile).QueryRowContext(ctx, update.DisplayName, update.Locale, update.AvatarURL, update.Metadata, time.Now().Unix(), name)
+
+	profile, err := scanProfile(row)
 
 	if err != nil {
+		return models.Profile{}, helpers.WrapErr(op, err)
+	}
+
+	return profile, nil
+}
+
+// scanProfile returns ErrClientNotFound for no row, a profile never updated has no update time
+func scanProfile(row *sql.Row) (models.Profile, error) {
+	var profile models.Profile
+	var updatedAt int64
+
+	if err := row.Scan(&profile.DisplayName, &profile.Locale, &profile.AvatarURL, &profile.Metadata, &updatedAt); err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
-			return models.App{}, helpers.WrapErr(op, storage.ErrAppNotFound)
+			return models.Profile{}, storage.ErrClientNotFound
 		}
 
-		return models.App{}, helpers.WrapErr(op, err)
+		return models.Profile{}, err
 	}
 
-	return app, nil
+	if updatedAt > 0 {
+		profile.UpdatedAt = time.Unix(updatedAt, 0)
+	}
+
+	return profile, nil
 }
 
-func (s *Storage) Stop() {
+func (s *Storage) RecoveryCodes(ctx context.Context, name string) ([]models.RecoveryCode, error) {
+	const op = "storage.sqlite.RecoveryCodes"
+
+	rows, err := s.stmt(ctx, s.recoveryCodes).QueryContext(ctx, name)
+
+	if err != nil {
+		return nil, helpers.WrapErr(op, err)
+	}
+
+	defer rows.Close()
+
+	var codes []models.RecoveryCode
+
+	for rows.Next() {
+		var code models.RecoveryCode
+
+		if err := rows.Scan(&code.ID, &code.Hash); err != nil {
+			return nil, helpers.WrapErr(op, err)
+		}
+
+		codes = append(codes, code)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, helpers.WrapErr(op, err)
+	}
+
+	return codes, nil
+}
+
+func (s *Storage) AddRecoveryCodes(ctx context.Context, name string, hashes [][]byte) error {
+	const op = "storage.sqlite.AddRecoveryCodes"
+
+	now := time.Now().Unix()
+
+	for _, hash := range hashes {
+		res, err := s.stmt(ctx, s.addRecoveryCode).ExecContext(ctx, hash, now, name)
+
+		if err := clientAffected(op, res, err); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (s *Storage) UseRecoveryCode(ctx context.Context, name string, id int64) error {
+	const op = "storage.sqlite.UseRecoveryCode"
+
+	res, err := s.stmt(ctx, s.useRecoveryCode).ExecContext(ctx, time.Now().Unix(), id, name)
+
+	return recoveryCodeAffected(op, res, err)
+}
+
+func (s *Storage) DeleteRecoveryCodes(ctx context.Context, name string) error {
+	const op = "storage.sqlite.DeleteRecoveryCodes"
+
+	if _, err := s.stmt(ctx, s.deleteRecoveryCodes).ExecContext(ctx, name); err != nil {
+		return helpers.WrapErr(op, err)
+	}
+
+	return nil
+}
+
+func (s *Storage) SaveWebAuthnCredential(ctx context.Context, name string, credential models.WebAuthnCredential) error {
+	const op = "storage.sqlite.SaveWebAuthnCredential"
+
+	res, err := s.stmt(ctx, s.saveCredential).ExecContext(ctx, credential.ID, credential.PublicKey, int64(credential.SignCount), time.Now().Unix(), name)
+
+	if err != nil {
+		if liteErr, ok := err.(*sqlite.Error); ok && liteErr.Code() == sqlite3.SQLITE_CONSTRAINT_UNIQUE {
+			return helpers.WrapErr(op, storage.ErrWebAuthnCredentialExists)
+		}
+	}
+
+	return clientAffected(op, res, err)
+}
+
+func (s *Storage) WebAuthnCredentials(ctx context.Context, name string) ([]models.WebAuthnCredential, error) {
+	const op = "storage.sqlite.WebAuthnCredentials"
+
+	rows, err := s.stmt(ctx, s.credentials).QueryContext(ctx, name)
+
+	if err != nil {
+		return nil, helpers.WrapErr(op, err)
+	}
+
+	defer rows.Close()
+
+	var credentials []models.WebAuthnCredential
+
+	for rows.Next() {
+		var credential models.WebAuthnCredential
+		var createdAt, lastUsedAt int64
+
+		if err := rows.Scan(&credential.ID, &credential.PublicKey, &credential.SignCount, &createdAt, &lastUsedAt); err != nil {
+			return nil, helpers.WrapErr(op, err)
+		}
+
+		credential.CreatedAt = time.Unix(createdAt, 0)
+
+		if lastUsedAt != 0 {
+			credential.LastUsedAt = time.Unix(lastUsedAt, 0)
+		}
+
+		credentials = append(credentials, credential)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, helpers.WrapErr(op, err)
+	}
+
+	return credentials, nil
+}
+
+func (s *Storage) UpdateWebAuthnSignCount(ctx context.Context, name string, id []byte, signCount uint32) error {
+	const op = "storage.sqlite.UpdateWebAuthnSignCount"
+
+	res, err := s.stmt(ctx, s.updateSignCount).ExecContext(ctx, int64(signCount), time.Now().Unix(), id, name)
+
+	return credentialAffected(op, res, err)
+}
+
+func (s *Storage) DeleteWebAuthnCredential(ctx context.Context, name string, id []byte) error {
+	const op = "storage.sqlite.DeleteWebAuthnCredential"
+
+	res, err := s.stmt(ctx, s.deleteCredential).ExecContext(ctx, id, name)
+
+	return credentialAffected(op, res, err)
+}
+
+func (s *Storage) SaveLoginTransaction(ctx context.Context, transaction models.LoginTransaction) error {
+	const op = "storage.sqlite.SaveLoginTransaction"
+
+	_, err := s.stmt(ctx, s.saveLogin).ExecContext(ctx, transaction.ID, transaction.ClientName, transaction.AppName, transaction.Role,
+		storage.JoinFactors(transaction.Required), storage.JoinFactors(transaction.Completed), transaction.ExpiresAt.Unix(), time.Now().Unix())
+
+	if err != nil {
+		return helpers.WrapErr(op, err)
+	}
+
+	return nil
+}
+
+func (s *Storage) LoginTransaction(ctx context.Context, id string) (models.LoginTransaction, error) {
+	const op = "storage.sqlite.LoginTransaction"
+
+	row := s.stmt(ctx, s.login).QueryRowContext(ctx, id)
+
+	var transaction models.LoginTransaction
+	var required, completed string
+	var expiresAt, createdAt int64
+
+	err := row.Scan(&transaction.ID, &transaction.ClientName, &transaction.AppName, &transaction.Role, &required, &completed, &expiresAt, &createdAt)
+
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return models.LoginTransaction{}, helpers.WrapErr(op, storage.ErrLoginTransactionNotFound)
+		}
+
+		return models.LoginTransaction{}, helpers.WrapErr(op, err)
+	}
+
+	transaction.Required, transaction.Completed = storage.SplitFactors(required), storage.SplitFactors(completed)
+	transaction.ExpiresAt, transaction.CreatedAt = time.Unix(expiresAt, 0), time.Unix(createdAt, 0)
+
+	return transaction, nil
+}
+
+func (s *Storage) UpdateLoginFactors(ctx context.Context, id string, required []string, completed []string) error {
+	const op = "storage.sqlite.UpdateLoginFactors"
+
+	res, err := s.stmt(ctx, s.updateLoginFactors).ExecContext(ctx, storage.JoinFactors(required), storage.JoinFactors(completed), id)
+
+	return loginAffected(op, res, err)
+}
+
+func (s *Storage) DeleteLoginTransaction(ctx context.Context, id string) error {
+	const op = "storage.sqlite.DeleteLoginTransaction"
+
+	res, err := s.stmt(ctx, s.deleteLogin).ExecContext(ctx, id)
+
+	return loginAffected(op, res, err)
+}
+
+func (s *Storage) DeleteExpiredLoginTransactions(ctx context.Context, now time.Time) (int64, error) {
+	const op = "storage.sqlite.DeleteExpiredLoginTransactions"
+
+	res, err := s.stmt(ctx, s.deleteExpiredLogins).ExecContext(ctx, now.Unix())
+
+	if err != nil {
+		return 0, helpers.WrapErr(op, err)
+	}
+
+	n, err := res.RowsAffected()
+
+	if err != nil {
+		return 0, helpers.WrapErr(op, err)
+	}
+
+	return n, nil
+}
+
+func (s *Storage) SavePasswordReset(ctx context.Context, reset models.PasswordReset) error {
+	const op = "storage.sqlite.SavePasswordReset"
+
+	res, err := s.stmt(ctx, s.saveReset).ExecContext(ctx, reset.ID, reset.ExpiresAt.Unix(), time.Now().Unix(), reset.ClientName)
+
+	return clientAffected(op, res, err)
+}
+
+func (s *Storage) PasswordReset(ctx context.Context, id string) (models.PasswordReset, error) {
+	const op = "storage.sqlite.PasswordReset"
+
+	var reset models.PasswordReset
+	var expiresAt, createdAt int64
+
+	err := s.stmt(ctx, s.reset).QueryRowContext(ctx, id).Scan(&reset.ID, &reset.ClientName, &expiresAt, &createdAt)
+
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return models.PasswordReset{}, helpers.WrapErr(op, storage.ErrPasswordResetNotFound)
+		}
+
+		return models.PasswordReset{}, helpers.WrapErr(op, err)
+	}
+
+	reset.ExpiresAt, reset.CreatedAt = time.Unix(expiresAt, 0), time.Unix(createdAt, 0)
+
+	return reset, nil
+}
+
+func (s *Storage) DeletePasswordReset(ctx context.Context, id string) error {
+	const op = "storage.sqlite.DeletePasswordReset"
+
+	res, err := s.stmt(ctx, s.deleteReset).ExecContext(ctx, id)
+
+	return resetAffected(op, res, err)
+}
+
+func (s *Storage) DeletePasswordResets(ctx context.Context, name string) error {
+	const op = "storage.sqlite.DeletePasswordResets"
+
+	if _, err := s.stmt(ctx, s.deleteClientResets).ExecContext(ctx, name); err != nil {
+		return helpers.WrapErr(op, err)
+	}
+
+	return nil
+}
+
+func (s *Storage) DeleteExpiredPasswordResets(ctx context.Context, now time.Time) (int64, error) {
+	const op = "storage.sqlite.DeleteExpiredPasswordResets"
+
+	res, err := s.stmt(ctx, s.deleteExpiredResets).ExecContext(ctx, now.Unix())
+
+	if err != nil {
+		return 0, helpers.WrapErr(op, err)
+	}
+
+	n, err := res.RowsAffected()
+
+	if err != nil {
+		return 0, helpers.WrapErr(op, err)
+	}
+
+	return n, nil
+}
+
+func (s *Storage) SaveApp(ctx context.Context, name string, secret string, roles string) (int64, error) {
+
+	const op = "storage.sqlite.SaveApp"
+
+	org, _ := models.SplitName(name)
+
+	res, err := s.stmt(ctx, s.saveApp).ExecContext(ctx, name, secret, roles, time.Now().Unix(), org)
+
+	if err != nil {
+		if liteErr, ok := err.(*sqlite.Error); ok {
+			code := liteErr.Code()
+			if code == sqlite3.SQLITE_CONSTRAINT_UNIQUE {
+				return 0, helpers.WrapErr(op, storage.ErrAppExists)
+			}
+		}
+
+		return 0, helpers.WrapErr(op, err)
+	}
+
+	if err := organizationAffected(op, res); err != nil {
+		return 0, err
+	}
+
+	id, err := res.LastInsertId()
+
+	if err != nil {
+		return 0, helpers.WrapErr(op, err)
+	}
+
+	return id, nil
+}
+
+func (s *Storage) App(ctx context.Context, name string) (models.App, error) {
+	const op = "storage.sqlite.App"
+
+	row := s.stmt(ctx, s.app).QueryRowContext(ctx, name)
+
+	var app models.App
+	var createdAt, updatedAt int64
+
+	err := row.Scan(&app.ID, &app.Name, &app.OrgID, &app.DisplayName, &app.Secret, &app.Roles, &app.Settings, &app.RequireMFA, &app.RequireVerifiedEmail, &app.ProfileClaims, &app.RoleGrants, &app.GroupsClaim, &app.OrgClients, &app.Version, &createdAt, &updatedAt)
+
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return models.App{}, helpers.WrapErr(op, storage.ErrAppNotFound)
+		}
+
+		return models.App{}, helpers.WrapErr(op, err)
+	}
+
+	app.CreatedAt, app.UpdatedAt = time.Unix(createdAt, 0), time.Unix(updatedAt, 0)
+
+	return app, nil
+}
+
+func (s *Storage) UpdateApp(ctx context.Context, name string, version int64, update models.AppUpdate) (int64, error) {
+	const op = "storage.sqlite.UpdateApp"
+
+	row := s.stmt(ctx, s.updateApp).QueryRowContext(ctx, update.DisplayName, update.Roles, update.Settings, update.RequireMFA, update.RequireVerifiedEmail, update.ProfileClaims,
+		update.RoleGrants, update.GroupsClaim, update.OrgClients, time.Now().Unix(), name, version)
+
+	err := row.Scan(&version)
+
+	if err != nil {
+		if !errors.Is(err, sql.ErrNoRows) {
+			return 0, helpers.WrapErr(op, err)
+		}
+
+		// either there is no such app or the version has moved on
+		if _, err := s.App(ctx, name); err != nil {
+			return 0, helpers.WrapErr(op, err)
+		}
+
+		return 0, helpers.WrapErr(op, storage.ErrAppConflict)
+	}
+
+	return version, nil
+}
+
+func (s *Storage) DeleteApp(ctx context.Context, name string) error {
+	const op = "storage.sqlite.DeleteApp"
+
+	res, err := s.stmt(ctx, s.deleteApp).ExecContext(ctx, name)
+
+	if err != nil {
+		return helpers.WrapErr(op, err)
+	}
+
+	n, err := res.RowsAffected()
+
+	if err != nil {
+		return helpers.WrapErr(op, err)
+	}
+
+	if n == 0 {
+		return helpers.WrapErr(op, storage.ErrAppNotFound)
+	}
+
+	return nil
+}
+
+func (s *Storage) ListClients(ctx context.Context, q models.ListQuery) ([]models.Client, string, error) {
+	const op = "storage.sqlite.ListClients"
+
+	var clients []models.Client
+
+	next, err := s.list(ctx, `SELECT id, name, COALESCE(org_id, 0), status, token_version, totp_enabled, email, email_verified,
+			EXISTS(SELECT 1 FROM webauthn_credentials w WHERE w.client_id = clients.id), created_at, updated_at FROM clients`, q, func(rows *sql.Rows) (string, error) {
+		var client models.Client
+		var createdAt, updatedAt int64
+
+		if err := rows.Scan(&client.ID, &client.Name, &client.OrgID, &client.Status, &client.TokenVersion, &client.TOTPEnabled, &client.Email, &client.EmailVerified,
+			&client.WebAuthnEnabled, &createdAt, &updatedAt); err != nil {
+			return "", err
+		}
+
+		client.CreatedAt, client.UpdatedAt = time.Unix(createdAt, 0), time.Unix(updatedAt, 0)
+		clients = append(clients, client)
+
+		return storage.NewCursor(q, client.ID, client.Name, client.CreatedAt, client.UpdatedAt), nil
+	})
+
+	if err != nil {
+		return nil, "", helpers.WrapErr(op, err)
+	}
+
+	return clients, next, nil
+}
+
+func (s *Storage) ListApps(ctx context.Context, q models.ListQuery) ([]models.App, string, error) {
+	const op = "storage.sqlite.ListApps"
+
+	var apps []models.App
+
+	next, err := s.list(ctx, "SELECT id, name, COALESCE(org_id, 0), display_name, roles, settings, require_mfa, require_verified_email, profile_claims, role_grants, groups_claim, org_clients, version, created_at, updated_at FROM apps", q, func(rows *sql.Rows) (string, error) {
+		var app models.App
+		var createdAt, updatedAt int64
+
+		if err := rows.Scan(&app.ID, &app.Name, &app.OrgID, &app.DisplayName, &app.Roles, &app.Settings, &app.RequireMFA, &app.RequireVerifiedEmail, &app.ProfileClaims, &app.RoleGrants, &app.GroupsClaim, &app.OrgClients, &app.Version, &createdAt, &updatedAt); err != nil {
+			return "", err
+		}
+
+		app.CreatedAt, app.UpdatedAt = time.Unix(createdAt, 0), time.Unix(updatedAt, 0)
+		apps = append(apps, app)
+
+		return storage.NewCursor(q, app.ID, app.Name, app.CreatedAt, app.UpdatedAt), nil
+	})
+
+	if err != nil {
+		return nil, "", helpers.WrapErr(op, err)
+	}
+
+	return apps, next, nil
+}
+
+/*
+list runs a page query built from q, scan reads one row and returns the cursor after it.
+The query depends on the sort, so it isn't prepared up front like the rest.
+*/
+func (s *Storage) list(ctx context.Context, selectFrom string, q models.ListQuery, scan func(rows *sql.Rows) (string, error)) (string, error) {
+	column, err := storage.SortColumn(q)
+
+	if err != nil {
+		return "", err
+	}
+
+	cursor, ok, err := storage.DecodeCursor(q)
+
+	if err != nil {
+		return "", err
+	}
+
+	query := selectFrom + " WHERE substr(name, 1, length(?)) = ?"
+	args := []any{q.NamePrefix, q.NamePrefix}
+
+	direction, compare := "ASC", ">"
+
+	if q.Desc {
+		direction, compare = "DESC", "<"
+	}
+
+	if ok {
+		var value any = cursor.At
+
+		if column == "name" {
+			value = cursor.Name
+		}
+
+		query += fmt.Sprintf(" AND (%[1]s %[2]s ? OR (%[1]s = ? AND id %[2]s ?))", column, compare)
+		args = append(args, value, value, cursor.ID)
+	}
+
+	limit := storage.PageLimit(q)
+
+	query += fmt.Sprintf(" ORDER BY %[1]s %[2]s, id %[2]s LIMIT ?", column, direction)
+	args = append(args, limit+1)
+
+	var rows *sql.Rows
+
+	if s.tx != nil {
+		rows, err = s.tx.QueryContext(ctx, query, args...)
+	} else {
+		rows, err = s.db.QueryContext(ctx, query, args...)
+	}
+
+	if err != nil {
+		return "", err
+	}
+
+	defer rows.Close()
+
+	var next string
+
+	for i := 0; rows.Next(); i++ {
+		// the extra row only tells there is another page
+		if i == limit {
+			return next, rows.Err()
+		}
+
+		if next, err = scan(rows); err != nil {
+			return "", err
+		}
+	}
+
+	return "", rows.Err()
+}
+
+func (s *Storage) LoginAttempts(ctx context.Context, key string) (models.LoginAttempts, error) {
+	const op = "storage.sqlite.LoginAttempts"
+
+	row := s.stmt(ctx, s.loginAttempts).QueryRowContext(ctx, key)
+
+	attempts, err := scanLoginAttempts(key, row)
+
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return models.LoginAttempts{Key: key}, nil
+		}
+
+		return models.LoginAttempts{}, helpers.WrapErr(op, err)
+	}
+
+	return attempts, nil
+}
+
+func (s *Storage) IncrementFailedLogins(ctx context.Context, key string, at time.Time, resetBefore time.Time) (models.LoginAttempts, error) {
+	const op = "storage.sqlite.IncrementFailedLogins"
+
+	row := s.stmt(ctx, s.incrementFailedLogins).QueryRowContext(ctx, key, at.Unix(), resetBefore.Unix())
+
+	attempts, err := scanLoginAttempts(key, row)
+
+	if err != nil {
+		return models.LoginAttempts{}, helpers.WrapErr(op, err)
+	}
+
+	return attempts, nil
+}
+
+func (s *Storage) LockLogin(ctx context.Context, key string, until time.Time) error {
+	const op = "storage.sqlite.LockLogin"
+
+	if _, err := s.stmt(ctx, s.lockLogin).ExecContext(ctx, key, until.Unix()); err != nil {
+		return helpers.WrapErr(op, err)
+	}
+
+	return nil
+}
+
+func (s *Storage) ResetLoginAttempts(ctx context.Context, key string) error {
+	const op = "storage.sqlite.ResetLoginAttempts"
+
+	if _, err := s.stmt(ctx, s.resetLoginAttempts).ExecContext(ctx, key); err != nil {
+		return helpers.WrapErr(op, err)
+	}
+
+	return nil
+}
+
+// clientAffected turns an update of no rows into ErrClientNotFound
+func clientAffected(op string, res sql.Result, err error) error {
+	if err != nil {
+		return helpers.WrapErr(op, err)
+	}
+
+	n, err := res.RowsAffected()
+
+	if err != nil {
+		return helpers.WrapErr(op, err)
+	}
+
+	if n == 0 {
+		return helpers.WrapErr(op, storage.ErrClientNotFound)
+	}
+
+	return nil
+}
+
+// organizationAffected turns a change of no rows into ErrOrganizationNotFound
+func organizationAffected(op string, res sql.Result) error {
+	n, err := res.RowsAffected()
+
+	if err != nil {
+		return helpers.WrapErr(op, err)
+	}
+
+	if n == 0 {
+		return helpers.WrapErr(op, storage.ErrOrganizationNotFound)
+	}
+
+	return nil
+}
+
+// groupAffected turns a change of no rows into ErrGroupNotFound
+func groupAffected(op string, res sql.Result, err error) error {
+	if err != nil {
+		return helpers.WrapErr(op, err)
+	}
+
+	n, err := res.RowsAffected()
+
+	if err != nil {
+		return helpers.WrapErr(op, err)
+	}
+
+	if n == 0 {
+		return helpers.WrapErr(op, storage.ErrGroupNotFound)
+	}
+
+	return nil
+}
+
+// recoveryCodeAffected turns an update of no rows into ErrRecoveryCodeUsed
+func recoveryCodeAffected(op string, res sql.Result, err error) error {
+	if err != nil {
+		return helpers.WrapErr(op, err)
+	}
+
+	n, err := res.RowsAffected()
+
+	if err != nil {
+		return helpers.WrapErr(op, err)
+	}
+
+	if n == 0 {
+		return helpers.WrapErr(op, storage.ErrRecoveryCodeUsed)
+	}
+
+	return nil
+}
+
+// resetAffected turns a change of no rows into ErrPasswordResetNotFound
+func resetAffected(op string, res sql.Result, err error) error {
+	if err != nil {
+		return helpers.WrapErr(op, err)
+	}
+
+	n, err := res.RowsAffected()
+
+	if err != nil {
+		return helpers.WrapErr(op, err)
+	}
+
+	if n == 0 {
+		return helpers.WrapErr(op, storage.ErrPasswordResetNotFound)
+	}
+
+	return nil
+}
+
+// credentialAffected turns a change of no rows into ErrWebAuthnCredentialNotFound
+func credentialAffected(op string, res sql.Result, err error) error {
+	if err != nil {
+		return helpers.WrapErr(op, err)
+	}
+
+	n, err := res.RowsAffected()
+
+	if err != nil {
+		return helpers.WrapErr(op, err)
+	}
+
+	if n == 0 {
+		return helpers.WrapErr(op, storage.ErrWebAuthnCredentialNotFound)
+	}
+
+	return nil
+}
+
+// loginAffected turns a change of no rows into ErrLoginTransactionNotFound
+func loginAffected(op string, res sql.Result, err error) error {
+	if err != nil {
+		return helpers.WrapErr(op, err)
+	}
+
+	n, err := res.RowsAffected()
+
+	if err != nil {
+		return helpers.WrapErr(op, err)
+	}
+
+	if n == 0 {
+		return helpers.WrapErr(op, storage.ErrLoginTransactionNotFound)
+	}
+
+	return nil
+}
+
+func scanLoginAttempts(key string, row *sql.Row) (models.LoginAttempts, error) {
+	var failures int
+	var lastFailureAt, lockedUntil int64
+
+	if err := row.Scan(&failures, &lastFailureAt, &lockedUntil); err != nil {
+		return models.LoginAttempts{}, err
+	}
+
+	return models.LoginAttempts{
+		Key:           key,
+		Failures:      failures,
+		LastFailureAt: time.Unix(lastFailureAt, 0),
+		LockedUntil:   time.Unix(lockedUntil, 0),
+	}, nil
+}
+
+func (s *Storage) Stop() {
+	for _, stmt := range s.statements {
+		stmt.Close()
+	}
+
 	s.db.Close()
 }
@@ -0,0 +1,55 @@
+package sqlite
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"log/slog"
+	"ssosage/internal/services/ssosage"
+	"testing"
+	"time"
+)
+
+// plainHasher keeps hashing out of the numbers, the benchmark measures storage
+type plainHasher struct{}
+
+func (plainHasher) Hash(password string) ([]byte, error) {
+	return []byte(password), nil
+}
+
+func (plainHasher) Compare(hash []byte, password string) (bool, error) {
+	return bytes.Equal(hash, []byte(password)), nil
+}
+
+func BenchmarkConcurrentGenerateToken(b *testing.B) {
+	s := newTestStorage(b)
+
+	deps := ssosage.StorageDeps(s)
+	deps.Hasher = plainHasher{}
+
+	sso := ssosage.New(slog.New(slog.NewTextHandler(io.Discard, nil)), deps, ssosage.Policies{
+		Lockout: ssosage.LockoutPolicy{ClientMaxAttempts: 5, IPMaxAttempts: 20, Window: time.Minute},
+	})
+
+	ctx := context.Background()
+
+	if _, err := sso.RegisterNewClient(ctx, "client", "password"); err != nil {
+		b.Fatalf("failed to register client: %v", err)
+	}
+
+	if _, err := sso.RegisterNewApp(ctx, "app", "secret", "user"); err != nil {
+		b.Fatalf("failed to register app: %v", err)
+	}
+
+	b.ResetTimer()
+
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			if _, err := sso.GenerateToken(ctx, "client", "password", "", "app", "user", "127.0.0.1"); err != nil {
+				b.Errorf("failed to generate token: %v", err)
+
+				return
+			}
+		}
+	})
+}
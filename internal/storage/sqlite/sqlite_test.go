@@ -0,0 +1,41 @@
+package sqlite
+
+import (
+	"database/sql"
+	"path/filepath"
+	"ssosage/internal/interfaces"
+	"ssosage/internal/storage/storagetest"
+	"testing"
+)
+
+func TestConformance(t *testing.T) {
+	storagetest.Run(t, func(t *testing.T) interfaces.Storage {
+		return newTestStorage(t)
+	})
+}
+
+// newTestStorage migrates a fresh database file before New prepares the statements
+func newTestStorage(t testing.TB) *Storage {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), "ssosage.db")
+
+	db, err := sql.Open("sqlite", path)
+
+	if err != nil {
+		t.Fatalf("failed to open database: %v", err)
+	}
+
+	storagetest.ApplyMigrations(t, db, "../../../migrations/sqlite")
+	db.Close()
+
+	s, err := New(path, DefaultOptions)
+
+	if err != nil {
+		t.Fatalf("failed to create storage: %v", err)
+	}
+
+	t.Cleanup(s.Stop)
+
+	return s
+}
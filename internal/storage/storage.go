@@ -3,8 +3,23 @@ package storage
 import "errors"
 
 var (
-	ErrClientExists   = errors.New("client already exists")
-	ErrClientNotFound = errors.New("client not found")
-	ErrAppExists      = errors.New("app already exists")
-	ErrAppNotFound    = errors.New("app not found")
+	ErrClientExists               = errors.New("client already exists")
+	ErrClientNotFound             = errors.New("client not found")
+	ErrTOTPStepUsed               = errors.New("totp code was already used")
+	ErrRecoveryCodeUsed           = errors.New("recovery code was already used")
+	ErrEmailChanged               = errors.New("client email has changed")
+	ErrWebAuthnCredentialExists   = errors.New("webauthn credential already registered")
+	ErrWebAuthnCredentialNotFound = errors.New("webauthn credential not found")
+	ErrAppExists                  = errors.New("app already exists")
+	ErrAppNotFound                = errors.New("app not found")
+	ErrAppConflict                = errors.New("app was changed by someone else")
+	ErrLoginTransactionNotFound   = errors.New("login transaction not found")
+	ErrPasswordResetNotFound      = errors.New("password reset not found")
+	ErrOrganizationExists         = errors.New("organization already exists")
+	ErrOrganizationNotFound       = errors.New("organization not found")
+	ErrOrganizationNotEmpty       = errors.New("organization still has clients, apps or groups")
+	ErrGroupExists                = errors.New("group already exists")
+	ErrGroupNotFound              = errors.New("group not found")
+	ErrInvalidCursor              = errors.New("invalid cursor")
+	ErrInvalidSort                = errors.New("invalid sort field")
 )
@@ -0,0 +1,1604 @@
+/*
+storagetest is the conformance suite every storage backend has to pass,
+a backend test calls Run with a constructor returning a migrated storage.
+Tests don't expect empty tables, so a backend may share one database between them.
+*/
+package storagetest
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"ssosage/internal/interfaces"
+	"ssosage/internal/models"
+	"ssosage/internal/storage"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+var counter atomic.Int64
+
+func Run(t *testing.T, newStorage func(t *testing.T) interfaces.Storage) {
+	tests := []struct {
+		name string
+		test func(t *testing.T, s interfaces.Storage)
+	}{
+		{"SaveAndGetClient", testSaveAndGetClient},
+		{"ClientExists", testClientExists},
+		{"ClientNotFound", testClientNotFound},
+		{"UpdateClientPassword", testUpdateClientPassword},
+		{"SetClientStatus", testSetClientStatus},
+		{"DeleteClient", testDeleteClient},
+		{"UpdateMissingClient", testUpdateMissingClient},
+		{"TOTP", testTOTP},
+		{"ClientEmail", testClientEmail},
+		{"Profiles", testProfiles},
+		{"RecoveryCodes", testRecoveryCodes},
+		{"WebAuthnCredentials", testWebAuthnCredentials},
+		{"LoginTransactions", testLoginTransactions},
+		{"PasswordResets", testPasswordResets},
+		{"SaveAndGetApp", testSaveAndGetApp},
+		{"AppExists", testAppExists},
+		{"AppNotFound", testAppNotFound},
+		{"UpdateApp", testUpdateApp},
+		{"DeleteApp", testDeleteApp},
+		{"LoginAttempts", testLoginAttempts},
+		{"LoginAttemptsWindow", testLoginAttemptsWindow},
+		{"UniqueAcrossKinds", testUniqueAcrossKinds},
+		{"Organizations", testOrganizations},
+		{"Groups", testGroups},
+		{"CanceledContext", testCanceledContext},
+		{"ConcurrentDuplicateInserts", testConcurrentDuplicateInserts},
+		{"ConcurrentInserts", testConcurrentInserts},
+		{"LargePasswordHash", testLargePasswordHash},
+		{"UnicodeNames", testUnicodeNames},
+		{"ListClients", testListClients},
+		{"ListApps", testListApps},
+		{"ListInvalidQuery", testListInvalidQuery},
+		{"TxCommit", testTxCommit},
+		{"TxRollback", testTxRollback},
+		{"TxNested", testTxNested},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.test(t, newStorage(t))
+		})
+	}
+}
+
+// ApplyMigrations runs the up migrations from dir in version order
+func ApplyMigrations(t testing.TB, db *sql.DB, dir string) {
+	t.Helper()
+
+	files, err := filepath.Glob(filepath.Join(dir, "*.up.sql"))
+
+	if err != nil {
+		t.Fatalf("failed to list migrations: %v", err)
+	}
+
+	slices.SortFunc(files, func(a, b string) int {
+		return migrationVersion(a) - migrationVersion(b)
+	})
+
+	for _, file := range files {
+		query, err := os.ReadFile(file)
+
+		if err != nil {
+			t.Fatalf("failed to read migration %s: %v", file, err)
+		}
+
+		if _, err := db.Exec(string(query)); err != nil {
+			t.Fatalf("failed to apply migration %s: %v", file, err)
+		}
+	}
+}
+
+// UniqueName returns a name that no other test uses
+func UniqueName(prefix string) string {
+	return fmt.Sprintf("%s-%d-%d", prefix, time.Now().UnixNano(), counter.Add(1))
+}
+
+func migrationVersion(file string) int {
+	version, _, _ := strings.Cut(filepath.Base(file), "_")
+
+	v, _ := strconv.Atoi(version)
+
+	return v
+}
+
+func testSaveAndGetClient(t *testing.T, s interfaces.Storage) {
+	ctx := context.Background()
+	name := UniqueName("client")
+	hash := []byte("password hash")
+
+	id, err := s.SaveClient(ctx, name, hash)
+
+	if err != nil {
+		t.Fatalf("failed to save client: %v", err)
+	}
+
+	client, err := s.Client(ctx, name)
+
+	if err != nil {
+		t.Fatalf("failed to get client: %v", err)
+	}
+
+	if int64(client.ID) != id || client.Name != name || !bytes.Equal(client.PasswordHash, hash) {
+		t.Fatalf("unexpected client: %+v, id %d", client, id)
+	}
+
+	if client.Status != models.ClientActive || client.TokenVersion != 0 {
+		t.Fatalf("unexpected defaults of a new client: %+v", client)
+	}
+}
+
+func testClientExists(t *testing.T, s interfaces.Storage) {
+	ctx := context.Background()
+	name := UniqueName("client")
+
+	if _, err := s.SaveClient(ctx, name, []byte("hash")); err != nil {
+		t.Fatalf("failed to save client: %v", err)
+	}
+
+	_, err := s.SaveClient(ctx, name, []byte("other hash"))
+
+	if !errors.Is(err, storage.ErrClientExists) {
+		t.Fatalf("expected ErrClientExists, got: %v", err)
+	}
+}
+
+func testClientNotFound(t *testing.T, s interfaces.Storage) {
+	_, err := s.Client(context.Background(), UniqueName("missing"))
+
+	if !errors.Is(err, storage.ErrClientNotFound) {
+		t.Fatalf("expected ErrClientNotFound, got: %v", err)
+	}
+}
+
+func testUpdateClientPassword(t *testing.T, s interfaces.Storage) {
+	ctx := context.Background()
+	name := UniqueName("client")
+
+	if _, err := s.SaveClient(ctx, name, []byte("old hash")); err != nil {
+		t.Fatalf("failed to save client: %v", err)
+	}
+
+	if err := s.UpdateClientPassword(ctx, name, []byte("new hash")); err != nil {
+		t.Fatalf("failed to update password: %v", err)
+	}
+
+	client, err := s.Client(ctx, name)
+
+	if err != nil {
+		t.Fatalf("failed to get client: %v", err)
+	}
+
+	if !bytes.Equal(client.PasswordHash, []byte("new hash")) || client.TokenVersion != 1 {
+		t.Fatalf("unexpected client: %+v", client)
+	}
+}
+
+func testSetClientStatus(t *testing.T, s interfaces.Storage) {
+	ctx := context.Background()
+	name := UniqueName("client")
+
+	if _, err := s.SaveClient(ctx, name, []byte("hash")); err != nil {
+		t.Fatalf("failed to save client: %v", err)
+	}
+
+	if err := s.SetClientStatus(ctx, name, models.ClientDisabled); err != nil {
+		t.Fatalf("failed to disable client: %v", err)
+	}
+
+	client, err := s.Client(ctx, name)
+
+	if err != nil {
+		t.Fatalf("failed to get client: %v", err)
+	}
+
+	if client.Status != models.ClientDisabled || client.TokenVersion != 1 {
+		t.Fatalf("unexpected client: %+v", client)
+	}
+}
+
+func testDeleteClient(t *testing.T, s interfaces.Storage) {
+	ctx := context.Background()
+	name := UniqueName("client")
+
+	if _, err := s.SaveClient(ctx, name, []byte("hash")); err != nil {
+		t.Fatalf("failed to save client: %v", err)
+	}
+
+	if err := s.DeleteClient(ctx, name); err != nil {
+		t.Fatalf("failed to delete client: %v", err)
+	}
+
+	if _, err := s.Client(ctx, name); !errors.Is(err, storage.ErrClientNotFound) {
+		t.Fatalf("expected ErrClientNotFound, got: %v", err)
+	}
+
+	// the name is free again
+	if _, err := s.SaveClient(ctx, name, []byte("hash")); err != nil {
+		t.Fatalf("failed to save client again: %v", err)
+	}
+}
+
+func testUpdateMissingClient(t *testing.T, s interfaces.Storage) {
+	ctx := context.Background()
+	name := UniqueName("missing")
+
+	if err := s.UpdateClientPassword(ctx, name, []byte("hash")); !errors.Is(err, storage.ErrClientNotFound) {
+		t.Fatalf("UpdateClientPassword: expected ErrClientNotFound, got: %v", err)
+	}
+
+	if err := s.SetClientStatus(ctx, name, models.ClientDisabled); !errors.Is(err, storage.ErrClientNotFound) {
+		t.Fatalf("SetClientStatus: expected ErrClientNotFound, got: %v", err)
+	}
+
+	if err := s.DeleteClient(ctx, name); !errors.Is(err, storage.ErrClientNotFound) {
+		t.Fatalf("DeleteClient: expected ErrClientNotFound, got: %v", err)
+	}
+}
+
+func testTOTP(t *testing.T, s interfaces.Storage) {
+	ctx := context.Background()
+	name := UniqueName("client")
+
+	if _, err := s.SaveClient(ctx, name, []byte("hash")); err != nil {
+		t.Fatalf("failed to save client: %v", err)
+	}
+
+	if err := s.SetTOTPSecret(ctx, name, []byte("sealed")); err != nil {
+		t.Fatalf("failed to set totp secret: %v", err)
+	}
+
+	client, err := s.Client(ctx, name)
+
+	if err != nil {
+		t.Fatalf("failed to get client: %v", err)
+	}
+
+	if string(client.TOTPSecret) != "sealed" || client.TOTPEnabled {
+		t.Fatalf("expected a pending totp secret: %+v", client)
+	}
+
+	if err := s.EnableTOTP(ctx, name); err != nil {
+		t.Fatalf("failed to enable totp: %v", err)
+	}
+
+	if err := s.UseTOTPStep(ctx, name, 100); err != nil {
+		t.Fatalf("failed to use totp step: %v", err)
+	}
+
+	// a code is accepted once, and an older one never after it
+	for _, step := range []int64{100, 99} {
+		if err := s.UseTOTPStep(ctx, name, step); !errors.Is(err, storage.ErrTOTPStepUsed) {
+			t.Fatalf("step %d: expected ErrTOTPStepUsed, got: %v", step, err)
+		}
+	}
+
+	client, err = s.Client(ctx, name)
+
+	if err != nil {
+		t.Fatalf("failed to get client: %v", err)
+	}
+
+	// totp changes don't revoke tokens
+	if !client.TOTPEnabled || client.TOTPStep != 100 || client.TokenVersion != 0 {
+		t.Fatalf("unexpected client with totp: %+v", client)
+	}
+
+	if err := s.ClearTOTP(ctx, name); err != nil {
+		t.Fatalf("failed to clear totp: %v", err)
+	}
+
+	client, err = s.Client(ctx, name)
+
+	if err != nil {
+		t.Fatalf("failed to get client: %v", err)
+	}
+
+	if client.TOTPSecret != nil || client.TOTPEnabled || client.TOTPStep != 0 {
+		t.Fatalf("expected totp to be cleared: %+v", client)
+	}
+
+	if err := s.UseTOTPStep(ctx, UniqueName("missing"), 1); !errors.Is(err, storage.ErrClientNotFound) {
+		t.Fatalf("expected ErrClientNotFound, got: %v", err)
+	}
+}
+
+func testClientEmail(t *testing.T, s interfaces.Storage) {
+	ctx := context.Background()
+	name := UniqueName("client")
+
+	if _, err := s.SaveClient(ctx, name, []byte("hash")); err != nil {
+		t.Fatalf("failed to save client: %v", err)
+	}
+
+	if err := s.SetClientEmail(ctx, name, "old@example.com"); err != nil {
+		t.Fatalf("failed to set email: %v", err)
+	}
+
+	if err := s.SetClientEmail(ctx, name, "new@example.com"); err != nil {
+		t.Fatalf("failed to set email: %v", err)
+	}
+
+	// a verification of the address it had before is stale
+	if err := s.VerifyClientEmail(ctx, name, "old@example.com"); !errors.Is(err, storage.ErrEmailChanged) {
+		t.Fatalf("expected ErrEmailChanged, got: %v", err)
+	}
+
+	client, err := s.Client(ctx, name)
+
+	if err != nil {
+		t.Fatalf("failed to get client: %v", err)
+	}
+
+	// a new address revokes tokens claiming the old one was verified
+	if client.Email != "new@example.com" || client.EmailVerified || client.TokenVersion != 2 {
+		t.Fatalf("expected an unverified email: %+v", client)
+	}
+
+	if err := s.VerifyClientEmail(ctx, name, "new@example.com"); err != nil {
+		t.Fatalf("failed to verify email: %v", err)
+	}
+
+	client, err = s.Client(ctx, name)
+
+	if err != nil {
+		t.Fatalf("failed to get client: %v", err)
+	}
+
+	if !client.EmailVerified || client.TokenVersion != 2 {
+		t.Fatalf("expected a verified email: %+v", client)
+	}
+
+	clients, _, err := s.ListClients(ctx, models.ListQuery{NamePrefix: name, Limit: 10})
+
+	if err != nil {
+		t.Fatalf("failed to list clients: %v", err)
+	}
+
+	if len(clients) != 1 || clients[0].Email != "new@example.com" || !clients[0].EmailVerified {
+		t.Fatalf("expected the email in the list: %+v", clients)
+	}
+
+	for _, err := range []error{
+		s.SetClientEmail(ctx, UniqueName("missing"), "missing@example.com"),
+		s.VerifyClientEmail(ctx, UniqueName("missing"), "missing@example.com"),
+	} {
+		if !errors.Is(err, storage.ErrClientNotFound) {
+			t.Fatalf("expected ErrClientNotFound, got: %v", err)
+		}
+	}
+}
+
+func testProfiles(t *testing.T, s interfaces.Storage) {
+	ctx := context.Background()
+	name := UniqueName("client")
+
+	if _, err := s.SaveClient(ctx, name, []byte("hash")); err != nil {
+		t.Fatalf("failed to save client: %v", err)
+	}
+
+	profile, err := s.Profile(ctx, name)
+
+	if err != nil {
+		t.Fatalf("failed to get profile: %v", err)
+	}
+
+	if profile != (models.Profile{Metadata: "{}"}) {
+		t.Fatalf("expected an empty profile: %+v", profile)
+	}
+
+	displayName := "Client"
+	metadata := `{"team":"core"}`
+
+	profile, err = s.UpdateProfile(ctx, name, models.ProfileUpdate{DisplayName: &displayName, Metadata: &metadata})
+
+	if err != nil {
+		t.Fatalf("failed to update profile: %v", err)
+	}
+
+	if profile.DisplayName != displayName || profile.Metadata != metadata || profile.UpdatedAt.IsZero() {
+		t.Fatalf("unexpected profile: %+v", profile)
+	}
+
+	locale := "en-GB"
+
+	if _, err := s.UpdateProfile(ctx, name, models.ProfileUpdate{Locale: &locale}); err != nil {
+		t.Fatalf("failed to update profile: %v", err)
+	}
+
+	profile, err = s.Profile(ctx, name)
+
+	if err != nil {
+		t.Fatalf("failed to get profile: %v", err)
+	}
+
+	// fields left out of an update keep their values
+	if profile.DisplayName != displayName || profile.Locale != locale || profile.Metadata != metadata || profile.AvatarURL != "" {
+		t.Fatalf("unexpected profile: %+v", profile)
+	}
+
+	client, err := s.Client(ctx, name)
+
+	if err != nil {
+		t.Fatalf("failed to get client: %v", err)
+	}
+
+	if client.TokenVersion != 0 {
+		t.Fatalf("expected profile updates to keep tokens: %+v", client)
+	}
+
+	if err := s.DeleteClient(ctx, name); err != nil {
+		t.Fatalf("failed to delete client: %v", err)
+	}
+
+	// the profile goes with the client
+	if _, err := s.SaveClient(ctx, name, []byte("hash")); err != nil {
+		t.Fatalf("failed to save client: %v", err)
+	}
+
+	if profile, err := s.Profile(ctx, name); err != nil || profile.DisplayName != "" {
+		t.Fatalf("expected an empty profile, got %+v, %v", profile, err)
+	}
+
+	if _, err := s.Profile(ctx, UniqueName("missing")); !errors.Is(err, storage.ErrClientNotFound) {
+		t.Fatalf("expected ErrClientNotFound, got: %v", err)
+	}
+
+	if _, err := s.UpdateProfile(ctx, UniqueName("missing"), models.ProfileUpdate{Locale: &locale}); !errors.Is(err, storage.ErrClientNotFound) {
+		t.Fatalf("expected ErrClientNotFound, got: %v", err)
+	}
+}
+
+func testRecoveryCodes(t *testing.T, s interfaces.Storage) {
+	ctx := context.Background()
+	name := UniqueName("client")
+
+	if _, err := s.SaveClient(ctx, name, []byte("hash")); err != nil {
+		t.Fatalf("failed to save client: %v", err)
+	}
+
+	if err := s.AddRecoveryCodes(ctx, name, [][]byte{[]byte("first"), []byte("second")}); err != nil {
+		t.Fatalf("failed to add recovery codes: %v", err)
+	}
+
+	codes, err := s.RecoveryCodes(ctx, name)
+
+	if err != nil {
+		t.Fatalf("failed to get recovery codes: %v", err)
+	}
+
+	if len(codes) != 2 || string(codes[0].Hash) != "first" || string(codes[1].Hash) != "second" {
+		t.Fatalf("unexpected recovery codes: %+v", codes)
+	}
+
+	if err := s.UseRecoveryCode(ctx, name, codes[0].ID); err != nil {
+		t.Fatalf("failed to use recovery code: %v", err)
+	}
+
+	if err := s.UseRecoveryCode(ctx, name, codes[0].ID); !errors.Is(err, storage.ErrRecoveryCodeUsed) {
+		t.Fatalf("expected ErrRecoveryCodeUsed, got: %v", err)
+	}
+
+	// codes belong to their client
+	if err := s.UseRecoveryCode(ctx, UniqueName("other"), codes[1].ID); !errors.Is(err, storage.ErrRecoveryCodeUsed) {
+		t.Fatalf("expected ErrRecoveryCodeUsed for another client, got: %v", err)
+	}
+
+	if codes, err = s.RecoveryCodes(ctx, name); err != nil || len(codes) != 1 {
+		t.Fatalf("expected one unused code, got %+v, %v", codes, err)
+	}
+
+	if err := s.DeleteRecoveryCodes(ctx, name); err != nil {
+		t.Fatalf("failed to delete recovery codes: %v", err)
+	}
+
+	if codes, err = s.RecoveryCodes(ctx, name); err != nil || len(codes) != 0 {
+		t.Fatalf("expected no codes, got %+v, %v", codes, err)
+	}
+
+	if err := s.AddRecoveryCodes(ctx, UniqueName("missing"), [][]byte{[]byte("code")}); !errors.Is(err, storage.ErrClientNotFound) {
+		t.Fatalf("expected ErrClientNotFound, got: %v", err)
+	}
+}
+
+func testWebAuthnCredentials(t *testing.T, s interfaces.Storage) {
+	ctx := context.Background()
+	name, other := UniqueName("client"), UniqueName("other")
+
+	for _, n := range []string{name, other} {
+		if _, err := s.SaveClient(ctx, n, []byte("hash")); err != nil {
+			t.Fatalf("failed to save client: %v", err)
+		}
+	}
+
+	if client, err := s.Client(ctx, name); err != nil || client.WebAuthnEnabled {
+		t.Fatalf("expected a client without passkeys, got %+v, %v", client, err)
+	}
+
+	first := models.WebAuthnCredential{ID: []byte(UniqueName("credential")), PublicKey: []byte("first key"), SignCount: 1}
+	second := models.WebAuthnCredential{ID: []byte(UniqueName("credential")), PublicKey: []byte("second key")}
+
+	for _, credential := range []models.WebAuthnCredential{first, second} {
+		if err := s.SaveWebAuthnCredential(ctx, name, credential); err != nil {
+			t.Fatalf("failed to save credential: %v", err)
+		}
+	}
+
+	// credential ids are unique across clients
+	if err := s.SaveWebAuthnCredential(ctx, other, first); !errors.Is(err, storage.ErrWebAuthnCredentialExists) {
+		t.Fatalf("expected ErrWebAuthnCredentialExists, got: %v", err)
+	}
+
+	if err := s.SaveWebAuthnCredential(ctx, UniqueName("missing"), models.WebAuthnCredential{ID: []byte(UniqueName("credential"))}); !errors.Is(err, storage.ErrClientNotFound) {
+		t.Fatalf("expected ErrClientNotFound, got: %v", err)
+	}
+
+	if client, err := s.Client(ctx, name); err != nil || !client.WebAuthnEnabled {
+		t.Fatalf("expected a client with passkeys, got %+v, %v", client, err)
+	}
+
+	if clients, _, err := s.ListClients(ctx, models.ListQuery{NamePrefix: name}); err != nil || len(clients) != 1 || !clients[0].WebAuthnEnabled {
+		t.Fatalf("expected a listed client with passkeys, got %+v, %v", clients, err)
+	}
+
+	credentials, err := s.WebAuthnCredentials(ctx, name)
+
+	if err != nil {
+		t.Fatalf("failed to get credentials: %v", err)
+	}
+
+	if len(credentials) != 2 || !bytes.Equal(credentials[0].ID, first.ID) || string(credentials[0].PublicKey) != "first key" ||
+		credentials[0].SignCount != 1 || credentials[0].CreatedAt.IsZero() || !credentials[0].LastUsedAt.IsZero() {
+		t.Fatalf("unexpected credentials: %+v", credentials)
+	}
+
+	if err := s.UpdateWebAuthnSignCount(ctx, name, first.ID, 7); err != nil {
+		t.Fatalf("failed to update sign count: %v", err)
+	}
+
+	// credentials belong to their client
+	if err := s.UpdateWebAuthnSignCount(ctx, other, first.ID, 8); !errors.Is(err, storage.ErrWebAuthnCredentialNotFound) {
+		t.Fatalf("expected ErrWebAuthnCredentialNotFound for another client, got: %v", err)
+	}
+
+	if err := s.DeleteWebAuthnCredential(ctx, other, second.ID); !errors.Is(err, storage.ErrWebAuthnCredentialNotFound) {
+		t.Fatalf("expected ErrWebAuthnCredentialNotFound for another client, got: %v", err)
+	}
+
+	if err := s.DeleteWebAuthnCredential(ctx, name, second.ID); err != nil {
+		t.Fatalf("failed to delete credential: %v", err)
+	}
+
+	if err := s.DeleteWebAuthnCredential(ctx, name, second.ID); !errors.Is(err, storage.ErrWebAuthnCredentialNotFound) {
+		t.Fatalf("expected ErrWebAuthnCredentialNotFound, got: %v", err)
+	}
+
+	credentials, err = s.WebAuthnCredentials(ctx, name)
+
+	if err != nil || len(credentials) != 1 || credentials[0].SignCount != 7 || credentials[0].LastUsedAt.IsZero() {
+		t.Fatalf("expected the used credential to remain, got %+v, %v", credentials, err)
+	}
+
+	// deleting the client deletes its credentials
+	if err := s.DeleteClient(ctx, name); err != nil {
+		t.Fatalf("failed to delete client: %v", err)
+	}
+
+	if err := s.SaveWebAuthnCredential(ctx, other, first); err != nil {
+		t.Fatalf("expected the credential id to be free again, got: %v", err)
+	}
+}
+
+func testLoginTransactions(t *testing.T, s interfaces.Storage) {
+	ctx := context.Background()
+	now := time.Unix(time.Now().Unix(), 0)
+
+	transaction := models.LoginTransaction{
+		ID:         UniqueName("login"),
+		ClientName: "client",
+		AppName:    "app",
+		Role:       "user",
+		Required:   []string{"password"},
+		ExpiresAt:  now.Add(time.Minute),
+	}
+
+	expired := transaction
+	expired.ID = UniqueName("expired")
+	expired.ExpiresAt = now.Add(-time.Second)
+
+	for _, tr := range []models.LoginTransaction{transaction, expired} {
+		if err := s.SaveLoginTransaction(ctx, tr); err != nil {
+			t.Fatalf("failed to save login transaction: %v", err)
+		}
+	}
+
+	if err := s.UpdateLoginFactors(ctx, transaction.ID, []string{"password", "totp"}, []string{"password"}); err != nil {
+		t.Fatalf("failed to update login factors: %v", err)
+	}
+
+	got, err := s.LoginTransaction(ctx, transaction.ID)
+
+	if err != nil {
+		t.Fatalf("failed to get login transaction: %v", err)
+	}
+
+	if got.ClientName != "client" || got.AppName != "app" || got.Role != "user" || !got.ExpiresAt.Equal(transaction.ExpiresAt) ||
+		!slices.Equal(got.Required, []string{"password", "totp"}) || !slices.Equal(got.Completed, []string{"password"}) {
+		t.Fatalf("unexpected login transaction: %+v", got)
+	}
+
+	if _, err := s.DeleteExpiredLoginTransactions(ctx, now); err != nil {
+		t.Fatalf("failed to delete expired login transactions: %v", err)
+	}
+
+	if _, err := s.LoginTransaction(ctx, expired.ID); !errors.Is(err, storage.ErrLoginTransactionNotFound) {
+		t.Fatalf("expected the expired transaction to be gone, got: %v", err)
+	}
+
+	if err := s.DeleteLoginTransaction(ctx, transaction.ID); err != nil {
+		t.Fatalf("failed to delete login transaction: %v", err)
+	}
+
+	// only one caller claims a finished login
+	if err := s.DeleteLoginTransaction(ctx, transaction.ID); !errors.Is(err, storage.ErrLoginTransactionNotFound) {
+		t.Fatalf("expected ErrLoginTransactionNotFound, got: %v", err)
+	}
+
+	if err := s.UpdateLoginFactors(ctx, transaction.ID, nil, nil); !errors.Is(err, storage.ErrLoginTransactionNotFound) {
+		t.Fatalf("expected ErrLoginTransactionNotFound, got: %v", err)
+	}
+}
+
+func testPasswordResets(t *testing.T, s interfaces.Storage) {
+	ctx := context.Background()
+	now := time.Unix(time.Now().Unix(), 0)
+	name := UniqueName("client")
+
+	if _, err := s.SaveClient(ctx, name, []byte("hash")); err != nil {
+		t.Fatalf("failed to save client: %v", err)
+	}
+
+	reset := models.PasswordReset{ID: UniqueName("reset"), ClientName: name, ExpiresAt: now.Add(time.Minute)}
+	other := models.PasswordReset{ID: UniqueName("reset"), ClientName: name, ExpiresAt: now.Add(time.Minute)}
+	expired := models.PasswordReset{ID: UniqueName("expired"), ClientName: name, ExpiresAt: now.Add(-time.Second)}
+
+	for _, r := range []models.PasswordReset{reset, other, expired} {
+		if err := s.SavePasswordReset(ctx, r); err != nil {
+			t.Fatalf("failed to save password reset: %v", err)
+		}
+	}
+
+	if err := s.SavePasswordReset(ctx, models.PasswordReset{ID: UniqueName("reset"), ClientName: UniqueName("missing"), ExpiresAt: now}); !errors.Is(err, storage.ErrClientNotFound) {
+		t.Fatalf("expected ErrClientNotFound, got: %v", err)
+	}
+
+	got, err := s.PasswordReset(ctx, reset.ID)
+
+	if err != nil {
+		t.Fatalf("failed to get password reset: %v", err)
+	}
+
+	if got.ID != reset.ID || got.ClientName != name || !got.ExpiresAt.Equal(reset.ExpiresAt) || got.CreatedAt.IsZero() {
+		t.Fatalf("unexpected password reset: %+v", got)
+	}
+
+	if n, err := s.DeleteExpiredPasswordResets(ctx, now); err != nil || n < 1 {
+		t.Fatalf("expected the expired reset to be purged, got %d, %v", n, err)
+	}
+
+	if _, err := s.PasswordReset(ctx, expired.ID); !errors.Is(err, storage.ErrPasswordResetNotFound) {
+		t.Fatalf("expected ErrPasswordResetNotFound, got: %v", err)
+	}
+
+	if err := s.DeletePasswordReset(ctx, reset.ID); err != nil {
+		t.Fatalf("failed to delete password reset: %v", err)
+	}
+
+	// only one caller claims a reset
+	if err := s.DeletePasswordReset(ctx, reset.ID); !errors.Is(err, storage.ErrPasswordResetNotFound) {
+		t.Fatalf("expected ErrPasswordResetNotFound, got: %v", err)
+	}
+
+	if err := s.DeletePasswordResets(ctx, name); err != nil {
+		t.Fatalf("failed to delete password resets: %v", err)
+	}
+
+	if _, err := s.PasswordReset(ctx, other.ID); !errors.Is(err, storage.ErrPasswordResetNotFound) {
+		t.Fatalf("expected the other reset to be gone, got: %v", err)
+	}
+
+	// deleting the client deletes its resets
+	if err := s.SavePasswordReset(ctx, reset); err != nil {
+		t.Fatalf("failed to save password reset: %v", err)
+	}
+
+	if err := s.DeleteClient(ctx, name); err != nil {
+		t.Fatalf("failed to delete client: %v", err)
+	}
+
+	if _, err := s.PasswordReset(ctx, reset.ID); !errors.Is(err, storage.ErrPasswordResetNotFound) {
+		t.Fatalf("expected the reset to go with its client, got: %v", err)
+	}
+}
+
+func testSaveAndGetApp(t *testing.T, s interfaces.Storage) {
+	ctx := context.Background()
+	name := UniqueName("app")
+
+	id, err := s.SaveApp(ctx, name, "secret", "user,admin")
+
+	if err != nil {
+		t.Fatalf("failed to save app: %v", err)
+	}
+
+	app, err := s.App(ctx, name)
+
+	if err != nil {
+		t.Fatalf("failed to get app: %v", err)
+	}
+
+	if int64(app.ID) != id || app.Name != name || app.Secret != "secret" || app.Roles != "user,admin" {
+		t.Fatalf("unexpected app: %+v, id %d", app, id)
+	}
+
+	if app.Version != 1 || app.Settings != "{}" || app.DisplayName != "" || app.RequireMFA {
+		t.Fatalf("unexpected defaults of a new app: %+v", app)
+	}
+}
+
+func testUpdateApp(t *testing.T, s interfaces.Storage) {
+	ctx := context.Background()
+	name := UniqueName("app")
+
+	if _, err := s.SaveApp(ctx, name, "secret", "user"); err != nil {
+		t.Fatalf("failed to save app: %v", err)
+	}
+
+	app, err := s.App(ctx, name)
+
+	if err != nil {
+		t.Fatalf("failed to get app: %v", err)
+	}
+
+	displayName := "Display name"
+	roles := "user,admin"
+
+	version, err := s.UpdateApp(ctx, name, app.Version, models.AppUpdate{DisplayName: &displayName, Roles: &roles})
+
+	if err != nil {
+		t.Fatalf("failed to update app: %v", err)
+	}
+
+	if version != app.Version+1 {
+		t.Fatalf("expected version %d, got %d", app.Version+1, version)
+	}
+
+	settings := `{"theme":"dark"}`
+	requireMFA := true
+	requireVerifiedEmail := true
+	roleGrants := true
+	groupsClaim := true
+	orgClients := true
+
+	profileClaims := "display_name,metadata.team"
+
+	update := models.AppUpdate{Settings: &settings, RequireMFA: &requireMFA, RequireVerifiedEmail: &requireVerifiedEmail, ProfileClaims: &profileClaims,
+		RoleGrants: &roleGrants, GroupsClaim: &groupsClaim, OrgClients: &orgClients}
+
+	if version, err = s.UpdateApp(ctx, name, version, update); err != nil {
+		t.Fatalf("failed to update settings: %v", err)
+	}
+
+	updated, err := s.App(ctx, name)
+
+	if err != nil {
+		t.Fatalf("failed to get app: %v", err)
+	}
+
+	// fields left out of an update keep their values
+	if updated.DisplayName != displayName || updated.Roles != roles || updated.Settings != settings || !updated.RequireMFA || !updated.RequireVerifiedEmail ||
+		updated.ProfileClaims != profileClaims || !updated.RoleGrants || !updated.GroupsClaim || !updated.OrgClients || updated.Version != version || updated.Secret != "secret" {
+		t.Fatalf("unexpected updated app: %+v", updated)
+	}
+
+	// the stale version loses
+	if _, err := s.UpdateApp(ctx, name, app.Version, models.AppUpdate{Roles: &roles}); !errors.Is(err, storage.ErrAppConflict) {
+		t.Fatalf("expected ErrAppConflict, got: %v", err)
+	}
+
+	if _, err := s.UpdateApp(ctx, UniqueName("missing"), 1, models.AppUpdate{Roles: &roles}); !errors.Is(err, storage.ErrAppNotFound) {
+		t.Fatalf("expected ErrAppNotFound, got: %v", err)
+	}
+}
+
+func testDeleteApp(t *testing.T, s interfaces.Storage) {
+	ctx := context.Background()
+	name := UniqueName("app")
+
+	if _, err := s.SaveApp(ctx, name, "secret", "user"); err != nil {
+		t.Fatalf("failed to save app: %v", err)
+	}
+
+	if err := s.DeleteApp(ctx, name); err != nil {
+		t.Fatalf("failed to delete app: %v", err)
+	}
+
+	if _, err := s.App(ctx, name); !errors.Is(err, storage.ErrAppNotFound) {
+		t.Fatalf("expected ErrAppNotFound, got: %v", err)
+	}
+
+	if err := s.DeleteApp(ctx, name); !errors.Is(err, storage.ErrAppNotFound) {
+		t.Fatalf("expected ErrAppNotFound, got: %v", err)
+	}
+}
+
+func testAppExists(t *testing.T, s interfaces.Storage) {
+	ctx := context.Background()
+	name := UniqueName("app")
+
+	if _, err := s.SaveApp(ctx, name, "secret", "user"); err != nil {
+		t.Fatalf("failed to save app: %v", err)
+	}
+
+	_, err := s.SaveApp(ctx, name, "other secret", "admin")
+
+	if !errors.Is(err, storage.ErrAppExists) {
+		t.Fatalf("expected ErrAppExists, got: %v", err)
+	}
+}
+
+func testAppNotFound(t *testing.T, s interfaces.Storage) {
+	_, err := s.App(context.Background(), UniqueName("missing"))
+
+	if !errors.Is(err, storage.ErrAppNotFound) {
+		t.Fatalf("expected ErrAppNotFound, got: %v", err)
+	}
+}
+
+func testLoginAttempts(t *testing.T, s interfaces.Storage) {
+	ctx := context.Background()
+	key := UniqueName("client")
+	now := time.Unix(time.Now().Unix(), 0)
+
+	attempts, err := s.LoginAttempts(ctx, key)
+
+	if err != nil || attempts.Failures != 0 || !attempts.LockedUntil.Before(now) {
+		t.Fatalf("expected no attempts, got: %+v, %v", attempts, err)
+	}
+
+	for i := 1; i <= 3; i++ {
+		attempts, err = s.IncrementFailedLogins(ctx, key, now, now.Add(-time.Minute))
+
+		if err != nil || attempts.Failures != i {
+			t.Fatalf("expected %d failures, got: %+v, %v", i, attempts, err)
+		}
+	}
+
+	until := now.Add(time.Hour)
+
+	if err := s.LockLogin(ctx, key, until); err != nil {
+		t.Fatalf("failed to lock login: %v", err)
+	}
+
+	attempts, err = s.LoginAttempts(ctx, key)
+
+	if err != nil || attempts.Failures != 3 || !attempts.LockedUntil.Equal(until) || !attempts.LastFailureAt.Equal(now) {
+		t.Fatalf("unexpected attempts after lock: %+v, %v", attempts, err)
+	}
+
+	if err := s.ResetLoginAttempts(ctx, key); err != nil {
+		t.Fatalf("failed to reset login attempts: %v", err)
+	}
+
+	attempts, err = s.LoginAttempts(ctx, key)
+
+	if err != nil || attempts.Failures != 0 || attempts.LockedUntil.After(now) {
+		t.Fatalf("expected reset attempts, got: %+v, %v", attempts, err)
+	}
+}
+
+func testLoginAttemptsWindow(t *testing.T, s interfaces.Storage) {
+	ctx := context.Background()
+	key := UniqueName("ip")
+	start := time.Now().Add(-time.Hour)
+
+	if _, err := s.IncrementFailedLogins(ctx, key, start, start.Add(-time.Minute)); err != nil {
+		t.Fatalf("failed to increment failed logins: %v", err)
+	}
+
+	// the previous failure is older than the window, so counting starts again
+	now := time.Now()
+
+	attempts, err := s.IncrementFailedLogins(ctx, key, now, now.Add(-time.Minute))
+
+	if err != nil || attempts.Failures != 1 {
+		t.Fatalf("expected failures to restart, got: %+v, %v", attempts, err)
+	}
+}
+
+// a client and an app may share a name, names differing only in case are different
+func testUniqueAcrossKinds(t *testing.T, s interfaces.Storage) {
+	ctx := context.Background()
+	name := UniqueName("shared")
+
+	if _, err := s.SaveClient(ctx, name, []byte("hash")); err != nil {
+		t.Fatalf("failed to save client: %v", err)
+	}
+
+	if _, err := s.SaveApp(ctx, name, "secret", "user"); err != nil {
+		t.Fatalf("failed to save app with a client name: %v", err)
+	}
+
+	if _, err := s.SaveClient(ctx, strings.ToUpper(name), []byte("hash")); err != nil {
+		t.Fatalf("failed to save client differing in case: %v", err)
+	}
+}
+
+func testOrganizations(t *testing.T, s interfaces.Storage) {
+	ctx := context.Background()
+	first, second := UniqueName("org"), UniqueName("org")
+
+	id, err := s.SaveOrganization(ctx, first)
+
+	if err != nil {
+		t.Fatalf("failed to save organization: %v", err)
+	}
+
+	if _, err := s.SaveOrganization(ctx, second); err != nil {
+		t.Fatalf("failed to save organization: %v", err)
+	}
+
+	if _, err := s.SaveOrganization(ctx, first); !errors.Is(err, storage.ErrOrganizationExists) {
+		t.Fatalf("expected ErrOrganizationExists, got: %v", err)
+	}
+
+	org, err := s.Organization(ctx, first)
+
+	if err != nil || org.ID != uint64(id) || org.Name != first {
+		t.Fatalf("unexpected organization %+v, %v", org, err)
+	}
+
+	// the same names in two organizations and the global namespace
+	for _, name := range []string{first + "/admin", second + "/admin", "admin-" + first} {
+		if _, err := s.SaveClient(ctx, name, []byte("hash")); err != nil {
+			t.Fatalf("failed to save client %s: %v", name, err)
+		}
+
+		if _, err := s.SaveApp(ctx, name, "secret", "user"); err != nil {
+			t.Fatalf("failed to save app %s: %v", name, err)
+		}
+	}
+
+	client, err := s.Client(ctx, first+"/admin")
+
+	if err != nil || client.OrgID != org.ID {
+		t.Fatalf("expected a client in the organization, got %+v, %v", client, err)
+	}
+
+	app, err := s.App(ctx, first+"/admin")
+
+	if err != nil || app.OrgID != org.ID {
+		t.Fatalf("expected an app in the organization, got %+v, %v", app, err)
+	}
+
+	if client, err := s.Client(ctx, "admin-"+first); err != nil || client.OrgID != 0 {
+		t.Fatalf("expected a global client, got %+v, %v", client, err)
+	}
+
+	missing := UniqueName("missing") + "/admin"
+
+	if _, err := s.SaveClient(ctx, missing, []byte("hash")); !errors.Is(err, storage.ErrOrganizationNotFound) {
+		t.Fatalf("expected ErrOrganizationNotFound, got: %v", err)
+	}
+
+	if _, err := s.SaveApp(ctx, missing, "secret", "user"); !errors.Is(err, storage.ErrOrganizationNotFound) {
+		t.Fatalf("expected ErrOrganizationNotFound, got: %v", err)
+	}
+
+	if err := s.DeleteOrganization(ctx, first); !errors.Is(err, storage.ErrOrganizationNotEmpty) {
+		t.Fatalf("expected ErrOrganizationNotEmpty, got: %v", err)
+	}
+
+	if err := s.DeleteClient(ctx, first+"/admin"); err != nil {
+		t.Fatalf("failed to delete client: %v", err)
+	}
+
+	// the app is still in it
+	if err := s.DeleteOrganization(ctx, first); !errors.Is(err, storage.ErrOrganizationNotEmpty) {
+		t.Fatalf("expected ErrOrganizationNotEmpty, got: %v", err)
+	}
+
+	if err := s.DeleteApp(ctx, first+"/admin"); err != nil {
+		t.Fatalf("failed to delete app: %v", err)
+	}
+
+	if _, err := s.SaveGroup(ctx, first+"/devs"); err != nil {
+		t.Fatalf("failed to save group: %v", err)
+	}
+
+	// a group only belongs to the organization by its name, a similar prefix doesn't count
+	if _, err := s.SaveGroup(ctx, first+"-other/devs"); err != nil {
+		t.Fatalf("failed to save group: %v", err)
+	}
+
+	if err := s.DeleteOrganization(ctx, first); !errors.Is(err, storage.ErrOrganizationNotEmpty) {
+		t.Fatalf("expected ErrOrganizationNotEmpty while a group is in it, got: %v", err)
+	}
+
+	if err := s.DeleteGroup(ctx, first+"/devs"); err != nil {
+		t.Fatalf("failed to delete group: %v", err)
+	}
+
+	if err := s.DeleteOrganization(ctx, first); err != nil {
+		t.Fatalf("failed to delete organization: %v", err)
+	}
+
+	if err := s.DeleteOrganization(ctx, first); !errors.Is(err, storage.ErrOrganizationNotFound) {
+		t.Fatalf("expected ErrOrganizationNotFound, got: %v", err)
+	}
+}
+
+func testGroups(t *testing.T, s interfaces.Storage) {
+	ctx := context.Background()
+	group, client, app := UniqueName("group"), UniqueName("client"), UniqueName("app")
+
+	if _, err := s.SaveClient(ctx, client, []byte("hash")); err != nil {
+		t.Fatalf("failed to save client: %v", err)
+	}
+
+	if _, err := s.SaveApp(ctx, app, "secret", "user,editor,admin"); err != nil {
+		t.Fatalf("failed to save app: %v", err)
+	}
+
+	id, err := s.SaveGroup(ctx, group)
+
+	if err != nil {
+		t.Fatalf("failed to save group: %v", err)
+	}
+
+	if _, err := s.SaveGroup(ctx, group); !errors.Is(err, storage.ErrGroupExists) {
+		t.Fatalf("expected ErrGroupExists, got: %v", err)
+	}
+
+	if saved, err := s.Group(ctx, group); err != nil || saved.ID != uint64(id) || saved.Name != group {
+		t.Fatalf("unexpected group %+v, %v", saved, err)
+	}
+
+	// adding twice is no error
+	for range 2 {
+		if err := s.AddGroupMember(ctx, group, client); err != nil {
+			t.Fatalf("failed to add group member: %v", err)
+		}
+	}
+
+	if err := s.AddGroupMember(ctx, UniqueName("missing"), client); !errors.Is(err, storage.ErrGroupNotFound) {
+		t.Fatalf("expected ErrGroupNotFound, got: %v", err)
+	}
+
+	if err := s.AddGroupMember(ctx, group, UniqueName("missing")); !errors.Is(err, storage.ErrClientNotFound) {
+		t.Fatalf("expected ErrClientNotFound, got: %v", err)
+	}
+
+	if groups, err := s.ClientGroups(ctx, client); err != nil || !slices.Equal(groups, []string{group}) {
+		t.Fatalf("expected the client in %s, got %v, %v", group, groups, err)
+	}
+
+	grants := []models.RoleGrant{
+		{ClientName: client, AppName: app, Role: "user"},
+		{GroupName: group, AppName: app, Role: "editor"},
+		{GroupName: group, AppName: app, Role: "user"},
+	}
+
+	for _, grant := range append(grants, grants[0]) {
+		if err := s.GrantRole(ctx, grant); err != nil {
+			t.Fatalf("failed to grant %+v: %v", grant, err)
+		}
+	}
+
+	if err := s.GrantRole(ctx, models.RoleGrant{GroupName: group, AppName: UniqueName("missing"), Role: "user"}); !errors.Is(err, storage.ErrAppNotFound) {
+		t.Fatalf("expected ErrAppNotFound, got: %v", err)
+	}
+
+	if err := s.GrantRole(ctx, models.RoleGrant{ClientName: UniqueName("missing"), AppName: app, Role: "user"}); !errors.Is(err, storage.ErrClientNotFound) {
+		t.Fatalf("expected ErrClientNotFound, got: %v", err)
+	}
+
+	// direct and group grants, without duplicates
+	if roles, err := s.ClientRoles(ctx, client, app); err != nil || !slices.Equal(roles, []string{"editor", "user"}) {
+		t.Fatalf("expected editor and user, got %v, %v", roles, err)
+	}
+
+	if err := s.RevokeRole(ctx, grants[1]); err != nil {
+		t.Fatalf("failed to revoke role: %v", err)
+	}
+
+	if err := s.RemoveGroupMember(ctx, group, client); err != nil {
+		t.Fatalf("failed to remove group member: %v", err)
+	}
+
+	// removing again is no error
+	if err := s.RemoveGroupMember(ctx, group, client); err != nil {
+		t.Fatalf("failed to remove group member: %v", err)
+	}
+
+	if roles, err := s.ClientRoles(ctx, client, app); err != nil || !slices.Equal(roles, []string{"user"}) {
+		t.Fatalf("expected the direct grant only, got %v, %v", roles, err)
+	}
+
+	if err := s.AddGroupMember(ctx, group, client); err != nil {
+		t.Fatalf("failed to add group member: %v", err)
+	}
+
+	if err := s.DeleteGroup(ctx, group); err != nil {
+		t.Fatalf("failed to delete group: %v", err)
+	}
+
+	if err := s.DeleteGroup(ctx, group); !errors.Is(err, storage.ErrGroupNotFound) {
+		t.Fatalf("expected ErrGroupNotFound, got: %v", err)
+	}
+
+	// a group registered again starts empty
+	if _, err := s.SaveGroup(ctx, group); err != nil {
+		t.Fatalf("failed to save group: %v", err)
+	}
+
+	if groups, err := s.ClientGroups(ctx, client); err != nil || len(groups) != 0 {
+		t.Fatalf("expected no groups, got %v, %v", groups, err)
+	}
+
+	if err := s.DeleteApp(ctx, app); err != nil {
+		t.Fatalf("failed to delete app: %v", err)
+	}
+
+	if _, err := s.SaveApp(ctx, app, "secret", "user"); err != nil {
+		t.Fatalf("failed to save app: %v", err)
+	}
+
+	if roles, err := s.ClientRoles(ctx, client, app); err != nil || len(roles) != 0 {
+		t.Fatalf("expected no roles in an app registered again, got %v, %v", roles, err)
+	}
+
+	if roles, err := s.ClientRoles(ctx, UniqueName("missing"), app); err != nil || len(roles) != 0 {
+		t.Fatalf("expected no roles for an unknown client, got %v, %v", roles, err)
+	}
+}
+
+func testCanceledContext(t *testing.T, s interfaces.Storage) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	name := UniqueName("canceled")
+
+	if _, err := s.SaveClient(ctx, name, []byte("hash")); !errors.Is(err, context.Canceled) {
+		t.Fatalf("SaveClient: expected context.Canceled, got: %v", err)
+	}
+
+	if _, err := s.Client(ctx, name); !errors.Is(err, context.Canceled) {
+		t.Fatalf("Client: expected context.Canceled, got: %v", err)
+	}
+
+	if _, err := s.SaveApp(ctx, name, "secret", "user"); !errors.Is(err, context.Canceled) {
+		t.Fatalf("SaveApp: expected context.Canceled, got: %v", err)
+	}
+
+	if _, err := s.App(ctx, name); !errors.Is(err, context.Canceled) {
+		t.Fatalf("App: expected context.Canceled, got: %v", err)
+	}
+
+	// nothing was written with the canceled context
+	if _, err := s.Client(context.Background(), name); !errors.Is(err, storage.ErrClientNotFound) {
+		t.Fatalf("expected ErrClientNotFound, got: %v", err)
+	}
+}
+
+func testConcurrentDuplicateInserts(t *testing.T, s interfaces.Storage) {
+	const workers = 16
+
+	ctx := context.Background()
+	name := UniqueName("client")
+
+	var wg sync.WaitGroup
+	var saved, exists atomic.Int64
+
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+
+			_, err := s.SaveClient(ctx, name, []byte("hash"))
+
+			switch {
+			case err == nil:
+				saved.Add(1)
+			case errors.Is(err, storage.ErrClientExists):
+				exists.Add(1)
+			default:
+				t.Errorf("unexpected error: %v", err)
+			}
+		}()
+	}
+
+	wg.Wait()
+
+	if saved.Load() != 1 || exists.Load() != workers-1 {
+		t.Fatalf("expected 1 saved and %d existing, got %d and %d", workers-1, saved.Load(), exists.Load())
+	}
+}
+
+func testConcurrentInserts(t *testing.T, s interfaces.Storage) {
+	const workers = 16
+
+	ctx := context.Background()
+
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+
+	ids := make(map[int64]string, workers)
+
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+
+			name := UniqueName("app")
+
+			id, err := s.SaveApp(ctx, name, "secret", "user")
+
+			if err != nil {
+				t.Errorf("failed to save app: %v", err)
+
+				return
+			}
+
+			mu.Lock()
+			defer mu.Unlock()
+
+			if other, ok := ids[id]; ok {
+				t.Errorf("apps %s and %s got the same id %d", name, other, id)
+			}
+
+			ids[id] = name
+		}()
+	}
+
+	wg.Wait()
+
+	for id, name := range ids {
+		app, err := s.App(ctx, name)
+
+		if err != nil || int64(app.ID) != id {
+			t.Fatalf("unexpected app %s: %+v, %v", name, app, err)
+		}
+	}
+}
+
+func testLargePasswordHash(t *testing.T, s interfaces.Storage) {
+	ctx := context.Background()
+	name := UniqueName("client")
+
+	hash := make([]byte, 1<<20)
+
+	for i := range hash {
+		hash[i] = byte(i % 251)
+	}
+
+	if _, err := s.SaveClient(ctx, name, hash); err != nil {
+		t.Fatalf("failed to save client: %v", err)
+	}
+
+	client, err := s.Client(ctx, name)
+
+	if err != nil {
+		t.Fatalf("failed to get client: %v", err)
+	}
+
+	if !bytes.Equal(client.PasswordHash, hash) {
+		t.Fatalf("password hash of %d bytes came back as %d bytes", len(hash), len(client.PasswordHash))
+	}
+}
+
+func testUnicodeNames(t *testing.T, s interfaces.Storage) {
+	ctx := context.Background()
+	suffix := UniqueName("")
+
+	// precomposed and decomposed forms are different names
+	names := []string{"клиент" + suffix, "客户" + suffix, "🔑" + suffix, "caf\u00e9" + suffix, "cafe\u0301" + suffix}
+
+	for _, name := range names {
+		if _, err := s.SaveClient(ctx, name, []byte(name)); err != nil {
+			t.Fatalf("failed to save client %q: %v", name, err)
+		}
+
+		if _, err := s.SaveApp(ctx, name, name, "роль"); err != nil {
+			t.Fatalf("failed to save app %q: %v", name, err)
+		}
+	}
+
+	for _, name := range names {
+		client, err := s.Client(ctx, name)
+
+		if err != nil || client.Name != name || string(client.PasswordHash) != name {
+			t.Fatalf("unexpected client %q: %+v, %v", name, client, err)
+		}
+
+		app, err := s.App(ctx, name)
+
+		if err != nil || app.Name != name || app.Secret != name || app.Roles != "роль" {
+			t.Fatalf("unexpected app %q: %+v, %v", name, app, err)
+		}
+	}
+}
+
+func testTxCommit(t *testing.T, s interfaces.Storage) {
+	ctx := context.Background()
+	client := UniqueName("client")
+	app := UniqueName("app")
+	key := UniqueName("key")
+
+	err := s.WithinTx(ctx, func(repo interfaces.Repository) error {
+		if _, err := repo.SaveClient(ctx, client, []byte("hash")); err != nil {
+			return err
+		}
+
+		if _, err := repo.SaveApp(ctx, app, "secret", "user"); err != nil {
+			return err
+		}
+
+		if _, err := repo.IncrementFailedLogins(ctx, key, time.Now(), time.Now().Add(-time.Minute)); err != nil {
+			return err
+		}
+
+		// the transaction sees its own writes
+		_, err := repo.Client(ctx, client)
+
+		return err
+	})
+
+	if err != nil {
+		t.Fatalf("transaction failed: %v", err)
+	}
+
+	if _, err := s.Client(ctx, client); err != nil {
+		t.Fatalf("failed to get committed client: %v", err)
+	}
+
+	if _, err := s.App(ctx, app); err != nil {
+		t.Fatalf("failed to get committed app: %v", err)
+	}
+
+	attempts, err := s.LoginAttempts(ctx, key)
+
+	if err != nil {
+		t.Fatalf("failed to get login attempts: %v", err)
+	}
+
+	if attempts.Failures != 1 {
+		t.Fatalf("expected 1 failure, got %d", attempts.Failures)
+	}
+}
+
+func testTxRollback(t *testing.T, s interfaces.Storage) {
+	ctx := context.Background()
+	client := UniqueName("client")
+	app := UniqueName("app")
+	key := UniqueName("key")
+	errAbort := errors.New("abort")
+
+	err := s.WithinTx(ctx, func(repo interfaces.Repository) error {
+		if _, err := repo.SaveClient(ctx, client, []byte("hash")); err != nil {
+			return err
+		}
+
+		if _, err := repo.SaveApp(ctx, app, "secret", "user"); err != nil {
+			return err
+		}
+
+		if err := repo.LockLogin(ctx, key, time.Now().Add(time.Hour)); err != nil {
+			return err
+		}
+
+		return errAbort
+	})
+
+	if !errors.Is(err, errAbort) {
+		t.Fatalf("expected the error of fn, got: %v", err)
+	}
+
+	if _, err := s.Client(ctx, client); !errors.Is(err, storage.ErrClientNotFound) {
+		t.Fatalf("expected ErrClientNotFound, got: %v", err)
+	}
+
+	if _, err := s.App(ctx, app); !errors.Is(err, storage.ErrAppNotFound) {
+		t.Fatalf("expected ErrAppNotFound, got: %v", err)
+	}
+
+	attempts, err := s.LoginAttempts(ctx, key)
+
+	if err != nil {
+		t.Fatalf("failed to get login attempts: %v", err)
+	}
+
+	if attempts.LockedUntil.After(time.Now()) {
+		t.Fatalf("lock survived the rollback: %+v", attempts)
+	}
+
+	// the storage is still usable after a rollback
+	if _, err := s.SaveClient(ctx, client, []byte("hash")); err != nil {
+		t.Fatalf("failed to save client after rollback: %v", err)
+	}
+}
+
+func testTxNested(t *testing.T, s interfaces.Storage) {
+	ctx := context.Background()
+	outer := UniqueName("client")
+	inner := UniqueName("client")
+	errAbort := errors.New("abort")
+
+	err := s.WithinTx(ctx, func(repo interfaces.Repository) error {
+		if _, err := repo.SaveClient(ctx, outer, []byte("hash")); err != nil {
+			return err
+		}
+
+		err := repo.WithinTx(ctx, func(repo interfaces.Repository) error {
+			_, err := repo.SaveClient(ctx, inner, []byte("hash"))
+
+			return err
+		})
+
+		if err != nil {
+			return err
+		}
+
+		return errAbort
+	})
+
+	if !errors.Is(err, errAbort) {
+		t.Fatalf("expected the error of fn, got: %v", err)
+	}
+
+	// the nested call joined the outer transaction, so both writes are gone
+	for _, name := range []string{outer, inner} {
+		if _, err := s.Client(ctx, name); !errors.Is(err, storage.ErrClientNotFound) {
+			t.Fatalf("expected ErrClientNotFound for %s, got: %v", name, err)
+		}
+	}
+}
+
+func testListClients(t *testing.T, s interfaces.Storage) {
+	ctx := context.Background()
+	prefix := UniqueName("list") + "-"
+
+	for _, suffix := range []string{"c", "a", "e", "b", "d"} {
+		if _, err := s.SaveClient(ctx, prefix+suffix, []byte("hash")); err != nil {
+			t.Fatalf("failed to save client: %v", err)
+		}
+	}
+
+	// another prefix sharing the first characters stays out
+	if _, err := s.SaveClient(ctx, strings.TrimSuffix(prefix, "-"), []byte("hash")); err != nil {
+		t.Fatalf("failed to save client: %v", err)
+	}
+
+	tests := []struct {
+		name  string
+		query models.ListQuery
+		want  []string
+	}{
+		{"by name", models.ListQuery{SortBy: models.SortByName}, []string{"a", "b", "c", "d", "e"}},
+		{"by name desc", models.ListQuery{SortBy: models.SortByName, Desc: true}, []string{"e", "d", "c", "b", "a"}},
+		{"by creation", models.ListQuery{SortBy: models.SortByCreatedAt}, []string{"c", "a", "e", "b", "d"}},
+		{"by creation desc", models.ListQuery{SortBy: models.SortByCreatedAt, Desc: true}, []string{"d", "b", "e", "a", "c"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			q := tt.query
+			q.NamePrefix = prefix
+			q.Limit = 2
+
+			var got []string
+			pages := 0
+
+			for {
+				clients, next, err := s.ListClients(ctx, q)
+
+				if err != nil {
+					t.Fatalf("failed to list clients: %v", err)
+				}
+
+				for _, client := range clients {
+					if client.PasswordHash != nil || client.Status != models.ClientActive || client.CreatedAt.IsZero() {
+						t.Fatalf("unexpected listed client: %+v", client)
+					}
+
+					got = append(got, strings.TrimPrefix(client.Name, prefix))
+				}
+
+				pages++
+
+				if next == "" {
+					break
+				}
+
+				q.Cursor = next
+			}
+
+			if !slices.Equal(got, tt.want) || pages != 3 {
+				t.Fatalf("expected %v in 3 pages, got %v in %d", tt.want, got, pages)
+			}
+		})
+	}
+}
+
+func testListApps(t *testing.T, s interfaces.Storage) {
+	ctx := context.Background()
+	prefix := UniqueName("list") + "-"
+
+	for _, suffix := range []string{"b", "a"} {
+		if _, err := s.SaveApp(ctx, prefix+suffix, "secret", "user"); err != nil {
+			t.Fatalf("failed to save app: %v", err)
+		}
+	}
+
+	apps, next, err := s.ListApps(ctx, models.ListQuery{NamePrefix: prefix})
+
+	if err != nil {
+		t.Fatalf("failed to list apps: %v", err)
+	}
+
+	if next != "" || len(apps) != 2 || apps[0].Name != prefix+"a" || apps[1].Name != prefix+"b" {
+		t.Fatalf("unexpected page: %+v, next %q", apps, next)
+	}
+
+	if apps[0].Secret != "" || apps[0].Roles != "user" {
+		t.Fatalf("unexpected listed app: %+v", apps[0])
+	}
+}
+
+func testListInvalidQuery(t *testing.T, s interfaces.Storage) {
+	ctx := context.Background()
+	prefix := UniqueName("list") + "-"
+
+	for _, suffix := range []string{"a", "b"} {
+		if _, err := s.SaveClient(ctx, prefix+suffix, []byte("hash")); err != nil {
+			t.Fatalf("failed to save client: %v", err)
+		}
+	}
+
+	if _, _, err := s.ListClients(ctx, models.ListQuery{SortBy: "password_hash"}); !errors.Is(err, storage.ErrInvalidSort) {
+		t.Fatalf("expected ErrInvalidSort, got: %v", err)
+	}
+
+	if _, _, err := s.ListClients(ctx, models.ListQuery{Cursor: "not a cursor"}); !errors.Is(err, storage.ErrInvalidCursor) {
+		t.Fatalf("expected ErrInvalidCursor, got: %v", err)
+	}
+
+	_, next, err := s.ListClients(ctx, models.ListQuery{NamePrefix: prefix, Limit: 1})
+
+	if err != nil || next == "" {
+		t.Fatalf("expected a next page, got %q, err: %v", next, err)
+	}
+
+	// a cursor only fits the sort it was made for
+	_, _, err = s.ListClients(ctx, models.ListQuery{NamePrefix: prefix, Limit: 1, SortBy: models.SortByName, Desc: true, Cursor: next})
+
+	if !errors.Is(err, storage.ErrInvalidCursor) {
+		t.Fatalf("expected ErrInvalidCursor, got: %v", err)
+	}
+}
@@ -0,0 +1,198 @@
+package tlsconfig
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"slices"
+	"ssosage/internal/helpers"
+	"sync"
+	"time"
+)
+
+var (
+	ErrNoCertificate = errors.New("no certificates found in client ca file")
+)
+
+// files are checked for changes at most that often
+const reloadCheckInterval = time.Second
+
+type Config struct {
+	CertFile     string
+	KeyFile      string
+	ClientCAFile string
+	MinVersion   string
+}
+
+/*
+Reloader serves the certificate and client ca bundle from disk and rereads them
+when one of the files changes, a broken update is logged and the previous files keep being served.
+*/
+type Reloader struct {
+	log        *slog.Logger
+	cfg        Config
+	minVersion uint16
+
+	mu        sync.RWMutex
+	cert      *tls.Certificate
+	clientCAs *x509.CertPool
+	modTimes  []time.Time
+	lastCheck time.Time
+}
+
+func NewReloader(log *slog.Logger, cfg Config) (*Reloader, error) {
+	const op = "tlsconfig.NewReloader"
+
+	minVersion, err := parseVersion(cfg.MinVersion)
+
+	if err != nil {
+		return nil, helpers.WrapErr(op, err)
+	}
+
+	r := &Reloader{log: log, cfg: cfg, minVersion: minVersion}
+
+	if err := r.load(); err != nil {
+		return nil, helpers.WrapErr(op, err)
+	}
+
+	return r, nil
+}
+
+// ServerConfig returns a tls config requiring and verifying client certificates if client ca file is set
+func (r *Reloader) ServerConfig() *tls.Config {
+	return &tls.Config{
+		MinVersion: r.minVersion,
+		GetConfigForClient: func(*tls.ClientHelloInfo) (*tls.Config, error) {
+			r.reloadIfChanged()
+
+			r.mu.RLock()
+			defer r.mu.RUnlock()
+
+			cfg := &tls.Config{
+				MinVersion:   r.minVersion,
+				Certificates: []tls.Certificate{*r.cert},
+			}
+
+			if r.clientCAs != nil {
+				cfg.ClientCAs = r.clientCAs
+				cfg.ClientAuth = tls.RequireAndVerifyClientCert
+			}
+
+			return cfg, nil
+		},
+	}
+}
+
+func (r *Reloader) reloadIfChanged() {
+	const op = "tlsconfig.reloadIfChanged"
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if time.Since(r.lastCheck) < reloadCheckInterval {
+		return
+	}
+
+	r.lastCheck = time.Now()
+
+	modTimes, err := r.stat()
+
+	if err != nil {
+		r.log.Error("failed to check tls files", slog.String("op", op), helpers.SlErr(err))
+
+		return
+	}
+
+	if slices.EqualFunc(modTimes, r.modTimes, time.Time.Equal) {
+		return
+	}
+
+	if err := r.loadLocked(); err != nil {
+		r.log.Error("failed to reload tls files, keeping the previous ones", slog.String("op", op), helpers.SlErr(err))
+
+		return
+	}
+
+	r.log.Info("reloaded tls files", slog.String("op", op))
+}
+
+func (r *Reloader) load() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.lastCheck = time.Now()
+
+	return r.loadLocked()
+}
+
+func (r *Reloader) loadLocked() error {
+	modTimes, err := r.stat()
+
+	if err != nil {
+		return err
+	}
+
+	cert, err := tls.LoadX509KeyPair(r.cfg.CertFile, r.cfg.KeyFile)
+
+	if err != nil {
+		return err
+	}
+
+	var clientCAs *x509.CertPool
+
+	if r.cfg.ClientCAFile != "" {
+		pem, err := os.ReadFile(r.cfg.ClientCAFile)
+
+		if err != nil {
+			return err
+		}
+
+		clientCAs = x509.NewCertPool()
+
+		if !clientCAs.AppendCertsFromPEM(pem) {
+			return ErrNoCertificate
+		}
+	}
+
+	r.cert = &cert
+	r.clientCAs = clientCAs
+	r.modTimes = modTimes
+
+	return nil
+}
+
+func (r *Reloader) stat() ([]time.Time, error) {
+	files := []string{r.cfg.CertFile, r.cfg.KeyFile}
+
+	if r.cfg.ClientCAFile != "" {
+		files = append(files, r.cfg.ClientCAFile)
+	}
+
+	modTimes := make([]time.Time, 0, len(files))
+
+	for _, file := range files {
+		info, err := os.Stat(file)
+
+		if err != nil {
+			return nil, err
+		}
+
+		modTimes = append(modTimes, info.ModTime())
+	}
+
+	return modTimes, nil
+}
+
+func parseVersion(version string) (uint16, error) {
+	switch version {
+	case "", "1.2":
+		return tls.VersionTLS12, nil
+	case "1.3":
+		return tls.VersionTLS13, nil
+	}
+
+	return 0, fmt.Errorf("unsupported tls min version %q", version)
+}
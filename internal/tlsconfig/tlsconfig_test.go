@@ -0,0 +1,199 @@
+package tlsconfig
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"io"
+	"log/slog"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// writeSelfSigned writes a new self-signed certificate with its key and returns the certificate der
+func writeSelfSigned(t *testing.T, certFile string, keyFile string, name string, modTime time.Time) []byte {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+
+	if err != nil {
+		t.Fatalf("failed to generate key: %v", err)
+	}
+
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(time.Now().UnixNano()),
+		Subject:               pkix.Name{CommonName: name},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+
+	if err != nil {
+		t.Fatalf("failed to create certificate: %v", err)
+	}
+
+	keyDER, err := x509.MarshalECPrivateKey(key)
+
+	if err != nil {
+		t.Fatalf("failed to marshal key: %v", err)
+	}
+
+	writePEM(t, certFile, "CERTIFICATE", der, modTime)
+	writePEM(t, keyFile, "EC PRIVATE KEY", keyDER, modTime)
+
+	return der
+}
+
+func writePEM(t *testing.T, file string, blockType string, der []byte, modTime time.Time) {
+	t.Helper()
+
+	if err := os.WriteFile(file, pem.EncodeToMemory(&pem.Block{Type: blockType, Bytes: der}), 0o600); err != nil {
+		t.Fatalf("failed to write %s: %v", file, err)
+	}
+
+	// mod times are set explicitly, the test runs faster than some file systems tick
+	if err := os.Chtimes(file, modTime, modTime); err != nil {
+		t.Fatalf("failed to set mod time of %s: %v", file, err)
+	}
+}
+
+// served returns the config the reloader hands to a new connection, checking the files first
+func served(t *testing.T, r *Reloader) *tls.Config {
+	t.Helper()
+
+	// skip the wait between checks
+	r.mu.Lock()
+	r.lastCheck = time.Time{}
+	r.mu.Unlock()
+
+	cfg, err := r.ServerConfig().GetConfigForClient(&tls.ClientHelloInfo{})
+
+	if err != nil {
+		t.Fatalf("failed to get config: %v", err)
+	}
+
+	return cfg
+}
+
+func TestReload(t *testing.T) {
+	dir := t.TempDir()
+	certFile, keyFile := filepath.Join(dir, "server.pem"), filepath.Join(dir, "server-key.pem")
+	modTime := time.Now().Add(-time.Minute)
+
+	first := writeSelfSigned(t, certFile, keyFile, "first", modTime)
+
+	r, err := NewReloader(slog.New(slog.NewTextHandler(io.Discard, nil)), Config{CertFile: certFile, KeyFile: keyFile})
+
+	if err != nil {
+		t.Fatalf("failed to create reloader: %v", err)
+	}
+
+	cfg := served(t, r)
+
+	if string(cfg.Certificates[0].Certificate[0]) != string(first) || cfg.ClientAuth != tls.NoClientCert || cfg.MinVersion != tls.VersionTLS12 {
+		t.Fatalf("unexpected config: %+v", cfg)
+	}
+
+	second := writeSelfSigned(t, certFile, keyFile, "second", modTime.Add(time.Second))
+
+	if cfg = served(t, r); string(cfg.Certificates[0].Certificate[0]) != string(second) {
+		t.Fatalf("expected the new certificate to be served")
+	}
+
+	// a broken update keeps the previous certificate
+	writePEM(t, certFile, "CERTIFICATE", []byte("garbage"), modTime.Add(2*time.Second))
+
+	if cfg = served(t, r); string(cfg.Certificates[0].Certificate[0]) != string(second) {
+		t.Fatalf("expected the previous certificate after a broken update")
+	}
+
+	third := writeSelfSigned(t, certFile, keyFile, "third", modTime.Add(3*time.Second))
+
+	if cfg = served(t, r); string(cfg.Certificates[0].Certificate[0]) != string(third) {
+		t.Fatalf("expected the fixed certificate to be served")
+	}
+}
+
+func TestReloadClientCA(t *testing.T) {
+	dir := t.TempDir()
+	certFile, keyFile := filepath.Join(dir, "server.pem"), filepath.Join(dir, "server-key.pem")
+	caFile, caKeyFile := filepath.Join(dir, "ca.pem"), filepath.Join(dir, "ca-key.pem")
+	modTime := time.Now().Add(-time.Minute)
+
+	writeSelfSigned(t, certFile, keyFile, "server", modTime)
+	ca := writeSelfSigned(t, caFile, caKeyFile, "first ca", modTime)
+
+	r, err := NewReloader(slog.New(slog.NewTextHandler(io.Discard, nil)), Config{CertFile: certFile, KeyFile: keyFile, ClientCAFile: caFile, MinVersion: "1.3"})
+
+	if err != nil {
+		t.Fatalf("failed to create reloader: %v", err)
+	}
+
+	cfg := served(t, r)
+
+	if cfg.ClientAuth != tls.RequireAndVerifyClientCert || cfg.MinVersion != tls.VersionTLS13 || !hasSubject(cfg, ca) {
+		t.Fatalf("unexpected config: %+v", cfg)
+	}
+
+	next := writeSelfSigned(t, caFile, caKeyFile, "next ca", modTime.Add(time.Second))
+
+	if cfg = served(t, r); !hasSubject(cfg, next) || hasSubject(cfg, ca) {
+		t.Fatalf("expected the new client ca bundle to be served")
+	}
+}
+
+// hasSubject tells whether the client cas of cfg include the certificate der
+func hasSubject(cfg *tls.Config, der []byte) bool {
+	cert, err := x509.ParseCertificate(der)
+
+	if err != nil {
+		return false
+	}
+
+	for _, subject := range cfg.ClientCAs.Subjects() {
+		if string(subject) == string(cert.RawSubject) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func TestNewReloaderErrors(t *testing.T) {
+	dir := t.TempDir()
+	certFile, keyFile := filepath.Join(dir, "server.pem"), filepath.Join(dir, "server-key.pem")
+	empty := filepath.Join(dir, "empty.pem")
+
+	writeSelfSigned(t, certFile, keyFile, "server", time.Now())
+
+	if err := os.WriteFile(empty, nil, 0o600); err != nil {
+		t.Fatalf("failed to write empty file: %v", err)
+	}
+
+	log := slog.New(slog.NewTextHandler(io.Discard, nil))
+
+	if _, err := NewReloader(log, Config{CertFile: filepath.Join(dir, "missing.pem"), KeyFile: keyFile}); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("expected a missing file error, got: %v", err)
+	}
+
+	if _, err := NewReloader(log, Config{CertFile: certFile, KeyFile: keyFile, ClientCAFile: empty}); !errors.Is(err, ErrNoCertificate) {
+		t.Fatalf("expected ErrNoCertificate, got: %v", err)
+	}
+
+	if _, err := NewReloader(log, Config{CertFile: certFile, KeyFile: keyFile, MinVersion: "1.0"}); err == nil {
+		t.Fatalf("expected an error for tls 1.0")
+	}
+}
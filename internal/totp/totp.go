@@ -0,0 +1,93 @@
+/*
+totp implements time based one time passwords (RFC 6238) the way authenticator apps expect them:
+HMAC-SHA1, 6 digits and a 30 second period.
+*/
+package totp
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/binary"
+	"fmt"
+	"net/url"
+	"time"
+)
+
+const (
+	Digits     = 6
+	Period     = 30 * time.Second
+	SecretSize = 20
+)
+
+var encoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+func GenerateSecret() ([]byte, error) {
+	secret := make([]byte, SecretSize)
+
+	if _, err := rand.Read(secret); err != nil {
+		return nil, err
+	}
+
+	return secret, nil
+}
+
+// EncodeSecret returns the form users type into an authenticator app
+func EncodeSecret(secret []byte) string {
+	return encoding.EncodeToString(secret)
+}
+
+// URI builds the otpauth uri usually shown as a qr code
+func URI(issuer string, account string, secret []byte) string {
+	label := url.PathEscape(issuer + ":" + account)
+
+	params := url.Values{}
+	params.Set("secret", EncodeSecret(secret))
+	params.Set("issuer", issuer)
+	params.Set("algorithm", "SHA1")
+	params.Set("digits", fmt.Sprint(Digits))
+	params.Set("period", fmt.Sprint(int(Period.Seconds())))
+
+	return "otpauth://totp/" + label + "?" + params.Encode()
+}
+
+// Step is the time step counter at t
+func Step(t time.Time) int64 {
+	return t.Unix() / int64(Period.Seconds())
+}
+
+// Code computes the code of a time step
+func Code(secret []byte, step int64) string {
+	mac := hmac.New(sha1.New, secret)
+	binary.Write(mac, binary.BigEndian, uint64(step))
+	sum := mac.Sum(nil)
+
+	offset := sum[len(sum)-1] & 0x0f
+	value := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+
+	return fmt.Sprintf("%0*d", Digits, value%1_000_000)
+}
+
+/*
+Validate looks for code among the steps within skew of now and returns the matching step.
+Callers prevent replays by accepting only steps newer than the last accepted one.
+*/
+func Validate(secret []byte, code string, now time.Time, skew int) (int64, bool) {
+	if len(code) != Digits {
+		return 0, false
+	}
+
+	current := Step(now)
+
+	for i := -skew; i <= skew; i++ {
+		step := current + int64(i)
+
+		if subtle.ConstantTimeCompare([]byte(Code(secret, step)), []byte(code)) == 1 {
+			return step, true
+		}
+	}
+
+	return 0, false
+}
@@ -0,0 +1,57 @@
+package totp
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+// the sha1 vectors of RFC 6238 appendix B, truncated to 6 digits
+func TestCode(t *testing.T) {
+	secret := []byte("12345678901234567890")
+
+	tests := []struct {
+		unix int64
+		code string
+	}{
+		{59, "287082"},
+		{1111111109, "081804"},
+		{1111111111, "050471"},
+		{1234567890, "005924"},
+		{2000000000, "279037"},
+	}
+
+	for _, tt := range tests {
+		if code := Code(secret, Step(time.Unix(tt.unix, 0))); code != tt.code {
+			t.Errorf("at %d expected %s, got %s", tt.unix, tt.code, code)
+		}
+	}
+}
+
+func TestValidate(t *testing.T) {
+	secret := []byte("12345678901234567890")
+	now := time.Unix(1111111111, 0)
+	previous := Code(secret, Step(now)-1)
+
+	step, ok := Validate(secret, previous, now, 1)
+
+	if !ok || step != Step(now)-1 {
+		t.Fatalf("expected the previous step to match, got %d %v", step, ok)
+	}
+
+	if _, ok := Validate(secret, previous, now, 0); ok {
+		t.Fatal("expected the previous step to be rejected without skew")
+	}
+
+	if _, ok := Validate(secret, "12345", now, 1); ok {
+		t.Fatal("expected a short code to be rejected")
+	}
+}
+
+func TestURI(t *testing.T) {
+	uri := URI("ssosage", "client", []byte("12345678901234567890"))
+
+	if !strings.HasPrefix(uri, "otpauth://totp/ssosage:client?") || !strings.Contains(uri, "secret=GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ") {
+		t.Fatalf("unexpected uri: %s", uri)
+	}
+}
@@ -0,0 +1,163 @@
+package webauthn
+
+import (
+	"encoding/binary"
+	"errors"
+	"math"
+)
+
+var ErrInvalidCBOR = errors.New("invalid cbor")
+
+const maxCBORDepth = 16
+
+/*
+decodeCBOR reads one item of data and returns the bytes after it. Only what attestation
+objects and cose keys use is supported: integers as int64, byte strings as []byte,
+text as string, arrays as []any, maps as map[any]any, booleans and null.
+*/
+func decodeCBOR(data []byte) (any, []byte, error) {
+	return decodeItem(data, 0)
+}
+
+func decodeItem(data []byte, depth int) (any, []byte, error) {
+	if depth > maxCBORDepth || len(data) == 0 {
+		return nil, nil, ErrInvalidCBOR
+	}
+
+	major, info := data[0]>>5, data[0]&0x1f
+
+	// simple values
+	if major == 7 {
+		switch info {
+		case 20:
+			return false, data[1:], nil
+		case 21:
+			return true, data[1:], nil
+		case 22:
+			return nil, data[1:], nil
+		}
+
+		return nil, nil, ErrInvalidCBOR
+	}
+
+	arg, rest, err := readArgument(info, data[1:])
+
+	if err != nil {
+		return nil, nil, err
+	}
+
+	switch major {
+	case 0:
+		if arg > math.MaxInt64 {
+			return nil, nil, ErrInvalidCBOR
+		}
+
+		return int64(arg), rest, nil
+	case 1:
+		if arg > math.MaxInt64 {
+			return nil, nil, ErrInvalidCBOR
+		}
+
+		return -1 - int64(arg), rest, nil
+	case 2, 3:
+		if arg > uint64(len(rest)) {
+			return nil, nil, ErrInvalidCBOR
+		}
+
+		if major == 3 {
+			return string(rest[:arg]), rest[arg:], nil
+		}
+
+		return rest[:arg:arg], rest[arg:], nil
+	case 4:
+		// every item takes at least a byte
+		if arg > uint64(len(rest)) {
+			return nil, nil, ErrInvalidCBOR
+		}
+
+		items := make([]any, 0, arg)
+
+		for range arg {
+			var item any
+
+			if item, rest, err = decodeItem(rest, depth+1); err != nil {
+				return nil, nil, err
+			}
+
+			items = append(items, item)
+		}
+
+		return items, rest, nil
+	case 5:
+		if arg > uint64(len(rest))/2 {
+			return nil, nil, ErrInvalidCBOR
+		}
+
+		items := make(map[any]any, arg)
+
+		for range arg {
+			var key, value any
+
+			if key, rest, err = decodeItem(rest, depth+1); err != nil {
+				return nil, nil, err
+			}
+
+			switch key.(type) {
+			case int64, string:
+			default:
+				return nil, nil, ErrInvalidCBOR
+			}
+
+			if value, rest, err = decodeItem(rest, depth+1); err != nil {
+				return nil, nil, err
+			}
+
+			items[key] = value
+		}
+
+		return items, rest, nil
+	}
+
+	return nil, nil, ErrInvalidCBOR
+}
+
+// readArgument reads the length or value that follows the initial byte, indefinite lengths are not supported
+func readArgument(info byte, data []byte) (uint64, []byte, error) {
+	if info < 24 {
+		return uint64(info), data, nil
+	}
+
+	size := 0
+
+	switch info {
+	case 24:
+		size = 1
+	case 25:
+		size = 2
+	case 26:
+		size = 4
+	case 27:
+		size = 8
+	default:
+		return 0, nil, ErrInvalidCBOR
+	}
+
+	if len(data) < size {
+		return 0, nil, ErrInvalidCBOR
+	}
+
+	var arg uint64
+
+	switch size {
+	case 1:
+		arg = uint64(data[0])
+	case 2:
+		arg = uint64(binary.BigEndian.Uint16(data))
+	case 4:
+		arg = uint64(binary.BigEndian.Uint32(data))
+	case 8:
+		arg = binary.BigEndian.Uint64(data)
+	}
+
+	return arg, data[size:], nil
+}
@@ -0,0 +1,121 @@
+package webauthn
+
+import (
+	"crypto"
+	"crypto/ecdh"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"math/big"
+)
+
+// cose key parameters and algorithms, from RFC 9053
+const (
+	coseKeyType   = 1
+	coseAlgorithm = 3
+
+	coseKeyTypeOKP = 1
+	coseKeyTypeEC2 = 2
+	coseKeyTypeRSA = 3
+
+	coseCurveP256    = 1
+	coseCurveEd25519 = 6
+
+	// AlgES256 is ECDSA with P-256 and SHA-256, the one every authenticator supports
+	AlgES256 = -7
+	AlgEdDSA = -8
+	AlgRS256 = -257
+)
+
+// Algorithms lists the supported algorithms in order of preference, for pubKeyCredParams
+var Algorithms = []int{AlgES256, AlgEdDSA, AlgRS256}
+
+type publicKey struct {
+	ecdsa   *ecdsa.PublicKey
+	ed25519 ed25519.PublicKey
+	rsa     *rsa.PublicKey
+}
+
+func (k publicKey) verify(message []byte, signature []byte) bool {
+	switch {
+	case k.ecdsa != nil:
+		digest := sha256.Sum256(message)
+
+		return ecdsa.VerifyASN1(k.ecdsa, digest[:], signature)
+	case k.ed25519 != nil:
+		return ed25519.Verify(k.ed25519, message, signature)
+	case k.rsa != nil:
+		digest := sha256.Sum256(message)
+
+		return rsa.VerifyPKCS1v15(k.rsa, crypto.SHA256, digest[:], signature) == nil
+	}
+
+	return false
+}
+
+func parsePublicKey(raw []byte) (publicKey, error) {
+	item, rest, err := decodeCBOR(raw)
+
+	if err != nil || len(rest) != 0 {
+		return publicKey{}, ErrUnsupportedKey
+	}
+
+	params, ok := item.(map[any]any)
+
+	if !ok {
+		return publicKey{}, ErrUnsupportedKey
+	}
+
+	keyType, _ := params[int64(coseKeyType)].(int64)
+	algorithm, _ := params[int64(coseAlgorithm)].(int64)
+
+	switch {
+	case keyType == coseKeyTypeEC2 && algorithm == AlgES256:
+		curve, _ := params[int64(-1)].(int64)
+		x, _ := params[int64(-2)].([]byte)
+		y, _ := params[int64(-3)].([]byte)
+
+		if curve != coseCurveP256 || len(x) != 32 || len(y) != 32 {
+			return publicKey{}, ErrUnsupportedKey
+		}
+
+		// rejects points that aren't on the curve
+		if _, err := ecdh.P256().NewPublicKey(append(append([]byte{4}, x...), y...)); err != nil {
+			return publicKey{}, ErrUnsupportedKey
+		}
+
+		return publicKey{ecdsa: &ecdsa.PublicKey{
+			Curve: elliptic.P256(),
+			X:     new(big.Int).SetBytes(x),
+			Y:     new(big.Int).SetBytes(y),
+		}}, nil
+	case keyType == coseKeyTypeOKP && algorithm == AlgEdDSA:
+		curve, _ := params[int64(-1)].(int64)
+		x, _ := params[int64(-2)].([]byte)
+
+		if curve != coseCurveEd25519 || len(x) != ed25519.PublicKeySize {
+			return publicKey{}, ErrUnsupportedKey
+		}
+
+		return publicKey{ed25519: ed25519.PublicKey(x)}, nil
+	case keyType == coseKeyTypeRSA && algorithm == AlgRS256:
+		n, _ := params[int64(-1)].([]byte)
+		e, _ := params[int64(-2)].([]byte)
+
+		if len(n) < 256 || len(e) == 0 || len(e) > 4 {
+			return publicKey{}, ErrUnsupportedKey
+		}
+
+		exponent := int(new(big.Int).SetBytes(e).Int64())
+
+		if exponent < 3 || exponent%2 == 0 {
+			return publicKey{}, ErrUnsupportedKey
+		}
+
+		return publicKey{rsa: &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: exponent}}, nil
+	}
+
+	return publicKey{}, ErrUnsupportedKey
+}
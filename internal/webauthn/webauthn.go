@@ -0,0 +1,278 @@
+/*
+webauthn verifies the registration and assertion ceremonies of WebAuthn, for passkeys
+and security keys. It checks the client data, the authenticator data and the signature;
+attestation statements aren't verified, only the "none" format is accepted.
+Binary fields of the responses are base64url in json, as browsers encode them.
+*/
+package webauthn
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"slices"
+)
+
+const (
+	flagUserPresent  = 0x01
+	flagUserVerified = 0x04
+	flagAttestedData = 0x40
+
+	// rp id hash, flags and sign count
+	authDataMinSize = 32 + 1 + 4
+)
+
+var (
+	ErrInvalidResponse        = errors.New("malformed webauthn response")
+	ErrChallengeMismatch      = errors.New("webauthn response is for another challenge")
+	ErrOriginMismatch         = errors.New("webauthn response comes from an unknown origin")
+	ErrRPIDMismatch           = errors.New("webauthn response is for another relying party")
+	ErrUserNotPresent         = errors.New("authenticator did not check user presence")
+	ErrUserNotVerified        = errors.New("authenticator did not verify the user")
+	ErrUnsupportedAttestation = errors.New("unsupported attestation format")
+	ErrUnsupportedKey         = errors.New("unsupported credential public key")
+	ErrInvalidSignature       = errors.New("invalid webauthn signature")
+	ErrSignCountRegressed     = errors.New("sign count did not increase, the authenticator may be cloned")
+)
+
+// RelyingParty is the site credentials are scoped to, ID is its domain
+type RelyingParty struct {
+	ID      string
+	Name    string
+	Origins []string
+}
+
+// Credential is what is kept of a registered credential, PublicKey is a cose key
+type Credential struct {
+	ID        []byte
+	PublicKey []byte
+	SignCount uint32
+}
+
+// URLBytes is a byte slice that is base64url in json, with or without padding
+type URLBytes []byte
+
+func (b *URLBytes) UnmarshalJSON(data []byte) error {
+	var s string
+
+	if err := json.Unmarshal(data, &s); err != nil {
+		return err
+	}
+
+	raw, err := base64.RawURLEncoding.DecodeString(trimPadding(s))
+
+	if err != nil {
+		return err
+	}
+
+	*b = raw
+
+	return nil
+}
+
+func (b URLBytes) MarshalJSON() ([]byte, error) {
+	return json.Marshal(base64.RawURLEncoding.EncodeToString(b))
+}
+
+// AttestationResponse is the response of navigator.credentials.create
+type AttestationResponse struct {
+	ClientDataJSON    URLBytes `json:"clientDataJSON"`
+	AttestationObject URLBytes `json:"attestationObject"`
+}
+
+// AssertionResponse is the response of navigator.credentials.get, ID is the credential id
+type AssertionResponse struct {
+	ID                URLBytes `json:"id"`
+	ClientDataJSON    URLBytes `json:"clientDataJSON"`
+	AuthenticatorData URLBytes `json:"authenticatorData"`
+	Signature         URLBytes `json:"signature"`
+	UserHandle        URLBytes `json:"userHandle,omitempty"`
+}
+
+type clientData struct {
+	Type      string `json:"type"`
+	Challenge string `json:"challenge"`
+	Origin    string `json:"origin"`
+}
+
+type authData struct {
+	flags        byte
+	signCount    uint32
+	credentialID []byte
+	publicKey    []byte
+}
+
+/*
+VerifyRegistration checks a response to a registration with challenge, the base64url
+string the browser got the challenge bytes from, and returns the new credential.
+requireUV asks for user verification, a pin or biometrics, on top of presence.
+*/
+func (rp RelyingParty) VerifyRegistration(challenge string, response AttestationResponse, requireUV bool) (Credential, error) {
+	if err := rp.checkClientData(response.ClientDataJSON, "webauthn.create", challenge); err != nil {
+		return Credential{}, err
+	}
+
+	object, rest, err := decodeCBOR(response.AttestationObject)
+
+	if err != nil || len(rest) != 0 {
+		return Credential{}, ErrInvalidResponse
+	}
+
+	fields, ok := object.(map[any]any)
+
+	if !ok {
+		return Credential{}, ErrInvalidResponse
+	}
+
+	format, _ := fields["fmt"].(string)
+	statement, _ := fields["attStmt"].(map[any]any)
+	rawAuthData, _ := fields["authData"].([]byte)
+
+	if format != "none" || len(statement) != 0 {
+		return Credential{}, ErrUnsupportedAttestation
+	}
+
+	data, err := rp.checkAuthData(rawAuthData, requireUV)
+
+	if err != nil {
+		return Credential{}, err
+	}
+
+	if data.publicKey == nil {
+		return Credential{}, ErrInvalidResponse
+	}
+
+	if _, err := parsePublicKey(data.publicKey); err != nil {
+		return Credential{}, err
+	}
+
+	return Credential{ID: data.credentialID, PublicKey: data.publicKey, SignCount: data.signCount}, nil
+}
+
+/*
+VerifyAssertion checks a response to an authentication with challenge against the
+stored credential and returns the sign count to store. Authenticators that don't count
+report zero every time, any other count has to grow.
+*/
+func (rp RelyingParty) VerifyAssertion(challenge string, response AssertionResponse, credential Credential, requireUV bool) (uint32, error) {
+	if !bytes.Equal(response.ID, credential.ID) {
+		return 0, ErrInvalidResponse
+	}
+
+	if err := rp.checkClientData(response.ClientDataJSON, "webauthn.get", challenge); err != nil {
+		return 0, err
+	}
+
+	data, err := rp.checkAuthData(response.AuthenticatorData, requireUV)
+
+	if err != nil {
+		return 0, err
+	}
+
+	key, err := parsePublicKey(credential.PublicKey)
+
+	if err != nil {
+		return 0, err
+	}
+
+	clientDataHash := sha256.Sum256(response.ClientDataJSON)
+	signed := append(slices.Clip(response.AuthenticatorData), clientDataHash[:]...)
+
+	if !key.verify(signed, response.Signature) {
+		return 0, ErrInvalidSignature
+	}
+
+	if (data.signCount != 0 || credential.SignCount != 0) && data.signCount <= credential.SignCount {
+		return 0, ErrSignCountRegressed
+	}
+
+	return data.signCount, nil
+}
+
+func (rp RelyingParty) checkClientData(raw []byte, ceremony string, challenge string) error {
+	var data clientData
+
+	if err := json.Unmarshal(raw, &data); err != nil {
+		return ErrInvalidResponse
+	}
+
+	if data.Type != ceremony {
+		return ErrInvalidResponse
+	}
+
+	if subtle.ConstantTimeCompare([]byte(trimPadding(data.Challenge)), []byte(trimPadding(challenge))) != 1 {
+		return ErrChallengeMismatch
+	}
+
+	if !slices.Contains(rp.Origins, data.Origin) {
+		return ErrOriginMismatch
+	}
+
+	return nil
+}
+
+func (rp RelyingParty) checkAuthData(raw []byte, requireUV bool) (authData, error) {
+	if len(raw) < authDataMinSize {
+		return authData{}, ErrInvalidResponse
+	}
+
+	rpIDHash := sha256.Sum256([]byte(rp.ID))
+
+	if !bytes.Equal(raw[:32], rpIDHash[:]) {
+		return authData{}, ErrRPIDMismatch
+	}
+
+	data := authData{flags: raw[32], signCount: binary.BigEndian.Uint32(raw[33:37])}
+
+	if data.flags&flagUserPresent == 0 {
+		return authData{}, ErrUserNotPresent
+	}
+
+	if requireUV && data.flags&flagUserVerified == 0 {
+		return authData{}, ErrUserNotVerified
+	}
+
+	if data.flags&flagAttestedData == 0 {
+		return data, nil
+	}
+
+	// aaguid, then the credential id after its length
+	rest := raw[authDataMinSize:]
+
+	if len(rest) < 16+2 {
+		return authData{}, ErrInvalidResponse
+	}
+
+	idSize := int(binary.BigEndian.Uint16(rest[16:18]))
+	rest = rest[18:]
+
+	if idSize == 0 || len(rest) < idSize {
+		return authData{}, ErrInvalidResponse
+	}
+
+	data.credentialID = bytes.Clone(rest[:idSize])
+	rest = rest[idSize:]
+
+	// the key is followed by extensions, if any
+	_, after, err := decodeCBOR(rest)
+
+	if err != nil {
+		return authData{}, ErrInvalidResponse
+	}
+
+	data.publicKey = bytes.Clone(rest[:len(rest)-len(after)])
+
+	return data, nil
+}
+
+func trimPadding(s string) string {
+	for len(s) > 0 && s[len(s)-1] == '=' {
+		s = s[:len(s)-1]
+	}
+
+	return s
+}
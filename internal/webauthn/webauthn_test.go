@@ -0,0 +1,165 @@
+package webauthn_test
+
+import (
+	"encoding/json"
+	"errors"
+	"ssosage/internal/webauthn"
+	"ssosage/internal/webauthn/webauthntest"
+	"testing"
+)
+
+const challenge = "Y2hhbGxlbmdlLWNoYWxsZW5nZS1jaGFsbGVuZ2U"
+
+var rp = webauthn.RelyingParty{ID: "example.com", Name: "Example", Origins: []string{"https://example.com"}}
+
+func register(t *testing.T, authenticator *webauthntest.Authenticator) webauthn.Credential {
+	t.Helper()
+
+	credential, err := rp.VerifyRegistration(challenge, authenticator.Register(challenge), true)
+
+	if err != nil {
+		t.Fatalf("registration failed: %v", err)
+	}
+
+	return credential
+}
+
+func TestCeremonies(t *testing.T) {
+	authenticator := webauthntest.New(rp.ID, rp.Origins[0])
+	credential := register(t, authenticator)
+
+	if string(credential.ID) != string(authenticator.CredentialID) {
+		t.Fatalf("expected the authenticator's credential id")
+	}
+
+	for i := range 2 {
+		signCount, err := rp.VerifyAssertion(challenge, authenticator.Assert(challenge), credential, true)
+
+		if err != nil {
+			t.Fatalf("assertion %d failed: %v", i, err)
+		}
+
+		if signCount != authenticator.SignCount {
+			t.Fatalf("expected sign count %d, got %d", authenticator.SignCount, signCount)
+		}
+
+		credential.SignCount = signCount
+	}
+}
+
+func TestResponsesFromJSON(t *testing.T) {
+	authenticator := webauthntest.New(rp.ID, rp.Origins[0])
+
+	var registration webauthn.AttestationResponse
+
+	if err := json.Unmarshal([]byte(authenticator.RegisterJSON(challenge)), &registration); err != nil {
+		t.Fatal(err)
+	}
+
+	credential, err := rp.VerifyRegistration(challenge, registration, true)
+
+	if err != nil {
+		t.Fatalf("registration failed: %v", err)
+	}
+
+	var assertion webauthn.AssertionResponse
+
+	if err := json.Unmarshal([]byte(authenticator.AssertJSON(challenge)), &assertion); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := rp.VerifyAssertion(challenge, assertion, credential, true); err != nil {
+		t.Fatalf("assertion failed: %v", err)
+	}
+}
+
+func TestRegistrationRejected(t *testing.T) {
+	tests := []struct {
+		name   string
+		modify func(a *webauthntest.Authenticator)
+		rp     webauthn.RelyingParty
+		want   error
+	}{
+		{"origin", func(a *webauthntest.Authenticator) { a.Origin = "https://evil.example" }, rp, webauthn.ErrOriginMismatch},
+		{"rp id", func(a *webauthntest.Authenticator) { a.RPID = "evil.example" }, rp, webauthn.ErrRPIDMismatch},
+		{"user verification", func(a *webauthntest.Authenticator) { a.UserVerified = false }, rp, webauthn.ErrUserNotVerified},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			authenticator := webauthntest.New(rp.ID, rp.Origins[0])
+			tt.modify(authenticator)
+
+			if _, err := tt.rp.VerifyRegistration(challenge, authenticator.Register(challenge), true); !errors.Is(err, tt.want) {
+				t.Fatalf("expected %v, got %v", tt.want, err)
+			}
+		})
+	}
+
+	authenticator := webauthntest.New(rp.ID, rp.Origins[0])
+
+	if _, err := rp.VerifyRegistration("b3RoZXI", authenticator.Register(challenge), true); !errors.Is(err, webauthn.ErrChallengeMismatch) {
+		t.Fatalf("expected a challenge mismatch, got %v", err)
+	}
+
+	response := authenticator.Register(challenge)
+	response.AttestationObject = response.AttestationObject[:len(response.AttestationObject)-1]
+
+	if _, err := rp.VerifyRegistration(challenge, response, true); !errors.Is(err, webauthn.ErrInvalidResponse) {
+		t.Fatalf("expected a truncated attestation to be rejected, got %v", err)
+	}
+}
+
+func TestAssertionRejected(t *testing.T) {
+	authenticator := webauthntest.New(rp.ID, rp.Origins[0])
+	credential := register(t, authenticator)
+
+	response := authenticator.Assert(challenge)
+	response.Signature[len(response.Signature)-1] ^= 1
+
+	if _, err := rp.VerifyAssertion(challenge, response, credential, true); !errors.Is(err, webauthn.ErrInvalidSignature) {
+		t.Fatalf("expected a bad signature, got %v", err)
+	}
+
+	if _, err := rp.VerifyAssertion("b3RoZXI", authenticator.Assert(challenge), credential, true); !errors.Is(err, webauthn.ErrChallengeMismatch) {
+		t.Fatalf("expected a challenge mismatch, got %v", err)
+	}
+
+	other := webauthntest.New(rp.ID, rp.Origins[0])
+	other.CredentialID = authenticator.CredentialID
+
+	if _, err := rp.VerifyAssertion(challenge, other.Assert(challenge), credential, true); !errors.Is(err, webauthn.ErrInvalidSignature) {
+		t.Fatalf("expected another key to be rejected, got %v", err)
+	}
+
+	authenticator.UserVerified = false
+
+	if _, err := rp.VerifyAssertion(challenge, authenticator.Assert(challenge), credential, true); !errors.Is(err, webauthn.ErrUserNotVerified) {
+		t.Fatalf("expected missing user verification to be rejected, got %v", err)
+	}
+
+	if _, err := rp.VerifyAssertion(challenge, authenticator.Assert(challenge), credential, false); err != nil {
+		t.Fatalf("expected presence to be enough, got %v", err)
+	}
+}
+
+func TestSignCount(t *testing.T) {
+	authenticator := webauthntest.New(rp.ID, rp.Origins[0])
+	credential := register(t, authenticator)
+	credential.SignCount = 5
+
+	authenticator.SignCount = 4
+
+	if _, err := rp.VerifyAssertion(challenge, authenticator.Assert(challenge), credential, true); !errors.Is(err, webauthn.ErrSignCountRegressed) {
+		t.Fatalf("expected a repeated count to be rejected, got %v", err)
+	}
+
+	// authenticators that don't count always send zero
+	credential.SignCount = 0
+	authenticator.SignCount = 0
+	authenticator.Frozen = true
+
+	if _, err := rp.VerifyAssertion(challenge, authenticator.Assert(challenge), credential, true); err != nil {
+		t.Fatalf("expected a zero count to pass, got %v", err)
+	}
+}
@@ -0,0 +1,197 @@
+/*
+webauthntest is a software authenticator for tests, it makes the responses a browser
+would return from navigator.credentials.create and get, signed with an ES256 key.
+*/
+package webauthntest
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/json"
+	"ssosage/internal/webauthn"
+)
+
+const (
+	flagUserPresent  = 0x01
+	flagUserVerified = 0x04
+	flagAttestedData = 0x40
+)
+
+type Authenticator struct {
+	RPID   string
+	Origin string
+
+	// UserVerified sets the uv flag, on by default
+	UserVerified bool
+	// SignCount is the counter sent with the next assertion, incremented before signing unless Frozen
+	SignCount uint32
+	Frozen    bool
+
+	CredentialID []byte
+	key          *ecdsa.PrivateKey
+}
+
+// New makes an authenticator with a new key and credential id
+func New(rpID string, origin string) *Authenticator {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+
+	if err != nil {
+		panic(err)
+	}
+
+	id := make([]byte, 16)
+
+	if _, err := rand.Read(id); err != nil {
+		panic(err)
+	}
+
+	return &Authenticator{RPID: rpID, Origin: origin, UserVerified: true, CredentialID: id, key: key}
+}
+
+// Register answers a registration with challenge, the base64url string the server returned
+func (a *Authenticator) Register(challenge string) webauthn.AttestationResponse {
+	x, y := make([]byte, 32), make([]byte, 32)
+
+	a.key.X.FillBytes(x)
+	a.key.Y.FillBytes(y)
+
+	publicKey := encodeMap(
+		[2][]byte{encodeInt(1), encodeInt(2)},
+		[2][]byte{encodeInt(3), encodeInt(webauthn.AlgES256)},
+		[2][]byte{encodeInt(-1), encodeInt(1)},
+		[2][]byte{encodeInt(-2), encodeBytes(x)},
+		[2][]byte{encodeInt(-3), encodeBytes(y)},
+	)
+
+	attested := make([]byte, 16, 16+2+len(a.CredentialID)+len(publicKey))
+	attested = binary.BigEndian.AppendUint16(attested, uint16(len(a.CredentialID)))
+	attested = append(attested, a.CredentialID...)
+	attested = append(attested, publicKey...)
+
+	authData := a.authData(flagAttestedData, a.SignCount)
+	authData = append(authData, attested...)
+
+	object := encodeMap(
+		[2][]byte{encodeText("fmt"), encodeText("none")},
+		[2][]byte{encodeText("attStmt"), encodeMap()},
+		[2][]byte{encodeText("authData"), encodeBytes(authData)},
+	)
+
+	return webauthn.AttestationResponse{
+		ClientDataJSON:    a.clientData("webauthn.create", challenge),
+		AttestationObject: object,
+	}
+}
+
+// Assert answers an authentication with challenge
+func (a *Authenticator) Assert(challenge string) webauthn.AssertionResponse {
+	if !a.Frozen {
+		a.SignCount++
+	}
+
+	clientData := a.clientData("webauthn.get", challenge)
+	authData := a.authData(0, a.SignCount)
+
+	clientDataHash := sha256.Sum256(clientData)
+	digest := sha256.Sum256(append(authData, clientDataHash[:]...))
+
+	signature, err := ecdsa.SignASN1(rand.Reader, a.key, digest[:])
+
+	if err != nil {
+		panic(err)
+	}
+
+	return webauthn.AssertionResponse{
+		ID:                a.CredentialID,
+		ClientDataJSON:    clientData,
+		AuthenticatorData: authData,
+		Signature:         signature,
+	}
+}
+
+// RegisterJSON and AssertJSON return the responses as a client would send them
+func (a *Authenticator) RegisterJSON(challenge string) string {
+	return marshal(a.Register(challenge))
+}
+
+func (a *Authenticator) AssertJSON(challenge string) string {
+	return marshal(a.Assert(challenge))
+}
+
+func (a *Authenticator) authData(flags byte, signCount uint32) []byte {
+	rpIDHash := sha256.Sum256([]byte(a.RPID))
+
+	flags |= flagUserPresent
+
+	if a.UserVerified {
+		flags |= flagUserVerified
+	}
+
+	data := append(rpIDHash[:], flags)
+
+	return binary.BigEndian.AppendUint32(data, signCount)
+}
+
+func (a *Authenticator) clientData(ceremony string, challenge string) []byte {
+	data, _ := json.Marshal(map[string]string{
+		"type":      ceremony,
+		"challenge": challenge,
+		"origin":    a.Origin,
+	})
+
+	return data
+}
+
+func marshal(v any) string {
+	data, err := json.Marshal(v)
+
+	if err != nil {
+		panic(err)
+	}
+
+	return string(data)
+}
+
+func encodeHead(major byte, arg uint64) []byte {
+	switch {
+	case arg < 24:
+		return []byte{major<<5 | byte(arg)}
+	case arg <= 0xff:
+		return []byte{major<<5 | 24, byte(arg)}
+	case arg <= 0xffff:
+		return binary.BigEndian.AppendUint16([]byte{major<<5 | 25}, uint16(arg))
+	case arg <= 0xffffffff:
+		return binary.BigEndian.AppendUint32([]byte{major<<5 | 26}, uint32(arg))
+	}
+
+	return binary.BigEndian.AppendUint64([]byte{major<<5 | 27}, arg)
+}
+
+func encodeInt(n int64) []byte {
+	if n < 0 {
+		return encodeHead(1, uint64(-1-n))
+	}
+
+	return encodeHead(0, uint64(n))
+}
+
+func encodeBytes(b []byte) []byte {
+	return append(encodeHead(2, uint64(len(b))), b...)
+}
+
+func encodeText(s string) []byte {
+	return append(encodeHead(3, uint64(len(s))), s...)
+}
+
+func encodeMap(pairs ...[2][]byte) []byte {
+	out := encodeHead(5, uint64(len(pairs)))
+
+	for _, pair := range pairs {
+		out = append(append(out, pair[0]...), pair[1]...)
+	}
+
+	return out
+}
@@ -0,0 +1,7 @@
+// migrations embeds the sql migrations, one directory per storage driver
+package migrations
+
+import "embed"
+
+//go:embed sqlite/*.sql postgres/*.sql
+var FS embed.FS
@@ -0,0 +1,3 @@
+alter table apps drop column require_verified_email;
+alter table clients drop column email_verified;
+alter table clients drop column email;
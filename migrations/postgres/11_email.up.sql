@@ -0,0 +1,3 @@
+alter table clients add column email text not null default '';
+alter table clients add column email_verified boolean not null default false;
+alter table apps add column require_verified_email boolean not null default false;
@@ -0,0 +1,3 @@
+alter table apps drop column org_id;
+alter table clients drop column org_id;
+drop table if exists organizations;
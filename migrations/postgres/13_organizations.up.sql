@@ -0,0 +1,11 @@
+create table if not exists organizations (
+    id bigserial primary key,
+    name text not null unique,
+    created_at bigint not null default 0
+);
+
+alter table clients add column org_id bigint references organizations (id);
+alter table apps add column org_id bigint references organizations (id);
+
+create index if not exists idx_client_org on clients (org_id);
+create index if not exists idx_app_org on apps (org_id);
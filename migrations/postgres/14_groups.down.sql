@@ -0,0 +1,6 @@
+alter table apps drop column groups_claim;
+alter table apps drop column role_grants;
+drop table if exists group_roles;
+drop table if exists client_roles;
+drop table if exists group_members;
+drop table if exists client_groups;
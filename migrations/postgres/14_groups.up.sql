@@ -0,0 +1,34 @@
+create table if not exists client_groups (
+    id bigserial primary key,
+    name text not null unique,
+    created_at bigint not null default 0
+);
+
+create table if not exists group_members (
+    group_id bigint not null references client_groups (id) on delete cascade,
+    client_id bigint not null references clients (id) on delete cascade,
+    primary key (group_id, client_id)
+);
+
+create index if not exists idx_group_member_client on group_members (client_id);
+
+create table if not exists client_roles (
+    client_id bigint not null references clients (id) on delete cascade,
+    app_id bigint not null references apps (id) on delete cascade,
+    role text not null,
+    primary key (client_id, app_id, role)
+);
+
+create index if not exists idx_client_role_app on client_roles (app_id);
+
+create table if not exists group_roles (
+    group_id bigint not null references client_groups (id) on delete cascade,
+    app_id bigint not null references apps (id) on delete cascade,
+    role text not null,
+    primary key (group_id, app_id, role)
+);
+
+create index if not exists idx_group_role_app on group_roles (app_id);
+
+alter table apps add column role_grants boolean not null default false;
+alter table apps add column groups_claim boolean not null default false;
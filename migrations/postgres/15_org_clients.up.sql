@@ -0,0 +1 @@
+alter table apps add column org_clients boolean not null default false;
@@ -0,0 +1,12 @@
+create table if not exists clients (
+    id bigserial primary key,
+    name text not null unique,
+    password_hash bytea not null
+);
+
+create table if not exists apps (
+    id bigserial primary key,
+    name text not null unique,
+    secret text not null,
+    roles text not null
+);
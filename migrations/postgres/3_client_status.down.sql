@@ -0,0 +1,2 @@
+alter table clients drop column token_version;
+alter table clients drop column status;
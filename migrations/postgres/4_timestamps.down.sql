@@ -0,0 +1,4 @@
+alter table apps drop column updated_at;
+alter table apps drop column created_at;
+alter table clients drop column updated_at;
+alter table clients drop column created_at;
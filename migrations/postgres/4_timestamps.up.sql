@@ -0,0 +1,7 @@
+alter table clients add column created_at bigint not null default 0;
+alter table clients add column updated_at bigint not null default 0;
+alter table apps add column created_at bigint not null default 0;
+alter table apps add column updated_at bigint not null default 0;
+
+update clients set created_at = extract(epoch from now())::bigint, updated_at = extract(epoch from now())::bigint;
+update apps set created_at = extract(epoch from now())::bigint, updated_at = extract(epoch from now())::bigint;
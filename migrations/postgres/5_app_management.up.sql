@@ -0,0 +1,3 @@
+alter table apps add column display_name text not null default '';
+alter table apps add column settings text not null default '{}';
+alter table apps add column version bigint not null default 1;
@@ -0,0 +1,4 @@
+alter table clients add column totp_secret bytea;
+alter table clients add column totp_enabled boolean not null default false;
+alter table clients add column totp_step bigint not null default 0;
+alter table apps add column require_mfa boolean not null default false;
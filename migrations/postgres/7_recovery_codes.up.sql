@@ -0,0 +1,9 @@
+create table if not exists recovery_codes (
+    id bigserial primary key,
+    client_id bigint not null references clients (id) on delete cascade,
+    code_hash bytea not null,
+    used_at bigint,
+    created_at bigint not null default 0
+);
+
+create index if not exists idx_recovery_code_client on recovery_codes (client_id);
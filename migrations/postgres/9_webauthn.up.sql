@@ -0,0 +1,11 @@
+create table if not exists webauthn_credentials (
+    id bigserial primary key,
+    client_id bigint not null references clients (id) on delete cascade,
+    credential_id bytea not null unique,
+    public_key bytea not null,
+    sign_count bigint not null default 0,
+    created_at bigint not null default 0,
+    last_used_at bigint not null default 0
+);
+
+create index if not exists idx_webauthn_credential_client on webauthn_credentials (client_id);
@@ -0,0 +1,9 @@
+create table if not exists password_resets (
+    id text primary key,
+    client_id integer not null references clients (id) on delete cascade,
+    expires_at integer not null,
+    created_at integer not null
+);
+
+create index if not exists idx_password_reset_client on password_resets (client_id);
+create index if not exists idx_password_reset_expires_at on password_resets (expires_at);
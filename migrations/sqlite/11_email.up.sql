@@ -0,0 +1,3 @@
+alter table clients add column email text not null default '';
+alter table clients add column email_verified integer not null default 0;
+alter table apps add column require_verified_email integer not null default 0;
@@ -0,0 +1,2 @@
+alter table apps drop column profile_claims;
+drop table if exists client_profiles;
@@ -0,0 +1,10 @@
+create table if not exists client_profiles (
+    client_id integer primary key references clients (id) on delete cascade,
+    display_name text not null default '',
+    locale text not null default '',
+    avatar_url text not null default '',
+    metadata text not null default '{}',
+    updated_at integer not null default 0
+);
+
+alter table apps add column profile_claims text not null default '';
@@ -0,0 +1,5 @@
+drop index if exists idx_app_org;
+drop index if exists idx_client_org;
+alter table apps drop column org_id;
+alter table clients drop column org_id;
+drop table if exists organizations;
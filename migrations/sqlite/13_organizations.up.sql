@@ -0,0 +1,13 @@
+create table if not exists organizations (
+    id integer primary key,
+    name text not null unique,
+    created_at integer not null default 0
+);
+
+-- no foreign keys, sqlite can't drop a column in one on the way down.
+-- inserts look the organization up and DeleteOrganization refuses while it has members
+alter table clients add column org_id integer;
+alter table apps add column org_id integer;
+
+create index if not exists idx_client_org on clients (org_id);
+create index if not exists idx_app_org on apps (org_id);
@@ -0,0 +1 @@
+alter table apps add column org_clients integer not null default 0;
@@ -0,0 +1,2 @@
+drop table if exists clients;
+drop table if exists apps;
\ No newline at end of file
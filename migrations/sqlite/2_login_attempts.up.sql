@@ -0,0 +1,6 @@
+create table if not exists login_attempts (
+    key text primary key,
+    failures integer not null default 0,
+    last_failure_at integer not null default 0,
+    locked_until integer not null default 0
+);
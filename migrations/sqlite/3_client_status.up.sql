@@ -0,0 +1,2 @@
+alter table clients add column status text not null default 'active';
+alter table clients add column token_version integer not null default 0;
@@ -0,0 +1,7 @@
+alter table clients add column created_at integer not null default 0;
+alter table clients add column updated_at integer not null default 0;
+alter table apps add column created_at integer not null default 0;
+alter table apps add column updated_at integer not null default 0;
+
+update clients set created_at = strftime('%s', 'now'), updated_at = strftime('%s', 'now');
+update apps set created_at = strftime('%s', 'now'), updated_at = strftime('%s', 'now');
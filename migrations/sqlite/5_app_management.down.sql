@@ -0,0 +1,3 @@
+alter table apps drop column version;
+alter table apps drop column settings;
+alter table apps drop column display_name;
@@ -0,0 +1,4 @@
+alter table apps drop column require_mfa;
+alter table clients drop column totp_step;
+alter table clients drop column totp_enabled;
+alter table clients drop column totp_secret;
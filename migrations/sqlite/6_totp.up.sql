@@ -0,0 +1,4 @@
+alter table clients add column totp_secret blob;
+alter table clients add column totp_enabled integer not null default 0;
+alter table clients add column totp_step integer not null default 0;
+alter table apps add column require_mfa integer not null default 0;
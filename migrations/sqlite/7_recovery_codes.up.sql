@@ -0,0 +1,9 @@
+create table if not exists recovery_codes (
+    id integer primary key,
+    client_id integer not null references clients (id) on delete cascade,
+    code_hash blob not null,
+    used_at integer,
+    created_at integer not null default 0
+);
+
+create index if not exists idx_recovery_code_client on recovery_codes (client_id);
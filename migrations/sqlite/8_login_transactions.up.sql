@@ -0,0 +1,12 @@
+create table if not exists login_transactions (
+    id text primary key,
+    client_name text not null,
+    app_name text not null,
+    role text not null,
+    required text not null,
+    completed text not null default '',
+    expires_at integer not null,
+    created_at integer not null
+);
+
+create index if not exists idx_login_transaction_expires_at on login_transactions (expires_at);
@@ -0,0 +1,11 @@
+create table if not exists webauthn_credentials (
+    id integer primary key,
+    client_id integer not null references clients (id) on delete cascade,
+    credential_id blob not null unique,
+    public_key blob not null,
+    sign_count integer not null default 0,
+    created_at integer not null default 0,
+    last_used_at integer not null default 0
+);
+
+create index if not exists idx_webauthn_credential_client on webauthn_credentials (client_id);
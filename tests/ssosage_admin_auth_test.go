@@ -0,0 +1,40 @@
+package tests
+
+import (
+	"ssosage/tests/suite"
+	"testing"
+
+	"github.com/brianvoe/gofakeit/v6"
+	"github.com/hyperfyodor/ssosage_proto"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+func TestRegisterAppRequiresAdmin(t *testing.T) {
+	ctx, suite := suite.NewSuite(t)
+
+	request := &ssosage_proto.RegisterAppRequest{
+		AppName:   gofakeit.AppName(),
+		AppSecret: APP_SECRET,
+		Roles:     []string{"user"},
+	}
+
+	_, err := suite.SsosageClient.RegisterApp(ctx, request)
+
+	if status.Code(err) != codes.Unauthenticated {
+		t.Fatalf("expected unauthenticated without credentials, got: %v", err)
+	}
+
+	_, err = suite.SsosageClient.RegisterApp(metadata.AppendToOutgoingContext(ctx, "authorization", "Bearer wrong-key"), request)
+
+	if status.Code(err) != codes.Unauthenticated {
+		t.Fatalf("expected unauthenticated with a wrong key, got: %v", err)
+	}
+
+	_, err = suite.SsosageClient.RegisterApp(suite.AdminContext(ctx), request)
+
+	if err != nil {
+		t.Fatalf("failed to register an app as admin: %v", err)
+	}
+}
@@ -0,0 +1,126 @@
+package tests
+
+import (
+	"slices"
+	"ssosage/tests/suite"
+	"testing"
+
+	"github.com/hyperfyodor/ssosage_proto"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+)
+
+func TestUpdateApp(t *testing.T) {
+	ctx, suite := suite.NewSuite(t)
+
+	appName := suite.RegisterApp(ctx, "user", "editor")
+	clientName, password := suite.RegisterClient(ctx)
+
+	resp, err := suite.SsosageClient.GenerateToken(ctx, &ssosage_proto.GenerateTokenRequest{
+		ClientName: clientName,
+		Password:   password,
+		AppName:    appName,
+		Role:       "user",
+	})
+
+	if err != nil {
+		t.Fatalf("failed to generate token: %v", err)
+	}
+
+	got, err := suite.SsosageClient.GetApp(suite.AdminContext(ctx), &ssosage_proto.GetAppRequest{AppName: appName})
+
+	if err != nil {
+		t.Fatalf("failed to get app: %v", err)
+	}
+
+	version := got.GetApp().GetVersion()
+
+	update := &ssosage_proto.UpdateAppRequest{
+		AppName:     appName,
+		Version:     version,
+		DisplayName: proto.String("Renamed"),
+		Roles:       &ssosage_proto.RoleList{Roles: []string{"editor"}},
+		Settings:    proto.String(`{"theme":"dark"}`),
+	}
+
+	if _, err := suite.SsosageClient.UpdateApp(ctx, update); status.Code(err) != codes.Unauthenticated {
+		t.Fatalf("expected unauthenticated without credentials, got: %v", err)
+	}
+
+	updated, err := suite.SsosageClient.UpdateApp(suite.AdminContext(ctx), update)
+
+	if err != nil {
+		t.Fatalf("failed to update app: %v", err)
+	}
+
+	if updated.GetVersion() <= version {
+		t.Fatalf("expected the version to grow from %d, got %d", version, updated.GetVersion())
+	}
+
+	// the same update based on the old version lost the race
+	if _, err := suite.SsosageClient.UpdateApp(suite.AdminContext(ctx), update); status.Code(err) != codes.Aborted {
+		t.Fatalf("expected aborted for a stale version, got: %v", err)
+	}
+
+	got, err = suite.SsosageClient.GetApp(suite.AdminContext(ctx), &ssosage_proto.GetAppRequest{AppName: appName})
+
+	if err != nil {
+		t.Fatalf("failed to get app: %v", err)
+	}
+
+	app := got.GetApp()
+
+	if app.GetDisplayName() != "Renamed" || !slices.Equal(app.GetRoles(), []string{"editor"}) || app.GetSettings() != `{"theme":"dark"}` || app.GetVersion() != updated.GetVersion() {
+		t.Fatalf("unexpected app: %v", app)
+	}
+
+	// the user role is gone and its token with it
+	if introspection, err := suite.SsosageClient.IntrospectToken(ctx, &ssosage_proto.IntrospectTokenRequest{Token: resp.GetToken()}); err != nil || introspection.GetActive() {
+		t.Fatalf("expected an inactive token, got: %v, %v", introspection, err)
+	}
+
+	_, err = suite.SsosageClient.UpdateApp(suite.AdminContext(ctx), &ssosage_proto.UpdateAppRequest{
+		AppName:  appName,
+		Version:  app.GetVersion(),
+		Settings: proto.String("[1, 2]"),
+	})
+
+	if status.Code(err) != codes.InvalidArgument {
+		t.Fatalf("expected invalid argument for settings that aren't an object, got: %v", err)
+	}
+}
+
+func TestDeleteApp(t *testing.T) {
+	ctx, suite := suite.NewSuite(t)
+
+	appName := suite.RegisterApp(ctx, "user")
+	clientName, password := suite.RegisterClient(ctx)
+
+	resp, err := suite.SsosageClient.GenerateToken(ctx, &ssosage_proto.GenerateTokenRequest{
+		ClientName: clientName,
+		Password:   password,
+		AppName:    appName,
+		Role:       "user",
+	})
+
+	if err != nil {
+		t.Fatalf("failed to generate token: %v", err)
+	}
+
+	if _, err := suite.SsosageClient.DeleteApp(suite.AdminContext(ctx), &ssosage_proto.DeleteAppRequest{AppName: appName}); err != nil {
+		t.Fatalf("failed to delete app: %v", err)
+	}
+
+	if introspection, err := suite.SsosageClient.IntrospectToken(ctx, &ssosage_proto.IntrospectTokenRequest{Token: resp.GetToken()}); err != nil || introspection.GetActive() {
+		t.Fatalf("expected an inactive token, got: %v, %v", introspection, err)
+	}
+
+	if _, err := suite.SsosageClient.GetApp(suite.AdminContext(ctx), &ssosage_proto.GetAppRequest{AppName: appName}); status.Code(err) != codes.NotFound {
+		t.Fatalf("expected not found, got: %v", err)
+	}
+
+	if _, err := suite.SsosageClient.DeleteApp(suite.AdminContext(ctx), &ssosage_proto.DeleteAppRequest{AppName: appName}); status.Code(err) != codes.NotFound {
+		t.Fatalf("expected not found, got: %v", err)
+	}
+}
@@ -0,0 +1,168 @@
+package tests
+
+import (
+	"ssosage/tests/suite"
+	"testing"
+
+	"github.com/hyperfyodor/ssosage_proto"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestChangePasswordRevokesTokens(t *testing.T) {
+	ctx, suite := suite.NewSuite(t)
+
+	appName := suite.RegisterApp(ctx, "user")
+	clientName, password := suite.RegisterClient(ctx)
+
+	resp, err := suite.SsosageClient.GenerateToken(ctx, &ssosage_proto.GenerateTokenRequest{
+		ClientName: clientName,
+		Password:   password,
+		AppName:    appName,
+		Role:       "user",
+	})
+
+	if err != nil {
+		t.Fatalf("failed to generate token: %v", err)
+	}
+
+	introspection, err := suite.SsosageClient.IntrospectToken(ctx, &ssosage_proto.IntrospectTokenRequest{Token: resp.GetToken()})
+
+	if err != nil {
+		t.Fatalf("failed to introspect token: %v", err)
+	}
+
+	if !introspection.GetActive() || introspection.GetClientName() != clientName || introspection.GetAppName() != appName || introspection.GetRole() != "user" {
+		t.Fatalf("unexpected introspection: %v", introspection)
+	}
+
+	newPassword := password + "-changed"
+
+	_, err = suite.SsosageClient.ChangePassword(ctx, &ssosage_proto.ChangePasswordRequest{
+		ClientName:  clientName,
+		OldPassword: password,
+		NewPassword: newPassword,
+	})
+
+	if err != nil {
+		t.Fatalf("failed to change password: %v", err)
+	}
+
+	// the token still carries a valid signature, only introspection tells it was revoked
+	introspection, err = suite.SsosageClient.IntrospectToken(ctx, &ssosage_proto.IntrospectTokenRequest{Token: resp.GetToken()})
+
+	if err != nil || introspection.GetActive() || introspection.GetClientName() != "" {
+		t.Fatalf("expected an inactive token, got: %v, %v", introspection, err)
+	}
+
+	_, err = suite.SsosageClient.GenerateToken(ctx, &ssosage_proto.GenerateTokenRequest{
+		ClientName: clientName,
+		Password:   newPassword,
+		AppName:    appName,
+		Role:       "user",
+	})
+
+	if err != nil {
+		t.Fatalf("failed to generate token with the new password: %v", err)
+	}
+}
+
+func TestDisableEnableClient(t *testing.T) {
+	ctx, suite := suite.NewSuite(t)
+
+	appName := suite.RegisterApp(ctx, "user")
+	clientName, password := suite.RegisterClient(ctx)
+
+	request := &ssosage_proto.GenerateTokenRequest{
+		ClientName: clientName,
+		Password:   password,
+		AppName:    appName,
+		Role:       "user",
+	}
+
+	resp, err := suite.SsosageClient.GenerateToken(ctx, request)
+
+	if err != nil {
+		t.Fatalf("failed to generate token: %v", err)
+	}
+
+	_, err = suite.SsosageClient.DisableClient(ctx, &ssosage_proto.DisableClientRequest{ClientName: clientName})
+
+	if status.Code(err) != codes.Unauthenticated {
+		t.Fatalf("expected unauthenticated without credentials, got: %v", err)
+	}
+
+	if _, err := suite.SsosageClient.DisableClient(suite.AdminContext(ctx), &ssosage_proto.DisableClientRequest{ClientName: clientName}); err != nil {
+		t.Fatalf("failed to disable client: %v", err)
+	}
+
+	if _, err := suite.SsosageClient.GenerateToken(ctx, request); status.Code(err) != codes.PermissionDenied {
+		t.Fatalf("expected a disabled client to be refused, got: %v", err)
+	}
+
+	if introspection, err := suite.SsosageClient.IntrospectToken(ctx, &ssosage_proto.IntrospectTokenRequest{Token: resp.GetToken()}); err != nil || introspection.GetActive() {
+		t.Fatalf("expected an inactive token, got: %v, %v", introspection, err)
+	}
+
+	if _, err := suite.SsosageClient.EnableClient(suite.AdminContext(ctx), &ssosage_proto.EnableClientRequest{ClientName: clientName}); err != nil {
+		t.Fatalf("failed to enable client: %v", err)
+	}
+
+	if _, err := suite.SsosageClient.GenerateToken(ctx, request); err != nil {
+		t.Fatalf("failed to generate token after enabling: %v", err)
+	}
+}
+
+func TestResetAndDeleteClient(t *testing.T) {
+	ctx, suite := suite.NewSuite(t)
+
+	appName := suite.RegisterApp(ctx, "user")
+	clientName, _ := suite.RegisterClient(ctx)
+
+	newPassword := "reset-by-admin-password"
+
+	_, err := suite.SsosageClient.ResetPassword(ctx, &ssosage_proto.ResetPasswordRequest{ClientName: clientName, NewPassword: newPassword})
+
+	if status.Code(err) != codes.Unauthenticated {
+		t.Fatalf("expected unauthenticated without credentials, got: %v", err)
+	}
+
+	if _, err := suite.SsosageClient.ResetPassword(suite.AdminContext(ctx), &ssosage_proto.ResetPasswordRequest{ClientName: clientName, NewPassword: newPassword}); err != nil {
+		t.Fatalf("failed to reset password: %v", err)
+	}
+
+	resp, err := suite.SsosageClient.GenerateToken(ctx, &ssosage_proto.GenerateTokenRequest{
+		ClientName: clientName,
+		Password:   newPassword,
+		AppName:    appName,
+		Role:       "user",
+	})
+
+	if err != nil {
+		t.Fatalf("failed to generate token with the reset password: %v", err)
+	}
+
+	if _, err := suite.SsosageClient.DeleteClient(suite.AdminContext(ctx), &ssosage_proto.DeleteClientRequest{ClientName: clientName}); err != nil {
+		t.Fatalf("failed to delete client: %v", err)
+	}
+
+	if introspection, err := suite.SsosageClient.IntrospectToken(ctx, &ssosage_proto.IntrospectTokenRequest{Token: resp.GetToken()}); err != nil || introspection.GetActive() {
+		t.Fatalf("expected an inactive token, got: %v, %v", introspection, err)
+	}
+
+	_, err = suite.SsosageClient.DeleteClient(suite.AdminContext(ctx), &ssosage_proto.DeleteClientRequest{ClientName: clientName})
+
+	if status.Code(err) != codes.NotFound {
+		t.Fatalf("expected not found, got: %v", err)
+	}
+}
+
+func TestIntrospectGarbage(t *testing.T) {
+	ctx, suite := suite.NewSuite(t)
+
+	introspection, err := suite.SsosageClient.IntrospectToken(ctx, &ssosage_proto.IntrospectTokenRequest{Token: "not.a.token"})
+
+	if err != nil || introspection.GetActive() {
+		t.Fatalf("expected an inactive token, got: %v, %v", introspection, err)
+	}
+}
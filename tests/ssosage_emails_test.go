@@ -0,0 +1,89 @@
+package tests
+
+import (
+	"ssosage/tests/suite"
+	"testing"
+
+	"github.com/hyperfyodor/ssosage_proto"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+)
+
+const emailVerificationSubject = "Email verification"
+
+func TestEmailVerification(t *testing.T) {
+	ctx, suite := suite.NewSuite(t)
+
+	appName := suite.RegisterApp(ctx, "user")
+	clientName, password := suite.RegisterClient(ctx)
+
+	suite.UpdateApp(ctx, &ssosage_proto.UpdateAppRequest{AppName: appName, RequireVerifiedEmail: proto.Bool(true)})
+
+	request := &ssosage_proto.GenerateTokenRequest{
+		ClientName: clientName,
+		Password:   password,
+		AppName:    appName,
+		Role:       "user",
+	}
+
+	if _, err := suite.SsosageClient.GenerateToken(ctx, request); status.Code(err) != codes.FailedPrecondition {
+		t.Fatalf("expected a client without a verified email to be refused, got: %v", err)
+	}
+
+	_, err := suite.SsosageClient.SetEmail(ctx, &ssosage_proto.SetEmailRequest{ClientName: clientName, Password: password, Email: "not an email"})
+
+	if status.Code(err) != codes.InvalidArgument {
+		t.Fatalf("expected an invalid email to be refused, got: %v", err)
+	}
+
+	email := "client@example.com"
+
+	if _, err := suite.SsosageClient.SetEmail(ctx, &ssosage_proto.SetEmailRequest{ClientName: clientName, Password: password, Email: email}); err != nil {
+		t.Fatalf("failed to set email: %v", err)
+	}
+
+	listed, err := suite.SsosageClient.ListClients(suite.AdminContext(ctx), &ssosage_proto.ListClientsRequest{Query: &ssosage_proto.ListQuery{NamePrefix: clientName}})
+
+	if err != nil || len(listed.GetClients()) != 1 || listed.GetClients()[0].GetEmail() != email || listed.GetClients()[0].GetEmailVerified() {
+		t.Fatalf("expected a client with an unverified email, got: %v, %v", listed, err)
+	}
+
+	// a second token works as well as the first
+	if _, err := suite.SsosageClient.SendEmailVerification(ctx, &ssosage_proto.SendEmailVerificationRequest{ClientName: clientName}); err != nil {
+		t.Fatalf("failed to send email verification: %v", err)
+	}
+
+	verificationToken := suite.NotificationToken(clientName, emailVerificationSubject)
+
+	if _, err := suite.SsosageClient.VerifyEmail(ctx, &ssosage_proto.VerifyEmailRequest{Token: verificationToken}); err != nil {
+		t.Fatalf("failed to verify email: %v", err)
+	}
+
+	resp, err := suite.SsosageClient.GenerateToken(ctx, request)
+
+	if err != nil {
+		t.Fatalf("failed to generate token with a verified email: %v", err)
+	}
+
+	introspection, err := suite.SsosageClient.IntrospectToken(ctx, &ssosage_proto.IntrospectTokenRequest{Token: resp.GetToken()})
+
+	if err != nil || !introspection.GetActive() || !introspection.GetEmailVerified() {
+		t.Fatalf("expected a token with a verified email, got: %v, %v", introspection, err)
+	}
+
+	// the token belongs to the address it was sent to
+	if _, err := suite.SsosageClient.SetEmail(ctx, &ssosage_proto.SetEmailRequest{ClientName: clientName, Password: password, Email: "other@example.com"}); err != nil {
+		t.Fatalf("failed to change email: %v", err)
+	}
+
+	_, err = suite.SsosageClient.VerifyEmail(ctx, &ssosage_proto.VerifyEmailRequest{Token: verificationToken})
+
+	if status.Code(err) != codes.InvalidArgument {
+		t.Fatalf("expected a token for the old email to be refused, got: %v", err)
+	}
+
+	if _, err := suite.SsosageClient.GenerateToken(ctx, request); status.Code(err) != codes.FailedPrecondition {
+		t.Fatalf("expected a changed email to need verifying again, got: %v", err)
+	}
+}
@@ -0,0 +1,226 @@
+package tests
+
+import (
+	"slices"
+	"ssosage/tests/suite"
+	"testing"
+
+	"github.com/brianvoe/gofakeit/v6"
+	"github.com/hyperfyodor/ssosage_proto"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+)
+
+func TestGroupRoles(t *testing.T) {
+	ctx, suite := suite.NewSuite(t)
+
+	appName := suite.RegisterApp(ctx, "user", "editor")
+	clientName, password := suite.RegisterClient(ctx)
+	adminCtx := suite.AdminContext(ctx)
+	group := "devs-" + gofakeit.UUID()
+
+	suite.UpdateApp(ctx, &ssosage_proto.UpdateAppRequest{AppName: appName, RoleGrants: proto.Bool(true), GroupsClaim: proto.Bool(true)})
+
+	request := &ssosage_proto.GenerateTokenRequest{
+		ClientName: clientName,
+		Password:   password,
+		AppName:    appName,
+		Role:       "editor",
+	}
+
+	if _, err := suite.SsosageClient.GenerateToken(ctx, request); status.Code(err) != codes.PermissionDenied {
+		t.Fatalf("expected a role without a grant to be refused, got: %v", err)
+	}
+
+	if _, err := suite.SsosageClient.RegisterGroup(adminCtx, &ssosage_proto.RegisterGroupRequest{GroupName: group}); err != nil {
+		t.Fatalf("failed to register group: %v", err)
+	}
+
+	if _, err := suite.SsosageClient.RegisterGroup(adminCtx, &ssosage_proto.RegisterGroupRequest{GroupName: group}); status.Code(err) != codes.AlreadyExists {
+		t.Fatalf("expected a second group with the name to be refused, got: %v", err)
+	}
+
+	if _, err := suite.SsosageClient.AddGroupMember(adminCtx, &ssosage_proto.AddGroupMemberRequest{GroupName: group, ClientName: clientName}); err != nil {
+		t.Fatalf("failed to add group member: %v", err)
+	}
+
+	grants := []*ssosage_proto.GrantRoleRequest{
+		{AppName: appName, Role: "editor", GroupName: group},
+		{AppName: appName, Role: "user", ClientName: clientName},
+	}
+
+	for _, grant := range grants {
+		if _, err := suite.SsosageClient.GrantRole(adminCtx, grant); err != nil {
+			t.Fatalf("failed to grant role: %v", err)
+		}
+	}
+
+	roles, err := suite.SsosageClient.ClientRoles(adminCtx, &ssosage_proto.ClientRolesRequest{ClientName: clientName, AppName: appName})
+
+	if err != nil || !slices.Equal(roles.GetRoles(), []string{"editor", "user"}) {
+		t.Fatalf("expected the direct and the group grant, got: %v, %v", roles, err)
+	}
+
+	resp, err := suite.SsosageClient.GenerateToken(ctx, request)
+
+	if err != nil {
+		t.Fatalf("failed to generate token with a group grant: %v", err)
+	}
+
+	introspection, err := suite.SsosageClient.IntrospectToken(ctx, &ssosage_proto.IntrospectTokenRequest{Token: resp.GetToken()})
+
+	if err != nil || !slices.Equal(introspection.GetRoles(), []string{"editor", "user"}) || !slices.Equal(introspection.GetGroups(), []string{group}) {
+		t.Fatalf("expected a token with roles and groups, got: %v, %v", introspection, err)
+	}
+
+	// the editor role came through the group only
+	if _, err := suite.SsosageClient.RemoveGroupMember(adminCtx, &ssosage_proto.RemoveGroupMemberRequest{GroupName: group, ClientName: clientName}); err != nil {
+		t.Fatalf("failed to remove group member: %v", err)
+	}
+
+	introspection, err = suite.SsosageClient.IntrospectToken(ctx, &ssosage_proto.IntrospectTokenRequest{Token: resp.GetToken()})
+
+	if err != nil || introspection.GetActive() {
+		t.Fatalf("expected the token to stop verifying, got: %v, %v", introspection, err)
+	}
+
+	if _, err := suite.SsosageClient.GenerateToken(ctx, request); status.Code(err) != codes.PermissionDenied {
+		t.Fatalf("expected a role lost with the group to be refused, got: %v", err)
+	}
+
+	if _, err := suite.SsosageClient.RevokeRole(adminCtx, &ssosage_proto.RevokeRoleRequest{AppName: appName, Role: "user", ClientName: clientName}); err != nil {
+		t.Fatalf("failed to revoke role: %v", err)
+	}
+
+	roles, err = suite.SsosageClient.ClientRoles(adminCtx, &ssosage_proto.ClientRolesRequest{ClientName: clientName, AppName: appName})
+
+	if err != nil || len(roles.GetRoles()) != 0 {
+		t.Fatalf("expected no roles left, got: %v, %v", roles, err)
+	}
+
+	if _, err := suite.SsosageClient.DeleteGroup(adminCtx, &ssosage_proto.DeleteGroupRequest{GroupName: group}); err != nil {
+		t.Fatalf("failed to delete group: %v", err)
+	}
+}
+
+func TestGroupErrors(t *testing.T) {
+	ctx, suite := suite.NewSuite(t)
+
+	appName := suite.RegisterApp(ctx, "user")
+	clientName, _ := suite.RegisterClient(ctx)
+	adminCtx := suite.AdminContext(ctx)
+	group := "devs-" + gofakeit.UUID()
+
+	if _, err := suite.SsosageClient.RegisterGroup(ctx, &ssosage_proto.RegisterGroupRequest{GroupName: group}); status.Code(err) != codes.Unauthenticated {
+		t.Fatalf("expected unauthenticated without credentials, got: %v", err)
+	}
+
+	if _, err := suite.SsosageClient.RegisterGroup(adminCtx, &ssosage_proto.RegisterGroupRequest{GroupName: group}); err != nil {
+		t.Fatalf("failed to register group: %v", err)
+	}
+
+	tests := []struct {
+		name string
+		call func() error
+		code codes.Code
+	}{
+		{"grant to a client and a group", func() error {
+			_, err := suite.SsosageClient.GrantRole(adminCtx, &ssosage_proto.GrantRoleRequest{AppName: appName, Role: "user", ClientName: clientName, GroupName: group})
+			return err
+		}, codes.InvalidArgument},
+		{"grant to nobody", func() error {
+			_, err := suite.SsosageClient.GrantRole(adminCtx, &ssosage_proto.GrantRoleRequest{AppName: appName, Role: "user"})
+			return err
+		}, codes.InvalidArgument},
+		{"grant of a role the app lacks", func() error {
+			_, err := suite.SsosageClient.GrantRole(adminCtx, &ssosage_proto.GrantRoleRequest{AppName: appName, Role: "owner", ClientName: clientName})
+			return err
+		}, codes.InvalidArgument},
+		{"grant for an unknown app", func() error {
+			_, err := suite.SsosageClient.GrantRole(adminCtx, &ssosage_proto.GrantRoleRequest{AppName: appName + "-missing", Role: "user", ClientName: clientName})
+			return err
+		}, codes.NotFound},
+		{"member of an unknown group", func() error {
+			_, err := suite.SsosageClient.AddGroupMember(adminCtx, &ssosage_proto.AddGroupMemberRequest{GroupName: group + "-missing", ClientName: clientName})
+			return err
+		}, codes.NotFound},
+		{"unknown member", func() error {
+			_, err := suite.SsosageClient.AddGroupMember(adminCtx, &ssosage_proto.AddGroupMemberRequest{GroupName: group, ClientName: clientName + "-missing"})
+			return err
+		}, codes.NotFound},
+		{"member of another organization", func() error {
+			_, err := suite.SsosageClient.AddGroupMember(adminCtx, &ssosage_proto.AddGroupMemberRequest{GroupName: "acme/" + group, ClientName: clientName})
+			return err
+		}, codes.InvalidArgument},
+		{"roles in an unknown app", func() error {
+			_, err := suite.SsosageClient.ClientRoles(adminCtx, &ssosage_proto.ClientRolesRequest{ClientName: clientName, AppName: appName + "-missing"})
+			return err
+		}, codes.NotFound},
+	}
+
+	for _, tt := range tests {
+		if err := tt.call(); status.Code(err) != tt.code {
+			t.Fatalf("%s: expected %v, got: %v", tt.name, tt.code, err)
+		}
+	}
+}
+
+func TestOrganizationAdmin(t *testing.T) {
+	ctx, suite := suite.NewSuite(t)
+
+	adminCtx := suite.AdminContext(ctx)
+	org := "org-" + gofakeit.UUID()
+
+	if _, err := suite.SsosageClient.RegisterOrganization(adminCtx, &ssosage_proto.RegisterOrganizationRequest{Name: org}); err != nil {
+		t.Fatalf("failed to register organization: %v", err)
+	}
+
+	adminApp, adminName, password := org+"/"+suite.Cfg.Auth.AdminApp, org+"/boss", "some-password"
+
+	if _, err := suite.SsosageClient.RegisterApp(adminCtx, &ssosage_proto.RegisterAppRequest{AppName: adminApp, AppSecret: "secret", Roles: []string{"admin"}}); err != nil {
+		t.Fatalf("failed to register the admin app of the organization: %v", err)
+	}
+
+	suite.UpdateApp(ctx, &ssosage_proto.UpdateAppRequest{AppName: adminApp, RoleGrants: proto.Bool(true)})
+
+	if _, err := suite.SsosageClient.RegisterClient(adminCtx, &ssosage_proto.RegisterClientRequest{ClientName: adminName, Password: password}); err != nil {
+		t.Fatalf("failed to register client: %v", err)
+	}
+
+	if _, err := suite.SsosageClient.GrantRole(adminCtx, &ssosage_proto.GrantRoleRequest{AppName: adminApp, Role: "admin", ClientName: adminName}); err != nil {
+		t.Fatalf("failed to grant admin role: %v", err)
+	}
+
+	resp, err := suite.SsosageClient.GenerateToken(ctx, &ssosage_proto.GenerateTokenRequest{
+		ClientName: adminName,
+		Password:   password,
+		AppName:    adminApp,
+		Role:       "admin",
+	})
+
+	if err != nil {
+		t.Fatalf("failed to generate admin token: %v", err)
+	}
+
+	orgAdminCtx := metadata.AppendToOutgoingContext(ctx, "authorization", "Bearer "+resp.GetToken())
+
+	// an organization admin manages its own organization
+	if _, err := suite.SsosageClient.RegisterGroup(orgAdminCtx, &ssosage_proto.RegisterGroupRequest{GroupName: org + "/devs"}); err != nil {
+		t.Fatalf("failed to register group as organization admin: %v", err)
+	}
+
+	if _, err := suite.SsosageClient.RegisterApp(orgAdminCtx, &ssosage_proto.RegisterAppRequest{AppName: org + "/shop", AppSecret: "secret", Roles: []string{"user"}}); err != nil {
+		t.Fatalf("failed to register app as organization admin: %v", err)
+	}
+
+	// and nothing else
+	if _, err := suite.SsosageClient.RegisterGroup(orgAdminCtx, &ssosage_proto.RegisterGroupRequest{GroupName: "devs-" + gofakeit.UUID()}); status.Code(err) != codes.PermissionDenied {
+		t.Fatalf("expected a global group to be refused, got: %v", err)
+	}
+
+	if _, err := suite.SsosageClient.RegisterOrganization(orgAdminCtx, &ssosage_proto.RegisterOrganizationRequest{Name: org + "-other"}); status.Code(err) != codes.PermissionDenied {
+		t.Fatalf("expected a new organization to be refused, got: %v", err)
+	}
+}
@@ -0,0 +1,128 @@
+package tests
+
+import (
+	"slices"
+	"ssosage/tests/suite"
+	"testing"
+
+	"github.com/brianvoe/gofakeit/v6"
+	"github.com/hyperfyodor/ssosage_proto"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestListClients(t *testing.T) {
+	ctx, suite := suite.NewSuite(t)
+
+	prefix := gofakeit.UUID() + "-"
+	names := []string{prefix + "a", prefix + "b", prefix + "c"}
+
+	for _, name := range names {
+		_, err := suite.SsosageClient.RegisterClient(ctx, &ssosage_proto.RegisterClientRequest{
+			ClientName: name,
+			Password:   gofakeit.Password(true, true, true, true, false, 20),
+		})
+
+		if err != nil {
+			t.Fatalf("failed to register a client: %v", err)
+		}
+	}
+
+	query := &ssosage_proto.ListQuery{NamePrefix: prefix, SortBy: "name", Desc: true, Limit: 2}
+
+	_, err := suite.SsosageClient.ListClients(ctx, &ssosage_proto.ListClientsRequest{Query: query})
+
+	if status.Code(err) != codes.Unauthenticated {
+		t.Fatalf("expected unauthenticated without credentials, got: %v", err)
+	}
+
+	var listed []string
+
+	for {
+		page, err := suite.SsosageClient.ListClients(suite.AdminContext(ctx), &ssosage_proto.ListClientsRequest{Query: query})
+
+		if err != nil {
+			t.Fatalf("failed to list clients: %v", err)
+		}
+
+		for _, client := range page.GetClients() {
+			if client.GetStatus() != "active" || client.GetCreatedAt().AsTime().IsZero() {
+				t.Fatalf("unexpected client: %v", client)
+			}
+
+			listed = append(listed, client.GetName())
+		}
+
+		if page.GetNextCursor() == "" {
+			break
+		}
+
+		query.Cursor = page.GetNextCursor()
+	}
+
+	if !slices.Equal(listed, []string{names[2], names[1], names[0]}) {
+		t.Fatalf("expected %v, got %v", names, listed)
+	}
+
+	_, err = suite.SsosageClient.ListClients(suite.AdminContext(ctx), &ssosage_proto.ListClientsRequest{
+		Query: &ssosage_proto.ListQuery{SortBy: "password_hash"},
+	})
+
+	if status.Code(err) != codes.InvalidArgument {
+		t.Fatalf("expected invalid argument for an unknown sort field, got: %v", err)
+	}
+
+	_, err = suite.SsosageClient.ListClients(suite.AdminContext(ctx), &ssosage_proto.ListClientsRequest{
+		Query: &ssosage_proto.ListQuery{Cursor: "garbage"},
+	})
+
+	if status.Code(err) != codes.InvalidArgument {
+		t.Fatalf("expected invalid argument for a broken cursor, got: %v", err)
+	}
+}
+
+func TestListApps(t *testing.T) {
+	ctx, suite := suite.NewSuite(t)
+
+	prefix := gofakeit.UUID() + "-"
+
+	for _, name := range []string{prefix + "a", prefix + "b"} {
+		_, err := suite.SsosageClient.RegisterApp(suite.AdminContext(ctx), &ssosage_proto.RegisterAppRequest{
+			AppName:   name,
+			AppSecret: APP_SECRET,
+			Roles:     []string{"user", "admin"},
+		})
+
+		if err != nil {
+			t.Fatalf("failed to register an app: %v", err)
+		}
+	}
+
+	page, err := suite.SsosageClient.ListApps(suite.AdminContext(ctx), &ssosage_proto.ListAppsRequest{
+		Query: &ssosage_proto.ListQuery{NamePrefix: prefix, Limit: 1},
+	})
+
+	if err != nil {
+		t.Fatalf("failed to list apps: %v", err)
+	}
+
+	if len(page.GetApps()) != 1 || page.GetApps()[0].GetName() != prefix+"a" || page.GetNextCursor() == "" {
+		t.Fatalf("unexpected first page: %v", page)
+	}
+
+	if !slices.Equal(page.GetApps()[0].GetRoles(), []string{"user", "admin"}) {
+		t.Fatalf("unexpected roles: %v", page.GetApps()[0].GetRoles())
+	}
+
+	page, err = suite.SsosageClient.ListApps(suite.AdminContext(ctx), &ssosage_proto.ListAppsRequest{
+		Query: &ssosage_proto.ListQuery{NamePrefix: prefix, Limit: 1, Cursor: page.GetNextCursor()},
+	})
+
+	if err != nil {
+		t.Fatalf("failed to list apps: %v", err)
+	}
+
+	if len(page.GetApps()) != 1 || page.GetApps()[0].GetName() != prefix+"b" || page.GetNextCursor() != "" {
+		t.Fatalf("unexpected last page: %v", page)
+	}
+}
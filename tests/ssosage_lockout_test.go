@@ -0,0 +1,142 @@
+package tests
+
+import (
+	"ssosage/internal/interceptors/ratelimit"
+	"ssosage/tests/suite"
+	"testing"
+
+	"github.com/brianvoe/gofakeit/v6"
+	"github.com/hyperfyodor/ssosage_proto"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+func TestLoginLockedAfterFailedAttempt(t *testing.T) {
+	ctx, suite := suite.NewSuite(t)
+
+	appName := gofakeit.AppName()
+
+	_, err := suite.SsosageClient.RegisterApp(
+		suite.AdminContext(ctx),
+		&ssosage_proto.RegisterAppRequest{
+			AppName:   appName,
+			AppSecret: APP_SECRET,
+			Roles:     []string{"user"},
+		},
+	)
+
+	if err != nil {
+		t.Fatalf("failed to register an app: %v", err)
+	}
+
+	clientName := gofakeit.AppName()
+	password := gofakeit.Password(true, true, true, true, false, 20)
+
+	_, err = suite.SsosageClient.RegisterClient(
+		ctx,
+		&ssosage_proto.RegisterClientRequest{
+			ClientName: clientName,
+			Password:   password,
+		},
+	)
+
+	if err != nil {
+		t.Fatalf("failed to register a client: %v", err)
+	}
+
+	_, err = suite.SsosageClient.GenerateToken(
+		ctx,
+		&ssosage_proto.GenerateTokenRequest{
+			ClientName: clientName,
+			Password:   password + "wrong",
+			AppName:    appName,
+			Role:       "user",
+		},
+	)
+
+	if status.Code(err) != codes.InvalidArgument {
+		t.Fatalf("expected invalid credentials, got: %v", err)
+	}
+
+	var header metadata.MD
+
+	// the first failure locks the client for the base delay, even for the right password
+	_, err = suite.SsosageClient.GenerateToken(
+		ctx,
+		&ssosage_proto.GenerateTokenRequest{
+			ClientName: clientName,
+			Password:   password,
+			AppName:    appName,
+			Role:       "user",
+		},
+		grpc.Header(&header),
+	)
+
+	if status.Code(err) != codes.ResourceExhausted {
+		t.Fatalf("expected locked login, got: %v", err)
+	}
+
+	if len(header.Get(ratelimit.RetryAfterKey)) == 0 {
+		t.Fatalf("expected %s header on a locked login", ratelimit.RetryAfterKey)
+	}
+}
+
+func TestUnlockClient(t *testing.T) {
+	ctx, suite := suite.NewSuite(t)
+
+	appName := suite.RegisterApp(ctx, "user")
+	clientName, password := suite.RegisterClient(ctx)
+
+	request := &ssosage_proto.GenerateTokenRequest{
+		ClientName: clientName,
+		Password:   password,
+		AppName:    appName,
+		Role:       "user",
+	}
+
+	_, err := suite.SsosageClient.GenerateToken(ctx, &ssosage_proto.GenerateTokenRequest{
+		ClientName: clientName,
+		Password:   password + "wrong",
+		AppName:    appName,
+		Role:       "user",
+	})
+
+	if status.Code(err) != codes.InvalidArgument {
+		t.Fatalf("expected invalid credentials, got: %v", err)
+	}
+
+	if _, err := suite.SsosageClient.GenerateToken(ctx, request); status.Code(err) != codes.ResourceExhausted {
+		t.Fatalf("expected locked login, got: %v", err)
+	}
+
+	_, err = suite.SsosageClient.UnlockClient(ctx, &ssosage_proto.UnlockClientRequest{ClientName: clientName})
+
+	if status.Code(err) != codes.Unauthenticated {
+		t.Fatalf("expected unauthenticated without credentials, got: %v", err)
+	}
+
+	if _, err := suite.SsosageClient.UnlockClient(suite.AdminContext(ctx), &ssosage_proto.UnlockClientRequest{ClientName: clientName}); err != nil {
+		t.Fatalf("failed to unlock client: %v", err)
+	}
+
+	if _, err := suite.SsosageClient.GenerateToken(ctx, request); err != nil {
+		t.Fatalf("expected an unlocked login, got: %v", err)
+	}
+}
+
+func TestUnlockAddress(t *testing.T) {
+	ctx, suite := suite.NewSuite(t)
+
+	_, err := suite.SsosageClient.UnlockAddress(suite.AdminContext(ctx), &ssosage_proto.UnlockAddressRequest{Ip: "not-an-ip"})
+
+	if status.Code(err) != codes.InvalidArgument {
+		t.Fatalf("expected invalid argument, got: %v", err)
+	}
+
+	// a documentation address nobody logs in from, unlocking it touches no other test
+	if _, err := suite.SsosageClient.UnlockAddress(suite.AdminContext(ctx), &ssosage_proto.UnlockAddressRequest{Ip: "192.0.2.1"}); err != nil {
+		t.Fatalf("failed to unlock address: %v", err)
+	}
+}
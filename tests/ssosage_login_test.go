@@ -0,0 +1,146 @@
+package tests
+
+import (
+	"slices"
+	"ssosage/tests/suite"
+	"testing"
+
+	"github.com/hyperfyodor/ssosage_proto"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestMultiStepLogin(t *testing.T) {
+	ctx, suite := suite.NewSuite(t)
+
+	appName := suite.RegisterApp(ctx, "user")
+	clientName, password := suite.RegisterClient(ctx)
+
+	begun, err := suite.SsosageClient.BeginLogin(ctx, &ssosage_proto.BeginLoginRequest{ClientName: clientName, AppName: appName, Role: "user"})
+
+	if err != nil {
+		t.Fatalf("failed to begin login: %v", err)
+	}
+
+	step := begun.GetStep()
+
+	if step.GetChallenge() == "" || !slices.Equal(step.GetRemaining(), []string{"password"}) || !step.GetExpiresAt().IsValid() || step.GetToken() != "" {
+		t.Fatalf("unexpected first step: %v", step)
+	}
+
+	completed, err := suite.SsosageClient.CompleteFactor(ctx, &ssosage_proto.CompleteFactorRequest{
+		Challenge: step.GetChallenge(),
+		Factor:    "password",
+		Value:     password,
+	})
+
+	if err != nil {
+		t.Fatalf("failed to complete password: %v", err)
+	}
+
+	if completed.GetStep().GetToken() == "" || len(completed.GetStep().GetRemaining()) != 0 {
+		t.Fatalf("expected a token, got: %v", completed.GetStep())
+	}
+
+	introspection, err := suite.SsosageClient.IntrospectToken(ctx, &ssosage_proto.IntrospectTokenRequest{Token: completed.GetStep().GetToken()})
+
+	if err != nil || !introspection.GetActive() || introspection.GetClientName() != clientName || introspection.GetAppName() != appName {
+		t.Fatalf("expected an active token, got: %v, %v", introspection, err)
+	}
+
+	// a finished login is gone
+	_, err = suite.SsosageClient.CompleteFactor(ctx, &ssosage_proto.CompleteFactorRequest{
+		Challenge: step.GetChallenge(),
+		Factor:    "password",
+		Value:     password,
+	})
+
+	if status.Code(err) != codes.InvalidArgument {
+		t.Fatalf("expected a used challenge to be refused, got: %v", err)
+	}
+}
+
+func TestMultiStepLoginTOTP(t *testing.T) {
+	ctx, suite := suite.NewSuite(t)
+
+	appName := suite.RegisterApp(ctx, "user")
+	clientName, password := suite.RegisterClient(ctx)
+	secret, _ := enrollTOTP(ctx, t, suite, clientName, password)
+
+	begun, err := suite.SsosageClient.BeginLogin(ctx, &ssosage_proto.BeginLoginRequest{ClientName: clientName, AppName: appName, Role: "user"})
+
+	if err != nil {
+		t.Fatalf("failed to begin login: %v", err)
+	}
+
+	challenge := begun.GetStep().GetChallenge()
+
+	// the second factor only shows up after the password
+	if !slices.Equal(begun.GetStep().GetRemaining(), []string{"password"}) {
+		t.Fatalf("unexpected first step: %v", begun.GetStep())
+	}
+
+	_, err = suite.SsosageClient.CompleteFactor(ctx, &ssosage_proto.CompleteFactorRequest{Challenge: challenge, Factor: "totp", Value: nextCode(secret)})
+
+	if status.Code(err) != codes.FailedPrecondition {
+		t.Fatalf("expected totp before the password to be refused, got: %v", err)
+	}
+
+	completed, err := suite.SsosageClient.CompleteFactor(ctx, &ssosage_proto.CompleteFactorRequest{Challenge: challenge, Factor: "password", Value: password})
+
+	if err != nil {
+		t.Fatalf("failed to complete password: %v", err)
+	}
+
+	step := completed.GetStep()
+
+	if step.GetChallenge() != challenge || !slices.Equal(step.GetRemaining(), []string{"totp"}) || step.GetToken() != "" {
+		t.Fatalf("expected a totp step, got: %v", step)
+	}
+
+	completed, err = suite.SsosageClient.CompleteFactor(ctx, &ssosage_proto.CompleteFactorRequest{Challenge: challenge, Factor: "totp", Value: nextCode(secret)})
+
+	if err != nil {
+		t.Fatalf("failed to complete totp: %v", err)
+	}
+
+	if completed.GetStep().GetToken() == "" {
+		t.Fatalf("expected a token, got: %v", completed.GetStep())
+	}
+}
+
+func TestMultiStepLoginErrors(t *testing.T) {
+	ctx, suite := suite.NewSuite(t)
+
+	appName := suite.RegisterApp(ctx, "user")
+	clientName, _ := suite.RegisterClient(ctx)
+
+	_, err := suite.SsosageClient.BeginLogin(ctx, &ssosage_proto.BeginLoginRequest{ClientName: clientName, AppName: appName, Role: "admin"})
+
+	if status.Code(err) != codes.InvalidArgument {
+		t.Fatalf("expected an unknown role to be refused, got: %v", err)
+	}
+
+	_, err = suite.SsosageClient.CompleteFactor(ctx, &ssosage_proto.CompleteFactorRequest{Challenge: "nonsense", Factor: "password", Value: "password"})
+
+	if status.Code(err) != codes.InvalidArgument {
+		t.Fatalf("expected an unknown challenge to be refused, got: %v", err)
+	}
+
+	// an unknown client begins like any other, it fails at the password
+	begun, err := suite.SsosageClient.BeginLogin(ctx, &ssosage_proto.BeginLoginRequest{ClientName: clientName + "-missing", AppName: appName, Role: "user"})
+
+	if err != nil {
+		t.Fatalf("failed to begin login: %v", err)
+	}
+
+	_, err = suite.SsosageClient.CompleteFactor(ctx, &ssosage_proto.CompleteFactorRequest{
+		Challenge: begun.GetStep().GetChallenge(),
+		Factor:    "password",
+		Value:     "wrong",
+	})
+
+	if status.Code(err) != codes.InvalidArgument {
+		t.Fatalf("expected a wrong password to be refused, got: %v", err)
+	}
+}
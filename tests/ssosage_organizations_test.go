@@ -0,0 +1,127 @@
+package tests
+
+import (
+	"ssosage/tests/suite"
+	"testing"
+
+	"github.com/brianvoe/gofakeit/v6"
+	"github.com/hyperfyodor/ssosage_proto"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+)
+
+func TestOrganizations(t *testing.T) {
+	ctx, suite := suite.NewSuite(t)
+
+	adminCtx := suite.AdminContext(ctx)
+	org := "org-" + gofakeit.UUID()
+
+	if _, err := suite.SsosageClient.RegisterOrganization(ctx, &ssosage_proto.RegisterOrganizationRequest{Name: org}); status.Code(err) != codes.Unauthenticated {
+		t.Fatalf("expected unauthenticated without credentials, got: %v", err)
+	}
+
+	registered, err := suite.SsosageClient.RegisterOrganization(adminCtx, &ssosage_proto.RegisterOrganizationRequest{Name: org})
+
+	if err != nil || registered.GetOrgId() == 0 {
+		t.Fatalf("failed to register organization: %v, %v", registered, err)
+	}
+
+	if _, err := suite.SsosageClient.RegisterOrganization(adminCtx, &ssosage_proto.RegisterOrganizationRequest{Name: org}); status.Code(err) != codes.AlreadyExists {
+		t.Fatalf("expected a second organization with the name to be refused, got: %v", err)
+	}
+
+	if _, err := suite.SsosageClient.RegisterOrganization(adminCtx, &ssosage_proto.RegisterOrganizationRequest{Name: org + "/team"}); status.Code(err) != codes.InvalidArgument {
+		t.Fatalf("expected a nested organization to be refused, got: %v", err)
+	}
+
+	appName, clientName, password := org+"/billing", org+"/alice", "some-password"
+
+	_, err = suite.SsosageClient.RegisterApp(adminCtx, &ssosage_proto.RegisterAppRequest{AppName: appName, AppSecret: "secret", Roles: []string{"user"}})
+
+	if err != nil {
+		t.Fatalf("failed to register app in organization: %v", err)
+	}
+
+	// joining an organization is not public
+	_, err = suite.SsosageClient.RegisterClient(ctx, &ssosage_proto.RegisterClientRequest{ClientName: clientName, Password: password})
+
+	if status.Code(err) != codes.Unauthenticated {
+		t.Fatalf("expected unauthenticated without credentials, got: %v", err)
+	}
+
+	if _, err := suite.SsosageClient.RegisterClient(adminCtx, &ssosage_proto.RegisterClientRequest{ClientName: clientName, Password: password}); err != nil {
+		t.Fatalf("failed to register client in organization: %v", err)
+	}
+
+	resp, err := suite.SsosageClient.GenerateToken(ctx, &ssosage_proto.GenerateTokenRequest{
+		ClientName: clientName,
+		Password:   password,
+		AppName:    appName,
+		Role:       "user",
+	})
+
+	if err != nil {
+		t.Fatalf("failed to generate token: %v", err)
+	}
+
+	introspection, err := suite.SsosageClient.IntrospectToken(ctx, &ssosage_proto.IntrospectTokenRequest{Token: resp.GetToken()})
+
+	if err != nil || introspection.GetOrg() != org || introspection.GetOrgId() != uint64(registered.GetOrgId()) {
+		t.Fatalf("expected a token of the organization, got: %v, %v", introspection, err)
+	}
+
+	// the apps of an organization are invisible to other clients
+	globalClient, globalPassword := suite.RegisterClient(ctx)
+
+	_, err = suite.SsosageClient.GenerateToken(ctx, &ssosage_proto.GenerateTokenRequest{
+		ClientName: globalClient,
+		Password:   globalPassword,
+		AppName:    appName,
+		Role:       "user",
+	})
+
+	if status.Code(err) != codes.InvalidArgument {
+		t.Fatalf("expected an outside client to be refused, got: %v", err)
+	}
+
+	// global apps take the clients of organizations once they have org_clients
+	globalApp := suite.RegisterApp(ctx, "user")
+
+	request := &ssosage_proto.GenerateTokenRequest{
+		ClientName: clientName,
+		Password:   password,
+		AppName:    globalApp,
+		Role:       "user",
+	}
+
+	if _, err := suite.SsosageClient.GenerateToken(ctx, request); status.Code(err) != codes.InvalidArgument {
+		t.Fatalf("expected a global app to refuse the client of an organization, got: %v", err)
+	}
+
+	suite.UpdateApp(ctx, &ssosage_proto.UpdateAppRequest{AppName: globalApp, OrgClients: proto.Bool(true)})
+
+	if _, err := suite.SsosageClient.GenerateToken(ctx, request); err != nil {
+		t.Fatalf("failed to generate token for an app with org_clients: %v", err)
+	}
+
+	if _, err := suite.SsosageClient.DeleteOrganization(adminCtx, &ssosage_proto.DeleteOrganizationRequest{Name: org}); status.Code(err) != codes.FailedPrecondition {
+		t.Fatalf("expected an organization with members to stay, got: %v", err)
+	}
+
+	if _, err := suite.SsosageClient.DeleteApp(adminCtx, &ssosage_proto.DeleteAppRequest{AppName: appName}); err != nil {
+		t.Fatalf("failed to delete app: %v", err)
+	}
+
+	if _, err := suite.SsosageClient.DeleteClient(adminCtx, &ssosage_proto.DeleteClientRequest{ClientName: clientName}); err != nil {
+		t.Fatalf("failed to delete client: %v", err)
+	}
+
+	if _, err := suite.SsosageClient.DeleteOrganization(adminCtx, &ssosage_proto.DeleteOrganizationRequest{Name: org}); err != nil {
+		t.Fatalf("failed to delete organization: %v", err)
+	}
+
+	if _, err := suite.SsosageClient.DeleteOrganization(adminCtx, &ssosage_proto.DeleteOrganizationRequest{Name: org}); status.Code(err) != codes.NotFound {
+		t.Fatalf("expected a deleted organization to be gone, got: %v", err)
+	}
+}
@@ -0,0 +1,239 @@
+package tests
+
+import (
+	"bytes"
+	"context"
+	"slices"
+	"ssosage/internal/webauthn/webauthntest"
+	"ssosage/tests/suite"
+	"testing"
+
+	"github.com/brianvoe/gofakeit/v6"
+	"github.com/hyperfyodor/ssosage_proto"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// registerPasskey adds a passkey made by a new software authenticator to a client and returns the authenticator
+func registerPasskey(ctx context.Context, t *testing.T, suite *suite.Suite, clientName string, password string) *webauthntest.Authenticator {
+	t.Helper()
+
+	if suite.Cfg.WebAuthn.RPID == "" || len(suite.Cfg.WebAuthn.Origins) == 0 {
+		t.Fatal("no webauthn relying party in test config")
+	}
+
+	authenticator := webauthntest.New(suite.Cfg.WebAuthn.RPID, suite.Cfg.WebAuthn.Origins[0])
+
+	begun, err := suite.SsosageClient.BeginPasskeyRegistration(ctx, &ssosage_proto.BeginPasskeyRegistrationRequest{ClientName: clientName})
+
+	if err != nil {
+		t.Fatalf("failed to begin passkey registration: %v", err)
+	}
+
+	challenge := begun.GetStep().GetChallenge()
+
+	if !slices.Equal(begun.GetStep().GetRemaining(), []string{"password", "webauthn_registration"}) {
+		t.Fatalf("unexpected registration steps: %v", begun.GetStep())
+	}
+
+	if _, err := suite.SsosageClient.CompleteFactor(ctx, &ssosage_proto.CompleteFactorRequest{Challenge: challenge, Factor: "password", Value: password}); err != nil {
+		t.Fatalf("failed to complete password: %v", err)
+	}
+
+	completed, err := suite.SsosageClient.CompleteFactor(ctx, &ssosage_proto.CompleteFactorRequest{
+		Challenge: challenge,
+		Factor:    "webauthn_registration",
+		Value:     authenticator.RegisterJSON(challenge),
+	})
+
+	if err != nil {
+		t.Fatalf("failed to register passkey: %v", err)
+	}
+
+	// a registration issues nothing
+	if completed.GetStep().GetToken() != "" {
+		t.Fatalf("expected no token from a registration, got: %v", completed.GetStep())
+	}
+
+	return authenticator
+}
+
+func TestPasswordlessLogin(t *testing.T) {
+	ctx, suite := suite.NewSuite(t)
+
+	appName := suite.RegisterApp(ctx, "user")
+	clientName, password := suite.RegisterClient(ctx)
+	authenticator := registerPasskey(ctx, t, suite, clientName, password)
+
+	begun, err := suite.SsosageClient.BeginPasskeyLogin(ctx, &ssosage_proto.BeginPasskeyLoginRequest{ClientName: clientName, AppName: appName, Role: "user"})
+
+	if err != nil {
+		t.Fatalf("failed to begin passkey login: %v", err)
+	}
+
+	if !slices.Equal(begun.GetStep().GetRemaining(), []string{"webauthn"}) {
+		t.Fatalf("unexpected passkey login steps: %v", begun.GetStep())
+	}
+
+	challenge := begun.GetStep().GetChallenge()
+
+	completed, err := suite.SsosageClient.CompleteFactor(ctx, &ssosage_proto.CompleteFactorRequest{
+		Challenge: challenge,
+		Factor:    "webauthn",
+		Value:     authenticator.AssertJSON(challenge),
+	})
+
+	if err != nil {
+		t.Fatalf("failed to complete passkey: %v", err)
+	}
+
+	introspection, err := suite.SsosageClient.IntrospectToken(ctx, &ssosage_proto.IntrospectTokenRequest{Token: completed.GetStep().GetToken()})
+
+	if err != nil || !introspection.GetActive() || introspection.GetClientName() != clientName {
+		t.Fatalf("expected an active token, got: %v, %v", introspection, err)
+	}
+}
+
+func TestPasskeySecondFactor(t *testing.T) {
+	ctx, suite := suite.NewSuite(t)
+
+	appName := suite.RegisterApp(ctx, "user")
+	clientName, password := suite.RegisterClient(ctx)
+	authenticator := registerPasskey(ctx, t, suite, clientName, password)
+
+	// the password alone is not enough anymore
+	_, err := suite.SsosageClient.GenerateToken(ctx, &ssosage_proto.GenerateTokenRequest{
+		ClientName: clientName,
+		Password:   password,
+		AppName:    appName,
+		Role:       "user",
+	})
+
+	if status.Code(err) != codes.FailedPrecondition {
+		t.Fatalf("expected GenerateToken to refuse a passkey client, got: %v", err)
+	}
+
+	_, err = suite.SsosageClient.ChangePassword(ctx, &ssosage_proto.ChangePasswordRequest{
+		ClientName:  clientName,
+		OldPassword: password,
+		NewPassword: gofakeit.Password(true, true, true, true, false, 20),
+	})
+
+	if status.Code(err) != codes.FailedPrecondition {
+		t.Fatalf("expected ChangePassword to refuse a passkey client, got: %v", err)
+	}
+
+	begun, err := suite.SsosageClient.BeginLogin(ctx, &ssosage_proto.BeginLoginRequest{ClientName: clientName, AppName: appName, Role: "user"})
+
+	if err != nil {
+		t.Fatalf("failed to begin login: %v", err)
+	}
+
+	challenge := begun.GetStep().GetChallenge()
+
+	completed, err := suite.SsosageClient.CompleteFactor(ctx, &ssosage_proto.CompleteFactorRequest{Challenge: challenge, Factor: "password", Value: password})
+
+	if err != nil {
+		t.Fatalf("failed to complete password: %v", err)
+	}
+
+	if !slices.Equal(completed.GetStep().GetRemaining(), []string{"webauthn"}) {
+		t.Fatalf("expected a passkey step, got: %v", completed.GetStep())
+	}
+
+	completed, err = suite.SsosageClient.CompleteFactor(ctx, &ssosage_proto.CompleteFactorRequest{
+		Challenge: challenge,
+		Factor:    "webauthn",
+		Value:     authenticator.AssertJSON(challenge),
+	})
+
+	if err != nil {
+		t.Fatalf("failed to complete passkey: %v", err)
+	}
+
+	if completed.GetStep().GetToken() == "" {
+		t.Fatalf("expected a token, got: %v", completed.GetStep())
+	}
+}
+
+func TestManagePasskeys(t *testing.T) {
+	ctx, suite := suite.NewSuite(t)
+
+	appName := suite.RegisterApp(ctx, "user")
+	clientName, password := suite.RegisterClient(ctx)
+	authenticator := registerPasskey(ctx, t, suite, clientName, password)
+	adminCtx := suite.AdminContext(ctx)
+
+	listed, err := suite.SsosageClient.ListPasskeys(adminCtx, &ssosage_proto.ListPasskeysRequest{ClientName: clientName})
+
+	if err != nil {
+		t.Fatalf("failed to list passkeys: %v", err)
+	}
+
+	if len(listed.GetPasskeys()) != 1 || !bytes.Equal(listed.GetPasskeys()[0].GetId(), authenticator.CredentialID) {
+		t.Fatalf("unexpected passkeys: %v", listed.GetPasskeys())
+	}
+
+	clients, err := suite.SsosageClient.ListClients(adminCtx, &ssosage_proto.ListClientsRequest{Query: &ssosage_proto.ListQuery{NamePrefix: clientName}})
+
+	if err != nil || len(clients.GetClients()) != 1 || !clients.GetClients()[0].GetWebauthnEnabled() {
+		t.Fatalf("expected a client with a passkey, got: %v, %v", clients, err)
+	}
+
+	// the same authenticator can't register twice
+	begun, err := suite.SsosageClient.BeginPasskeyRegistration(ctx, &ssosage_proto.BeginPasskeyRegistrationRequest{ClientName: clientName})
+
+	if err != nil {
+		t.Fatalf("failed to begin passkey registration: %v", err)
+	}
+
+	challenge := begun.GetStep().GetChallenge()
+
+	if _, err := suite.SsosageClient.CompleteFactor(ctx, &ssosage_proto.CompleteFactorRequest{Challenge: challenge, Factor: "password", Value: password}); err != nil {
+		t.Fatalf("failed to complete password: %v", err)
+	}
+
+	// the registered passkey is now the second factor of the registration
+	if _, err := suite.SsosageClient.CompleteFactor(ctx, &ssosage_proto.CompleteFactorRequest{Challenge: challenge, Factor: "webauthn", Value: authenticator.AssertJSON(challenge)}); err != nil {
+		t.Fatalf("failed to complete passkey: %v", err)
+	}
+
+	_, err = suite.SsosageClient.CompleteFactor(ctx, &ssosage_proto.CompleteFactorRequest{
+		Challenge: challenge,
+		Factor:    "webauthn_registration",
+		Value:     authenticator.RegisterJSON(challenge),
+	})
+
+	if status.Code(err) != codes.AlreadyExists {
+		t.Fatalf("expected a second registration to be refused, got: %v", err)
+	}
+
+	_, err = suite.SsosageClient.DeletePasskey(adminCtx, &ssosage_proto.DeletePasskeyRequest{ClientName: clientName, Id: authenticator.CredentialID})
+
+	if err != nil {
+		t.Fatalf("failed to delete passkey: %v", err)
+	}
+
+	_, err = suite.SsosageClient.DeletePasskey(adminCtx, &ssosage_proto.DeletePasskeyRequest{ClientName: clientName, Id: authenticator.CredentialID})
+
+	if status.Code(err) != codes.NotFound {
+		t.Fatalf("expected a deleted passkey to be gone, got: %v", err)
+	}
+
+	// the deleted passkey signs in no more, and the failure locks the client for a moment, so it comes last
+	login, err := suite.SsosageClient.BeginPasskeyLogin(ctx, &ssosage_proto.BeginPasskeyLoginRequest{ClientName: clientName, AppName: appName, Role: "user"})
+
+	if err != nil {
+		t.Fatalf("failed to begin passkey login: %v", err)
+	}
+
+	_, err = suite.SsosageClient.CompleteFactor(ctx, &ssosage_proto.CompleteFactorRequest{
+		Challenge: login.GetStep().GetChallenge(),
+		Factor:    "webauthn",
+		Value:     authenticator.AssertJSON(login.GetStep().GetChallenge()),
+	})
+
+	if status.Code(err) != codes.InvalidArgument {
+		t.Fatalf("expected a deleted passkey to be refused, got: %v", err)
+	}
+}
@@ -0,0 +1,134 @@
+package tests
+
+import (
+	"ssosage/tests/suite"
+	"testing"
+
+	"github.com/hyperfyodor/ssosage_proto"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+)
+
+func TestProfile(t *testing.T) {
+	ctx, suite := suite.NewSuite(t)
+
+	clientName, _ := suite.RegisterClient(ctx)
+	adminCtx := suite.AdminContext(ctx)
+
+	if _, err := suite.SsosageClient.GetProfile(ctx, &ssosage_proto.GetProfileRequest{ClientName: clientName}); status.Code(err) != codes.Unauthenticated {
+		t.Fatalf("expected unauthenticated without credentials, got: %v", err)
+	}
+
+	got, err := suite.SsosageClient.GetProfile(adminCtx, &ssosage_proto.GetProfileRequest{ClientName: clientName})
+
+	if err != nil {
+		t.Fatalf("failed to get profile: %v", err)
+	}
+
+	if got.GetProfile().GetDisplayName() != "" || got.GetProfile().GetUpdatedAt() != nil {
+		t.Fatalf("expected an empty profile, got: %v", got.GetProfile())
+	}
+
+	updated, err := suite.SsosageClient.UpdateProfile(adminCtx, &ssosage_proto.UpdateProfileRequest{
+		ClientName:  clientName,
+		DisplayName: proto.String("Some Client"),
+		Locale:      proto.String("pt-BR"),
+		Metadata:    proto.String(`{"team":"core","level":3}`),
+	})
+
+	if err != nil {
+		t.Fatalf("failed to update profile: %v", err)
+	}
+
+	profile := updated.GetProfile()
+
+	if profile.GetDisplayName() != "Some Client" || profile.GetLocale() != "pt-BR" || profile.GetAvatarUrl() != "" || !profile.GetUpdatedAt().IsValid() {
+		t.Fatalf("unexpected profile: %v", profile)
+	}
+
+	// only the fields that are set change
+	updated, err = suite.SsosageClient.UpdateProfile(adminCtx, &ssosage_proto.UpdateProfileRequest{ClientName: clientName, Locale: proto.String("")})
+
+	if err != nil || updated.GetProfile().GetLocale() != "" || updated.GetProfile().GetDisplayName() != "Some Client" {
+		t.Fatalf("expected the locale cleared, got: %v, %v", updated, err)
+	}
+
+	tests := []struct {
+		name    string
+		request *ssosage_proto.UpdateProfileRequest
+		code    codes.Code
+	}{
+		{"bad locale", &ssosage_proto.UpdateProfileRequest{ClientName: clientName, Locale: proto.String("not a locale")}, codes.InvalidArgument},
+		{"bad avatar url", &ssosage_proto.UpdateProfileRequest{ClientName: clientName, AvatarUrl: proto.String("ftp://example.com/a.png")}, codes.InvalidArgument},
+		{"bad metadata", &ssosage_proto.UpdateProfileRequest{ClientName: clientName, Metadata: proto.String(`["team"]`)}, codes.InvalidArgument},
+		{"unknown client", &ssosage_proto.UpdateProfileRequest{ClientName: clientName + "-missing", Locale: proto.String("en")}, codes.NotFound},
+	}
+
+	for _, tt := range tests {
+		if _, err := suite.SsosageClient.UpdateProfile(adminCtx, tt.request); status.Code(err) != tt.code {
+			t.Fatalf("%s: expected %v, got: %v", tt.name, tt.code, err)
+		}
+	}
+}
+
+func TestProfileClaims(t *testing.T) {
+	ctx, suite := suite.NewSuite(t)
+
+	appName := suite.RegisterApp(ctx, "user")
+	clientName, password := suite.RegisterClient(ctx)
+	adminCtx := suite.AdminContext(ctx)
+
+	_, err := suite.SsosageClient.UpdateProfile(adminCtx, &ssosage_proto.UpdateProfileRequest{
+		ClientName:  clientName,
+		DisplayName: proto.String("Some Client"),
+		Locale:      proto.String("en"),
+		Metadata:    proto.String(`{"team":"core","level":3}`),
+	})
+
+	if err != nil {
+		t.Fatalf("failed to update profile: %v", err)
+	}
+
+	got, err := suite.SsosageClient.GetApp(adminCtx, &ssosage_proto.GetAppRequest{AppName: appName})
+
+	if err != nil {
+		t.Fatalf("failed to get app: %v", err)
+	}
+
+	_, err = suite.SsosageClient.UpdateApp(adminCtx, &ssosage_proto.UpdateAppRequest{
+		AppName:       appName,
+		Version:       got.GetApp().GetVersion(),
+		ProfileClaims: proto.String("display_name,nickname"),
+	})
+
+	if status.Code(err) != codes.InvalidArgument {
+		t.Fatalf("expected unknown profile claims to be refused, got: %v", err)
+	}
+
+	suite.UpdateApp(ctx, &ssosage_proto.UpdateAppRequest{AppName: appName, ProfileClaims: proto.String("display_name,metadata.team")})
+
+	resp, err := suite.SsosageClient.GenerateToken(ctx, &ssosage_proto.GenerateTokenRequest{
+		ClientName: clientName,
+		Password:   password,
+		AppName:    appName,
+		Role:       "user",
+	})
+
+	if err != nil {
+		t.Fatalf("failed to generate token: %v", err)
+	}
+
+	introspection, err := suite.SsosageClient.IntrospectToken(ctx, &ssosage_proto.IntrospectTokenRequest{Token: resp.GetToken()})
+
+	if err != nil {
+		t.Fatalf("failed to introspect token: %v", err)
+	}
+
+	// the token carries the attributes the app asked for and nothing else
+	profile := introspection.GetProfile()
+
+	if profile.GetDisplayName() != "Some Client" || profile.GetLocale() != "" || profile.GetMetadata() != `{"team":"core"}` {
+		t.Fatalf("unexpected token profile: %v", profile)
+	}
+}
@@ -0,0 +1,82 @@
+package tests
+
+import (
+	"ssosage/tests/suite"
+	"testing"
+
+	"github.com/hyperfyodor/ssosage_proto"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const passwordResetSubject = "Password reset"
+
+func TestPasswordReset(t *testing.T) {
+	ctx, suite := suite.NewSuite(t)
+
+	appName := suite.RegisterApp(ctx, "user")
+	clientName, password := suite.RegisterClient(ctx)
+
+	request := &ssosage_proto.GenerateTokenRequest{
+		ClientName: clientName,
+		Password:   password,
+		AppName:    appName,
+		Role:       "user",
+	}
+
+	resp, err := suite.SsosageClient.GenerateToken(ctx, request)
+
+	if err != nil {
+		t.Fatalf("failed to generate token: %v", err)
+	}
+
+	if _, err := suite.SsosageClient.RequestPasswordReset(ctx, &ssosage_proto.RequestPasswordResetRequest{ClientName: clientName}); err != nil {
+		t.Fatalf("failed to request password reset: %v", err)
+	}
+
+	resetToken := suite.NotificationToken(clientName, passwordResetSubject)
+	newPassword := password + "-reset"
+
+	_, err = suite.SsosageClient.ConfirmPasswordReset(ctx, &ssosage_proto.ConfirmPasswordResetRequest{Token: resetToken, NewPassword: newPassword})
+
+	if err != nil {
+		t.Fatalf("failed to confirm password reset: %v", err)
+	}
+
+	// the reset revokes the tokens issued before it
+	introspection, err := suite.SsosageClient.IntrospectToken(ctx, &ssosage_proto.IntrospectTokenRequest{Token: resp.GetToken()})
+
+	if err != nil || introspection.GetActive() {
+		t.Fatalf("expected an inactive token, got: %v, %v", introspection, err)
+	}
+
+	request.Password = newPassword
+
+	if _, err := suite.SsosageClient.GenerateToken(ctx, request); err != nil {
+		t.Fatalf("failed to generate token with the new password: %v", err)
+	}
+
+	// a reset token works once
+	_, err = suite.SsosageClient.ConfirmPasswordReset(ctx, &ssosage_proto.ConfirmPasswordResetRequest{Token: resetToken, NewPassword: password})
+
+	if status.Code(err) != codes.InvalidArgument {
+		t.Fatalf("expected a used reset token to be refused, got: %v", err)
+	}
+}
+
+func TestPasswordResetUnknown(t *testing.T) {
+	ctx, suite := suite.NewSuite(t)
+
+	// the call does not tell which clients exist
+	_, err := suite.SsosageClient.RequestPasswordReset(ctx, &ssosage_proto.RequestPasswordResetRequest{ClientName: "missing-client"})
+
+	if err != nil {
+		t.Fatalf("expected a reset of an unknown client to succeed, got: %v", err)
+	}
+
+	_, err = suite.SsosageClient.ConfirmPasswordReset(ctx, &ssosage_proto.ConfirmPasswordResetRequest{Token: "nonsense", NewPassword: "password"})
+
+	if status.Code(err) != codes.InvalidArgument {
+		t.Fatalf("expected an unknown reset token to be refused, got: %v", err)
+	}
+}
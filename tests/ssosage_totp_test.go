@@ -0,0 +1,222 @@
+package tests
+
+import (
+	"context"
+	"encoding/base32"
+	"ssosage/internal/totp"
+	"ssosage/tests/suite"
+	"testing"
+	"time"
+
+	"github.com/hyperfyodor/ssosage_proto"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+)
+
+// enrollTOTP turns on totp for a client and returns its secret and recovery codes
+func enrollTOTP(ctx context.Context, t *testing.T, suite *suite.Suite, clientName string, password string) ([]byte, []string) {
+	t.Helper()
+
+	enrolled, err := suite.SsosageClient.EnrollTOTP(ctx, &ssosage_proto.EnrollTOTPRequest{ClientName: clientName, Password: password})
+
+	if err != nil {
+		t.Fatalf("failed to enroll totp: %v", err)
+	}
+
+	secret, err := base32.StdEncoding.WithPadding(base32.NoPadding).DecodeString(enrolled.GetSecret())
+
+	if err != nil {
+		t.Fatalf("failed to decode totp secret: %v", err)
+	}
+
+	confirmed, err := suite.SsosageClient.ConfirmTOTP(ctx, &ssosage_proto.ConfirmTOTPRequest{
+		ClientName: clientName,
+		Password:   password,
+		TotpCode:   totp.Code(secret, totp.Step(time.Now())),
+	})
+
+	if err != nil {
+		t.Fatalf("failed to confirm totp: %v", err)
+	}
+
+	return secret, confirmed.GetRecoveryCodes()
+}
+
+// nextCode is the code of the step after the current one, the server accepts it and
+// it is newer than the step ConfirmTOTP used, each step is accepted once
+func nextCode(secret []byte) string {
+	return totp.Code(secret, totp.Step(time.Now())+1)
+}
+
+func TestTOTP(t *testing.T) {
+	ctx, suite := suite.NewSuite(t)
+
+	appName := suite.RegisterApp(ctx, "user")
+	clientName, password := suite.RegisterClient(ctx)
+
+	secret, recoveryCodes := enrollTOTP(ctx, t, suite, clientName, password)
+
+	request := &ssosage_proto.GenerateTokenRequest{
+		ClientName: clientName,
+		Password:   password,
+		AppName:    appName,
+		Role:       "user",
+	}
+
+	if _, err := suite.SsosageClient.GenerateToken(ctx, request); status.Code(err) != codes.Unauthenticated {
+		t.Fatalf("expected a totp code to be required, got: %v", err)
+	}
+
+	request.TotpCode = nextCode(secret)
+
+	if _, err := suite.SsosageClient.GenerateToken(ctx, request); err != nil {
+		t.Fatalf("failed to generate token with a totp code: %v", err)
+	}
+
+	_, err := suite.SsosageClient.EnrollTOTP(ctx, &ssosage_proto.EnrollTOTPRequest{ClientName: clientName, Password: password})
+
+	if status.Code(err) != codes.FailedPrecondition {
+		t.Fatalf("expected enrolling twice to fail, got: %v", err)
+	}
+
+	_, err = suite.SsosageClient.DisableTOTP(ctx, &ssosage_proto.DisableTOTPRequest{
+		ClientName: clientName,
+		Password:   password,
+		TotpCode:   recoveryCodes[0],
+	})
+
+	if err != nil {
+		t.Fatalf("failed to disable totp: %v", err)
+	}
+
+	request.TotpCode = ""
+
+	if _, err := suite.SsosageClient.GenerateToken(ctx, request); err != nil {
+		t.Fatalf("failed to generate token without totp: %v", err)
+	}
+}
+
+func TestRequireMFA(t *testing.T) {
+	ctx, suite := suite.NewSuite(t)
+
+	appName := suite.RegisterApp(ctx, "user")
+	clientName, password := suite.RegisterClient(ctx)
+
+	app, err := suite.SsosageClient.GetApp(suite.AdminContext(ctx), &ssosage_proto.GetAppRequest{AppName: appName})
+
+	if err != nil {
+		t.Fatalf("failed to get app: %v", err)
+	}
+
+	_, err = suite.SsosageClient.UpdateApp(suite.AdminContext(ctx), &ssosage_proto.UpdateAppRequest{
+		AppName:    appName,
+		Version:    app.GetApp().GetVersion(),
+		RequireMfa: proto.Bool(true),
+	})
+
+	if err != nil {
+		t.Fatalf("failed to update app: %v", err)
+	}
+
+	request := &ssosage_proto.GenerateTokenRequest{
+		ClientName: clientName,
+		Password:   password,
+		AppName:    appName,
+		Role:       "user",
+	}
+
+	if _, err := suite.SsosageClient.GenerateToken(ctx, request); status.Code(err) != codes.FailedPrecondition {
+		t.Fatalf("expected a client without totp to be refused, got: %v", err)
+	}
+
+	secret, _ := enrollTOTP(ctx, t, suite, clientName, password)
+
+	request.TotpCode = nextCode(secret)
+
+	if _, err := suite.SsosageClient.GenerateToken(ctx, request); err != nil {
+		t.Fatalf("failed to generate token with a totp code: %v", err)
+	}
+}
+
+func TestResetTOTP(t *testing.T) {
+	ctx, suite := suite.NewSuite(t)
+
+	appName := suite.RegisterApp(ctx, "user")
+	clientName, password := suite.RegisterClient(ctx)
+
+	enrollTOTP(ctx, t, suite, clientName, password)
+
+	_, err := suite.SsosageClient.ResetTOTP(ctx, &ssosage_proto.ResetTOTPRequest{ClientName: clientName})
+
+	if status.Code(err) != codes.Unauthenticated {
+		t.Fatalf("expected unauthenticated without credentials, got: %v", err)
+	}
+
+	if _, err := suite.SsosageClient.ResetTOTP(suite.AdminContext(ctx), &ssosage_proto.ResetTOTPRequest{ClientName: clientName}); err != nil {
+		t.Fatalf("failed to reset totp: %v", err)
+	}
+
+	_, err = suite.SsosageClient.GenerateToken(ctx, &ssosage_proto.GenerateTokenRequest{
+		ClientName: clientName,
+		Password:   password,
+		AppName:    appName,
+		Role:       "user",
+	})
+
+	if err != nil {
+		t.Fatalf("failed to generate token after a reset: %v", err)
+	}
+}
+
+func TestRecoveryCodes(t *testing.T) {
+	ctx, suite := suite.NewSuite(t)
+
+	appName := suite.RegisterApp(ctx, "user")
+	clientName, password := suite.RegisterClient(ctx)
+
+	_, recoveryCodes := enrollTOTP(ctx, t, suite, clientName, password)
+
+	if len(recoveryCodes) != 10 {
+		t.Fatalf("expected 10 recovery codes, got %v", recoveryCodes)
+	}
+
+	request := &ssosage_proto.GenerateTokenRequest{
+		ClientName: clientName,
+		Password:   password,
+		AppName:    appName,
+		Role:       "user",
+		TotpCode:   recoveryCodes[0],
+	}
+
+	if _, err := suite.SsosageClient.GenerateToken(ctx, request); err != nil {
+		t.Fatalf("failed to generate token with a recovery code: %v", err)
+	}
+
+	regenerated, err := suite.SsosageClient.RegenerateRecoveryCodes(ctx, &ssosage_proto.RegenerateRecoveryCodesRequest{
+		ClientName: clientName,
+		Password:   password,
+		TotpCode:   recoveryCodes[1],
+	})
+
+	if err != nil {
+		t.Fatalf("failed to regenerate recovery codes: %v", err)
+	}
+
+	if len(regenerated.GetRecoveryCodes()) != 10 {
+		t.Fatalf("expected 10 recovery codes, got %v", regenerated.GetRecoveryCodes())
+	}
+
+	request.TotpCode = regenerated.GetRecoveryCodes()[0]
+
+	if _, err := suite.SsosageClient.GenerateToken(ctx, request); err != nil {
+		t.Fatalf("failed to generate token with a new recovery code: %v", err)
+	}
+
+	// a used code is gone for good, and the failure locks the client for a moment, so it comes last
+	request.TotpCode = recoveryCodes[0]
+
+	if _, err := suite.SsosageClient.GenerateToken(ctx, request); status.Code(err) != codes.InvalidArgument {
+		t.Fatalf("expected a used recovery code to be refused, got: %v", err)
+	}
+}
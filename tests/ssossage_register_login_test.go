@@ -17,7 +17,7 @@ func TestRegisterLogin(t *testing.T) {
 	appName := gofakeit.AppName()
 
 	_, err := suite.SsosageClient.RegisterApp(
-		ctx,
+		suite.AdminContext(ctx),
 		&ssosage_proto.RegisterAppRequest{
 			AppName:   appName,
 			AppSecret: APP_SECRET,
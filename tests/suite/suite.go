@@ -2,19 +2,43 @@ package suite
 
 import (
 	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
 	"net"
+	"os"
 	"ssosage/internal/config/ssosage"
 	"strconv"
+	"strings"
 	"testing"
 	"time"
 
+	"github.com/brianvoe/gofakeit/v6"
 	"github.com/hyperfyodor/ssosage_proto"
+	"github.com/ilyakaznacheev/cleanenv"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
 	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
 )
 
+// Config is the server config plus what the tests need to connect to it
+type Config struct {
+	ssosage.Config
+	ClientTLS ClientTLS `json:"client_tls"`
+}
+
+// ClientTLS is off when ca file is empty, cert and key are sent for mutual tls
+type ClientTLS struct {
+	CAFile     string `json:"ca_file"`
+	CertFile   string `json:"cert_file"`
+	KeyFile    string `json:"key_file"`
+	ServerName string `json:"server_name"`
+}
+
 type Suite struct {
 	*testing.T
+	Cfg           *Config
 	SsosageClient ssosage_proto.SsosageClient
 }
 
@@ -22,7 +46,11 @@ func NewSuite(t *testing.T) (context.Context, *Suite) {
 	t.Helper()
 	t.Parallel()
 
-	cfg := ssosage.MustLoad("./config/test_config.json")
+	var cfg Config
+
+	if err := cleanenv.ReadConfig("./config/test_config.json", &cfg); err != nil {
+		t.Fatalf("failed to read test config: %v", err)
+	}
 
 	ctx, cancelCtx := context.WithTimeout(context.Background(), 10*time.Minute)
 
@@ -33,7 +61,13 @@ func NewSuite(t *testing.T) (context.Context, *Suite) {
 
 	grpcAddress := net.JoinHostPort("0.0.0.0", strconv.Itoa(cfg.GrpcPort))
 
-	cc, err := grpc.NewClient(grpcAddress, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	creds, err := transportCredentials(cfg.ClientTLS)
+
+	if err != nil {
+		t.Fatalf("failed to load client tls files: %v", err)
+	}
+
+	cc, err := grpc.NewClient(grpcAddress, grpc.WithTransportCredentials(creds))
 
 	if err != nil {
 		t.Fatalf("grpc server connection failed: %v", err)
@@ -43,6 +77,155 @@ func NewSuite(t *testing.T) (context.Context, *Suite) {
 
 	return ctx, &Suite{
 		T:             t,
+		Cfg:           &cfg,
 		SsosageClient: ssosageClient,
 	}
 }
+
+// AdminContext authenticates calls made with the returned context by the first admin api key
+func (s *Suite) AdminContext(ctx context.Context) context.Context {
+	s.Helper()
+
+	if len(s.Cfg.Auth.AdminAPIKeys) == 0 {
+		s.Fatal("no admin api key in test config")
+	}
+
+	return metadata.AppendToOutgoingContext(ctx, "authorization", "Bearer "+s.Cfg.Auth.AdminAPIKeys[0])
+}
+
+// AppSecret signs the tokens of the apps registered by RegisterApp
+const AppSecret = "some_secret"
+
+// RegisterApp registers an app with a random name and the roles as admin and returns its name
+func (s *Suite) RegisterApp(ctx context.Context, roles ...string) string {
+	s.Helper()
+
+	name := gofakeit.AppName() + "-" + gofakeit.UUID()
+
+	_, err := s.SsosageClient.RegisterApp(s.AdminContext(ctx), &ssosage_proto.RegisterAppRequest{
+		AppName:   name,
+		AppSecret: AppSecret,
+		Roles:     roles,
+	})
+
+	if err != nil {
+		s.Fatalf("failed to register an app: %v", err)
+	}
+
+	return name
+}
+
+// UpdateApp applies update as admin to the current version of the app it names
+func (s *Suite) UpdateApp(ctx context.Context, update *ssosage_proto.UpdateAppRequest) {
+	s.Helper()
+
+	got, err := s.SsosageClient.GetApp(s.AdminContext(ctx), &ssosage_proto.GetAppRequest{AppName: update.GetAppName()})
+
+	if err != nil {
+		s.Fatalf("failed to get app: %v", err)
+	}
+
+	update.Version = got.GetApp().GetVersion()
+
+	if _, err := s.SsosageClient.UpdateApp(s.AdminContext(ctx), update); err != nil {
+		s.Fatalf("failed to update app: %v", err)
+	}
+}
+
+// RegisterClient registers a client with a random name and returns its name and password
+func (s *Suite) RegisterClient(ctx context.Context) (string, string) {
+	s.Helper()
+
+	name := gofakeit.Username() + "-" + gofakeit.UUID()
+	password := gofakeit.Password(true, true, true, true, false, 20)
+
+	_, err := s.SsosageClient.RegisterClient(ctx, &ssosage_proto.RegisterClientRequest{
+		ClientName: name,
+		Password:   password,
+	})
+
+	if err != nil {
+		s.Fatalf("failed to register a client: %v", err)
+	}
+
+	return name, password
+}
+
+// NotificationToken returns the token in the last notification with subject sent to a client,
+// the server has to write notifications to the file of the test config
+func (s *Suite) NotificationToken(clientName string, subject string) string {
+	s.Helper()
+
+	if s.Cfg.Notifier.FilePath == "" {
+		s.Fatal("no notifier file in test config")
+	}
+
+	content, err := os.ReadFile(s.Cfg.Notifier.FilePath)
+
+	if err != nil {
+		s.Fatalf("failed to read notifications: %v", err)
+	}
+
+	var body string
+
+	for _, line := range strings.Split(string(content), "\n") {
+		var notification struct {
+			ClientName string `json:"client_name"`
+			Subject    string `json:"subject"`
+			Body       string `json:"body"`
+		}
+
+		// other tests may be halfway through writing theirs
+		if json.Unmarshal([]byte(line), &notification) != nil {
+			continue
+		}
+
+		if notification.ClientName == clientName && notification.Subject == subject {
+			body = notification.Body
+		}
+	}
+
+	// the token is the only line without spaces
+	for _, line := range strings.Split(body, "\n") {
+		if line != "" && !strings.Contains(line, " ") {
+			return line
+		}
+	}
+
+	s.Fatalf("no %q notification with a token for %s", subject, clientName)
+
+	return ""
+}
+
+func transportCredentials(cfg ClientTLS) (credentials.TransportCredentials, error) {
+	if cfg.CAFile == "" {
+		return insecure.NewCredentials(), nil
+	}
+
+	pem, err := os.ReadFile(cfg.CAFile)
+
+	if err != nil {
+		return nil, err
+	}
+
+	roots := x509.NewCertPool()
+	roots.AppendCertsFromPEM(pem)
+
+	tlsCfg := &tls.Config{
+		RootCAs:    roots,
+		ServerName: cfg.ServerName,
+		MinVersion: tls.VersionTLS12,
+	}
+
+	if cfg.CertFile != "" {
+		cert, err := tls.LoadX509KeyPair(cfg.CertFile, cfg.KeyFile)
+
+		if err != nil {
+			return nil, err
+		}
+
+		tlsCfg.Certificates = []tls.Certificate{cert}
+	}
+
+	return credentials.NewTLS(tlsCfg), nil
+}